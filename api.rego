@@ -1,7 +1,11 @@
 package api.rbac
 
 # import roles list from data.api.rbac
+import data.api.rbac.clusterrolebindings
+import data.api.rbac.clusterroles
+import data.api.rbac.groups
 import data.api.rbac.permissions
+import data.api.rbac.rolebindings
 import data.api.rbac.roles
 import input
 
@@ -33,9 +37,15 @@ allow {
 	is_resourceName_match(grant.resourceNames)
 }
 
-# non resource request match
+# Allow the non resource request if the user is granted access to the URL.
 allow {
 	input.resourceRequest == false
+
+	some grant
+	user_is_granted[grant]
+
+	is_verb_match(grant.verbs)
+	is_nonResourceURL_match(grant.nonResourceURLs)
 }
 
 # user_is_admin is true if...
@@ -59,6 +69,60 @@ user_is_granted[grant] {
 	grant := permissions[role][j]
 }
 
+# The grants of the roles bound to the user, or to one of the user's groups,
+# by a role binding or a cluster role binding.
+user_is_granted[grant] {
+	some name, i, j
+
+	# `binding` assigned a binding with a subject matching the user...
+	binding := bindings[name]
+	is_subject_match(binding.subjects[i])
+
+	# `grant` assigned a single grant of the role the binding refers to...
+	grant := role_ref_grants(binding.roleRef)[j]
+}
+
+# bindings merges role bindings and cluster role bindings, which only differ
+# in the kinds of roles they may refer to.
+bindings[name] = binding {
+	binding := rolebindings[name]
+}
+
+bindings[name] = binding {
+	binding := clusterrolebindings[name]
+}
+
+# user_groups is the set of groups of the user identified in the request,
+# the ones passed in the request and the ones listing the user as a member.
+user_groups[group] {
+	group := input.groups[_]
+}
+
+user_groups[group] {
+	some group
+	groups[group][_] == input.user
+}
+
+is_subject_match(subject) {
+	subject.kind == "User"
+	subject.name == input.user
+}
+
+is_subject_match(subject) {
+	subject.kind == "Group"
+	user_groups[subject.name]
+}
+
+role_ref_grants(roleRef) = grants {
+	roleRef.kind == "Role"
+	grants := permissions[roleRef.name]
+}
+
+role_ref_grants(roleRef) = grants {
+	roleRef.kind == "ClusterRole"
+	grants := clusterroles[roleRef.name]
+}
+
 # who_are is a set of users who has roles identified in the request.
 who_are[user] {
     # for some `user`...
@@ -162,16 +226,19 @@ is_resourceName_match(resourceNames) {
 
 is_resourceName_match(resourceNames) {
 	some i
-	resourceNames[i] == input.resourceNames
+	resourceNames[i] == input.resourceName
 }
 
+# A trailing `*` matches every URL with the preceding prefix, a lone `*`
+# matches all URLs.
 is_nonResourceURL_match(nonResourceURLs) {
 	some i
-	nonResourceURLs[i] == "*"
+	endswith(nonResourceURLs[i], "*")
+	startswith(input.nonResourceURL, trim_suffix(nonResourceURLs[i], "*"))
 }
 
 is_nonResourceURL_match(nonResourceURLs) {
 	some i
-	nonResourceURLs[i] == input.Path
+	nonResourceURLs[i] == input.nonResourceURL
 }
 
@@ -16,7 +16,14 @@ permissions = {"regular": [
 		"apiGroups": ["*"],
 		"resources": ["namespaces", "clusters"],
 		"resourceNames": [],
-		"nonResourceURLs": ["/metrics"],
+		"nonResourceURLs": ["/metrics", "/debug/*"],
+	},
+	{
+		"verbs": ["DELETE"],
+		"apiGroups": ["*"],
+		"resources": ["namespaces"],
+		"resourceNames": ["test"],
+		"nonResourceURLs": [],
 	},
 	{
 		"verbs": "POST",
@@ -27,6 +34,26 @@ permissions = {"regular": [
 	},
 ]}
 
+clusterroles = {"viewer": [{
+	"verbs": ["get", "list"],
+	"apiGroups": ["*"],
+	"resources": ["pods"],
+	"resourceNames": [],
+	"nonResourceURLs": [],
+}]}
+
+groups = {"dev": ["carol"]}
+
+rolebindings = {"dave-regular": {
+	"subjects": [{"kind": "User", "name": "dave"}],
+	"roleRef": {"kind": "Role", "name": "regular"},
+}}
+
+clusterrolebindings = {"dev-viewer": {
+	"subjects": [{"kind": "Group", "name": "dev"}],
+	"roleRef": {"kind": "ClusterRole", "name": "viewer"},
+}}
+
 test_admin_allowed {
 	allow with input as {"user": "alice"} with rbac.roles as roles
 }
@@ -39,12 +66,57 @@ test_grants_allowed {
 	allow with input as {"user": "bob", "resourceRequest": true, "verb": "UPDATE", "apiGroup": "*", "resource": "namespaces"} with rbac.roles as roles with rbac.permissions as permissions
 }
 
+test_grants_resourceName_allowed {
+	allow with input as {"user": "bob", "resourceRequest": true, "verb": "DELETE", "apiGroup": "", "resource": "namespaces", "resourceName": "test"} with rbac.roles as roles with rbac.permissions as permissions
+}
+
+test_grants_resourceName_not_allowed {
+	not allow with input as {"user": "bob", "resourceRequest": true, "verb": "DELETE", "apiGroup": "", "resource": "namespaces", "resourceName": "prod"} with rbac.roles as roles with rbac.permissions as permissions
+}
+
 test_grants_nonResourcesURLs_allowed {
-    allow with input as {"user": "bob", "resourceRequest": false,"path":"/metrics"} with rbac.roles as roles with rbac.permissions as permissions
+	allow with input as {"user": "bob", "resourceRequest": false, "verb": "GET", "nonResourceURL": "/metrics"} with rbac.roles as roles with rbac.permissions as permissions
+}
+
+test_grants_nonResourcesURLs_prefix_allowed {
+	allow with input as {"user": "bob", "resourceRequest": false, "verb": "GET", "nonResourceURL": "/debug/pprof"} with rbac.roles as roles with rbac.permissions as permissions
 }
 
 test_grants_nonResourcesURLs_not_allowed {
-    allow with input as {"user": "bob", "resourceRequest": false,"path":"/healthz"} with rbac.roles as roles with rbac.permissions as permissions
+	not allow with input as {"user": "bob", "resourceRequest": false, "verb": "GET", "nonResourceURL": "/healthz"} with rbac.roles as roles with rbac.permissions as permissions
+}
+
+test_grants_nonResourcesURLs_verb_not_allowed {
+	not allow with input as {"user": "bob", "resourceRequest": false, "verb": "POST", "nonResourceURL": "/metrics"} with rbac.roles as roles with rbac.permissions as permissions
+}
+
+test_unknown_user_nonResourcesURLs_not_allowed {
+	not allow with input as {"user": "mallory", "resourceRequest": false, "verb": "GET", "nonResourceURL": "/metrics"} with rbac.roles as roles with rbac.permissions as permissions
+	not allow with input as {"resourceRequest": false, "verb": "GET", "nonResourceURL": "/metrics"} with rbac.roles as roles with rbac.permissions as permissions
+}
+
+test_role_binding_allowed {
+	allow with input as {"user": "dave", "resourceRequest": true, "verb": "GET", "apiGroup": "apps", "resource": "clusters"} with rbac.permissions as permissions with rbac.rolebindings as rolebindings
+}
+
+test_role_binding_other_user_not_allowed {
+	not allow with input as {"user": "erin", "resourceRequest": true, "verb": "GET", "apiGroup": "apps", "resource": "clusters"} with rbac.permissions as permissions with rbac.rolebindings as rolebindings
+}
+
+test_cluster_role_binding_group_member_allowed {
+	allow with input as {"user": "carol", "resourceRequest": true, "verb": "list", "apiGroup": "", "resource": "pods"} with rbac.clusterroles as clusterroles with rbac.groups as groups with rbac.clusterrolebindings as clusterrolebindings
+}
+
+test_cluster_role_binding_request_group_allowed {
+	allow with input as {"user": "frank", "groups": ["dev"], "resourceRequest": true, "verb": "get", "apiGroup": "", "resource": "pods"} with rbac.clusterroles as clusterroles with rbac.clusterrolebindings as clusterrolebindings
+}
+
+test_cluster_role_binding_non_member_not_allowed {
+	not allow with input as {"user": "frank", "groups": ["ops"], "resourceRequest": true, "verb": "get", "apiGroup": "", "resource": "pods"} with rbac.clusterroles as clusterroles with rbac.groups as groups with rbac.clusterrolebindings as clusterrolebindings
+}
+
+test_cluster_role_binding_verb_not_allowed {
+	not allow with input as {"user": "carol", "resourceRequest": true, "verb": "delete", "apiGroup": "", "resource": "pods"} with rbac.clusterroles as clusterroles with rbac.groups as groups with rbac.clusterrolebindings as clusterrolebindings
 }
 
 test_who_are_list {
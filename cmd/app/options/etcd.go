@@ -0,0 +1,205 @@
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/x893675/opa-server/pkg/storage/etcd3"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"github.com/x893675/opa-server/pkg/storage/value/encryptionconfig"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultEtcdPrefix = "/opa-server"
+	defaultBoltPath   = "opa-server.db"
+
+	defaultWatchCacheSize = 100
+
+	defaultCountMetricPollPeriod = time.Minute
+)
+
+// EtcdOptions holds the options of the etcd storage backend.
+type EtcdOptions struct {
+	// StorageBackend is the storage backend for persistence, "etcd3",
+	// "memory" or "bolt". The memory backend keeps nothing across restarts
+	// and is meant for tests and single instance deployments, the bolt
+	// backend keeps the objects in the local file BoltPath.
+	StorageBackend string `json:"storageBackend,omitempty"`
+	// BoltPath is the file of the bolt storage backend.
+	BoltPath string `json:"boltPath,omitempty"`
+	// Servers is the list of etcd servers to connect with.
+	Servers []string `json:"servers,omitempty"`
+	// KeyFile, CertFile and TrustedCAFile hold the TLS credentials used to
+	// talk to etcd.
+	KeyFile       string `json:"keyFile,omitempty"`
+	CertFile      string `json:"certFile,omitempty"`
+	TrustedCAFile string `json:"trustedCAFile,omitempty"`
+	// Prefix is prepended to every key written to etcd.
+	Prefix string `json:"prefix,omitempty"`
+	// Paging enables chunked lists.
+	Paging bool `json:"paging"`
+	// LeaseReuseDurationSeconds is the time in seconds each lease is reused.
+	LeaseReuseDurationSeconds int64 `json:"leaseReuseDurationSeconds,omitempty"`
+	// LeaseMaxObjectCount is the maximum number of objects attached to a lease.
+	LeaseMaxObjectCount int64 `json:"leaseMaxObjectCount,omitempty"`
+	// EncryptionProviderConfigFilepath is the path of the file configuring
+	// how values are encrypted in etcd. Values are stored in plain text if
+	// it is empty.
+	EncryptionProviderConfigFilepath string `json:"encryptionProviderConfig,omitempty"`
+	// EnableWatchCache puts a watch cache in front of the storage of every
+	// resource, which serves the watches and the reads at a resource
+	// version from memory.
+	EnableWatchCache bool `json:"enableWatchCache"`
+	// DefaultWatchCacheSize is the number of recent events the watch cache
+	// of a resource keeps to resume watches from.
+	DefaultWatchCacheSize int `json:"defaultWatchCacheSize,omitempty"`
+	// CompactionInterval is the interval of the compaction of the etcd
+	// history, which keeps the revisions of about the last interval. The
+	// history is never compacted if it is 0.
+	CompactionInterval metav1.Duration `json:"compactionInterval"`
+	// HealthcheckTimeout is the timeout of the health check of etcd.
+	HealthcheckTimeout metav1.Duration `json:"healthcheckTimeout,omitempty"`
+	// CountMetricPollPeriod is how often the number of objects of every
+	// resource is counted for the metrics. They are not counted if it is 0.
+	CountMetricPollPeriod metav1.Duration `json:"countMetricPollPeriod"`
+	// DBMetricPollInterval is how often the database size of every etcd
+	// endpoint is polled for the metrics. It is not polled if it is 0.
+	DBMetricPollInterval metav1.Duration `json:"dbMetricPollInterval"`
+}
+
+// NewEtcdOptions creates a new EtcdOptions object with default parameters.
+func NewEtcdOptions() *EtcdOptions {
+	leaseManagerConfig := etcd3.NewDefaultLeaseManagerConfig()
+	return &EtcdOptions{
+		StorageBackend:            storagebackend.StorageTypeETCD3,
+		BoltPath:                  defaultBoltPath,
+		Servers:                   []string{"http://127.0.0.1:2379"},
+		Prefix:                    defaultEtcdPrefix,
+		Paging:                    true,
+		LeaseReuseDurationSeconds: leaseManagerConfig.ReuseDurationSeconds,
+		LeaseMaxObjectCount:       leaseManagerConfig.MaxObjectCount,
+		EnableWatchCache:          true,
+		DefaultWatchCacheSize:     defaultWatchCacheSize,
+		CompactionInterval:        metav1.Duration{Duration: storagebackend.DefaultCompactInterval},
+		HealthcheckTimeout:        metav1.Duration{Duration: storagebackend.DefaultHealthcheckTimeout},
+		CountMetricPollPeriod:     metav1.Duration{Duration: defaultCountMetricPollPeriod},
+		DBMetricPollInterval:      metav1.Duration{Duration: storagebackend.DefaultDBMetricPollInterval},
+	}
+}
+
+// AddFlags adds flags related to etcd storage to the specified FlagSet.
+func (o *EtcdOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.StorageBackend, "storage-backend", o.StorageBackend, ""+
+		"The storage backend for persistence. Options: 'etcd3' (default), 'memory', 'bolt'. "+
+		"The memory backend loses all data on restart, the bolt backend keeps it in the file --bolt-path.")
+	fs.StringVar(&o.BoltPath, "bolt-path", o.BoltPath, ""+
+		"The file the bolt storage backend keeps the data in.")
+	fs.StringSliceVar(&o.Servers, "etcd-servers", o.Servers, ""+
+		"List of etcd servers to connect with (scheme://ip:port), comma separated.")
+	fs.StringVar(&o.KeyFile, "etcd-keyfile", o.KeyFile, ""+
+		"SSL key file used to secure etcd communication.")
+	fs.StringVar(&o.CertFile, "etcd-certfile", o.CertFile, ""+
+		"SSL certification file used to secure etcd communication.")
+	fs.StringVar(&o.TrustedCAFile, "etcd-cafile", o.TrustedCAFile, ""+
+		"SSL Certificate Authority file used to secure etcd communication.")
+	fs.StringVar(&o.Prefix, "etcd-prefix", o.Prefix, ""+
+		"The prefix to prepend to all resource paths in etcd.")
+	fs.BoolVar(&o.Paging, "etcd-paging", o.Paging, ""+
+		"Allow lists to be served in chunks.")
+	fs.Int64Var(&o.LeaseReuseDurationSeconds, "etcd-lease-reuse-duration-seconds", o.LeaseReuseDurationSeconds, ""+
+		"The time in seconds that each lease is reused.")
+	fs.Int64Var(&o.LeaseMaxObjectCount, "etcd-lease-max-object-count", o.LeaseMaxObjectCount, ""+
+		"The maximum number of objects attached to a single etcd lease.")
+	fs.StringVar(&o.EncryptionProviderConfigFilepath, "encryption-provider-config", o.EncryptionProviderConfigFilepath, ""+
+		"The file containing configuration for encryption providers to be used for storing secrets in etcd.")
+	fs.BoolVar(&o.EnableWatchCache, "watch-cache", o.EnableWatchCache, ""+
+		"Enable watch caching in the server.")
+	fs.IntVar(&o.DefaultWatchCacheSize, "default-watch-cache-size", o.DefaultWatchCacheSize, ""+
+		"Default watch cache size, the number of recent events a watch can be resumed from.")
+	fs.DurationVar(&o.CompactionInterval.Duration, "etcd-compaction-interval", o.CompactionInterval.Duration, ""+
+		"The interval of compaction requests. If 0, the compaction requests from the server are disabled. "+
+		"Multiple servers sharing the etcd cluster compact at most once per interval between them.")
+	fs.DurationVar(&o.HealthcheckTimeout.Duration, "etcd-healthcheck-timeout", o.HealthcheckTimeout.Duration, ""+
+		"The timeout to use when checking etcd health.")
+	fs.DurationVar(&o.CountMetricPollPeriod.Duration, "etcd-count-metric-poll-period", o.CountMetricPollPeriod.Duration, ""+
+		"Frequency of polling storage for number of objects of each resource. Default 1 min. 0 disables the metric collection.")
+	fs.DurationVar(&o.DBMetricPollInterval.Duration, "etcd-db-metric-poll-interval", o.DBMetricPollInterval.Duration, ""+
+		"The interval of requests to poll etcd and update metric. 0 disables the metric collection.")
+}
+
+// Validate checks EtcdOptions and returns a slice of found errors.
+func (o *EtcdOptions) Validate() []error {
+	var errs []error
+	switch o.StorageBackend {
+	case storagebackend.StorageTypeUnset, storagebackend.StorageTypeETCD3:
+		if len(o.Servers) == 0 {
+			errs = append(errs, fmt.Errorf("--etcd-servers must be specified"))
+		}
+	case storagebackend.StorageTypeMemory:
+	case storagebackend.StorageTypeBolt:
+		if len(o.BoltPath) == 0 {
+			errs = append(errs, fmt.Errorf("--bolt-path must be specified"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("--storage-backend invalid, allowed values: %s, %s, %s",
+			storagebackend.StorageTypeETCD3, storagebackend.StorageTypeMemory, storagebackend.StorageTypeBolt))
+	}
+	if len(o.Prefix) == 0 {
+		errs = append(errs, fmt.Errorf("--etcd-prefix must not be empty"))
+	}
+	if o.LeaseReuseDurationSeconds < 0 {
+		errs = append(errs, fmt.Errorf("--etcd-lease-reuse-duration-seconds must not be negative"))
+	}
+	if o.LeaseMaxObjectCount < 0 {
+		errs = append(errs, fmt.Errorf("--etcd-lease-max-object-count must not be negative"))
+	}
+	if o.CompactionInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--etcd-compaction-interval must not be negative"))
+	}
+	if o.HealthcheckTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("--etcd-healthcheck-timeout must be greater than 0"))
+	}
+	if o.CountMetricPollPeriod.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--etcd-count-metric-poll-period must not be negative"))
+	}
+	if o.DBMetricPollInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--etcd-db-metric-poll-interval must not be negative"))
+	}
+	if o.EnableWatchCache && o.DefaultWatchCacheSize <= 0 {
+		errs = append(errs, fmt.Errorf("--default-watch-cache-size must be positive when the watch cache is enabled"))
+	}
+	return errs
+}
+
+// ApplyTo applies the etcd options to the storage backend config. It fails
+// if the encryption provider configuration cannot be loaded.
+func (o *EtcdOptions) ApplyTo(c *storagebackend.Config) error {
+	c.Type = o.StorageBackend
+	c.Prefix = o.Prefix
+	c.BoltPath = o.BoltPath
+	c.Paging = o.Paging
+	c.CompactionInterval = o.CompactionInterval.Duration
+	c.HealthcheckTimeout = o.HealthcheckTimeout.Duration
+	c.CountMetricPollPeriod = o.CountMetricPollPeriod.Duration
+	c.DBMetricPollInterval = o.DBMetricPollInterval.Duration
+	c.Transport = storagebackend.TransportConfig{
+		ServerList:    o.Servers,
+		KeyFile:       o.KeyFile,
+		CertFile:      o.CertFile,
+		TrustedCAFile: o.TrustedCAFile,
+	}
+	c.LeaseManagerConfig = etcd3.LeaseManagerConfig{
+		ReuseDurationSeconds: o.LeaseReuseDurationSeconds,
+		MaxObjectCount:       o.LeaseMaxObjectCount,
+	}
+	if len(o.EncryptionProviderConfigFilepath) > 0 {
+		transformer, err := encryptionconfig.GetTransformer(o.EncryptionProviderConfigFilepath)
+		if err != nil {
+			return err
+		}
+		c.Transformer = transformer
+	}
+	return nil
+}
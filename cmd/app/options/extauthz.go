@@ -0,0 +1,56 @@
+package options
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/pflag"
+	"github.com/x893675/opa-server/pkg/extauthz"
+)
+
+// ExtAuthzOptions holds the options of the Envoy external authorization
+// gRPC server.
+type ExtAuthzOptions struct {
+	// Addr is the listening address of the gRPC server. The server is
+	// disabled if it is empty.
+	Addr string `json:"addr,omitempty"`
+	// UserHeader is the request header holding the name of the user.
+	UserHeader string `json:"userHeader,omitempty"`
+	// GroupHeader is the request header holding the comma separated groups
+	// of the user.
+	GroupHeader string `json:"groupHeader,omitempty"`
+}
+
+// NewExtAuthzOptions creates a new ExtAuthzOptions object with default parameters.
+func NewExtAuthzOptions() *ExtAuthzOptions {
+	return &ExtAuthzOptions{
+		UserHeader:  extauthz.DefaultUserHeader,
+		GroupHeader: extauthz.DefaultGroupHeader,
+	}
+}
+
+// AddFlags adds flags related to the ext_authz server to the specified FlagSet.
+func (o *ExtAuthzOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Addr, "ext-authz-addr", o.Addr, ""+
+		"The listening address of the Envoy ext_authz gRPC server (envoy.service.auth.v3.Authorization). "+
+		"If empty, the server is disabled.")
+	fs.StringVar(&o.UserHeader, "ext-authz-user-header", o.UserHeader, ""+
+		"The request header holding the user name checked by the ext_authz server.")
+	fs.StringVar(&o.GroupHeader, "ext-authz-group-header", o.GroupHeader, ""+
+		"The request header holding the comma separated groups of the user checked by the ext_authz server.")
+}
+
+// Validate checks ExtAuthzOptions and returns a slice of found errors.
+func (o *ExtAuthzOptions) Validate() []error {
+	if len(o.Addr) == 0 {
+		return nil
+	}
+	var errs []error
+	if _, _, err := net.SplitHostPort(o.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("--ext-authz-addr is invalid: %v", err))
+	}
+	if len(o.UserHeader) == 0 {
+		errs = append(errs, fmt.Errorf("--ext-authz-user-header must not be empty"))
+	}
+	return errs
+}
@@ -0,0 +1,163 @@
+package options
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultAddr                   = ":8181"
+	defaultGracefulShutdownPeriod = 10
+	defaultShutdownTimeout        = 30 * time.Second
+	defaultMinRequestTimeout      = 1800
+)
+
+// ServerRunOptions runs an opa server. The options may be read from a YAML
+// config file; flags set on the command line take precedence over it.
+type ServerRunOptions struct {
+	// ConfigFile is the path of the YAML file the options are loaded from.
+	ConfigFile string `json:"-"`
+
+	// Addrs are the listening addresses of the OPA REST API.
+	Addrs []string `json:"addrs,omitempty"`
+	// DiagnosticAddrs are the listening addresses of the read-only
+	// diagnostic API (/health, /metrics).
+	DiagnosticAddrs []string `json:"diagnosticAddrs,omitempty"`
+	// TLSCertFile and TLSPrivateKeyFile hold the x509 serving certificate.
+	// TLS is disabled unless both are set.
+	TLSCertFile       string `json:"tlsCertFile,omitempty"`
+	TLSPrivateKeyFile string `json:"tlsPrivateKeyFile,omitempty"`
+	// GracefulShutdownPeriod is the time in seconds the HTTP and ext_authz
+	// servers are given to finish in-flight requests on shutdown.
+	GracefulShutdownPeriod int `json:"gracefulShutdownPeriod,omitempty"`
+	// ShutdownTimeout bounds the whole shutdown: draining the HTTP servers,
+	// stopping the replicator, flushing decision logs and closing etcd.
+	ShutdownTimeout metav1.Duration `json:"shutdownTimeout,omitempty"`
+	// MinRequestTimeout is the minimum number of seconds a watch of the RBAC
+	// API is kept open if the client does not set timeoutSeconds.
+	MinRequestTimeout int `json:"minRequestTimeout,omitempty"`
+	// Paths are the policy and data files loaded on startup.
+	Paths []string `json:"paths,omitempty"`
+	// BootstrapAdmins are the users bound to the role granting every verb on
+	// the RBAC API when the server first starts.
+	BootstrapAdmins []string `json:"bootstrapAdmins,omitempty"`
+
+	Etcd     *EtcdOptions     `json:"etcd,omitempty"`
+	ExtAuthz *ExtAuthzOptions `json:"extAuthz,omitempty"`
+}
+
+// NewServerRunOptions creates a new ServerRunOptions object with default parameters.
+func NewServerRunOptions() *ServerRunOptions {
+	return &ServerRunOptions{
+		Addrs:                  []string{defaultAddr},
+		GracefulShutdownPeriod: defaultGracefulShutdownPeriod,
+		ShutdownTimeout:        metav1.Duration{Duration: defaultShutdownTimeout},
+		MinRequestTimeout:      defaultMinRequestTimeout,
+		Etcd:                   NewEtcdOptions(),
+		ExtAuthz:               NewExtAuthzOptions(),
+	}
+}
+
+// AddFlags adds flags for the server and all the storage options to the specified FlagSet.
+func (o *ServerRunOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ConfigFile, "config", o.ConfigFile, ""+
+		"The path to a YAML file holding the server options. Flags set on the "+
+		"command line override the values of the file.")
+	fs.StringSliceVar(&o.Addrs, "addr", o.Addrs, ""+
+		"The listening addresses of the OPA REST API.")
+	fs.StringSliceVar(&o.DiagnosticAddrs, "diagnostic-addr", o.DiagnosticAddrs, ""+
+		"The listening addresses of the diagnostic API serving /health and /metrics. "+
+		"If empty, the diagnostic API is only served on --addr.")
+	fs.StringVar(&o.TLSCertFile, "tls-cert-file", o.TLSCertFile, ""+
+		"File containing the x509 certificate used for serving HTTPS.")
+	fs.StringVar(&o.TLSPrivateKeyFile, "tls-private-key-file", o.TLSPrivateKeyFile, ""+
+		"File containing the x509 private key matching --tls-cert-file.")
+	fs.IntVar(&o.GracefulShutdownPeriod, "graceful-shutdown-period", o.GracefulShutdownPeriod, ""+
+		"The time in seconds the HTTP and ext_authz servers are given to finish in-flight "+
+		"requests on shutdown. It is bounded by --shutdown-timeout.")
+	fs.DurationVar(&o.ShutdownTimeout.Duration, "shutdown-timeout", o.ShutdownTimeout.Duration, ""+
+		"The maximum time the whole shutdown may take. If it is exceeded, the server "+
+		"exits with a non-zero code.")
+	fs.IntVar(&o.MinRequestTimeout, "min-request-timeout", o.MinRequestTimeout, ""+
+		"An optional field indicating the minimum number of seconds a handler must keep "+
+		"a request open before timing it out. Currently only honored by the watch request "+
+		"handler, which picks a randomized value above this number as the connection timeout, "+
+		"to spread out load.")
+	fs.StringSliceVar(&o.Paths, "path", o.Paths, ""+
+		"Policy or data files and directories loaded on startup, e.g. api.rego.")
+	fs.StringSliceVar(&o.BootstrapAdmins, "bootstrap-admin", o.BootstrapAdmins, ""+
+		"Users bound to the rbac-admin role, which grants every verb on the RBAC API. "+
+		"The role and its binding are created together on startup unless either exists.")
+
+	o.Etcd.AddFlags(fs)
+	o.ExtAuthz.AddFlags(fs)
+}
+
+// Complete loads ConfigFile, if one is set, underneath the flags that were
+// explicitly set in fs.
+func (o *ServerRunOptions) Complete(fs *pflag.FlagSet) error {
+	if len(o.ConfigFile) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(o.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %q: %v", o.ConfigFile, err)
+	}
+
+	// remember what was set on the command line, the file overwrites it
+	changed := map[string][]string{}
+	fs.Visit(func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			// copy, decoding the file reuses the backing array of the slice
+			changed[f.Name] = append([]string(nil), sv.GetSlice()...)
+		} else {
+			changed[f.Name] = []string{f.Value.String()}
+		}
+	})
+
+	if err := yaml.UnmarshalStrict(data, o); err != nil {
+		return fmt.Errorf("failed to decode config file %q: %v", o.ConfigFile, err)
+	}
+
+	for name, values := range changed {
+		f := fs.Lookup(name)
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			if err := sv.Replace(values); err != nil {
+				return fmt.Errorf("failed to restore flag --%s: %v", name, err)
+			}
+			continue
+		}
+		if err := f.Value.Set(values[0]); err != nil {
+			return fmt.Errorf("failed to restore flag --%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks ServerRunOptions and returns a slice of found errors.
+func (o *ServerRunOptions) Validate() []error {
+	var errs []error
+	if len(o.Addrs) == 0 {
+		errs = append(errs, fmt.Errorf("--addr must not be empty"))
+	}
+	if (len(o.TLSCertFile) == 0) != (len(o.TLSPrivateKeyFile) == 0) {
+		errs = append(errs, fmt.Errorf("--tls-cert-file and --tls-private-key-file must be set together"))
+	}
+	if o.GracefulShutdownPeriod < 0 {
+		errs = append(errs, fmt.Errorf("--graceful-shutdown-period must not be negative"))
+	}
+	if o.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("--shutdown-timeout must be positive"))
+	}
+	if o.MinRequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("--min-request-timeout must not be negative"))
+	}
+	errs = append(errs, o.Etcd.Validate()...)
+	errs = append(errs, o.ExtAuthz.Validate()...)
+	return errs
+}
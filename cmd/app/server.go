@@ -0,0 +1,402 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	goflag "flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	oparuntime "github.com/open-policy-agent/opa/runtime"
+	"github.com/spf13/cobra"
+	"github.com/x893675/opa-server/cmd/app/options"
+	opaauthorizer "github.com/x893675/opa-server/pkg/authorizer/opa"
+	"github.com/x893675/opa-server/pkg/endpoints"
+	"github.com/x893675/opa-server/pkg/extauthz"
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/opareplicator"
+	"github.com/x893675/opa-server/pkg/registry/authorization/subjectaccessreview"
+	genericregistry "github.com/x893675/opa-server/pkg/registry/generic/registry"
+	"github.com/x893675/opa-server/pkg/registry/rbac/bootstrap"
+	rbacrest "github.com/x893675/opa-server/pkg/registry/rbac/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/server"
+	"github.com/x893675/opa-server/pkg/signal"
+	"github.com/x893675/opa-server/pkg/storage"
+	etcd3metrics "github.com/x893675/opa-server/pkg/storage/etcd3/metrics"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend/factory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/klog/v2"
+)
+
+// maxRequestBodyBytes is the limit on the size of the request bodies of the
+// RBAC API, the same as the kube-apiserver default.
+const maxRequestBodyBytes = 3 * 1024 * 1024
+
+// NewOPAServerCommand creates a *cobra.Command object with default parameters
+func NewOPAServerCommand() *cobra.Command {
+	o := options.NewServerRunOptions()
+	cmd := &cobra.Command{
+		Use: "opa-server",
+		Long: `The opa-server runs an Open Policy Agent that authorizes requests against
+RBAC users and roles, which are kept in etcd and replicated into the policy
+data as they change.`,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Complete(cmd.Flags()); err != nil {
+				return err
+			}
+			if errs := o.Validate(); len(errs) != 0 {
+				return utilerrors.NewAggregate(errs)
+			}
+			return Run(o, signal.SetupSignalHandler())
+		},
+	}
+
+	fs := cmd.Flags()
+	o.AddFlags(fs)
+	klogFlags := goflag.NewFlagSet("klog", goflag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	fs.AddGoFlagSet(klogFlags)
+
+	return cmd
+}
+
+// Run runs the specified ServerRunOptions until stopCh is closed, then shuts
+// the server down. It returns an error if the server could not be started or
+// if shutting down did not complete within o.ShutdownTimeout.
+func Run(o *options.ServerRunOptions, stopCh <-chan struct{}) error {
+	runtimeCtx, cancelRuntime := context.WithCancel(context.Background())
+	defer cancelRuntime()
+
+	params := oparuntime.NewParams()
+	params.Addrs = &o.Addrs
+	params.DiagnosticAddrs = &o.DiagnosticAddrs
+	params.Paths = o.Paths
+	if len(o.TLSCertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSPrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load serving certificate: %v", err)
+		}
+		params.Certificate = &cert
+	}
+	rt, err := oparuntime.NewRuntime(runtimeCtx, params)
+	if err != nil {
+		return err
+	}
+
+	// objects are written with the apiVersion and kind they are registered
+	// with, so that they can be decoded without knowing their type
+	scheme := runtime.NewScheme()
+	if err := meta.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := model.AddToScheme(scheme); err != nil {
+		return err
+	}
+	c := storagebackend.NewDefaultConfig("", json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{}))
+	if err := o.Etcd.ApplyTo(c); err != nil {
+		return err
+	}
+
+	decorator := genericregistry.UndecoratedStorage
+	if o.Etcd.EnableWatchCache {
+		decorator = genericregistry.StorageWithCacher(o.Etcd.DefaultWatchCacheSize)
+	}
+
+	// every resource has its own storage with its own client, which is
+	// shared by the replicator and the REST storage so that a single watch
+	// cache serves both. Destroying it closes the client.
+	var destroyFuncs []factory.DestroyFunc
+	destroyStorage := func() {
+		for _, destroyFunc := range destroyFuncs {
+			destroyFunc()
+		}
+	}
+	// the client of the storage health check is closed with the storage
+	storageHealthCheckStopCh := make(chan struct{})
+	destroyFuncs = append(destroyFuncs, func() { close(storageHealthCheckStopCh) })
+	type resourceStorage struct {
+		storage     storage.Interface
+		destroyFunc factory.DestroyFunc
+	}
+	storages := map[string]resourceStorage{}
+	storageFor := func(resource string, newFunc, newListFunc func() runtime.Object) (storage.Interface, factory.DestroyFunc, error) {
+		if s, ok := storages[resource]; ok {
+			return s.storage, s.destroyFunc, nil
+		}
+		prefix := "/" + resource
+		s, destroyFunc, err := decorator(*c, prefix, genericregistry.NoNamespaceObjectKeyFunc(prefix), newFunc, newListFunc,
+			storage.DefaultClusterScopedAttr, genericregistry.DefaultClusterScopedIndexers())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create storage backend %v: %v", c.Transport.ServerList, err)
+		}
+		if c.CountMetricPollPeriod > 0 {
+			stopObservingCount := genericregistry.StartObservingCount(s, resource, prefix, c.CountMetricPollPeriod)
+			destroyBackend := destroyFunc
+			destroyFunc = func() {
+				stopObservingCount()
+				destroyBackend()
+			}
+		}
+		storages[resource] = resourceStorage{storage: s, destroyFunc: destroyFunc}
+		destroyFuncs = append(destroyFuncs, destroyFunc)
+		return s, destroyFunc, nil
+	}
+
+	users, _, err := storageFor("users",
+		func() runtime.Object { return &model.User{} },
+		func() runtime.Object { return &model.UserList{} })
+	if err != nil {
+		return err
+	}
+	roles, _, err := storageFor("roles",
+		func() runtime.Object { return &model.Role{} },
+		func() runtime.Object { return &model.RoleList{} })
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+	clusterRoles, _, err := storageFor("clusterroles",
+		func() runtime.Object { return &model.ClusterRole{} },
+		func() runtime.Object { return &model.ClusterRoleList{} })
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+	roleBindings, _, err := storageFor("rolebindings",
+		func() runtime.Object { return &model.RoleBinding{} },
+		func() runtime.Object { return &model.RoleBindingList{} })
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+	clusterRoleBindings, _, err := storageFor("clusterrolebindings",
+		func() runtime.Object { return &model.ClusterRoleBinding{} },
+		func() runtime.Object { return &model.ClusterRoleBindingList{} })
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+	groups, _, err := storageFor("groups",
+		func() runtime.Object { return &model.Group{} },
+		func() runtime.Object { return &model.GroupList{} })
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+	replicator, err := opareplicator.New(opareplicator.Config{
+		Store:               rt.Store,
+		Users:               users,
+		Roles:               roles,
+		ClusterRoles:        clusterRoles,
+		RoleBindings:        roleBindings,
+		ClusterRoleBindings: clusterRoleBindings,
+		Groups:              groups,
+	})
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+
+	rbacStorage, err := rbacrest.NewRESTStorage(storageFor, c.Codec)
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+
+	// the role binding is written by the transaction of the roles storage,
+	// the keys of all the resources share the prefix of the backend
+	if len(o.BootstrapAdmins) > 0 {
+		err := bootstrap.EnsureRoleWithBinding(runtimeCtx, roles, bootstrap.AdminRole(), bootstrap.AdminRoleBinding(o.BootstrapAdmins))
+		if err != nil {
+			destroyStorage()
+			return fmt.Errorf("failed to bootstrap the RBAC admins: %v", err)
+		}
+	}
+
+	storageHealthCheck, err := factory.CreateHealthCheck(*c, storageHealthCheckStopCh)
+	if err != nil {
+		destroyStorage()
+		return err
+	}
+
+	srv := server.New(rt)
+	etcd3metrics.Register(srv.MetricsRegistry())
+	opareplicator.RegisterMetrics(srv.MetricsRegistry())
+	srv.AddHealthChecks(healthz.NamedCheck(storageCheckName(c.Type), func(_ *http.Request) error {
+		return storageHealthCheck()
+	}))
+	// the policy evaluates against an empty RBAC data document until the
+	// first list of every RBAC resource is written
+	srv.AddReadyzChecks(healthz.NamedCheck("replicator-sync", func(_ *http.Request) error {
+		if !replicator.HasSynced() {
+			return fmt.Errorf("RBAC objects not yet replicated")
+		}
+		return nil
+	}))
+	srv.InstallAPIGroup(&endpoints.APIGroupVersion{
+		Storage:             rbacStorage,
+		Root:                "/apis",
+		GroupVersion:        model.SchemeGroupVersion,
+		Serializer:          c.Codec,
+		MaxRequestBodyBytes: maxRequestBodyBytes,
+		MinRequestTimeout:   time.Duration(o.MinRequestTimeout) * time.Second,
+	})
+	authorizer := opaauthorizer.New(rt.Manager)
+	srv.Handle("/apis/authorization.k8s.io/v1/subjectaccessreviews", subjectaccessreview.NewREST(authorizer))
+	if err := srv.Start(runtimeCtx); err != nil {
+		destroyStorage()
+		return err
+	}
+
+	var extAuthzServer *grpc.Server
+	extAuthzErrCh := make(chan error, 1)
+	if len(o.ExtAuthz.Addr) > 0 {
+		listener, err := net.Listen("tcp", o.ExtAuthz.Addr)
+		if err != nil {
+			cancelRuntime()
+			srv.Shutdown(context.Background())
+			destroyStorage()
+			return fmt.Errorf("failed to listen on %s: %v", o.ExtAuthz.Addr, err)
+		}
+		var opts []grpc.ServerOption
+		if params.Certificate != nil {
+			opts = append(opts, grpc.Creds(credentials.NewServerTLSFromCert(params.Certificate)))
+		}
+		extAuthzServer = grpc.NewServer(opts...)
+		extauthz.New(authorizer, o.ExtAuthz.UserHeader, o.ExtAuthz.GroupHeader).Register(extAuthzServer)
+		go func() {
+			klog.Infof("Serving ext_authz on %s", o.ExtAuthz.Addr)
+			if err := extAuthzServer.Serve(listener); err != nil {
+				extAuthzErrCh <- err
+			}
+		}()
+	}
+
+	// the replicator is not stopped with the runtime, its watches are only
+	// cancelled once no more requests are served
+	replicatorCtx, stopReplicator := context.WithCancel(context.Background())
+	defer stopReplicator()
+	// the metrics share the watches of the replicator, they are counted
+	// from the first list on
+	if err := opareplicator.RecordChanges(replicatorCtx, replicator); err != nil {
+		klog.Errorf("failed to record the RBAC object changes: %v", err)
+	}
+	replicatorDone := make(chan struct{})
+	go func() {
+		defer close(replicatorDone)
+		if err := replicator.Run(replicatorCtx); err != nil {
+			klog.Errorf("replicator failed: %v", err)
+		}
+	}()
+
+	var errs []error
+	select {
+	case err := <-srv.Err():
+		errs = append(errs, fmt.Errorf("listener failed: %v", err))
+	case err := <-extAuthzErrCh:
+		errs = append(errs, fmt.Errorf("ext_authz listener failed: %v", err))
+	case <-stopCh:
+	}
+
+	klog.Infof("Shutting down, waiting at most %v", o.ShutdownTimeout.Duration)
+	ctx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout.Duration)
+	defer cancel()
+	// in-flight requests are given the grace period to finish, within the
+	// bound of the whole shutdown
+	gracePeriod := time.Duration(o.GracefulShutdownPeriod) * time.Second
+	errs = append(errs, shutdown(ctx, []shutdownStep{
+		{"stop serving", func(ctx context.Context) error {
+			cancelRuntime()
+			ctx, cancel := context.WithTimeout(ctx, gracePeriod)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		}},
+		{"stop ext_authz server", func(ctx context.Context) error {
+			if extAuthzServer == nil {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(ctx, gracePeriod)
+			defer cancel()
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				extAuthzServer.GracefulStop()
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				extAuthzServer.Stop()
+				return ctx.Err()
+			}
+		}},
+		{"stop replicator", func(ctx context.Context) error {
+			stopReplicator()
+			<-replicatorDone
+			return nil
+		}},
+		{"flush decision logs", func(ctx context.Context) error {
+			rt.Manager.Stop(ctx)
+			return nil
+		}},
+		{"destroy storage", func(ctx context.Context) error {
+			destroyStorage()
+			return nil
+		}},
+	})...)
+	if len(errs) == 0 {
+		klog.Info("Shutdown complete")
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// storageCheckName returns the name of the health check of the storage
+// backend of type storageType.
+func storageCheckName(storageType string) string {
+	switch storageType {
+	case storagebackend.StorageTypeUnset, storagebackend.StorageTypeETCD3:
+		return "etcd"
+	default:
+		return storageType
+	}
+}
+
+// shutdownStep is a named step of shutting down the server.
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// shutdown runs steps in order. It gives up as soon as ctx is done, since
+// steps that did not finish in time may still be running.
+func shutdown(ctx context.Context, steps []shutdownStep) []error {
+	var errs []error
+	for _, step := range steps {
+		done := make(chan error, 1)
+		go func(step shutdownStep) {
+			done <- step.run(ctx)
+		}(step)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to %s: %v", step.name, err))
+			}
+			klog.V(2).Infof("Shutdown step %q done", step.name)
+		case <-ctx.Done():
+			return append(errs, fmt.Errorf("failed to %s: %v", step.name, ctx.Err()))
+		}
+	}
+	return errs
+}
@@ -6,15 +6,16 @@ import (
 
 	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
 	"github.com/x893675/opa-server/pkg/storage"
-	"github.com/x893675/opa-server/pkg/storage/etcd3"
 	"github.com/x893675/opa-server/pkg/storage/storagebackend"
 	"github.com/x893675/opa-server/pkg/storage/storagebackend/factory"
 
 	"github.com/x893675/opa-server/pkg/runtime"
 	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type User struct {
+	meta.TypeMeta   `json:",inline"`
 	meta.ObjectMeta `json:",inline"`
 	Password        string `json:"password"`
 }
@@ -39,14 +40,16 @@ func main() {
 		CertFile:      "",
 		TrustedCAFile: "",
 	}
-	codec := json.NewSerializerWithOptions(json.SerializerOptions{})
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(schema.GroupVersion{Group: "test.opa.io", Version: "v1"}, &User{})
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{})
 	c := storagebackend.NewDefaultConfig("/kubecaas.io", codec)
 	c.Transport = tc
-	etcdClient, err := factory.NewETCD3Client(c.Transport)
+	store, destroyFunc, err := factory.Create(*c, NewUser)
 	if err != nil {
 		panic(err)
 	}
-	store := etcd3.New(etcdClient, c.Codec, NewUser, c.Prefix, c.Paging, c.LeaseManagerConfig)
+	defer destroyFunc()
 
 	user1 := &User{
 		ObjectMeta: meta.ObjectMeta{
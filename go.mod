@@ -3,16 +3,28 @@ module github.com/x893675/opa-server
 go 1.15
 
 require (
+	github.com/envoyproxy/go-control-plane v0.9.8
 	github.com/google/gofuzz v1.1.0
+	github.com/google/uuid v1.1.2
+	github.com/gorilla/mux v1.7.3
+	github.com/grpc-ecosystem/grpc-gateway v1.14.4 // indirect
 	github.com/open-policy-agent/opa v0.27.1
+	github.com/prometheus/client_golang v1.7.1
+	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
+	go.etcd.io/bbolt v1.3.5
 	go.etcd.io/etcd v0.5.0-alpha.5.0.20200910180754-dd1b699fc489
+	golang.org/x/net v0.0.0-20210224082022-3d97a244fca7
+	google.golang.org/genproto v0.0.0-20201110150050-8816d57aaa9a
 	google.golang.org/grpc v1.27.1
+	k8s.io/api v0.21.0
 	k8s.io/apimachinery v0.21.0
 	k8s.io/apiserver v0.21.0
 	k8s.io/klog/v2 v2.8.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
 	go.etcd.io/etcd => go.etcd.io/etcd v0.5.0-alpha.5.0.20200910180754-dd1b699fc489 // ae9734ed278b is the SHA for git tag v3.4.13
-	google.golang.org/grpc => google.golang.org/grpc v1.27.1
+	google.golang.org/grpc => google.golang.org/grpc v1.27.1 // etcd v3.4 does not build with grpc v1.30 and later
 )
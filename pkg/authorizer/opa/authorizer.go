@@ -0,0 +1,141 @@
+package opa
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+const (
+	// DefaultQuery is the rule that decides whether a request is allowed.
+	DefaultQuery = "data.api.rbac.allow"
+	// DefaultDenyQuery is the rule that decides whether a request is
+	// explicitly denied, whether or not DefaultQuery allows it.
+	DefaultDenyQuery = "data.api.rbac.deny"
+)
+
+// Authorizer authorizes requests by evaluating a rule of the policies and
+// data loaded into an OPA instance, against input built from the request
+// attributes:
+//
+//	{
+//	  "user": "alice",
+//	  "groups": ["dev"],
+//	  "verb": "get",
+//	  "resourceRequest": true,
+//	  "namespace": "default",
+//	  "apiGroup": "apps",
+//	  "apiVersion": "v1",
+//	  "resource": "deployments",
+//	  "subresource": "",
+//	  "resourceName": "web"
+//	}
+//
+// Non resource requests set "nonResourceURL" instead of the resource fields.
+//
+// A request that is not allowed gets no opinion, so that the authorizers
+// after this one, like the RBAC authorizer of a kube-apiserver, may still
+// allow it. Policies that must prevent that define the deny rule: the
+// requests it evaluates to true for are denied.
+type Authorizer struct {
+	manager   *plugins.Manager
+	query     string
+	denyQuery string
+}
+
+var _ authorizer.Authorizer = &Authorizer{}
+
+// New returns an Authorizer that evaluates DefaultQuery and
+// DefaultDenyQuery against the store and the compiled policies of manager.
+func New(manager *plugins.Manager) *Authorizer {
+	return &Authorizer{
+		manager:   manager,
+		query:     DefaultQuery,
+		denyQuery: DefaultDenyQuery,
+	}
+}
+
+// Authorize denies a request if the deny rule evaluates to true, and
+// allows it if the allow rule does. Otherwise it has no opinion. Both rules
+// are evaluated against the same snapshot of the store.
+func (a *Authorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	txn, err := a.manager.Store.NewTransaction(ctx)
+	if err != nil {
+		return authorizer.DecisionNoOpinion, "", fmt.Errorf("failed to open a transaction: %v", err)
+	}
+	defer a.manager.Store.Abort(ctx, txn)
+	input := Input(attrs)
+
+	denied, _, err := a.eval(ctx, txn, a.denyQuery, input)
+	if err != nil {
+		return authorizer.DecisionNoOpinion, "", err
+	}
+	if denied {
+		return authorizer.DecisionDeny, fmt.Sprintf("denied by %s", a.denyQuery), nil
+	}
+
+	allowed, defined, err := a.eval(ctx, txn, a.query, input)
+	if err != nil {
+		return authorizer.DecisionNoOpinion, "", err
+	}
+	if !defined {
+		// the rule is undefined, most likely no policy is loaded
+		return authorizer.DecisionNoOpinion, fmt.Sprintf("%s is undefined", a.query), nil
+	}
+	if !allowed {
+		return authorizer.DecisionNoOpinion, "", nil
+	}
+	return authorizer.DecisionAllow, fmt.Sprintf("allowed by %s", a.query), nil
+}
+
+// eval evaluates the boolean rule query in txn. defined is false if the
+// rule is undefined, as the deny rule of the policies that have none.
+func (a *Authorizer) eval(ctx context.Context, txn storage.Transaction, query string, input map[string]interface{}) (value, defined bool, err error) {
+	rs, err := rego.New(
+		rego.Query(query),
+		rego.Compiler(a.manager.GetCompiler()),
+		rego.Store(a.manager.Store),
+		rego.Transaction(txn),
+		rego.Input(input),
+	).Eval(ctx)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to evaluate %s: %v", query, err)
+	}
+
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return false, false, nil
+	}
+	value, ok := rs[0].Expressions[0].Value.(bool)
+	if !ok {
+		return false, true, fmt.Errorf("%s evaluated to %v, expected a boolean", query, rs[0].Expressions[0].Value)
+	}
+	return value, true, nil
+}
+
+// Input returns the policy input for attrs.
+func Input(attrs authorizer.Attributes) map[string]interface{} {
+	input := map[string]interface{}{
+		"verb":            attrs.GetVerb(),
+		"resourceRequest": attrs.IsResourceRequest(),
+	}
+	if u := attrs.GetUser(); u != nil {
+		input["user"] = u.GetName()
+		input["groups"] = u.GetGroups()
+	}
+
+	if !attrs.IsResourceRequest() {
+		input["nonResourceURL"] = attrs.GetPath()
+		return input
+	}
+	input["namespace"] = attrs.GetNamespace()
+	input["apiGroup"] = attrs.GetAPIGroup()
+	input["apiVersion"] = attrs.GetAPIVersion()
+	input["resource"] = attrs.GetResource()
+	input["subresource"] = attrs.GetSubresource()
+	input["resourceName"] = attrs.GetName()
+	return input
+}
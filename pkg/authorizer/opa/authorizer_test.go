@@ -0,0 +1,248 @@
+package opa
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// rbacData is the RBAC data document the replicator writes, with an admin
+// and a user granted reads of deployments.
+const rbacData = `{
+	"roles": {"alice": ["admin"], "mallory": ["admin"], "bob": ["dev"]},
+	"permissions": {"dev": [
+		{"verbs": ["get"], "apiGroups": ["apps"], "resources": ["deployments"], "resourceNames": [], "nonResourceURLs": []}
+	]}
+}`
+
+// denyPolicy denies every request of mallory, and the deletes of the
+// deployments named prod.
+const denyPolicy = `package api.rbac
+
+deny {
+	input.user == "mallory"
+}
+
+deny {
+	input.verb == "delete"
+	input.resource == "deployments"
+	input.resourceName == "prod"
+}
+`
+
+// newTestAuthorizer returns an Authorizer of the policies of api.rego and
+// of the files with the contents of policies.
+func newTestAuthorizer(t *testing.T, policies ...string) *Authorizer {
+	t.Helper()
+	paths := []string{"../../../api.rego"}
+	dir := t.TempDir()
+	for i, policy := range policies {
+		path := filepath.Join(dir, fmt.Sprintf("policy%d.rego", i))
+		if err := ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	files, err := loader.All(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := util.UnmarshalJSON([]byte(rbacData), &data); err != nil {
+		t.Fatal(err)
+	}
+	store := inmem.NewFromObject(map[string]interface{}{"api": map[string]interface{}{"rbac": data}})
+	manager, err := plugins.New(nil, "test", store, plugins.InitFiles(*files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return New(manager)
+}
+
+func resourceAttributes(name, verb, resourceName string) authorizer.AttributesRecord {
+	return authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: name},
+		Verb:            verb,
+		Namespace:       "default",
+		APIGroup:        "apps",
+		APIVersion:      "v1",
+		Resource:        "deployments",
+		Name:            resourceName,
+		ResourceRequest: true,
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policies []string
+		attrs    authorizer.Attributes
+		decision authorizer.Decision
+		reason   string
+		err      bool
+	}{
+		{
+			name:     "admin",
+			attrs:    resourceAttributes("alice", "delete", "web"),
+			decision: authorizer.DecisionAllow,
+			reason:   "allowed by data.api.rbac.allow",
+		},
+		{
+			name:     "granted",
+			attrs:    resourceAttributes("bob", "get", "web"),
+			decision: authorizer.DecisionAllow,
+			reason:   "allowed by data.api.rbac.allow",
+		},
+		{
+			name:     "not granted",
+			attrs:    resourceAttributes("bob", "delete", "web"),
+			decision: authorizer.DecisionNoOpinion,
+		},
+		{
+			name:     "no deny rule",
+			attrs:    resourceAttributes("mallory", "get", "web"),
+			decision: authorizer.DecisionAllow,
+			reason:   "allowed by data.api.rbac.allow",
+		},
+		{
+			name:     "denied user",
+			policies: []string{denyPolicy},
+			attrs:    resourceAttributes("mallory", "get", "web"),
+			decision: authorizer.DecisionDeny,
+			reason:   "denied by data.api.rbac.deny",
+		},
+		{
+			name:     "denied admin request",
+			policies: []string{denyPolicy},
+			attrs:    resourceAttributes("alice", "delete", "prod"),
+			decision: authorizer.DecisionDeny,
+			reason:   "denied by data.api.rbac.deny",
+		},
+		{
+			name:     "not denied",
+			policies: []string{denyPolicy},
+			attrs:    resourceAttributes("alice", "delete", "web"),
+			decision: authorizer.DecisionAllow,
+			reason:   "allowed by data.api.rbac.allow",
+		},
+		{
+			name:     "not a boolean",
+			policies: []string{"package api.rbac\n\ndeny = \"yes\"\n"},
+			attrs:    resourceAttributes("bob", "get", "web"),
+			decision: authorizer.DecisionNoOpinion,
+			err:      true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestAuthorizer(t, tc.policies...)
+			decision, reason, err := a.Authorize(context.Background(), tc.attrs)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected an error %v, got %v", tc.err, err)
+			}
+			if decision != tc.decision || reason != tc.reason {
+				t.Errorf("expected the decision %v for %q, got %v for %q", tc.decision, tc.reason, decision, reason)
+			}
+		})
+	}
+}
+
+func TestAuthorizeUndefined(t *testing.T) {
+	manager, err := plugins.New(nil, "test", inmem.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	decision, reason, err := New(manager).Authorize(context.Background(), resourceAttributes("alice", "get", "web"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision != authorizer.DecisionNoOpinion || reason != "data.api.rbac.allow is undefined" {
+		t.Errorf("expected no opinion on an undefined rule, got %v for %q", decision, reason)
+	}
+}
+
+func TestInput(t *testing.T) {
+	groups := []string{"dev", "system:authenticated"}
+	testCases := []struct {
+		name     string
+		attrs    authorizer.Attributes
+		expected map[string]interface{}
+	}{
+		{
+			name: "resource",
+			attrs: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{Name: "alice", Groups: groups},
+				Verb:            "get",
+				Namespace:       "default",
+				APIGroup:        "apps",
+				APIVersion:      "v1",
+				Resource:        "deployments",
+				Subresource:     "scale",
+				Name:            "web",
+				ResourceRequest: true,
+			},
+			expected: map[string]interface{}{
+				"user":            "alice",
+				"groups":          groups,
+				"verb":            "get",
+				"resourceRequest": true,
+				"namespace":       "default",
+				"apiGroup":        "apps",
+				"apiVersion":      "v1",
+				"resource":        "deployments",
+				"subresource":     "scale",
+				"resourceName":    "web",
+			},
+		},
+		{
+			name: "non resource",
+			attrs: authorizer.AttributesRecord{
+				User: &user.DefaultInfo{Name: "alice", Groups: groups},
+				Verb: "get",
+				Path: "/metrics",
+			},
+			expected: map[string]interface{}{
+				"user":            "alice",
+				"groups":          groups,
+				"verb":            "get",
+				"resourceRequest": false,
+				"nonResourceURL":  "/metrics",
+			},
+		},
+		{
+			name: "no user",
+			attrs: authorizer.AttributesRecord{
+				Verb: "get",
+				Path: "/healthz",
+			},
+			expected: map[string]interface{}{
+				"verb":            "get",
+				"resourceRequest": false,
+				"nonResourceURL":  "/healthz",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if input := Input(tc.attrs); !reflect.DeepEqual(input, tc.expected) {
+				t.Errorf("expected the input %#v, got %#v", tc.expected, input)
+			}
+		})
+	}
+}
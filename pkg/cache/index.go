@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Indexer extends Store with multiple indices and restricts each
+// accumulator to simply hold the current object (and be empty after
+// Delete).
+//
+// There are three kinds of strings here:
+//  1. a storage key, as defined in the Store interface,
+//  2. a name of an index, and
+//  3. an "indexed value", which is produced by the storage.IndexerFunc
+//     registered under the name of the index.
+//
+// The indexers are the same storage.IndexerFuncs the cacher indexes its
+// watchers with, so a single indexed value is computed for every object.
+type Indexer interface {
+	Store
+	// Index returns the stored objects whose indexed value
+	// is the indexed value of the given object
+	Index(indexName string, obj runtime.Object) ([]runtime.Object, error)
+	// IndexKeys returns the storage keys of the stored objects whose
+	// indexed value is indexedValue
+	IndexKeys(indexName, indexedValue string) ([]string, error)
+	// ListIndexFuncValues returns all the indexed values of the given index
+	ListIndexFuncValues(indexName string) []string
+	// ByIndex returns the stored objects whose indexed value is
+	// indexedValue
+	ByIndex(indexName, indexedValue string) ([]runtime.Object, error)
+	// GetIndexers return the indexers
+	GetIndexers() storage.IndexerFuncs
+
+	// AddIndexers adds more indexers to this store.  If you call this after you already have data
+	// in the store, the results are undefined.
+	AddIndexers(newIndexers storage.IndexerFuncs) error
+}
+
+// Index maps the indexed value to a set of keys in the store that match on that value
+type Index map[string]sets.String
+
+// Indices maps a name to an Index
+type Indices map[string]Index
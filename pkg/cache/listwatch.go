@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+)
+
+// ListWatch implements ListerWatcher for the objects kept under a key of a
+// storage.Interface, for the controllers that are handed the storage of a
+// resource instead of the Store of its registry.
+type ListWatch struct {
+	storage     storage.Interface
+	key         string
+	newListFunc func() runtime.Object
+}
+
+var _ ListerWatcher = &ListWatch{}
+
+// NewListWatchFromStorage returns a ListWatch of the objects kept under key
+// in s. newListFunc returns an empty list of the objects.
+func NewListWatchFromStorage(s storage.Interface, key string, newListFunc func() runtime.Object) *ListWatch {
+	return &ListWatch{
+		storage:     s,
+		key:         key,
+		newListFunc: newListFunc,
+	}
+}
+
+// NewList implements rest.Lister.
+func (lw *ListWatch) NewList() runtime.Object {
+	return lw.newListFunc()
+}
+
+// List implements rest.Lister.
+func (lw *ListWatch) List(ctx context.Context, options *meta.ListOptions) (runtime.Object, error) {
+	if options == nil {
+		options = &meta.ListOptions{}
+	}
+	pred := predicate(options)
+	pred.Limit = options.Limit
+	pred.Continue = options.Continue
+	list := lw.newListFunc()
+	err := lw.storage.List(ctx, lw.key, storage.ListOptions{ResourceVersion: options.ResourceVersion, Predicate: pred}, list)
+	return list, err
+}
+
+// Watch implements rest.Watcher. Progress notifications are requested from
+// the storage, so that bookmarks are sent even when the watch is not served
+// by a watch cache.
+func (lw *ListWatch) Watch(ctx context.Context, options *meta.ListOptions) (watch.Interface, error) {
+	if options == nil {
+		options = &meta.ListOptions{}
+	}
+	pred := predicate(options)
+	pred.AllowWatchBookmarks = options.AllowWatchBookmarks
+	return lw.storage.WatchList(ctx, lw.key, storage.ListOptions{
+		ResourceVersion: options.ResourceVersion,
+		Predicate:       pred,
+		ProgressNotify:  options.AllowWatchBookmarks,
+	})
+}
+
+// predicate returns the predicate matching the selectors of options.
+func predicate(options *meta.ListOptions) storage.SelectionPredicate {
+	pred := storage.Everything
+	if options.LabelSelector == nil && options.FieldSelector == nil {
+		return pred
+	}
+	if options.LabelSelector != nil {
+		pred.Label = options.LabelSelector
+	}
+	if options.FieldSelector != nil {
+		pred.Field = options.FieldSelector
+	}
+	pred.GetAttrs = storage.DefaultClusterScopedAttr
+	return pred
+}
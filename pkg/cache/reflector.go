@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// ListerWatcher is any object that knows how to perform an initial list and
+// start a watch on a resource, e.g. the Store of a registry.
+type ListerWatcher interface {
+	rest.Lister
+	rest.Watcher
+}
+
+// Reflector watches a specified resource and causes all changes to be reflected in the given store.
+type Reflector struct {
+	// name identifies this reflector. By default it will be the type of the
+	// lists returned by the ListerWatcher.
+	name string
+	// The destination to sync up with the watch source
+	store Store
+	// listerWatcher is used to perform lists and watches.
+	listerWatcher ListerWatcher
+
+	// backoff manages backoff of ListWatch
+	backoffManager wait.BackoffManager
+
+	resyncPeriod time.Duration
+	// ShouldResync is invoked periodically and whenever it returns `true` the Store's Resync operation is invoked
+	ShouldResync func() bool
+
+	// lastSyncResourceVersion is the resource version token last
+	// observed when doing a sync with the underlying store
+	// it is thread safe, but not synchronized with the underlying store
+	lastSyncResourceVersion string
+	// isLastSyncResourceVersionUnavailable is true if the previous list or watch request with
+	// lastSyncResourceVersion failed with an "expired" or "too large resource version" error.
+	isLastSyncResourceVersionUnavailable bool
+	// lastSyncResourceVersionMutex guards read/write access to lastSyncResourceVersion
+	lastSyncResourceVersionMutex sync.RWMutex
+}
+
+// NewReflector creates a new Reflector object which will keep the
+// given store up to date with the server's contents for the given
+// resource. Reflector promises to only put things in the store that
+// have the type of the items of the lists returned by lw.
+// If resyncPeriod is non-zero, then the reflector will periodically
+// consult its ShouldResync function to determine whether to invoke
+// the Store's Resync operation; `ShouldResync==nil` means always
+// "yes".  This enables you to use reflectors to periodically process
+// everything as well as incrementally processing the things that
+// change.
+func NewReflector(lw ListerWatcher, store Store, resyncPeriod time.Duration) *Reflector {
+	return NewNamedReflector(reflect.TypeOf(lw.NewList()).String(), lw, store, resyncPeriod)
+}
+
+// NewNamedReflector same as NewReflector, but with a specified name for logging
+func NewNamedReflector(name string, lw ListerWatcher, store Store, resyncPeriod time.Duration) *Reflector {
+	realClock := &clock.RealClock{}
+	return &Reflector{
+		name:          name,
+		listerWatcher: lw,
+		store:         store,
+		// We used to make the call every 1sec (1 QPS), the goal here is to achieve ~98% traffic reduction when
+		// the storage is not healthy. With these parameters, backoff will stop at [30,60) sec interval which is
+		// 0.22 QPS. If we don't backoff for 2min, assume the storage is healthy and we reset the backoff.
+		backoffManager: wait.NewExponentialBackoffManager(800*time.Millisecond, 30*time.Second, 2*time.Minute, 2.0, 1.0, realClock),
+		resyncPeriod:   resyncPeriod,
+	}
+}
+
+// Run repeatedly uses the reflector's ListAndWatch to fetch all the
+// objects and subsequent deltas.
+// Run will exit when stopCh is closed.
+func (r *Reflector) Run(stopCh <-chan struct{}) {
+	klog.V(3).InfoS("Starting reflector", "reflector", r.name, "resyncPeriod", r.resyncPeriod)
+	wait.BackoffUntil(func() {
+		if err := r.ListAndWatch(stopCh); err != nil {
+			klog.ErrorS(err, "Failed to list and watch", "reflector", r.name)
+		}
+	}, r.backoffManager, true, stopCh)
+	klog.V(3).InfoS("Stopping reflector", "reflector", r.name)
+}
+
+var (
+	// nothing will ever be sent down this channel
+	neverExitWatch <-chan time.Time = make(chan time.Time)
+
+	// Used to indicate that watching stopped because of a signal from the stop
+	// channel passed in from a client of the reflector.
+	errorStopRequested = fmt.Errorf("stop requested")
+)
+
+// resyncChan returns a channel which will receive something when a resync is
+// required, and a cleanup function.
+func (r *Reflector) resyncChan() (<-chan time.Time, func() bool) {
+	if r.resyncPeriod == 0 {
+		return neverExitWatch, func() bool { return false }
+	}
+	// The cleanup function is required: imagine the scenario where watches
+	// always fail so we end up listing frequently. Then, if we don't
+	// manually stop the timer, we could end up with many timers active
+	// concurrently.
+	t := time.NewTimer(r.resyncPeriod)
+	return t.C, t.Stop
+}
+
+// ListAndWatch first lists all items and get the resource version at the moment of call,
+// and then use the resource version to watch.
+// It returns error if ListAndWatch didn't even try to initialize watch.
+func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
+	klog.V(3).InfoS("Listing and watching", "reflector", r.name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	options := &meta.ListOptions{ResourceVersion: r.relistResourceVersion()}
+	list, err := r.listerWatcher.List(ctx, options)
+	if err != nil {
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			r.setIsLastSyncResourceVersionUnavailable(true)
+		}
+		return fmt.Errorf("failed to list %v: %v", r.name, err)
+	}
+	r.setIsLastSyncResourceVersionUnavailable(false) // list was successful
+
+	listMetaInterface, err := meta.ListAccessor(list)
+	if err != nil {
+		return fmt.Errorf("unable to understand list result %#v: %v", list, err)
+	}
+	resourceVersion := listMetaInterface.GetResourceVersion()
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return fmt.Errorf("unable to understand list result %#v (%v)", list, err)
+	}
+	if err := r.store.Replace(items, resourceVersion); err != nil {
+		return fmt.Errorf("unable to sync list result: %v", err)
+	}
+	r.setLastSyncResourceVersion(resourceVersion)
+	klog.V(4).InfoS("Listed", "reflector", r.name, "count", len(items), "resourceVersion", resourceVersion)
+
+	resyncerrc := make(chan error, 1)
+	cancelCh := make(chan struct{})
+	defer close(cancelCh)
+	go func() {
+		resyncCh, cleanup := r.resyncChan()
+		defer func() {
+			cleanup() // Call the last one written into cleanup
+		}()
+		for {
+			select {
+			case <-resyncCh:
+			case <-stopCh:
+				return
+			case <-cancelCh:
+				return
+			}
+			if r.ShouldResync == nil || r.ShouldResync() {
+				klog.V(4).InfoS("Forcing resync", "reflector", r.name)
+				if err := r.store.Resync(); err != nil {
+					resyncerrc <- err
+					return
+				}
+			}
+			cleanup()
+			resyncCh, cleanup = r.resyncChan()
+		}
+	}()
+
+	// The retry watcher restarts the watch from the last resource version
+	// it has seen whenever it ends, it only has to be replaced when that
+	// resource version has been compacted.
+	w, err := watch.NewRetryWatcher(resourceVersion, func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+		return r.listerWatcher.Watch(ctx, &meta.ListOptions{
+			Watch:           true,
+			ResourceVersion: resourceVersion,
+			// To reduce load on the storage on watch restarts, a reflector
+			// asks for bookmarks, so that it resumes from a recent resource
+			// version even if none of the watched objects changed.
+			AllowWatchBookmarks: true,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := r.watchHandler(w, resyncerrc, stopCh); err != nil {
+		if err != errorStopRequested {
+			klog.V(4).InfoS("Watch ended with an error", "reflector", r.name, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// watchHandler applies the events of w to the store, until the watch has
+// to be replaced.
+func (r *Reflector) watchHandler(w *watch.RetryWatcher, errc chan error, stopCh <-chan struct{}) error {
+	// Stopping the watcher should be idempotent and if we return from this function there's no way
+	// we're coming back in with the same watch interface.
+	defer w.Stop()
+
+	eventCount := 0
+	for {
+		select {
+		case <-stopCh:
+			return errorStopRequested
+		case err := <-errc:
+			return err
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				klog.V(4).InfoS("Watch closed", "reflector", r.name, "eventCount", eventCount)
+				return nil
+			}
+			if watch.IsRelistRequired(event) {
+				// The resource version the watch should resume from has
+				// been compacted, relist from the latest state.
+				r.setIsLastSyncResourceVersionUnavailable(true)
+				klog.V(2).InfoS("Watch cannot be resumed, relisting", "reflector", r.name, "resourceVersion", r.LastSyncResourceVersion())
+				return nil
+			}
+			if event.Type == watch.Error {
+				if status, ok := event.Object.(*meta.Status); ok {
+					return apierrors.FromObject(&status.Status)
+				}
+				return fmt.Errorf("watch of %s failed: %#v", r.name, event.Object)
+			}
+			accessor, err := meta.Accessor(event.Object)
+			if err != nil {
+				klog.ErrorS(err, "Unable to understand watch event", "reflector", r.name, "event", event)
+				continue
+			}
+			switch event.Type {
+			case watch.Added:
+				err = r.store.Add(event.Object)
+			case watch.Modified:
+				err = r.store.Update(event.Object)
+			case watch.Deleted:
+				err = r.store.Delete(event.Object)
+			default:
+				err = fmt.Errorf("unable to understand watch event %#v", event)
+			}
+			if err != nil {
+				klog.ErrorS(err, "Unable to apply watch event to the store", "reflector", r.name, "type", event.Type)
+			}
+			r.setLastSyncResourceVersion(accessor.GetResourceVersion())
+			eventCount++
+		}
+	}
+}
+
+// LastSyncResourceVersion is the resource version observed when last sync with the underlying store
+// The value returned is not synchronized with access to the underlying store and is not thread-safe
+func (r *Reflector) LastSyncResourceVersion() string {
+	r.lastSyncResourceVersionMutex.RLock()
+	defer r.lastSyncResourceVersionMutex.RUnlock()
+	return r.lastSyncResourceVersion
+}
+
+func (r *Reflector) setLastSyncResourceVersion(v string) {
+	r.lastSyncResourceVersionMutex.Lock()
+	defer r.lastSyncResourceVersionMutex.Unlock()
+	r.lastSyncResourceVersion = v
+}
+
+// relistResourceVersion determines the resource version the reflector should list or relist from.
+// Returns "0" for the initial list, which may be served from the watch
+// cache, the last sync resource version for a relist, so the list is not
+// older than what the store has already seen, and "" if the last sync
+// resource version is unavailable, for a consistent read from the storage.
+func (r *Reflector) relistResourceVersion() string {
+	r.lastSyncResourceVersionMutex.RLock()
+	defer r.lastSyncResourceVersionMutex.RUnlock()
+
+	if r.isLastSyncResourceVersionUnavailable {
+		// Since this reflector makes a list request at a resource version
+		// the storage may have compacted, use the latest state if the
+		// previous request failed.
+		return ""
+	}
+	if r.lastSyncResourceVersion == "" {
+		// For performance reasons, initial list performed by reflector uses "0" as resource version to allow it to
+		// be served from the watch cache if it is enabled.
+		return "0"
+	}
+	return r.lastSyncResourceVersion
+}
+
+// setIsLastSyncResourceVersionUnavailable sets if the last list or watch request with lastSyncResourceVersion returned
+// an "expired" error.
+func (r *Reflector) setIsLastSyncResourceVersionUnavailable(isUnavailable bool) {
+	r.lastSyncResourceVersionMutex.Lock()
+	defer r.lastSyncResourceVersionMutex.Unlock()
+	r.isLastSyncResourceVersionUnavailable = isUnavailable
+}
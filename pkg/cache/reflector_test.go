@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// testLW is a ListerWatcher of users. Every list is answered by ListFunc,
+// every watch is a FakeWatcher sent to watchers.
+type testLW struct {
+	ListFunc func(options *meta.ListOptions) (runtime.Object, error)
+	// WatchErr is returned by the next watch instead of a watcher.
+	WatchErr error
+
+	lock     sync.Mutex
+	lists    []meta.ListOptions
+	watches  []meta.ListOptions
+	watchers chan *watch.FakeWatcher
+}
+
+func newTestLW(listFunc func(options *meta.ListOptions) (runtime.Object, error)) *testLW {
+	return &testLW{
+		ListFunc: listFunc,
+		watchers: make(chan *watch.FakeWatcher, 10),
+	}
+}
+
+func (lw *testLW) NewList() runtime.Object {
+	return &model.UserList{}
+}
+
+func (lw *testLW) List(_ context.Context, options *meta.ListOptions) (runtime.Object, error) {
+	lw.lock.Lock()
+	lw.lists = append(lw.lists, *options)
+	lw.lock.Unlock()
+	return lw.ListFunc(options)
+}
+
+func (lw *testLW) Watch(_ context.Context, options *meta.ListOptions) (watch.Interface, error) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	lw.watches = append(lw.watches, *options)
+	if err := lw.WatchErr; err != nil {
+		lw.WatchErr = nil
+		return nil, err
+	}
+	w := watch.NewFake()
+	lw.watchers <- w
+	return w, nil
+}
+
+// nextWatcher returns the watcher of the next watch.
+func (lw *testLW) nextWatcher(t *testing.T) *watch.FakeWatcher {
+	t.Helper()
+	select {
+	case w := <-lw.watchers:
+		return w
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for a watch")
+		return nil
+	}
+}
+
+// listedVersions returns the resource versions of the lists made so far.
+func (lw *testLW) listedVersions() []string {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	versions := make([]string, 0, len(lw.lists))
+	for _, options := range lw.lists {
+		versions = append(versions, options.ResourceVersion)
+	}
+	return versions
+}
+
+func (lw *testLW) watchOptions() []meta.ListOptions {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return append([]meta.ListOptions(nil), lw.watches...)
+}
+
+func newUserList(resourceVersion string, users ...*model.User) *model.UserList {
+	list := &model.UserList{ListMeta: meta.ListMeta{ResourceVersion: resourceVersion}}
+	for _, user := range users {
+		list.Items = append(list.Items, *user)
+	}
+	return list
+}
+
+func expectStore(t *testing.T, store Store, expected ...string) {
+	t.Helper()
+	if got := names(store.List()); !equalStrings(got, expected) {
+		t.Errorf("expected the store to hold %v, got %v", expected, got)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReflectorRelistAfterExpiredResourceVersion(t *testing.T) {
+	testCases := []struct {
+		name string
+		// expire makes the watch of lw fail because its resource version
+		// has been compacted.
+		expire func(t *testing.T, lw *testLW, w *watch.FakeWatcher)
+	}{
+		{
+			name: "error event",
+			expire: func(t *testing.T, lw *testLW, w *watch.FakeWatcher) {
+				w.Error(&meta.Status{Status: apierrors.NewResourceExpired("too old resource version").ErrStatus})
+			},
+		},
+		{
+			name: "gone error on resume",
+			expire: func(t *testing.T, lw *testLW, w *watch.FakeWatcher) {
+				lw.lock.Lock()
+				lw.WatchErr = apierrors.NewGone("too old resource version")
+				lw.lock.Unlock()
+				// the watch ends and cannot be resumed
+				w.Stop()
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lists := []*model.UserList{
+				newUserList("10", newUser("alice", "9", nil)),
+				newUserList("20", newUser("alice", "15", nil), newUser("carol", "18", nil)),
+			}
+			lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+				list := lists[0]
+				lists = lists[1:]
+				return list, nil
+			})
+			store := NewStore(MetaNameKeyFunc)
+			r := NewReflector(lw, store, 0)
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			errc := make(chan error, 1)
+			go func() { errc <- r.ListAndWatch(stopCh) }()
+			w := lw.nextWatcher(t)
+			w.Add(newUser("bob", "11", nil))
+			tc.expire(t, lw, w)
+			select {
+			case err := <-errc:
+				if err != nil {
+					t.Fatalf("expected the watch to end for a relist, got %v", err)
+				}
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatalf("timed out waiting for the watch to end")
+			}
+			expectStore(t, store, "alice", "bob")
+			if rv := r.LastSyncResourceVersion(); rv != "11" {
+				t.Errorf("expected the last sync resource version 11, got %q", rv)
+			}
+			// the compacted resource version is not listed from
+			if rv := r.relistResourceVersion(); rv != "" {
+				t.Errorf("expected a relist from the latest state, got %q", rv)
+			}
+
+			go func() { errc <- r.ListAndWatch(stopCh) }()
+			lw.nextWatcher(t)
+			expectStore(t, store, "alice", "carol")
+			if rv := r.relistResourceVersion(); rv != "20" {
+				t.Errorf("expected the next relist from 20, got %q", rv)
+			}
+			if versions := lw.listedVersions(); !equalStrings(versions, []string{"0", ""}) {
+				t.Errorf("expected the lists from 0 and the latest state, got %q", versions)
+			}
+			watches := lw.watchOptions()
+			if rv := watches[len(watches)-1].ResourceVersion; rv != "20" {
+				t.Errorf("expected the watch to start from the relist, got %q", rv)
+			}
+			for _, options := range watches {
+				if !options.Watch || !options.AllowWatchBookmarks {
+					t.Errorf("expected a watch with bookmarks, got %+v", options)
+				}
+			}
+		})
+	}
+}
+
+func TestReflectorListExpired(t *testing.T) {
+	expired := true
+	lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+		if expired {
+			expired = false
+			return nil, apierrors.NewResourceExpired("too old resource version")
+		}
+		return newUserList("10"), nil
+	})
+	r := NewReflector(lw, NewStore(MetaNameKeyFunc), 0)
+	r.setLastSyncResourceVersion("5")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := r.ListAndWatch(stopCh); err == nil {
+		t.Fatalf("expected the list to fail")
+	}
+	if rv := r.relistResourceVersion(); rv != "" {
+		t.Errorf("expected a relist from the latest state, got %q", rv)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- r.ListAndWatch(stopCh) }()
+	lw.nextWatcher(t).Stop()
+	if versions := lw.listedVersions(); !equalStrings(versions, []string{"5", ""}) {
+		t.Errorf("expected the lists from 5 and the latest state, got %q", versions)
+	}
+	if rv := r.relistResourceVersion(); rv != "10" {
+		t.Errorf("expected the next relist from 10, got %q", rv)
+	}
+}
+
+func TestReflectorResumesFromBookmark(t *testing.T) {
+	lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+		return newUserList("10"), nil
+	})
+	r := NewReflector(lw, NewStore(MetaNameKeyFunc), 0)
+	stopCh := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- r.ListAndWatch(stopCh) }()
+
+	w := lw.nextWatcher(t)
+	w.Action(watch.Bookmark, newUser("", "15", nil))
+	w.Stop()
+	// the watch is resumed without a relist
+	w = lw.nextWatcher(t)
+	watches := lw.watchOptions()
+	if rv := watches[len(watches)-1].ResourceVersion; rv != "15" {
+		t.Errorf("expected the watch to resume from the bookmark, got %q", rv)
+	}
+	if versions := lw.listedVersions(); len(versions) != 1 {
+		t.Errorf("expected a single list, got %q", versions)
+	}
+
+	close(stopCh)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected a stopped reflector to return nil, got %v", err)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for the reflector to stop")
+	}
+}
+
+// resyncStore counts the resyncs of a Store.
+type resyncStore struct {
+	Store
+	resyncs chan struct{}
+}
+
+func (s *resyncStore) Resync() error {
+	s.resyncs <- struct{}{}
+	return nil
+}
+
+func TestReflectorResync(t *testing.T) {
+	lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+		return newUserList("10"), nil
+	})
+	store := &resyncStore{Store: NewStore(MetaNameKeyFunc), resyncs: make(chan struct{}, 10)}
+	r := NewReflector(lw, store, 10*time.Millisecond)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go r.ListAndWatch(stopCh)
+
+	// without ShouldResync every period resyncs
+	for i := 0; i < 3; i++ {
+		select {
+		case <-store.resyncs:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("timed out waiting for resync %d", i)
+		}
+	}
+}
@@ -0,0 +1,717 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// SharedInformer provides eventually consistent linkage of its
+// clients to the authoritative state of a given collection of
+// objects.  An object is identified by its name, as given by
+// MetaNameKeyFunc.  A SharedInformer maintains a local cache of the
+// objects of the collection, exposed by GetStore(), and notifies the
+// event handlers added to it of the changes made to that cache.
+//
+// The local cache starts out empty, and gets populated and updated
+// by a Reflector that lists and watches the ListerWatcher the informer
+// is given, e.g. the Store of a registry. A controller that needs the
+// objects of a resource can thus share a single list and watch with
+// every other controller of the server, instead of doing its own.
+//
+// As a simple example, if a collection of objects is henceforth
+// unchanging, a SharedInformer is created that links to that
+// collection, and that SharedInformer is `Run()` then that
+// SharedInformer's cache eventually holds an exact copy of that
+// collection (unless it is stopped too soon, the authoritative state
+// service ends, or communication problems between the two
+// persistently thwart achievement).
+//
+// Each event handler is notified asynchronously, in its own goroutine,
+// in the order the changes are made to the cache, and with an
+// unbounded buffer between the cache and the handler: a slow handler
+// does not slow down the other ones, but its notifications pile up.
+// A handler can also ask to be notified of all the objects of the
+// cache periodically, see AddEventHandlerWithResyncPeriod.
+//
+// A client must process each notification promptly; a SharedInformer
+// is not engineered to deal well with a large backlog of notifications
+// to deliver.  Lengthy processing should be passed off to something
+// else, for example through a work queue.
+type SharedInformer interface {
+	// AddEventHandler adds an event handler to the shared informer using the shared informer's resync
+	// period.  Events to a single handler are delivered sequentially, but there is no coordination
+	// between different handlers.
+	AddEventHandler(handler ResourceEventHandler)
+	// AddEventHandlerWithResyncPeriod adds an event handler to the
+	// shared informer with the requested resync period; zero means
+	// this handler does not care about resyncs.  The resync operation
+	// consists of delivering to the handler an update notification
+	// for every object in the informer's local cache; it does not add
+	// any interactions with the authoritative storage.  Some
+	// informers do no resyncs at all, not even for handlers added
+	// with a non-zero resyncPeriod.  For an informer that does
+	// resyncs, and for each handler that requests resyncs, that
+	// informer develops a nominal resync period that is no shorter
+	// than the requested period but may be longer.  The actual time
+	// between any two resyncs may be longer than the nominal period
+	// because the implementation takes time to do work and there may
+	// be competing load and scheduling noise.
+	AddEventHandlerWithResyncPeriod(handler ResourceEventHandler, resyncPeriod time.Duration)
+	// GetStore returns the informer's local cache as a Store.
+	GetStore() Store
+	// Run starts and runs the shared informer, returning after it stops.
+	// The informer will be stopped when stopCh is closed.
+	Run(stopCh <-chan struct{})
+	// HasSynced returns true if the shared informer's store has been
+	// informed by at least one full LIST of the authoritative state
+	// of the informer's object collection.  This is unrelated to "resync".
+	HasSynced() bool
+	// LastSyncResourceVersion is the resource version observed when last synced with the underlying
+	// store. The value returned is not synchronized with access to the underlying store and is not
+	// thread-safe.
+	LastSyncResourceVersion() string
+}
+
+// SharedIndexInformer provides add and get Indexers ability based on SharedInformer.
+type SharedIndexInformer interface {
+	SharedInformer
+	// AddIndexers add indexers to the informer before it starts.
+	AddIndexers(indexers storage.IndexerFuncs) error
+	GetIndexer() Indexer
+}
+
+// NewSharedInformer creates a new instance for the listwatcher.
+func NewSharedInformer(lw ListerWatcher, defaultEventHandlerResyncPeriod time.Duration) SharedInformer {
+	return NewSharedIndexInformer(lw, defaultEventHandlerResyncPeriod, storage.IndexerFuncs{})
+}
+
+// NewSharedIndexInformer creates a new instance for the listwatcher.
+// The created informer will not do resyncs if the given
+// defaultEventHandlerResyncPeriod is zero.  Otherwise: for each
+// handler that with a non-zero requested resync period, whether added
+// before or after the informer starts, the nominal resync period is
+// the requested resync period rounded up to a multiple of the
+// informer's resync checking period.  Such an informer's resync
+// checking period is established when the informer starts running,
+// and is the maximum of (a) the minimum of the resync periods
+// requested before the informer starts and the
+// defaultEventHandlerResyncPeriod given here and (b) the constant
+// `minimumResyncPeriod` defined in this file.
+func NewSharedIndexInformer(lw ListerWatcher, defaultEventHandlerResyncPeriod time.Duration, indexers storage.IndexerFuncs) SharedIndexInformer {
+	return &sharedIndexInformer{
+		processor:                       &sharedProcessor{},
+		indexer:                         NewIndexer(MetaNameKeyFunc, indexers),
+		listerWatcher:                   lw,
+		resyncCheckPeriod:               defaultEventHandlerResyncPeriod,
+		defaultEventHandlerResyncPeriod: defaultEventHandlerResyncPeriod,
+	}
+}
+
+// InformerSynced is a function that can be used to determine if an informer has synced.  This is useful for determining if caches have synced.
+type InformerSynced func() bool
+
+const (
+	// syncedPollPeriod controls how often you look at the status of your sync funcs
+	syncedPollPeriod = 100 * time.Millisecond
+
+	// initialBufferSize is the initial number of event notifications that can be buffered.
+	initialBufferSize = 1024
+
+	// minimumResyncPeriod is the lower bound of the resync periods of the
+	// handlers.
+	minimumResyncPeriod = 1 * time.Second
+)
+
+// WaitForCacheSync waits for caches to populate.  It returns true if it was successful, false
+// if the controller should shutdown
+// callers should prefer WaitForNamedCacheSync()
+func WaitForCacheSync(stopCh <-chan struct{}, cacheSyncs ...InformerSynced) bool {
+	err := wait.PollImmediateUntil(syncedPollPeriod,
+		func() (bool, error) {
+			for _, syncFunc := range cacheSyncs {
+				if !syncFunc() {
+					return false, nil
+				}
+			}
+			return true, nil
+		},
+		stopCh)
+	if err != nil {
+		klog.V(2).InfoS("Stop requested")
+		return false
+	}
+
+	klog.V(4).InfoS("Caches populated")
+	return true
+}
+
+// WaitForNamedCacheSync is a wrapper around WaitForCacheSync that generates log messages
+// indicating that the caller identified by name is waiting for syncs, followed by
+// either a successful or failed sync.
+func WaitForNamedCacheSync(controllerName string, stopCh <-chan struct{}, cacheSyncs ...InformerSynced) bool {
+	klog.InfoS("Waiting for caches to sync", "controller", controllerName)
+
+	if !WaitForCacheSync(stopCh, cacheSyncs...) {
+		klog.ErrorS(nil, "Unable to sync caches", "controller", controllerName)
+		return false
+	}
+
+	klog.InfoS("Caches are synced", "controller", controllerName)
+	return true
+}
+
+// `*sharedIndexInformer` implements SharedIndexInformer and has three
+// main components.  One is an indexed local cache, `indexer Indexer`.
+// The second main component is a Reflector that lists and watches the
+// ListerWatcher, and writes the changes to the cache through the
+// informerStore.  The third main component is a sharedProcessor, to
+// which the informerStore distributes a notification for every change
+// it makes to the cache, and that dispatches the notifications to the
+// event handlers.
+type sharedIndexInformer struct {
+	indexer   Indexer
+	reflector *Reflector
+
+	processor     *sharedProcessor
+	listerWatcher ListerWatcher
+
+	// resyncCheckPeriod is how often we want the reflector's resync timer to fire so it can call
+	// shouldResync to check if any of our listeners need a resync.
+	resyncCheckPeriod time.Duration
+	// defaultEventHandlerResyncPeriod is the default resync period for any handlers added via
+	// AddEventHandler (i.e. they don't specify one and just want to use the shared informer's default
+	// value).
+	defaultEventHandlerResyncPeriod time.Duration
+
+	started, stopped bool
+	startedLock      sync.Mutex
+
+	// synced is set to 1 once the first list has been written to the cache.
+	synced int32
+
+	// blockDeltas gives a way to stop all event distribution so that a late event handler
+	// can safely join the shared informer.
+	blockDeltas sync.Mutex
+}
+
+func (s *sharedIndexInformer) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	func() {
+		s.startedLock.Lock()
+		defer s.startedLock.Unlock()
+
+		s.reflector = NewReflector(s.listerWatcher, &informerStore{informer: s}, s.resyncCheckPeriod)
+		s.reflector.ShouldResync = s.processor.shouldResync
+		s.started = true
+	}()
+
+	var wg wait.Group
+	defer wg.Wait() // Wait for Processor to stop
+	processorStopCh := make(chan struct{})
+	defer close(processorStopCh) // Tell Processor to stop
+	wg.StartWithChannel(processorStopCh, s.processor.run)
+
+	defer func() {
+		s.startedLock.Lock()
+		defer s.startedLock.Unlock()
+		s.stopped = true // Don't want any new listeners
+	}()
+	s.reflector.Run(stopCh)
+}
+
+func (s *sharedIndexInformer) HasSynced() bool {
+	return atomic.LoadInt32(&s.synced) == 1
+}
+
+func (s *sharedIndexInformer) LastSyncResourceVersion() string {
+	s.startedLock.Lock()
+	defer s.startedLock.Unlock()
+
+	if s.reflector == nil {
+		return ""
+	}
+	return s.reflector.LastSyncResourceVersion()
+}
+
+func (s *sharedIndexInformer) GetStore() Store {
+	return s.indexer
+}
+
+func (s *sharedIndexInformer) GetIndexer() Indexer {
+	return s.indexer
+}
+
+func (s *sharedIndexInformer) AddIndexers(indexers storage.IndexerFuncs) error {
+	s.startedLock.Lock()
+	defer s.startedLock.Unlock()
+
+	if s.started {
+		return fmt.Errorf("informer has already started")
+	}
+
+	return s.indexer.AddIndexers(indexers)
+}
+
+func (s *sharedIndexInformer) AddEventHandler(handler ResourceEventHandler) {
+	s.AddEventHandlerWithResyncPeriod(handler, s.defaultEventHandlerResyncPeriod)
+}
+
+func determineResyncPeriod(desired, check time.Duration) time.Duration {
+	if desired == 0 {
+		return desired
+	}
+	if check == 0 {
+		klog.InfoS("The specified resyncPeriod is invalid because this shared informer doesn't support resyncing", "resyncPeriod", desired)
+		return 0
+	}
+	if desired < check {
+		klog.InfoS("The specified resyncPeriod is being increased to the minimum resyncCheckPeriod", "resyncPeriod", desired, "resyncCheckPeriod", check)
+		return check
+	}
+	return desired
+}
+
+func (s *sharedIndexInformer) AddEventHandlerWithResyncPeriod(handler ResourceEventHandler, resyncPeriod time.Duration) {
+	s.startedLock.Lock()
+	defer s.startedLock.Unlock()
+
+	if s.stopped {
+		klog.V(2).InfoS("Handler was not added to shared informer because it has stopped already", "handler", fmt.Sprintf("%T", handler))
+		return
+	}
+
+	if resyncPeriod > 0 {
+		if resyncPeriod < minimumResyncPeriod {
+			klog.InfoS("resyncPeriod is too small, changing it to the minimum allowed value", "resyncPeriod", resyncPeriod, "minimumResyncPeriod", minimumResyncPeriod)
+			resyncPeriod = minimumResyncPeriod
+		}
+
+		if resyncPeriod < s.resyncCheckPeriod {
+			if s.started {
+				klog.InfoS("resyncPeriod is smaller than resyncCheckPeriod and the informer has already started, changing it to resyncCheckPeriod", "resyncPeriod", resyncPeriod, "resyncCheckPeriod", s.resyncCheckPeriod)
+				resyncPeriod = s.resyncCheckPeriod
+			} else {
+				// if the event handler's resyncPeriod is smaller than the current resyncCheckPeriod, update
+				// resyncCheckPeriod to match resyncPeriod and adjust the resync periods of all the listeners
+				// accordingly
+				s.resyncCheckPeriod = resyncPeriod
+				s.processor.resyncCheckPeriodChanged(resyncPeriod)
+			}
+		}
+	}
+
+	listener := newProcessListener(handler, resyncPeriod, determineResyncPeriod(resyncPeriod, s.resyncCheckPeriod), time.Now(), initialBufferSize)
+
+	if !s.started {
+		s.processor.addListener(listener)
+		return
+	}
+
+	// in order to safely join, we have to
+	// 1. stop sending add/update/delete notifications
+	// 2. do a list against the store
+	// 3. send synthetic "Add" events to the new handler
+	// 4. unblock
+	s.blockDeltas.Lock()
+	defer s.blockDeltas.Unlock()
+
+	s.processor.addListener(listener)
+	for _, item := range s.indexer.List() {
+		listener.add(addNotification{newObj: item})
+	}
+}
+
+// informerStore is the Store the reflector of a sharedIndexInformer
+// writes to. It applies the changes to the indexer of the informer, and
+// distributes a notification of each of them to the event handlers.
+type informerStore struct {
+	informer *sharedIndexInformer
+}
+
+var _ Store = &informerStore{}
+
+// Add implements Store.Add. After a relist the watch may deliver an ADDED
+// event for an object the cache already has, it is notified as an update.
+func (i *informerStore) Add(obj runtime.Object) error {
+	return i.Update(obj)
+}
+
+// Update implements Store.Update.
+func (i *informerStore) Update(obj runtime.Object) error {
+	s := i.informer
+	s.blockDeltas.Lock()
+	defer s.blockDeltas.Unlock()
+
+	return i.updateLocked(obj)
+}
+
+func (i *informerStore) updateLocked(obj runtime.Object) error {
+	s := i.informer
+	old, exists, err := s.indexer.Get(obj)
+	if err != nil {
+		return err
+	}
+	if err := s.indexer.Update(obj); err != nil {
+		return err
+	}
+	if exists {
+		s.processor.distribute(updateNotification{oldObj: old, newObj: obj}, false)
+	} else {
+		s.processor.distribute(addNotification{newObj: obj}, false)
+	}
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (i *informerStore) Delete(obj runtime.Object) error {
+	s := i.informer
+	s.blockDeltas.Lock()
+	defer s.blockDeltas.Unlock()
+
+	_, exists, err := s.indexer.Get(obj)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	if err := s.indexer.Delete(obj); err != nil {
+		return err
+	}
+	s.processor.distribute(deleteNotification{oldObj: obj}, false)
+	return nil
+}
+
+// List implements Store.List.
+func (i *informerStore) List() []runtime.Object {
+	return i.informer.indexer.List()
+}
+
+// ListKeys implements Store.ListKeys.
+func (i *informerStore) ListKeys() []string {
+	return i.informer.indexer.ListKeys()
+}
+
+// Get implements Store.Get.
+func (i *informerStore) Get(obj runtime.Object) (item runtime.Object, exists bool, err error) {
+	return i.informer.indexer.Get(obj)
+}
+
+// GetByKey implements Store.GetByKey.
+func (i *informerStore) GetByKey(key string) (item runtime.Object, exists bool, err error) {
+	return i.informer.indexer.GetByKey(key)
+}
+
+// Replace implements Store.Replace. Every listed object is notified as an
+// add or an update, and every object of the cache that is not listed
+// anymore as a delete, with the last state the cache has seen. The
+// informer has synced once the first list has been replaced.
+func (i *informerStore) Replace(list []runtime.Object, resourceVersion string) error {
+	s := i.informer
+	s.blockDeltas.Lock()
+	defer s.blockDeltas.Unlock()
+
+	keys := make(sets.String, len(list))
+	for _, obj := range list {
+		key, err := MetaNameKeyFunc(obj)
+		if err != nil {
+			return KeyError{obj, err}
+		}
+		keys.Insert(key)
+		if err := i.updateLocked(obj); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range s.indexer.ListKeys() {
+		if keys.Has(key) {
+			continue
+		}
+		old, exists, err := s.indexer.GetByKey(key)
+		if err != nil || !exists {
+			continue
+		}
+		if err := s.indexer.Delete(old); err != nil {
+			return err
+		}
+		s.processor.distribute(deleteNotification{oldObj: old}, false)
+	}
+
+	atomic.StoreInt32(&s.synced, 1)
+	return nil
+}
+
+// Resync implements Store.Resync, it notifies the listeners that are due
+// for a resync of an update of every object of the cache.
+func (i *informerStore) Resync() error {
+	s := i.informer
+	s.blockDeltas.Lock()
+	defer s.blockDeltas.Unlock()
+
+	for _, obj := range s.indexer.List() {
+		s.processor.distribute(updateNotification{oldObj: obj, newObj: obj}, true)
+	}
+	return nil
+}
+
+type addNotification struct {
+	newObj runtime.Object
+}
+
+type updateNotification struct {
+	oldObj runtime.Object
+	newObj runtime.Object
+}
+
+type deleteNotification struct {
+	oldObj runtime.Object
+}
+
+// sharedProcessor has a collection of processorListener and can
+// distribute a notification object to its listeners.  There are two
+// kinds of distribute operations.  The sync distributions go to a
+// subset of the listeners that (a) is recomputed in the occasional
+// calls to shouldResync and (b) every listener is initially put in.
+// The non-sync distributions go to every listener.
+type sharedProcessor struct {
+	listenersStarted bool
+	listenersLock    sync.RWMutex
+	listeners        []*processorListener
+	syncingListeners []*processorListener
+	wg               wait.Group
+}
+
+func (p *sharedProcessor) addListener(listener *processorListener) {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+
+	p.listeners = append(p.listeners, listener)
+	p.syncingListeners = append(p.syncingListeners, listener)
+	if p.listenersStarted {
+		p.wg.Start(listener.run)
+		p.wg.Start(listener.pop)
+	}
+}
+
+func (p *sharedProcessor) distribute(obj interface{}, sync bool) {
+	p.listenersLock.RLock()
+	defer p.listenersLock.RUnlock()
+
+	if sync {
+		for _, listener := range p.syncingListeners {
+			listener.add(obj)
+		}
+	} else {
+		for _, listener := range p.listeners {
+			listener.add(obj)
+		}
+	}
+}
+
+func (p *sharedProcessor) run(stopCh <-chan struct{}) {
+	func() {
+		p.listenersLock.RLock()
+		defer p.listenersLock.RUnlock()
+		for _, listener := range p.listeners {
+			p.wg.Start(listener.run)
+			p.wg.Start(listener.pop)
+		}
+		p.listenersStarted = true
+	}()
+	<-stopCh
+	p.listenersLock.RLock()
+	defer p.listenersLock.RUnlock()
+	for _, listener := range p.listeners {
+		close(listener.addCh) // Tell .pop() to stop. .pop() will tell .run() to stop
+	}
+	p.wg.Wait() // Wait for all .pop() and .run() to stop
+}
+
+// shouldResync queries every listener to determine if any of them need a resync, based on each
+// listener's resyncPeriod.
+func (p *sharedProcessor) shouldResync() bool {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+
+	p.syncingListeners = []*processorListener{}
+
+	resyncNeeded := false
+	now := time.Now()
+	for _, listener := range p.listeners {
+		// need to loop through all the listeners to see if they need to resync so we can prepare any
+		// listeners that are going to be resyncing.
+		if listener.shouldResync(now) {
+			resyncNeeded = true
+			p.syncingListeners = append(p.syncingListeners, listener)
+			listener.determineNextResync(now)
+		}
+	}
+	return resyncNeeded
+}
+
+func (p *sharedProcessor) resyncCheckPeriodChanged(resyncCheckPeriod time.Duration) {
+	p.listenersLock.RLock()
+	defer p.listenersLock.RUnlock()
+
+	for _, listener := range p.listeners {
+		resyncPeriod := determineResyncPeriod(listener.requestedResyncPeriod, resyncCheckPeriod)
+		listener.setResyncPeriod(resyncPeriod)
+	}
+}
+
+// processorListener relays notifications from a sharedProcessor to
+// one ResourceEventHandler --- using two goroutines, two unbuffered
+// channels, and an unbounded buffer.  The `add(notification)`
+// function sends the given notification to `addCh`.  One goroutine
+// runs `pop()`, which pumps notifications from `addCh` to `nextCh`
+// using storage in the buffer while `nextCh` is not keeping up.
+// Another goroutine runs `run()`, which receives notifications from
+// `nextCh` and synchronously invokes the appropriate handler method.
+//
+// processorListener also keeps track of the adjusted requested resync
+// period of the listener.
+type processorListener struct {
+	nextCh chan interface{}
+	addCh  chan interface{}
+
+	handler ResourceEventHandler
+
+	// pendingNotifications is an unbounded buffer that holds all notifications not yet distributed.
+	// There is one per listener, but a failing/stalled listener will have infinite pendingNotifications
+	// added until we OOM.
+	pendingNotifications []interface{}
+
+	// requestedResyncPeriod is how frequently the listener wants a
+	// full resync from the shared informer, but modified by two
+	// adjustments.  One is imposing a lower bound,
+	// `minimumResyncPeriod`.  The other is another lower bound, the
+	// sharedProcessor's `resyncCheckPeriod`, that is imposed (a) only
+	// in AddEventHandlerWithResyncPeriod invocations made after the
+	// sharedProcessor starts and (b) only if the informer does
+	// resyncs at all.
+	requestedResyncPeriod time.Duration
+	// resyncPeriod is the threshold that will be used in the logic
+	// for this listener.  This value differs from
+	// requestedResyncPeriod only when the sharedIndexInformer does
+	// not do resyncs, in which case the value here is zero.  The
+	// actual time between resyncs depends on when the
+	// sharedProcessor's `shouldResync` function is invoked.
+	resyncPeriod time.Duration
+	// nextResync is the earliest time the listener should get a full resync
+	nextResync time.Time
+	// resyncLock guards access to resyncPeriod and nextResync
+	resyncLock sync.Mutex
+}
+
+func newProcessListener(handler ResourceEventHandler, requestedResyncPeriod, resyncPeriod time.Duration, now time.Time, bufferSize int) *processorListener {
+	ret := &processorListener{
+		nextCh:                make(chan interface{}),
+		addCh:                 make(chan interface{}),
+		handler:               handler,
+		pendingNotifications:  make([]interface{}, 0, bufferSize),
+		requestedResyncPeriod: requestedResyncPeriod,
+		resyncPeriod:          resyncPeriod,
+	}
+
+	ret.determineNextResync(now)
+
+	return ret
+}
+
+func (p *processorListener) add(notification interface{}) {
+	p.addCh <- notification
+}
+
+func (p *processorListener) pop() {
+	defer utilruntime.HandleCrash()
+	defer close(p.nextCh) // Tell .run() to stop
+
+	var nextCh chan<- interface{}
+	var notification interface{}
+	for {
+		select {
+		case nextCh <- notification:
+			// Notification dispatched
+			if len(p.pendingNotifications) == 0 { // Nothing to pop
+				notification = nil
+				nextCh = nil // Disable this select case
+				continue
+			}
+			notification = p.pendingNotifications[0]
+			p.pendingNotifications[0] = nil
+			p.pendingNotifications = p.pendingNotifications[1:]
+		case notificationToAdd, ok := <-p.addCh:
+			if !ok {
+				return
+			}
+			if notification == nil { // No notification to pop (and pendingNotifications is empty)
+				// Optimize the case - skip adding to pendingNotifications
+				notification = notificationToAdd
+				nextCh = p.nextCh
+			} else { // There is already a notification waiting to be dispatched
+				p.pendingNotifications = append(p.pendingNotifications, notificationToAdd)
+			}
+		}
+	}
+}
+
+func (p *processorListener) run() {
+	// this call blocks until the channel is closed.  When a panic happens during the notification
+	// we will catch it, **the offending item will be skipped!**, and after a short delay (one second)
+	// the next notification will be attempted.  This is usually better than the alternative of never
+	// delivering again.
+	stopCh := make(chan struct{})
+	wait.Until(func() {
+		for next := range p.nextCh {
+			switch notification := next.(type) {
+			case updateNotification:
+				p.handler.OnUpdate(notification.oldObj, notification.newObj)
+			case addNotification:
+				p.handler.OnAdd(notification.newObj)
+			case deleteNotification:
+				p.handler.OnDelete(notification.oldObj)
+			default:
+				utilruntime.HandleError(fmt.Errorf("unrecognized notification: %T", next))
+			}
+		}
+		// the only way to get here is if the p.nextCh is empty and closed
+		close(stopCh)
+	}, 1*time.Second, stopCh)
+}
+
+// shouldResync deterimines if the listener needs a resync. If the listener's resyncPeriod is 0,
+// this always returns false.
+func (p *processorListener) shouldResync(now time.Time) bool {
+	p.resyncLock.Lock()
+	defer p.resyncLock.Unlock()
+
+	if p.resyncPeriod == 0 {
+		return false
+	}
+
+	return now.After(p.nextResync) || now.Equal(p.nextResync)
+}
+
+func (p *processorListener) determineNextResync(now time.Time) {
+	p.resyncLock.Lock()
+	defer p.resyncLock.Unlock()
+
+	p.nextResync = now.Add(p.resyncPeriod)
+}
+
+func (p *processorListener) setResyncPeriod(resyncPeriod time.Duration) {
+	p.resyncLock.Lock()
+	defer p.resyncLock.Unlock()
+
+	p.resyncPeriod = resyncPeriod
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// testHandler records the notifications it is given as
+// "<add|update|delete> <name> <resource version>".
+type testHandler struct {
+	lock          sync.Mutex
+	notifications []string
+}
+
+func (h *testHandler) record(action string, obj runtime.Object) {
+	user := obj.(*model.User)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.notifications = append(h.notifications, fmt.Sprintf("%s %s %s", action, user.Name, user.ResourceVersion))
+}
+
+func (h *testHandler) OnAdd(obj runtime.Object) {
+	h.record("add", obj)
+}
+
+func (h *testHandler) OnUpdate(oldObj, newObj runtime.Object) {
+	if oldObj.(*model.User).Name != newObj.(*model.User).Name {
+		panic("update of two objects")
+	}
+	h.record("update", newObj)
+}
+
+func (h *testHandler) OnDelete(obj runtime.Object) {
+	h.record("delete", obj)
+}
+
+// wait waits for the handler to be notified of expected, in this order.
+func (h *testHandler) wait(t *testing.T, expected ...string) {
+	t.Helper()
+	if got := h.waitFor(len(expected)); !equalStrings(got, expected) {
+		t.Fatalf("expected the notifications %q, got %q", expected, got)
+	}
+}
+
+// waitUnordered waits for the handler to be notified of expected, in any
+// order.
+func (h *testHandler) waitUnordered(t *testing.T, expected ...string) {
+	t.Helper()
+	got := h.waitFor(len(expected))
+	sort.Strings(got)
+	sort.Strings(expected)
+	if !equalStrings(got, expected) {
+		t.Fatalf("expected the notifications %q, got %q", expected, got)
+	}
+}
+
+// waitFor returns the notifications once there are at least n of them.
+func (h *testHandler) waitFor(n int) []string {
+	var got []string
+	_ = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		got = append([]string(nil), h.notifications...)
+		return len(got) >= n, nil
+	})
+	return got
+}
+
+func TestSharedInformerHasSynced(t *testing.T) {
+	listed := make(chan struct{})
+	lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+		<-listed
+		return newUserList("10", newUser("alice", "9", nil)), nil
+	})
+	informer := NewSharedInformer(lw, 0)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+
+	time.Sleep(50 * time.Millisecond)
+	if informer.HasSynced() {
+		t.Errorf("expected the informer not to be synced before the list is delivered")
+	}
+	close(listed)
+	if !WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatalf("expected the informer to sync")
+	}
+	// the cache holds the list once the informer has synced
+	expectStore(t, informer.GetStore(), "alice")
+	if rv := informer.LastSyncResourceVersion(); rv != "10" {
+		t.Errorf("expected the last sync resource version 10, got %q", rv)
+	}
+}
+
+func TestSharedInformerNotifications(t *testing.T) {
+	lists := []*model.UserList{
+		newUserList("10", newUser("alice", "9", nil), newUser("bob", "8", nil)),
+		newUserList("20", newUser("alice", "15", nil), newUser("dave", "19", nil)),
+	}
+	lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+		list := lists[0]
+		lists = lists[1:]
+		return list, nil
+	})
+	informer := NewSharedInformer(lw, 0)
+	handler := &testHandler{}
+	informer.AddEventHandler(handler)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+
+	w := lw.nextWatcher(t)
+	w.Modify(newUser("alice", "11", nil))
+	w.Add(newUser("carol", "12", nil))
+	w.Delete(newUser("bob", "13", nil))
+	// a watch resumed after a relist may add an object the cache has
+	w.Add(newUser("carol", "14", nil))
+	handler.wait(t,
+		"add alice 9",
+		"add bob 8",
+		"update alice 11",
+		"add carol 12",
+		"delete bob 13",
+		"update carol 14",
+	)
+
+	// a handler added to a running informer is notified of the cache, in
+	// no particular order
+	late := &testHandler{}
+	informer.AddEventHandler(late)
+	late.waitUnordered(t, "add alice 11", "add carol 14")
+
+	// after the relist the objects that are gone are notified as deleted
+	// with their last known state
+	w.Error(&meta.Status{Status: apierrors.NewResourceExpired("too old resource version").ErrStatus})
+	lw.nextWatcher(t)
+	handler.wait(t,
+		"add alice 9",
+		"add bob 8",
+		"update alice 11",
+		"add carol 12",
+		"delete bob 13",
+		"update carol 14",
+		"update alice 15",
+		"add dave 19",
+		"delete carol 14",
+	)
+	expectStore(t, informer.GetStore(), "alice", "dave")
+}
+
+func TestSharedInformerResync(t *testing.T) {
+	lw := newTestLW(func(options *meta.ListOptions) (runtime.Object, error) {
+		return newUserList("10", newUser("alice", "9", nil)), nil
+	})
+	// an informer without a default resync period does no resyncs at all
+	informer := NewSharedInformer(lw, time.Hour)
+	resynced := &testHandler{}
+	informer.AddEventHandlerWithResyncPeriod(resynced, minimumResyncPeriod)
+	notResynced := &testHandler{}
+	informer.AddEventHandlerWithResyncPeriod(notResynced, 0)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+
+	lw.nextWatcher(t)
+	// every object of the cache is notified as updated once the resync
+	// period of the handler has passed
+	resynced.wait(t, "add alice 9", "update alice 9")
+	notResynced.wait(t, "add alice 9")
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// threadSafeMap is a thread safe storage of objects, indexed by the
+// storage.IndexerFuncs it is given.
+//
+// The objects are shared with the callers of Get, List, Index and
+// ByIndex, they must be treated as immutable: the indices are only updated
+// when the objects are added, updated or deleted.
+type threadSafeMap struct {
+	lock  sync.RWMutex
+	items map[string]runtime.Object
+
+	// indexers maps a name to an IndexerFunc
+	indexers storage.IndexerFuncs
+	// indices maps a name to an Index
+	indices Indices
+}
+
+func newThreadSafeMap(indexers storage.IndexerFuncs) *threadSafeMap {
+	// copy the indexers, AddIndexers must not modify the map of the caller
+	copied := make(storage.IndexerFuncs, len(indexers))
+	for name, indexFunc := range indexers {
+		copied[name] = indexFunc
+	}
+	return &threadSafeMap{
+		items:    map[string]runtime.Object{},
+		indexers: copied,
+		indices:  Indices{},
+	}
+}
+
+func (c *threadSafeMap) Add(key string, obj runtime.Object) {
+	c.Update(key, obj)
+}
+
+func (c *threadSafeMap) Update(key string, obj runtime.Object) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	oldObject := c.items[key]
+	c.items[key] = obj
+	c.updateIndices(oldObject, obj, key)
+}
+
+func (c *threadSafeMap) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if obj, exists := c.items[key]; exists {
+		c.updateIndices(obj, nil, key)
+		delete(c.items, key)
+	}
+}
+
+func (c *threadSafeMap) Get(key string) (item runtime.Object, exists bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	item, exists = c.items[key]
+	return item, exists
+}
+
+func (c *threadSafeMap) List() []runtime.Object {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	list := make([]runtime.Object, 0, len(c.items))
+	for _, item := range c.items {
+		list = append(list, item)
+	}
+	return list
+}
+
+// ListKeys returns a list of all the keys of the objects currently
+// in the threadSafeMap.
+func (c *threadSafeMap) ListKeys() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	list := make([]string, 0, len(c.items))
+	for key := range c.items {
+		list = append(list, key)
+	}
+	return list
+}
+
+func (c *threadSafeMap) Replace(items map[string]runtime.Object) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = items
+
+	// rebuild any index
+	c.indices = Indices{}
+	for key, item := range c.items {
+		c.updateIndices(nil, item, key)
+	}
+}
+
+// Index returns a list of items that match the given object on the index function.
+// Index is thread-safe so long as you treat all items as immutable.
+func (c *threadSafeMap) Index(indexName string, obj runtime.Object) ([]runtime.Object, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	indexFunc := c.indexers[indexName]
+	if indexFunc == nil {
+		return nil, fmt.Errorf("index with name %s does not exist", indexName)
+	}
+	return c.byIndexLocked(indexName, indexFunc(obj)), nil
+}
+
+// ByIndex returns a list of the items whose indexed values in the given index include the given indexed value
+func (c *threadSafeMap) ByIndex(indexName, indexedValue string) ([]runtime.Object, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.indexers[indexName] == nil {
+		return nil, fmt.Errorf("index with name %s does not exist", indexName)
+	}
+	return c.byIndexLocked(indexName, indexedValue), nil
+}
+
+func (c *threadSafeMap) byIndexLocked(indexName, indexedValue string) []runtime.Object {
+	set := c.indices[indexName][indexedValue]
+	list := make([]runtime.Object, 0, set.Len())
+	for key := range set {
+		list = append(list, c.items[key])
+	}
+	return list
+}
+
+// IndexKeys returns a list of the Store keys of the objects whose indexed values in the given index include the given indexed value.
+// IndexKeys is thread-safe so long as you treat all items as immutable.
+func (c *threadSafeMap) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.indexers[indexName] == nil {
+		return nil, fmt.Errorf("index with name %s does not exist", indexName)
+	}
+	return c.indices[indexName][indexedValue].List(), nil
+}
+
+func (c *threadSafeMap) ListIndexFuncValues(indexName string) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	index := c.indices[indexName]
+	names := make([]string, 0, len(index))
+	for key := range index {
+		names = append(names, key)
+	}
+	return names
+}
+
+func (c *threadSafeMap) GetIndexers() storage.IndexerFuncs {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	indexers := make(storage.IndexerFuncs, len(c.indexers))
+	for name, indexFunc := range c.indexers {
+		indexers[name] = indexFunc
+	}
+	return indexers
+}
+
+func (c *threadSafeMap) AddIndexers(newIndexers storage.IndexerFuncs) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.items) > 0 {
+		return fmt.Errorf("cannot add indexers to running index")
+	}
+
+	for name := range newIndexers {
+		if _, exists := c.indexers[name]; exists {
+			return fmt.Errorf("indexer conflict: %s", name)
+		}
+	}
+
+	for name, indexFunc := range newIndexers {
+		c.indexers[name] = indexFunc
+	}
+	return nil
+}
+
+// updateIndices modifies the objects location in the managed indexes:
+// - for create you must provide only the newObj
+// - for update you must provide both the oldObj and the newObj
+// - for delete you must provide only the oldObj
+// updateIndices must be called from a function that already has a lock on the cache
+func (c *threadSafeMap) updateIndices(oldObj, newObj runtime.Object, key string) {
+	for name, indexFunc := range c.indexers {
+		index := c.indices[name]
+		if index == nil {
+			index = Index{}
+			c.indices[name] = index
+		}
+
+		if oldObj != nil {
+			c.deleteKeyFromIndex(key, indexFunc(oldObj), index)
+		}
+		if newObj != nil {
+			indexValue := indexFunc(newObj)
+			set := index[indexValue]
+			if set == nil {
+				set = sets.String{}
+				index[indexValue] = set
+			}
+			set.Insert(key)
+		}
+	}
+}
+
+func (c *threadSafeMap) deleteKeyFromIndex(key, indexValue string, index Index) {
+	set := index[indexValue]
+	if set == nil {
+		return
+	}
+	set.Delete(key)
+	// If we don't delete the set when zero, indices with high cardinality
+	// short lived resources can cause memory to increase over time from
+	// unused empty sets.
+	if len(set) == 0 {
+		delete(index, indexValue)
+	}
+}
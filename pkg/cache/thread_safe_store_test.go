@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+)
+
+func newUser(name, resourceVersion string, labels map[string]string) *model.User {
+	return &model.User{ObjectMeta: meta.ObjectMeta{Name: name, ResourceVersion: resourceVersion, Labels: labels}}
+}
+
+func teamIndexFunc(obj runtime.Object) string {
+	return obj.(*model.User).Labels["team"]
+}
+
+// names returns the sorted names of objs.
+func names(objs []runtime.Object) []string {
+	result := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*model.User).Name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestThreadSafeStoreIndex(t *testing.T) {
+	indexer := NewIndexer(MetaNameKeyFunc, storage.IndexerFuncs{"team": teamIndexFunc})
+	alice := newUser("alice", "1", map[string]string{"team": "a"})
+	bob := newUser("bob", "2", map[string]string{"team": "a"})
+	carol := newUser("carol", "3", map[string]string{"team": "b"})
+	for _, user := range []*model.User{alice, bob, carol} {
+		if err := indexer.Add(user); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	expectIndex := func(value string, expected ...string) {
+		t.Helper()
+		if expected == nil {
+			expected = []string{}
+		}
+		objs, err := indexer.ByIndex("team", value)
+		if err != nil {
+			t.Fatalf("ByIndex failed: %v", err)
+		}
+		if got := names(objs); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected team %q to be %v, got %v", value, expected, got)
+		}
+		keys, err := indexer.IndexKeys("team", value)
+		if err != nil {
+			t.Fatalf("IndexKeys failed: %v", err)
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("expected the keys of team %q to be %v, got %v", value, expected, keys)
+		}
+	}
+	expectValues := func(expected ...string) {
+		t.Helper()
+		values := indexer.ListIndexFuncValues("team")
+		sort.Strings(values)
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("expected the indexed values %v, got %v", expected, values)
+		}
+	}
+
+	expectIndex("a", "alice", "bob")
+	expectIndex("b", "carol")
+	expectIndex("c")
+	expectValues("a", "b")
+	objs, err := indexer.Index("team", newUser("dave", "", map[string]string{"team": "b"}))
+	if err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if got := names(objs); !reflect.DeepEqual(got, []string{"carol"}) {
+		t.Errorf("expected the team of dave to be [carol], got %v", got)
+	}
+
+	// an update moves the object to its new indexed value
+	if err := indexer.Update(newUser("bob", "4", map[string]string{"team": "b"})); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	expectIndex("a", "alice")
+	expectIndex("b", "bob", "carol")
+
+	// the indexed values without objects are dropped
+	if err := indexer.Delete(alice); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	expectIndex("a")
+	expectValues("b")
+
+	// the indices are rebuilt from the replaced objects
+	if err := indexer.Replace([]runtime.Object{alice, newUser("erin", "5", map[string]string{"team": "c"})}, "5"); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	expectIndex("a", "alice")
+	expectIndex("b")
+	expectIndex("c", "erin")
+	expectValues("a", "c")
+	if keys := indexer.ListKeys(); len(keys) != 2 {
+		t.Errorf("expected 2 keys, got %v", keys)
+	}
+	if _, exists, _ := indexer.GetByKey("bob"); exists {
+		t.Errorf("expected bob to be replaced")
+	}
+}
+
+func TestThreadSafeStoreIndexErrors(t *testing.T) {
+	indexers := storage.IndexerFuncs{"team": teamIndexFunc}
+	indexer := NewIndexer(MetaNameKeyFunc, indexers)
+
+	if _, err := indexer.ByIndex("missing", "a"); err == nil {
+		t.Errorf("expected ByIndex of a missing index to fail")
+	}
+	if _, err := indexer.IndexKeys("missing", "a"); err == nil {
+		t.Errorf("expected IndexKeys of a missing index to fail")
+	}
+	if _, err := indexer.Index("missing", newUser("alice", "", nil)); err == nil {
+		t.Errorf("expected Index of a missing index to fail")
+	}
+
+	if err := indexer.AddIndexers(storage.IndexerFuncs{"team": teamIndexFunc}); err == nil {
+		t.Errorf("expected a conflicting indexer to be rejected")
+	}
+	if err := indexer.AddIndexers(storage.IndexerFuncs{"name": func(obj runtime.Object) string {
+		return obj.(*model.User).Name
+	}}); err != nil {
+		t.Fatalf("AddIndexers failed: %v", err)
+	}
+	if _, ok := indexers["name"]; ok {
+		t.Errorf("expected the indexers of the caller not to be modified")
+	}
+	if got := indexer.GetIndexers(); len(got) != 2 {
+		t.Errorf("expected 2 indexers, got %d", len(got))
+	}
+
+	if err := indexer.Add(newUser("alice", "1", nil)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if keys, err := indexer.IndexKeys("name", "alice"); err != nil || len(keys) != 1 {
+		t.Errorf("expected alice to be indexed by name, got %v, %v", keys, err)
+	}
+	if err := indexer.AddIndexers(storage.IndexerFuncs{"other": teamIndexFunc}); err == nil {
+		t.Errorf("expected indexers not to be added to a store with objects")
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+)
+
+// CreateResource returns a function that will handle a resource creation.
+func CreateResource(r rest.Creater, scope *RequestScope) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		dryRun, err := parseDryRun(req.URL.Query())
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		options := &meta.CreateOptions{DryRun: dryRun}
+
+		obj := r.New()
+		if err := scope.decode(req, obj); err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		result, err := r.Create(req.Context(), obj, rest.ValidateAllObjectFunc, options)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		responsewriters.WriteObject(http.StatusCreated, scope.Serializer, result, w)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeleteResource returns a function that will handle a resource deletion.
+func DeleteResource(r rest.GracefulDeleter, scope *RequestScope) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name, err := requestName(req)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		options, err := parseDeleteOptions(req, scope)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		result, wasDeleted, err := r.Delete(req.Context(), name, rest.ValidateAllObjectFunc, options)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		status := http.StatusOK
+		// Return http.StatusAccepted if the resource was not deleted immediately.
+		if !wasDeleted {
+			status = http.StatusAccepted
+		}
+		// if the rest.Deleter returns a nil object, fill out a status. Callers
+		// may return a nil object if they don't have a more specific response.
+		if result == nil {
+			result = &meta.Status{Status: metav1.Status{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Status",
+					APIVersion: "v1",
+				},
+				Status: metav1.StatusSuccess,
+				Code:   int32(status),
+				Details: &metav1.StatusDetails{
+					Name:  name,
+					Group: scope.Resource.Group,
+					Kind:  scope.Resource.Resource,
+				},
+			}}
+		}
+		responsewriters.WriteObject(status, scope.Serializer, result, w)
+	}
+}
+
+// DeleteCollection returns a function that will handle a collection deletion.
+func DeleteCollection(r rest.CollectionDeleter, scope *RequestScope) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		listOptions, err := parseListOptions(req.URL.Query())
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		if listOptions.Watch {
+			scope.err(errors.NewBadRequest("watch is not supported when deleting a collection"), w, req)
+			return
+		}
+		options, err := parseDeleteOptions(req, scope)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		result, err := r.DeleteCollection(req.Context(), rest.ValidateAllObjectFunc, options, listOptions)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		responsewriters.WriteObject(http.StatusOK, scope.Serializer, result, w)
+	}
+}
+
+// parseDeleteOptions decodes the DeleteOptions of the request body, if there
+// is one, and lets the gracePeriodSeconds and dryRun parameters override them.
+func parseDeleteOptions(req *http.Request, scope *RequestScope) (*meta.DeleteOptions, error) {
+	options := &meta.DeleteOptions{}
+	body, err := limitedReadBody(req, scope.MaxRequestBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, options); err != nil {
+			return nil, errors.NewBadRequest(fmt.Sprintf("invalid DeleteOptions: %v", err))
+		}
+	}
+
+	query := req.URL.Query()
+	if v := query.Get("gracePeriodSeconds"); len(v) > 0 {
+		grace, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.NewBadRequest(fmt.Sprintf("invalid gracePeriodSeconds %q: must be an integer", v))
+		}
+		options.GracePeriodSeconds = &grace
+	}
+	if _, ok := query["dryRun"]; ok {
+		if options.DryRun, err = parseDryRun(query); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/klog/v2"
+)
+
+// GetResource returns a function that handles retrieving a single resource from a rest.Storage object.
+func GetResource(r rest.Getter, scope *RequestScope) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name, err := requestName(req)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		options := &meta.GetOptions{ResourceVersion: req.URL.Query().Get("resourceVersion")}
+		result, err := r.Get(req.Context(), name, options)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		responsewriters.WriteObject(http.StatusOK, scope.Serializer, result, w)
+	}
+}
+
+// ListResource returns a function that handles listing the resources of a
+// rest.Storage object, or watching them if the watch parameter is set.
+// Watches without timeoutSeconds time out after a random duration between
+// minRequestTimeout and twice that, or never if it is zero.
+func ListResource(r rest.Lister, rw rest.Watcher, scope *RequestScope, minRequestTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts, err := parseListOptions(req.URL.Query())
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		if opts.Watch {
+			timeout := time.Duration(0)
+			if opts.TimeoutSeconds != nil {
+				timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+			}
+			if timeout == 0 && minRequestTimeout > 0 {
+				timeout = time.Duration(float64(minRequestTimeout) * (rand.Float64() + 1.0))
+			}
+			klog.V(3).Infof("Starting watch for %s, rv=%s labels=%s fields=%s timeout=%s",
+				req.URL.Path, opts.ResourceVersion, opts.LabelSelector, opts.FieldSelector, timeout)
+
+			ctx := req.Context()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			watcher, err := rw.Watch(ctx, opts)
+			if err != nil {
+				scope.err(err, w, req)
+				return
+			}
+			serveWatch(watcher, scope, req, w, timeout)
+			return
+		}
+
+		result, err := r.List(req.Context(), opts)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		responsewriters.WriteObject(http.StatusOK, scope.Serializer, result, w)
+	}
+}
+
+// IsWatch returns true if req is a list request that asks to watch the
+// resources instead.
+func IsWatch(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	watch, err := parseBool(req.URL.Query(), "watch")
+	return err == nil && watch
+}
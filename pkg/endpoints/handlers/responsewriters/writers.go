@@ -0,0 +1,58 @@
+package responsewriters
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"k8s.io/klog/v2"
+)
+
+// WriteObject renders a returned runtime.Object to the response as a stream
+// or an encoded object. The object is encoded before anything is written, so
+// that an encoding failure is still reported as an error status.
+func WriteObject(statusCode int, s runtime.Serializer, object runtime.Object, w http.ResponseWriter) {
+	buf := &bytes.Buffer{}
+	if err := s.Encode(object, buf); err != nil {
+		ErrorNegotiated(err, s, w)
+		return
+	}
+	write(statusCode, buf.Bytes(), w)
+}
+
+// ErrorNegotiated renders an error to the response. Returns the HTTP status
+// code of the error.
+func ErrorNegotiated(err error, s runtime.Serializer, w http.ResponseWriter) int {
+	status := ErrorToAPIStatus(err)
+	code := int(status.Code)
+	// when writing an error, check to see if the status indicates a retry after period
+	if status.Details != nil && status.Details.RetryAfterSeconds > 0 {
+		delay := strconv.Itoa(int(status.Details.RetryAfterSeconds))
+		w.Header().Set("Retry-After", delay)
+	}
+
+	if code == http.StatusNoContent {
+		w.WriteHeader(code)
+		return code
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Encode(status, buf); err != nil {
+		// the status is a plain struct, this only fails on a broken serializer
+		klog.Errorf("Error encoding status %#v: %v", status, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	write(code, buf.Bytes(), w)
+	return code
+}
+
+// write writes data encoded by a JSON serializer to the response.
+func write(statusCode int, data []byte, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(data); err != nil {
+		klog.V(4).Infof("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RequestScope encapsulates common fields across all RESTful handler methods.
+type RequestScope struct {
+	// Serializer encodes responses and decodes request bodies.
+	Serializer runtime.Serializer
+
+	// Resource is the qualified resource the handlers serve.
+	Resource schema.GroupResource
+
+	// MaxRequestBodyBytes is the limit on the size of request bodies, it
+	// is not enforced when zero.
+	MaxRequestBodyBytes int64
+}
+
+func (scope *RequestScope) err(err error, w http.ResponseWriter, req *http.Request) {
+	responsewriters.ErrorNegotiated(err, scope.Serializer, w)
+}
+
+// decode decodes the request body into obj. The apiVersion and kind of the
+// body, if set, must be the ones of obj.
+func (scope *RequestScope) decode(req *http.Request, obj runtime.Object) error {
+	body, err := limitedReadBody(req, scope.MaxRequestBodyBytes)
+	if err != nil {
+		return err
+	}
+	out, err := scope.Serializer.Decode(body, obj)
+	if err != nil {
+		return errors.NewBadRequest(err.Error())
+	}
+	if out != obj {
+		return errors.NewBadRequest(fmt.Sprintf("the API version and kind in the data (%s) do not match the ones of %s", out.GetObjectKind().GroupVersionKind(), scope.Resource))
+	}
+	return nil
+}
+
+// requestName returns the name of the object the request is for. The path
+// is matched in its encoded form, so the name is unescaped here.
+func requestName(req *http.Request) (string, error) {
+	name, err := url.PathUnescape(mux.Vars(req)["name"])
+	if err != nil {
+		return "", errors.NewBadRequest(fmt.Sprintf("invalid name: %v", err))
+	}
+	if len(name) == 0 {
+		return "", errors.NewBadRequest("name parameter required")
+	}
+	return name, nil
+}
+
+// checkName checks that the name of obj matches the name on the URL.
+func checkName(obj runtime.Object, name string) error {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return errors.NewBadRequest(err.Error())
+	}
+	if objName := objMeta.GetName(); objName != name {
+		return errors.NewBadRequest(fmt.Sprintf(
+			"the name of the object (%s) does not match the name on the URL (%s)", objName, name))
+	}
+	return nil
+}
+
+// parseListOptions decodes the list and watch parameters of query.
+func parseListOptions(query url.Values) (*meta.ListOptions, error) {
+	opts := &meta.ListOptions{
+		ResourceVersion: query.Get("resourceVersion"),
+		Continue:        query.Get("continue"),
+	}
+
+	var err error
+	if opts.LabelSelector, err = labels.Parse(query.Get("labelSelector")); err != nil {
+		return nil, errors.NewBadRequest(fmt.Sprintf("invalid labelSelector: %v", err))
+	}
+	if opts.FieldSelector, err = fields.ParseSelector(query.Get("fieldSelector")); err != nil {
+		return nil, errors.NewBadRequest(fmt.Sprintf("invalid fieldSelector: %v", err))
+	}
+	if opts.Watch, err = parseBool(query, "watch"); err != nil {
+		return nil, err
+	}
+	if opts.AllowWatchBookmarks, err = parseBool(query, "allowWatchBookmarks"); err != nil {
+		return nil, err
+	}
+	if v := query.Get("timeoutSeconds"); len(v) > 0 {
+		timeout, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || timeout < 0 {
+			return nil, errors.NewBadRequest(fmt.Sprintf("invalid timeoutSeconds %q: must be a non-negative integer", v))
+		}
+		opts.TimeoutSeconds = &timeout
+	}
+	if v := query.Get("limit"); len(v) > 0 {
+		if opts.Limit, err = strconv.ParseInt(v, 10, 64); err != nil || opts.Limit < 0 {
+			return nil, errors.NewBadRequest(fmt.Sprintf("invalid limit %q: must be a non-negative integer", v))
+		}
+	}
+	return opts, nil
+}
+
+// parseBool parses the boolean parameter key of query. A parameter without
+// a value, as in "?watch", is true.
+func parseBool(query url.Values, key string) (bool, error) {
+	values, ok := query[key]
+	if !ok {
+		return false, nil
+	}
+	if len(values) == 0 || len(values[0]) == 0 {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, errors.NewBadRequest(fmt.Sprintf("invalid %s %q: must be a boolean", key, values[0]))
+	}
+	return b, nil
+}
+
+// parseDryRun returns the dryRun parameters of query. The only supported
+// value is "All".
+func parseDryRun(query url.Values) ([]string, error) {
+	dryRun := query["dryRun"]
+	for _, v := range dryRun {
+		if v != metav1.DryRunAll {
+			return nil, errors.NewBadRequest(fmt.Sprintf("invalid dryRun %q: supported values: %q", v, metav1.DryRunAll))
+		}
+	}
+	return dryRun, nil
+}
+
+func limitedReadBody(req *http.Request, limit int64) ([]byte, error) {
+	defer req.Body.Close()
+	if limit <= 0 {
+		return ioutil.ReadAll(req.Body)
+	}
+	lr := &io.LimitedReader{
+		R: req.Body,
+		N: limit + 1,
+	}
+	data, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, err
+	}
+	if lr.N <= 0 {
+		return nil, errors.NewRequestEntityTooLargeError(fmt.Sprintf("limit is %d", limit))
+	}
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseListOptions(t *testing.T) {
+	timeout := int64(30)
+	testCases := []struct {
+		name     string
+		query    string
+		expected *meta.ListOptions
+	}{
+		{
+			name:  "empty",
+			query: "",
+			expected: &meta.ListOptions{
+				LabelSelector: labels.Everything(),
+				FieldSelector: fields.Everything(),
+			},
+		},
+		{
+			name:  "list",
+			query: "resourceVersion=10&limit=2&continue=token&labelSelector=team%3Da&fieldSelector=metadata.name%21%3Dbob",
+			expected: &meta.ListOptions{
+				ResourceVersion: "10",
+				Limit:           2,
+				Continue:        "token",
+				LabelSelector:   labels.SelectorFromSet(labels.Set{"team": "a"}),
+				FieldSelector:   fields.OneTermNotEqualSelector("metadata.name", "bob"),
+			},
+		},
+		{
+			name:  "watch",
+			query: "watch&allowWatchBookmarks=true&timeoutSeconds=30",
+			expected: &meta.ListOptions{
+				LabelSelector:       labels.Everything(),
+				FieldSelector:       fields.Everything(),
+				Watch:               true,
+				AllowWatchBookmarks: true,
+				TimeoutSeconds:      &timeout,
+			},
+		},
+		{
+			name:  "no watch",
+			query: "watch=false",
+			expected: &meta.ListOptions{
+				LabelSelector: labels.Everything(),
+				FieldSelector: fields.Everything(),
+			},
+		},
+		{name: "invalid label selector", query: "labelSelector=team+in+("},
+		{name: "invalid field selector", query: "fieldSelector=metadata.name"},
+		{name: "invalid watch", query: "watch=yes"},
+		{name: "invalid allowWatchBookmarks", query: "allowWatchBookmarks=2"},
+		{name: "negative timeoutSeconds", query: "timeoutSeconds=-1"},
+		{name: "negative limit", query: "limit=-1"},
+		{name: "invalid limit", query: "limit=all"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts, err := parseListOptions(query)
+			if tc.expected == nil {
+				if !apierrors.IsBadRequest(err) {
+					t.Errorf("expected a bad request, got %#v, %v", opts, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// the selectors are compared in their canonical form
+			if opts.LabelSelector.String() != tc.expected.LabelSelector.String() {
+				t.Errorf("expected the label selector %q, got %q", tc.expected.LabelSelector, opts.LabelSelector)
+			}
+			if opts.FieldSelector.String() != tc.expected.FieldSelector.String() {
+				t.Errorf("expected the field selector %q, got %q", tc.expected.FieldSelector, opts.FieldSelector)
+			}
+			opts.LabelSelector, opts.FieldSelector = nil, nil
+			tc.expected.LabelSelector, tc.expected.FieldSelector = nil, nil
+			if !reflect.DeepEqual(opts, tc.expected) {
+				t.Errorf("expected %#v, got %#v", tc.expected, opts)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+)
+
+// UpdateResource returns a function that will handle a resource update. The
+// resource is created if it does not exist and its strategy allows it.
+func UpdateResource(r rest.Updater, scope *RequestScope) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name, err := requestName(req)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		dryRun, err := parseDryRun(req.URL.Query())
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		options := &meta.UpdateOptions{DryRun: dryRun}
+
+		obj := r.New()
+		if err := scope.decode(req, obj); err != nil {
+			scope.err(err, w, req)
+			return
+		}
+		if err := checkName(obj, name); err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		result, created, err := r.Update(req.Context(), name, rest.DefaultUpdatedObjectInfo(obj), rest.ValidateAllObjectFunc, rest.ValidateAllObjectUpdateFunc, false, options)
+		if err != nil {
+			scope.err(err, w, req)
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		responsewriters.WriteObject(status, scope.Serializer, result, w)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	"golang.org/x/net/websocket"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/util/wsstream"
+)
+
+// nothing will ever be sent down this channel
+var neverExitWatch <-chan time.Time = make(chan time.Time)
+
+// TimeoutFactory abstracts watch timeout logic for testing
+type TimeoutFactory interface {
+	TimeoutCh() (<-chan time.Time, func() bool)
+}
+
+// realTimeoutFactory implements timeoutFactory
+type realTimeoutFactory struct {
+	timeout time.Duration
+}
+
+// TimeoutCh returns a channel which will receive something when the watch times out,
+// and a cleanup function to call when this happens.
+func (w *realTimeoutFactory) TimeoutCh() (<-chan time.Time, func() bool) {
+	if w.timeout == 0 {
+		return neverExitWatch, func() bool { return false }
+	}
+	t := time.NewTimer(w.timeout)
+	return t.C, t.Stop
+}
+
+// serveWatch will serve a watch response.
+func serveWatch(watcher watch.Interface, scope *RequestScope, req *http.Request, w http.ResponseWriter, timeout time.Duration) {
+	defer watcher.Stop()
+
+	server := &WatchServer{
+		Watching:       watcher,
+		Scope:          scope,
+		TimeoutFactory: &realTimeoutFactory{timeout},
+	}
+
+	server.ServeHTTP(w, req)
+}
+
+// WatchServer serves a watch.Interface over a websocket or vanilla HTTP.
+// Every event is sent as a JSON encoded meta.WatchEvent: one per line over
+// HTTP, one per text message over a websocket. The BOOKMARK events are the
+// ones of the storage, which sends them if the watch allows bookmarks.
+type WatchServer struct {
+	Watching watch.Interface
+	Scope    *RequestScope
+
+	TimeoutFactory TimeoutFactory
+}
+
+// ServeHTTP serves a series of encoded events via HTTP with Transfer-Encoding: chunked
+// or over a websocket connection.
+func (s *WatchServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if wsstream.IsWebSocketRequest(req) {
+		w.Header().Set("Content-Type", "application/json")
+		websocket.Handler(s.HandleWS).ServeHTTP(w, req)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := fmt.Errorf("unable to start watch - can't get http.Flusher: %#v", w)
+		utilruntime.HandleError(err)
+		s.Scope.err(errors.NewInternalError(err), w, req)
+		return
+	}
+
+	// ensure the connection times out
+	timeoutCh, cleanup := s.TimeoutFactory.TimeoutCh()
+	defer cleanup()
+
+	// begin the stream
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := s.Watching.ResultChan()
+	s.serve(req.Context().Done(), timeoutCh, func(frame []byte) error {
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+		if len(ch) == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// HandleWS implements a websocket handler.
+func (s *WatchServer) HandleWS(ws *websocket.Conn) {
+	defer ws.Close()
+	done := make(chan struct{})
+
+	go func() {
+		defer utilruntime.HandleCrash()
+		// This blocks until the connection is closed.
+		// Client should not send anything.
+		wsstream.IgnoreReceives(ws, 0)
+		// Once the client closes, we should also close
+		close(done)
+	}()
+
+	// ensure the connection times out
+	timeoutCh, cleanup := s.TimeoutFactory.TimeoutCh()
+	defer cleanup()
+
+	s.serve(done, timeoutCh, func(frame []byte) error {
+		return websocket.Message.Send(ws, string(frame))
+	})
+}
+
+// serve encodes the events of the watch and hands each frame to send, until
+// the watch ends, done is closed, the watch times out or send fails.
+func (s *WatchServer) serve(done <-chan struct{}, timeoutCh <-chan time.Time, send func(frame []byte) error) {
+	buf := &bytes.Buffer{}
+	frame := &bytes.Buffer{}
+	ch := s.Watching.ResultChan()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeoutCh:
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// End of results.
+				return
+			}
+			if err := s.Scope.Serializer.Encode(event.Object, buf); err != nil {
+				// unexpected error
+				utilruntime.HandleError(fmt.Errorf("unable to encode watch object %T: %v", event.Object, err))
+				return
+			}
+			outEvent := &meta.WatchEvent{Type: string(event.Type), Object: buf.Bytes()}
+			if err := s.Scope.Serializer.Encode(outEvent, frame); err != nil {
+				// encoding error
+				utilruntime.HandleError(fmt.Errorf("unable to encode event: %v", err))
+				return
+			}
+			if err := send(frame.Bytes()); err != nil {
+				// Client disconnect.
+				return
+			}
+			buf.Reset()
+			frame.Reset()
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	encodingjson "encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	"golang.org/x/net/websocket"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fakeTimeoutFactory times out a watch when timeoutCh is sent to, done is
+// closed once the timeout is cleaned up.
+type fakeTimeoutFactory struct {
+	timeoutCh chan time.Time
+	done      chan struct{}
+}
+
+func newFakeTimeoutFactory() *fakeTimeoutFactory {
+	return &fakeTimeoutFactory{timeoutCh: make(chan time.Time), done: make(chan struct{})}
+}
+
+func (t *fakeTimeoutFactory) TimeoutCh() (<-chan time.Time, func() bool) {
+	return t.timeoutCh, func() bool {
+		defer close(t.done)
+		return true
+	}
+}
+
+// fakeWatchStorage lists nothing and serves the watch of its watcher.
+type fakeWatchStorage struct {
+	watcher *watch.FakeWatcher
+	options *meta.ListOptions
+}
+
+func (s *fakeWatchStorage) NewList() runtime.Object {
+	return &model.UserList{}
+}
+
+func (s *fakeWatchStorage) List(ctx context.Context, options *meta.ListOptions) (runtime.Object, error) {
+	return &model.UserList{}, nil
+}
+
+func (s *fakeWatchStorage) Watch(ctx context.Context, options *meta.ListOptions) (watch.Interface, error) {
+	s.options = options
+	return s.watcher, nil
+}
+
+func newTestScope() *RequestScope {
+	return &RequestScope{
+		Serializer: json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{}),
+		Resource:   model.Resource("users"),
+	}
+}
+
+func newTestUser(name, resourceVersion string) *model.User {
+	return &model.User{ObjectMeta: meta.ObjectMeta{Name: name, ResourceVersion: resourceVersion}}
+}
+
+// testEvents are the events the test watches serve, the bookmark is the one
+// a storage sends.
+var testEvents = []watch.Event{
+	{Type: watch.Added, Object: newTestUser("alice", "2")},
+	{Type: watch.Modified, Object: newTestUser("alice", "3")},
+	{Type: watch.Bookmark, Object: newTestUser("", "4")},
+	{Type: watch.Deleted, Object: newTestUser("alice", "5")},
+}
+
+// newFakeWatcher returns a stopped watcher of events, the events are served
+// before the end of the watch.
+func newFakeWatcher(events []watch.Event) *watch.FakeWatcher {
+	watcher := watch.NewFakeWithChanSize(len(events), false)
+	for _, event := range events {
+		watcher.Action(event.Type, event.Object)
+	}
+	watcher.Stop()
+	return watcher
+}
+
+// decodeEvent decodes the frame of an event of users.
+func decodeEvent(t *testing.T, frame []byte) watch.Event {
+	t.Helper()
+	event := meta.WatchEvent{}
+	if err := encodingjson.Unmarshal(frame, &event); err != nil {
+		t.Fatalf("unable to decode the event %q: %v", frame, err)
+	}
+	user := &model.User{}
+	if err := encodingjson.Unmarshal(event.Object, user); err != nil {
+		t.Fatalf("unable to decode the object of the event %q: %v", frame, err)
+	}
+	return watch.Event{Type: watch.EventType(event.Type), Object: user}
+}
+
+func TestWatchHTTP(t *testing.T) {
+	server := httptest.NewServer(&WatchServer{
+		Watching:       newFakeWatcher(testEvents),
+		Scope:          newTestScope(),
+		TimeoutFactory: &realTimeoutFactory{},
+	})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected the content type application/json, got %q", contentType)
+	}
+	if !reflect.DeepEqual(resp.TransferEncoding, []string{"chunked"}) {
+		t.Errorf("expected a chunked response, got the transfer encoding %v", resp.TransferEncoding)
+	}
+
+	// an event per line
+	decoder := encodingjson.NewDecoder(resp.Body)
+	var got []watch.Event
+	for {
+		var frame encodingjson.RawMessage
+		if err := decoder.Decode(&frame); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unable to read the events: %v", err)
+		}
+		got = append(got, decodeEvent(t, frame))
+	}
+	if !reflect.DeepEqual(got, testEvents) {
+		t.Errorf("expected the events %#v, got %#v", testEvents, got)
+	}
+}
+
+func TestWatchWebsocket(t *testing.T) {
+	server := httptest.NewServer(&WatchServer{
+		Watching:       newFakeWatcher(testEvents),
+		Scope:          newTestScope(),
+		TimeoutFactory: &realTimeoutFactory{},
+	})
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws://"+server.Listener.Addr().String(), "", "http://127.0.0.1/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ws.Close()
+
+	// an event per message
+	var got []watch.Event
+	for {
+		var frame string
+		if err := websocket.Message.Receive(ws, &frame); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unable to read the events: %v", err)
+		}
+		got = append(got, decodeEvent(t, []byte(frame)))
+	}
+	if !reflect.DeepEqual(got, testEvents) {
+		t.Errorf("expected the events %#v, got %#v", testEvents, got)
+	}
+}
+
+func TestWatchTimeout(t *testing.T) {
+	watcher := watch.NewFake()
+	timeoutFactory := newFakeTimeoutFactory()
+	server := httptest.NewServer(&WatchServer{
+		Watching:       watcher,
+		Scope:          newTestScope(),
+		TimeoutFactory: timeoutFactory,
+	})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	decoder := encodingjson.NewDecoder(resp.Body)
+	watcher.Add(newTestUser("alice", "2"))
+	var frame encodingjson.RawMessage
+	if err := decoder.Decode(&frame); err != nil {
+		t.Fatalf("unable to read the event: %v", err)
+	}
+	if event := decodeEvent(t, frame); event.Type != watch.Added {
+		t.Errorf("expected an added event, got %#v", event)
+	}
+
+	// the response ends when the watch times out
+	timeoutFactory.timeoutCh <- time.Now()
+	select {
+	case <-timeoutFactory.done:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for the watch to time out")
+	}
+	if err := decoder.Decode(&frame); err != io.EOF {
+		t.Errorf("expected the response to end, got %q, %v", frame, err)
+	}
+}
+
+func TestListResourceWatchBookmarks(t *testing.T) {
+	watcher := watch.NewFake()
+	storage := &fakeWatchStorage{watcher: watcher}
+	server := httptest.NewServer(ListResource(storage, storage, newTestScope(), 0))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?watch=true&allowWatchBookmarks=true&resourceVersion=1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if storage.options == nil || !storage.options.AllowWatchBookmarks || storage.options.ResourceVersion != "1" {
+		t.Fatalf("expected a watch allowing bookmarks from 1, got %#v", storage.options)
+	}
+
+	// no bookmark is sent but the ones of the storage
+	decoder := encodingjson.NewDecoder(resp.Body)
+	frames := make(chan encodingjson.RawMessage)
+	go func() {
+		defer close(frames)
+		for {
+			var frame encodingjson.RawMessage
+			if err := decoder.Decode(&frame); err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+	select {
+	case frame := <-frames:
+		t.Fatalf("unexpected event: %s", frame)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	bookmark := newTestUser("", "10")
+	watcher.Action(watch.Bookmark, bookmark)
+	select {
+	case frame := <-frames:
+		expected := watch.Event{Type: watch.Bookmark, Object: bookmark}
+		if event := decodeEvent(t, frame); !reflect.DeepEqual(event, expected) {
+			t.Errorf("expected the bookmark %#v, got %#v", expected, event)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for the bookmark")
+	}
+
+	watcher.Stop()
+	for frame := range frames {
+		t.Errorf("unexpected event: %s", frame)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		t.Errorf("expected a JSON response, got %q", resp.Header.Get("Content-Type"))
+	}
+}
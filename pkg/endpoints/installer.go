@@ -0,0 +1,85 @@
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/x893675/opa-server/pkg/endpoints/handlers"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// APIGroupVersion is a helper for exposing rest.StandardStorage objects as
+// http.Handlers. Every resource is served at
+// <Root>/<Group>/<Version>/<resource>[/<name>].
+type APIGroupVersion struct {
+	// Storage maps the plural resource names to their storage.
+	Storage map[string]rest.StandardStorage
+
+	// Root is the path the group version is served under, e.g. "/apis".
+	Root string
+
+	// GroupVersion is the external group version.
+	GroupVersion schema.GroupVersion
+
+	// Serializer encodes responses and decodes request bodies.
+	Serializer runtime.Serializer
+
+	// MaxRequestBodyBytes is the limit on the size of request bodies, it
+	// is not enforced when zero.
+	MaxRequestBodyBytes int64
+
+	// MinRequestTimeout is the minimum time a watch is kept open if it does
+	// not set timeoutSeconds. Each watch picks a random timeout between it
+	// and twice that, to spread out reconnects. Watches do not time out if
+	// it is zero.
+	MinRequestTimeout time.Duration
+}
+
+// InstallREST registers the REST handlers (storage, watch) of every resource
+// into router. Watches are served as newline delimited JSON events, or over a
+// websocket if the request asks for an upgrade.
+func (g *APIGroupVersion) InstallREST(router *mux.Router) error {
+	if g.Serializer == nil {
+		return fmt.Errorf("group version %s must have a Serializer", g.GroupVersion)
+	}
+	prefix := path.Join(g.Root, g.GroupVersion.Group, g.GroupVersion.Version)
+
+	// install the resources in a stable order
+	resources := make([]string, 0, len(g.Storage))
+	for resource := range g.Storage {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	for _, resource := range resources {
+		if len(resource) == 0 {
+			return fmt.Errorf("group version %s has a storage with an empty resource name", g.GroupVersion)
+		}
+		g.installResource(router, prefix, resource, g.Storage[resource])
+	}
+	return nil
+}
+
+func (g *APIGroupVersion) installResource(router *mux.Router, prefix, resource string, storage rest.StandardStorage) {
+	scope := &handlers.RequestScope{
+		Serializer:          g.Serializer,
+		Resource:            g.GroupVersion.WithResource(resource).GroupResource(),
+		MaxRequestBodyBytes: g.MaxRequestBodyBytes,
+	}
+
+	resourcePath := prefix + "/" + resource
+	router.Handle(resourcePath, handlers.ListResource(storage, storage, scope, g.MinRequestTimeout)).Methods(http.MethodGet)
+	router.Handle(resourcePath, handlers.CreateResource(storage, scope)).Methods(http.MethodPost)
+	router.Handle(resourcePath, handlers.DeleteCollection(storage, scope)).Methods(http.MethodDelete)
+
+	itemPath := resourcePath + "/{name}"
+	router.Handle(itemPath, handlers.GetResource(storage, scope)).Methods(http.MethodGet)
+	router.Handle(itemPath, handlers.UpdateResource(storage, scope)).Methods(http.MethodPut)
+	router.Handle(itemPath, handlers.DeleteResource(storage, scope)).Methods(http.MethodDelete)
+}
@@ -0,0 +1,356 @@
+package endpoints
+
+import (
+	"bytes"
+	encodingjson "encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/x893675/opa-server/pkg/model"
+	groupstore "github.com/x893675/opa-server/pkg/registry/rbac/group/storage"
+	userstore "github.com/x893675/opa-server/pkg/registry/rbac/user/storage"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const usersPath = "/apis/rbac.opa.io/v1/users"
+
+// newTestServer serves the users and the groups, kept in memory, the way
+// the server does.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := meta.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{})
+
+	newStorage := func(newFunc func() runtime.Object) storage.Interface {
+		return kvstore.New(memory.NewBackend(0), codec, newFunc, "/registry", value.IdentityTransformer, true)
+	}
+	users, err := userstore.NewREST(newStorage(func() runtime.Object { return &model.User{} }), nil, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := groupstore.NewREST(newStorage(func() runtime.Object { return &model.Group{} }), nil, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiGroup := &APIGroupVersion{
+		Storage:      map[string]rest.StandardStorage{"users": users, "groups": groups},
+		Root:         "/apis",
+		GroupVersion: model.SchemeGroupVersion,
+		Serializer:   codec,
+	}
+	router := mux.NewRouter()
+	router.UseEncodedPath()
+	if err := apiGroup.InstallREST(router); err != nil {
+		t.Fatalf("InstallREST failed: %v", err)
+	}
+	return httptest.NewServer(router)
+}
+
+// do sends a request with the JSON encoding of body, if it is not nil, and
+// returns the status code and the body of the response.
+func do(t *testing.T, method, url string, body interface{}) (int, []byte) {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := encodingjson.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// createUser creates a user through the API and returns it.
+func createUser(t *testing.T, server *httptest.Server, user *model.User) *model.User {
+	t.Helper()
+	code, body := do(t, http.MethodPost, server.URL+usersPath, user)
+	if code != http.StatusCreated {
+		t.Fatalf("expected the user %s to be created, got %d: %s", user.Name, code, body)
+	}
+	created := &model.User{}
+	if err := encodingjson.Unmarshal(body, created); err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func newTestUser(name string, labels map[string]string) *model.User {
+	return &model.User{ObjectMeta: meta.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestInstallREST(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	alice := newTestUser("alice", nil)
+	// the requests are sent in order
+	testCases := []struct {
+		method string
+		path   string
+		body   interface{}
+		code   int
+	}{
+		{method: http.MethodPost, path: usersPath, body: alice, code: http.StatusCreated},
+		{method: http.MethodGet, path: usersPath, code: http.StatusOK},
+		{method: http.MethodGet, path: usersPath + "/alice", code: http.StatusOK},
+		{method: http.MethodPut, path: usersPath + "/alice", body: alice, code: http.StatusOK},
+		{method: http.MethodDelete, path: usersPath + "/alice", code: http.StatusOK},
+		{method: http.MethodDelete, path: usersPath, code: http.StatusOK},
+		{method: http.MethodGet, path: "/apis/rbac.opa.io/v1/groups", code: http.StatusOK},
+		// resources, versions and groups that are not installed
+		{method: http.MethodGet, path: "/apis/rbac.opa.io/v1/roles", code: http.StatusNotFound},
+		{method: http.MethodGet, path: "/apis/rbac.opa.io/v2/users", code: http.StatusNotFound},
+		{method: http.MethodGet, path: "/apis/rbac.opa.io/users", code: http.StatusNotFound},
+		{method: http.MethodGet, path: usersPath + "/alice/roles", code: http.StatusNotFound},
+		{method: http.MethodPatch, path: usersPath + "/alice", code: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		code, body := do(t, tc.method, server.URL+tc.path, tc.body)
+		if code != tc.code {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tc.method, tc.path, tc.code, code, body)
+		}
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	createUser(t, server, newTestUser("alice", map[string]string{"team": "a"}))
+	createUser(t, server, newTestUser("bob", map[string]string{"team": "a"}))
+	carol := createUser(t, server, newTestUser("carol", map[string]string{"team": "b"}))
+
+	list := func(query url.Values) *model.UserList {
+		t.Helper()
+		code, body := do(t, http.MethodGet, server.URL+usersPath+"?"+query.Encode(), nil)
+		if code != http.StatusOK {
+			t.Fatalf("expected the users to be listed with %v, got %d: %s", query, code, body)
+		}
+		users := &model.UserList{}
+		if err := encodingjson.Unmarshal(body, users); err != nil {
+			t.Fatal(err)
+		}
+		return users
+	}
+	names := func(users *model.UserList) []string {
+		names := []string{}
+		for _, user := range users.Items {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	testCases := []struct {
+		name     string
+		query    url.Values
+		expected []string
+	}{
+		{
+			name:     "everything",
+			query:    url.Values{},
+			expected: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:     "label selector",
+			query:    url.Values{"labelSelector": {"team=a"}},
+			expected: []string{"alice", "bob"},
+		},
+		{
+			name:     "set based label selector",
+			query:    url.Values{"labelSelector": {"team notin (a)"}},
+			expected: []string{"carol"},
+		},
+		{
+			name:     "field selector",
+			query:    url.Values{"fieldSelector": {"metadata.name=bob"}},
+			expected: []string{"bob"},
+		},
+		{
+			name:     "label and field selectors",
+			query:    url.Values{"labelSelector": {"team=a"}, "fieldSelector": {"metadata.name!=bob"}},
+			expected: []string{"alice"},
+		},
+		{
+			name:     "any resource version",
+			query:    url.Values{"resourceVersion": {"0"}},
+			expected: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:     "resource version",
+			query:    url.Values{"resourceVersion": {carol.ResourceVersion}},
+			expected: []string{"alice", "bob", "carol"},
+		},
+	}
+	for _, tc := range testCases {
+		if got := names(list(tc.query)); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected the users %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+
+	// the pages are listed with limit and continue
+	first := list(url.Values{"limit": {"2"}})
+	if got := names(first); !reflect.DeepEqual(got, []string{"alice", "bob"}) || len(first.Continue) == 0 {
+		t.Fatalf("expected a first page of alice and bob, got %v, continue %q", got, first.Continue)
+	}
+	second := list(url.Values{"limit": {"2"}, "continue": {first.Continue}})
+	if got := names(second); !reflect.DeepEqual(got, []string{"carol"}) || len(second.Continue) != 0 {
+		t.Errorf("expected a last page of carol, got %v, continue %q", got, second.Continue)
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	alice := createUser(t, server, newTestUser("alice", nil))
+	stale := *alice
+	createUser(t, server, newTestUser("bob", nil))
+	// alice is updated so that the first version of her is stale
+	alice.Roles = []string{"admin"}
+	if code, body := do(t, http.MethodPut, server.URL+usersPath+"/alice", alice); code != http.StatusOK {
+		t.Fatalf("expected alice to be updated, got %d: %s", code, body)
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		code   int32
+		reason metav1.StatusReason
+		// details are the expected name and kind of the status details.
+		details *metav1.StatusDetails
+	}{
+		{
+			name:    "get not found",
+			method:  http.MethodGet,
+			path:    usersPath + "/carol",
+			code:    http.StatusNotFound,
+			reason:  metav1.StatusReasonNotFound,
+			details: &metav1.StatusDetails{Name: "carol", Group: model.GroupName, Kind: "users"},
+		},
+		{
+			name:    "delete not found",
+			method:  http.MethodDelete,
+			path:    usersPath + "/carol",
+			code:    http.StatusNotFound,
+			reason:  metav1.StatusReasonNotFound,
+			details: &metav1.StatusDetails{Name: "carol", Group: model.GroupName, Kind: "users"},
+		},
+		{
+			name:    "create already exists",
+			method:  http.MethodPost,
+			path:    usersPath,
+			body:    newTestUser("bob", nil),
+			code:    http.StatusConflict,
+			reason:  metav1.StatusReasonAlreadyExists,
+			details: &metav1.StatusDetails{Name: "bob", Group: model.GroupName, Kind: "users"},
+		},
+		{
+			name:    "update conflict",
+			method:  http.MethodPut,
+			path:    usersPath + "/alice",
+			body:    &stale,
+			code:    http.StatusConflict,
+			reason:  metav1.StatusReasonConflict,
+			details: &metav1.StatusDetails{Name: "alice", Group: model.GroupName, Kind: "users"},
+		},
+		{
+			name:   "create invalid",
+			method: http.MethodPost,
+			path:   usersPath,
+			body:   &model.User{ObjectMeta: meta.ObjectMeta{Name: "carol"}, Roles: []string{""}},
+			code:   http.StatusUnprocessableEntity,
+			reason: metav1.StatusReasonInvalid,
+			// the kind of invalid objects is the name of their type
+			details: &metav1.StatusDetails{Name: "carol", Kind: "User", Causes: []metav1.StatusCause{{
+				Type:    metav1.CauseTypeFieldValueRequired,
+				Message: "Required value",
+				Field:   "roles[0]",
+			}}},
+		},
+		{
+			name:   "name mismatch",
+			method: http.MethodPut,
+			path:   usersPath + "/alice",
+			body:   newTestUser("bob", nil),
+			code:   http.StatusBadRequest,
+			reason: metav1.StatusReasonBadRequest,
+		},
+		{
+			name:   "invalid label selector",
+			method: http.MethodGet,
+			path:   usersPath + "?labelSelector=team+in+(",
+			code:   http.StatusBadRequest,
+			reason: metav1.StatusReasonBadRequest,
+		},
+		{
+			name:   "invalid limit",
+			method: http.MethodGet,
+			path:   usersPath + "?limit=-1",
+			code:   http.StatusBadRequest,
+			reason: metav1.StatusReasonBadRequest,
+		},
+		{
+			name:   "invalid continue",
+			method: http.MethodGet,
+			path:   usersPath + "?limit=1&continue=invalid",
+			code:   http.StatusBadRequest,
+			reason: metav1.StatusReasonBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, body := do(t, tc.method, server.URL+tc.path, tc.body)
+			if code != int(tc.code) {
+				t.Errorf("expected status %d, got %d: %s", tc.code, code, body)
+			}
+			status := &metav1.Status{}
+			if err := encodingjson.Unmarshal(body, status); err != nil {
+				t.Fatalf("unable to decode the status %s: %v", body, err)
+			}
+			if status.Kind != "Status" || status.Status != metav1.StatusFailure || status.Code != tc.code || status.Reason != tc.reason || len(status.Message) == 0 {
+				t.Errorf("expected a %s failure status of code %d, got %#v", tc.reason, tc.code, status)
+			}
+			if tc.details != nil && !reflect.DeepEqual(status.Details, tc.details) {
+				t.Errorf("expected the details %#v, got %#v", tc.details, status.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultUserHeader is the header holding the user name, the same as the
+	// default of the kube-apiserver request header authentication.
+	DefaultUserHeader = "X-Remote-User"
+	// DefaultGroupHeader is the header holding the groups of the user.
+	DefaultGroupHeader = "X-Remote-Group"
+	// CheckResultHeader is set to "allowed" on the requests passed upstream
+	// and to "denied" on the responses of denied requests.
+	CheckResultHeader = "x-ext-authz-check-result"
+)
+
+// Server implements the Envoy external authorization service
+// envoy.service.auth.v3.Authorization. The HTTP request attributes of a
+// check are translated into authorizer attributes the way the kube-apiserver
+// does: the method is mapped to a verb and paths under /api and /apis are
+// parsed into resource requests, all other paths are non resource URLs.
+type Server struct {
+	authorizer          authorizer.Authorizer
+	requestInfoResolver *genericapirequest.RequestInfoFactory
+	userHeader          string
+	groupHeader         string
+}
+
+var _ authv3.AuthorizationServer = &Server{}
+
+// New returns a Server that authorizes requests with authorizer. The user is
+// read from userHeader and its comma separated groups from groupHeader.
+func New(authorizer authorizer.Authorizer, userHeader, groupHeader string) *Server {
+	return &Server{
+		authorizer: authorizer,
+		requestInfoResolver: &genericapirequest.RequestInfoFactory{
+			APIPrefixes:          sets.NewString("api", "apis"),
+			GrouplessAPIPrefixes: sets.NewString("api"),
+		},
+		// envoy passes the header names in lower case
+		userHeader:  strings.ToLower(userHeader),
+		groupHeader: strings.ToLower(groupHeader),
+	}
+}
+
+// Register registers the Authorization service on grpcServer.
+func (s *Server) Register(grpcServer *grpc.Server) {
+	authv3.RegisterAuthorizationServer(grpcServer, s)
+}
+
+// Check allows the request described by req if the authorizer allows it.
+// Requests without a user are answered with 401, denied requests with 403,
+// in both cases with a Status as JSON body.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	if httpReq == nil {
+		return nil, status.Error(codes.InvalidArgument, "the check request has no HTTP request attributes")
+	}
+
+	attrs, err := s.attributes(httpReq)
+	if err != nil {
+		return denied(apierrors.NewBadRequest(err.Error())), nil
+	}
+	if attrs.User == nil {
+		return denied(apierrors.NewUnauthorized(fmt.Sprintf("the %s header is not set", s.userHeader))), nil
+	}
+
+	decision, reason, err := s.authorizer.Authorize(ctx, attrs)
+	if err != nil {
+		klog.Errorf("Failed to authorize %s %s: %v", attrs.Verb, httpReq.GetPath(), err)
+		return denied(apierrors.NewInternalError(err)), nil
+	}
+	if decision != authorizer.DecisionAllow {
+		klog.V(4).Infof("Forbidden %s %s for user %q: %s", httpReq.GetMethod(), httpReq.GetPath(), attrs.User.GetName(), reason)
+		return denied(responsewriters.ForbiddenError(attrs, reason)), nil
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{header(CheckResultHeader, "allowed")},
+			},
+		},
+	}, nil
+}
+
+// attributes returns the authorizer attributes of httpReq.
+func (s *Server) attributes(httpReq *authv3.AttributeContext_HttpRequest) (authorizer.AttributesRecord, error) {
+	// the path holds the query as well, e.g. ?watch=true tells a watch from a
+	// get
+	u, err := url.ParseRequestURI(httpReq.GetPath())
+	if err != nil {
+		return authorizer.AttributesRecord{}, fmt.Errorf("invalid path %q: %v", httpReq.GetPath(), err)
+	}
+	info, err := s.requestInfoResolver.NewRequestInfo(&http.Request{Method: httpReq.GetMethod(), URL: u})
+	if err != nil {
+		return authorizer.AttributesRecord{}, err
+	}
+
+	attrs := authorizer.AttributesRecord{
+		Verb:            info.Verb,
+		Namespace:       info.Namespace,
+		APIGroup:        info.APIGroup,
+		APIVersion:      info.APIVersion,
+		Resource:        info.Resource,
+		Subresource:     info.Subresource,
+		Name:            info.Name,
+		ResourceRequest: info.IsResourceRequest,
+		Path:            info.Path,
+	}
+
+	headers := httpReq.GetHeaders()
+	if name := headers[s.userHeader]; len(name) > 0 {
+		var groups []string
+		for _, group := range strings.Split(headers[s.groupHeader], ",") {
+			if group = strings.TrimSpace(group); len(group) > 0 {
+				groups = append(groups, group)
+			}
+		}
+		attrs.User = &user.DefaultInfo{Name: name, Groups: groups}
+	}
+	return attrs, nil
+}
+
+// denied returns the response denying a request because of err.
+func denied(err error) *authv3.CheckResponse {
+	apiStatus := responsewriters.ErrorToAPIStatus(err)
+	body, err := json.Marshal(apiStatus)
+	if err != nil {
+		// the status is a plain struct, this never happens
+		klog.Errorf("Error encoding status %#v: %v", apiStatus, err)
+	}
+
+	code := codes.PermissionDenied
+	switch apiStatus.Code {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: apiStatus.Message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode(apiStatus.Code)},
+				Headers: []*corev3.HeaderValueOption{
+					header(CheckResultHeader, "denied"),
+					header("Content-Type", "application/json"),
+				},
+				Body: string(body),
+			},
+		},
+	}
+}
+
+func header(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header: &corev3.HeaderValue{Key: key, Value: value},
+	}
+}
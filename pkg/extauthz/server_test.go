@@ -0,0 +1,142 @@
+package extauthz
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+	opaauthorizer "github.com/x893675/opa-server/pkg/authorizer/opa"
+)
+
+// rbacData is the RBAC data document the replicator writes, with a user
+// granted the metrics URL and reads of deployments.
+const rbacData = `{
+	"roles": {"bob": ["monitoring"]},
+	"permissions": {"monitoring": [
+		{"verbs": ["get"], "apiGroups": [], "resources": [], "resourceNames": [], "nonResourceURLs": ["/metrics"]},
+		{"verbs": ["get", "list"], "apiGroups": ["apps"], "resources": ["deployments"], "resourceNames": [], "nonResourceURLs": []}
+	]}
+}`
+
+func newTestServer(t *testing.T) *Server {
+	files, err := loader.All([]string{"../../api.rego"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := util.UnmarshalJSON([]byte(rbacData), &data); err != nil {
+		t.Fatal(err)
+	}
+	store := inmem.NewFromObject(map[string]interface{}{"api": map[string]interface{}{"rbac": data}})
+	manager, err := plugins.New(nil, "test", store, plugins.InitFiles(*files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return New(opaauthorizer.New(manager), DefaultUserHeader, DefaultGroupHeader)
+}
+
+func TestCheck(t *testing.T) {
+	server := newTestServer(t)
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		user       string
+		expectCode typev3.StatusCode
+	}{
+		{
+			name:       "granted non resource URL",
+			method:     "GET",
+			path:       "/metrics",
+			user:       "bob",
+			expectCode: typev3.StatusCode_OK,
+		},
+		{
+			name:       "non resource URL that is not granted",
+			method:     "GET",
+			path:       "/debug/pprof",
+			user:       "bob",
+			expectCode: typev3.StatusCode_Forbidden,
+		},
+		{
+			name:       "non resource URL with a verb that is not granted",
+			method:     "POST",
+			path:       "/metrics",
+			user:       "bob",
+			expectCode: typev3.StatusCode_Forbidden,
+		},
+		{
+			name:       "unknown user on a non resource URL",
+			method:     "GET",
+			path:       "/metrics",
+			user:       "mallory",
+			expectCode: typev3.StatusCode_Forbidden,
+		},
+		{
+			name:       "no user",
+			method:     "GET",
+			path:       "/metrics",
+			expectCode: typev3.StatusCode_Unauthorized,
+		},
+		{
+			name:       "granted resource",
+			method:     "GET",
+			path:       "/apis/apps/v1/namespaces/default/deployments?limit=10",
+			user:       "bob",
+			expectCode: typev3.StatusCode_OK,
+		},
+		{
+			name:       "resource with a verb that is not granted",
+			method:     "DELETE",
+			path:       "/apis/apps/v1/namespaces/default/deployments/web",
+			user:       "bob",
+			expectCode: typev3.StatusCode_Forbidden,
+		},
+		{
+			name:       "invalid path",
+			method:     "GET",
+			path:       "metrics",
+			user:       "bob",
+			expectCode: typev3.StatusCode_BadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if len(tc.user) > 0 {
+				headers["x-remote-user"] = tc.user
+			}
+			resp, err := server.Check(context.Background(), &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{
+							Method:  tc.method,
+							Path:    tc.path,
+							Headers: headers,
+						},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			code := typev3.StatusCode_OK
+			if denied := resp.GetDeniedResponse(); denied != nil {
+				code = denied.GetStatus().GetCode()
+			}
+			if code != tc.expectCode {
+				t.Errorf("expected status %v, got %v: %s", tc.expectCode, code, resp.GetStatus().GetMessage())
+			}
+		})
+	}
+}
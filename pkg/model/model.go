@@ -1,8 +1,234 @@
 package model
 
-import "github.com/x893675/opa-server/pkg/storage/meta"
+import (
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
 
 type User struct {
+	meta.TypeMeta   `json:",inline"`
 	meta.ObjectMeta `json:",inline"`
 	Username        string `json:"username"`
+	// Roles holds the names of the roles granted to this user.
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (u *User) SetZeroValue() error {
+	*u = User{}
+	return nil
+}
+
+// UserList is a collection of Users.
+type UserList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:",inline"`
+	Items         []User `json:"items"`
+}
+
+func (l *UserList) SetZeroValue() error {
+	*l = UserList{}
+	return nil
+}
+
+// PolicyRule holds information that describes a policy rule, but does not contain information
+// about who the rule applies to.
+type PolicyRule struct {
+	// Verbs is a list of verbs that apply to ALL the resources contained in this rule. "*" represents all kinds.
+	Verbs []string `json:"verbs"`
+	// APIGroups is the name of the APIGroup that contains the resources. If multiple API groups are specified,
+	// any action requested against one of the enumerated resources in any API group will be allowed.
+	// +optional
+	APIGroups []string `json:"apiGroups,omitempty"`
+	// Resources is a list of resources this rule applies to. "*" represents all resources.
+	// +optional
+	Resources []string `json:"resources,omitempty"`
+	// ResourceNames is an optional white list of names that the rule applies to.
+	// An empty set means that everything is allowed.
+	// +optional
+	ResourceNames []string `json:"resourceNames,omitempty"`
+	// NonResourceURLs is a set of partial urls that a user should have access to.
+	// *s are allowed, but only as the full, final step in the path.
+	// +optional
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+}
+
+// Role is a named grouping of PolicyRules.
+type Role struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:",inline"`
+	// Rules holds all the PolicyRules for this Role.
+	Rules []PolicyRule `json:"rules"`
+}
+
+func (r *Role) SetZeroValue() error {
+	*r = Role{}
+	return nil
+}
+
+// RoleList is a collection of Roles.
+type RoleList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:",inline"`
+	Items         []Role `json:"items"`
+}
+
+func (l *RoleList) SetZeroValue() error {
+	*l = RoleList{}
+	return nil
+}
+
+// ClusterRole is a named grouping of PolicyRules that may be referenced by
+// a RoleBinding or ClusterRoleBinding.
+type ClusterRole struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:",inline"`
+	// Rules holds all the PolicyRules for this ClusterRole.
+	Rules []PolicyRule `json:"rules"`
+}
+
+func (r *ClusterRole) SetZeroValue() error {
+	*r = ClusterRole{}
+	return nil
+}
+
+// ClusterRoleList is a collection of ClusterRoles.
+type ClusterRoleList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:",inline"`
+	Items         []ClusterRole `json:"items"`
+}
+
+func (l *ClusterRoleList) SetZeroValue() error {
+	*l = ClusterRoleList{}
+	return nil
+}
+
+const (
+	// GroupName is the API group of the RBAC types.
+	GroupName = "rbac.opa.io"
+
+	// UserKind and GroupKind are the kinds a Subject may refer to.
+	UserKind  = "User"
+	GroupKind = "Group"
+
+	// RoleKind and ClusterRoleKind are the kinds a RoleRef may refer to.
+	RoleKind        = "Role"
+	ClusterRoleKind = "ClusterRole"
+)
+
+// SchemeGroupVersion is the group version the RBAC types are served under.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1"}
+
+// Resource takes an unqualified resource and returns a Group qualified GroupResource
+func Resource(resource string) schema.GroupResource {
+	return SchemeGroupVersion.WithResource(resource).GroupResource()
+}
+
+// Subject contains a reference to the object or user identities a role binding applies to.
+type Subject struct {
+	// Kind of object being referenced. Values defined by this API group are "User" and "Group".
+	Kind string `json:"kind"`
+	// APIGroup holds the API group of the referenced subject.
+	// Defaults to "rbac.opa.io".
+	// +optional
+	APIGroup string `json:"apiGroup,omitempty"`
+	// Name of the object being referenced.
+	Name string `json:"name"`
+}
+
+// RoleRef contains information that points to the role being used.
+type RoleRef struct {
+	// APIGroup is the group for the resource being referenced.
+	APIGroup string `json:"apiGroup"`
+	// Kind is the type of resource being referenced, "Role" or "ClusterRole".
+	Kind string `json:"kind"`
+	// Name is the name of resource being referenced.
+	Name string `json:"name"`
+}
+
+// RoleBinding references a role, but does not contain it. It adds who
+// information via Subjects. It can reference a Role or a ClusterRole.
+type RoleBinding struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:",inline"`
+	// Subjects holds references to the objects the role applies to.
+	// +optional
+	Subjects []Subject `json:"subjects,omitempty"`
+	// RoleRef can reference a Role or a ClusterRole.
+	// If the RoleRef cannot be resolved, the binding grants nothing.
+	RoleRef RoleRef `json:"roleRef"`
+}
+
+func (b *RoleBinding) SetZeroValue() error {
+	*b = RoleBinding{}
+	return nil
+}
+
+// RoleBindingList is a collection of RoleBindings.
+type RoleBindingList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:",inline"`
+	Items         []RoleBinding `json:"items"`
+}
+
+func (l *RoleBindingList) SetZeroValue() error {
+	*l = RoleBindingList{}
+	return nil
+}
+
+// ClusterRoleBinding references a ClusterRole, but does not contain it. It
+// adds who information via Subjects.
+type ClusterRoleBinding struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:",inline"`
+	// Subjects holds references to the objects the role applies to.
+	// +optional
+	Subjects []Subject `json:"subjects,omitempty"`
+	// RoleRef can only reference a ClusterRole.
+	// If the RoleRef cannot be resolved, the binding grants nothing.
+	RoleRef RoleRef `json:"roleRef"`
+}
+
+func (b *ClusterRoleBinding) SetZeroValue() error {
+	*b = ClusterRoleBinding{}
+	return nil
+}
+
+// ClusterRoleBindingList is a collection of ClusterRoleBindings.
+type ClusterRoleBindingList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:",inline"`
+	Items         []ClusterRoleBinding `json:"items"`
+}
+
+func (l *ClusterRoleBindingList) SetZeroValue() error {
+	*l = ClusterRoleBindingList{}
+	return nil
+}
+
+// Group is a named set of users that may be used as a Subject.
+type Group struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:",inline"`
+	// Users holds the names of the members of this group.
+	// +optional
+	Users []string `json:"users,omitempty"`
+}
+
+func (g *Group) SetZeroValue() error {
+	*g = Group{}
+	return nil
+}
+
+// GroupList is a collection of Groups.
+type GroupList struct {
+	meta.TypeMeta `json:",inline"`
+	meta.ListMeta `json:",inline"`
+	Items         []Group `json:"items"`
+}
+
+func (l *GroupList) SetZeroValue() error {
+	*l = GroupList{}
+	return nil
 }
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"github.com/x893675/opa-server/pkg/runtime"
+)
+
+var (
+	// SchemeBuilder collects the functions that add the RBAC types to a scheme.
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	// AddToScheme adds the RBAC types to a scheme, under SchemeGroupVersion.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+// Adds the list of known types to the given scheme.
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&User{},
+		&UserList{},
+		&Role{},
+		&RoleList{},
+		&ClusterRole{},
+		&ClusterRoleList{},
+		&RoleBinding{},
+		&RoleBindingList{},
+		&ClusterRoleBinding{},
+		&ClusterRoleBindingList{},
+		&Group{},
+		&GroupList{},
+	)
+	return nil
+}
@@ -1,4 +1,27 @@
 package opareplicator
 
+import (
+	"context"
+
+	"github.com/x893675/opa-server/pkg/watch"
+)
+
+// Interface replicates the RBAC objects kept in the storage backend into the
+// data document of an OPA instance, so that policies always evaluate against
+// the current set of users and roles.
 type Interface interface {
+	// Run replicates until ctx is cancelled. It returns nil on cancellation
+	// and an error if replication cannot be started at all.
+	Run(ctx context.Context) error
+	// HasSynced returns true once the objects of every resource have been
+	// written into the OPA store at least once. It stays true afterwards.
+	HasSynced() bool
+	// Resources returns the names of the resources replicated, e.g. "users".
+	Resources() []string
+	// Watch returns the ADDED, MODIFIED and DELETED changes of the objects of
+	// resource, as the replicator watches them, so that other consumers
+	// share its watch of the storage. The changes are only sent while Run
+	// runs, and the watch is closed once it returns. The changes of the
+	// first list are only sent to the watches started before Run.
+	Watch(resource string) (watch.Interface, error)
 }
@@ -0,0 +1,54 @@
+package opareplicator
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/x893675/opa-server/pkg/watch"
+)
+
+var objectChanges = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "opa_server_rbac_object_changes_total",
+		Help: "Number of changes of the RBAC objects watched by the replicator, split by resource and event type.",
+	},
+	[]string{"resource", "type"},
+)
+
+// RegisterMetrics registers the metrics of the replicator with registerer.
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(objectChanges)
+}
+
+// RecordChanges counts the changes of the objects of every resource of r in
+// the opa_server_rbac_object_changes_total metric, until ctx is cancelled or
+// r stops running. It must be called before r runs to count the objects of
+// the first list too.
+func RecordChanges(ctx context.Context, r Interface) error {
+	var watchers []watch.Interface
+	for _, resource := range r.Resources() {
+		w, err := r.Watch(resource)
+		if err != nil {
+			for _, w := range watchers {
+				w.Stop()
+			}
+			return err
+		}
+		watchers = append(watchers, w)
+		go func(resource string, w watch.Interface) {
+			defer w.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event, ok := <-w.ResultChan():
+					if !ok {
+						return
+					}
+					objectChanges.WithLabelValues(resource, string(event.Type)).Inc()
+				}
+			}
+		}(resource, w)
+	}
+	return nil
+}
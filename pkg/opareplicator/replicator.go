@@ -0,0 +1,501 @@
+package opareplicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	opastorage "github.com/open-policy-agent/opa/storage"
+	"github.com/x893675/opa-server/pkg/cache"
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/watch"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultUsersKey is the key, relative to the storage prefix, users are kept under.
+	DefaultUsersKey = "/users"
+	// DefaultRolesKey is the key, relative to the storage prefix, roles are kept under.
+	DefaultRolesKey = "/roles"
+	// DefaultClusterRolesKey is the key, relative to the storage prefix, cluster roles are kept under.
+	DefaultClusterRolesKey = "/clusterroles"
+	// DefaultRoleBindingsKey is the key, relative to the storage prefix, role bindings are kept under.
+	DefaultRoleBindingsKey = "/rolebindings"
+	// DefaultClusterRoleBindingsKey is the key, relative to the storage prefix, cluster role bindings are kept under.
+	DefaultClusterRoleBindingsKey = "/clusterrolebindings"
+	// DefaultGroupsKey is the key, relative to the storage prefix, groups are kept under.
+	DefaultGroupsKey = "/groups"
+
+	defaultRetryPeriod = time.Second
+
+	// changeQueueLength is the number of changes queued for each watcher of
+	// the changes of a resource. The informer waits for a watcher whose
+	// queue is full, so that the replicator never misses a change.
+	changeQueueLength = 100
+)
+
+var (
+	// rolesPath is where api.rego imports the user -> role names mapping from.
+	rolesPath = opastorage.MustParsePath("/api/rbac/roles")
+	// permissionsPath is where api.rego imports the role -> grants mapping from.
+	permissionsPath = opastorage.MustParsePath("/api/rbac/permissions")
+	// clusterRolesPath is where api.rego imports the cluster role -> grants mapping from.
+	clusterRolesPath = opastorage.MustParsePath("/api/rbac/clusterroles")
+	// roleBindingsPath is where api.rego imports the role bindings from.
+	roleBindingsPath = opastorage.MustParsePath("/api/rbac/rolebindings")
+	// clusterRoleBindingsPath is where api.rego imports the cluster role bindings from.
+	clusterRoleBindingsPath = opastorage.MustParsePath("/api/rbac/clusterrolebindings")
+	// groupsPath is where api.rego imports the group -> user names mapping from.
+	groupsPath = opastorage.MustParsePath("/api/rbac/groups")
+)
+
+// Config is the configuration for creating a replicator.
+type Config struct {
+	// Store is the OPA store the RBAC data is written into.
+	Store opastorage.Store
+
+	// Users is the storage model.User objects are read from.
+	Users storage.Interface
+	// UsersKey is the key users are kept under. Defaults to DefaultUsersKey.
+	UsersKey string
+
+	// Roles is the storage model.Role objects are read from.
+	Roles storage.Interface
+	// RolesKey is the key roles are kept under. Defaults to DefaultRolesKey.
+	RolesKey string
+
+	// ClusterRoles is the storage model.ClusterRole objects are read from.
+	ClusterRoles storage.Interface
+	// ClusterRolesKey is the key cluster roles are kept under. Defaults to
+	// DefaultClusterRolesKey.
+	ClusterRolesKey string
+
+	// RoleBindings is the storage model.RoleBinding objects are read from.
+	RoleBindings storage.Interface
+	// RoleBindingsKey is the key role bindings are kept under. Defaults to
+	// DefaultRoleBindingsKey.
+	RoleBindingsKey string
+
+	// ClusterRoleBindings is the storage model.ClusterRoleBinding objects are
+	// read from.
+	ClusterRoleBindings storage.Interface
+	// ClusterRoleBindingsKey is the key cluster role bindings are kept under.
+	// Defaults to DefaultClusterRoleBindingsKey.
+	ClusterRoleBindingsKey string
+
+	// Groups is the storage model.Group objects are read from.
+	Groups storage.Interface
+	// GroupsKey is the key groups are kept under. Defaults to DefaultGroupsKey.
+	GroupsKey string
+
+	// RetryPeriod is how long to wait before writing the objects of a
+	// resource again after a failed write. Defaults to one second.
+	RetryPeriod time.Duration
+}
+
+// resource describes how the objects under one storage key are mirrored into
+// a subtree of the OPA data document.
+type resource struct {
+	name string
+	// informer lists and watches the objects, its cache is the state the
+	// subtree is written from.
+	informer cache.SharedInformer
+	// changes distributes the changes the informer watches to the
+	// replicator and to the watchers returned by Watch, so that they all
+	// share its single watch of the storage.
+	changes *watch.Broadcaster
+	// path is the OPA data path the objects are mirrored under.
+	path opastorage.Path
+	// toData returns the member of path and the value obj is written as.
+	toData func(obj runtime.Object) (string, interface{}, error)
+
+	// lock serializes the writes to the subtree, so that a write always
+	// reflects a state of the cache at least as recent as the previous one.
+	lock sync.Mutex
+	// synced is set to 1 once the whole subtree has been written.
+	synced int32
+	// rewriteCh asks for the whole subtree to be written again, once the
+	// informer has synced and after a change could not be written.
+	rewriteCh chan struct{}
+}
+
+// rewrite asks for the whole subtree of res to be written again.
+func (res *resource) rewrite() {
+	select {
+	case res.rewriteCh <- struct{}{}:
+	default:
+	}
+}
+
+type replicator struct {
+	store       opastorage.Store
+	resources   []*resource
+	retryPeriod time.Duration
+
+	// lock guards stopped, the broadcasters of the resources must not be
+	// watched once they are shut down.
+	lock    sync.Mutex
+	stopped bool
+}
+
+var _ Interface = &replicator{}
+
+// New returns a replicator that follows the RBAC objects with a shared
+// informer per resource, and pushes them into c.Store:
+//  * every user becomes data.api.rbac.roles[<user name>] = [<role name>, ...]
+//  * every role becomes data.api.rbac.permissions[<role name>] = [<rule>, ...]
+//  * every cluster role becomes data.api.rbac.clusterroles[<name>] = [<rule>, ...]
+//  * every role binding becomes data.api.rbac.rolebindings[<name>] = {"subjects": [...], "roleRef": {...}}
+//  * every cluster role binding becomes data.api.rbac.clusterrolebindings[<name>], like role bindings
+//  * every group becomes data.api.rbac.groups[<group name>] = [<user name>, ...]
+func New(c Config) (Interface, error) {
+	if c.Store == nil {
+		return nil, fmt.Errorf("an OPA store is required")
+	}
+	if len(c.UsersKey) == 0 {
+		c.UsersKey = DefaultUsersKey
+	}
+	if len(c.RolesKey) == 0 {
+		c.RolesKey = DefaultRolesKey
+	}
+	if len(c.ClusterRolesKey) == 0 {
+		c.ClusterRolesKey = DefaultClusterRolesKey
+	}
+	if len(c.RoleBindingsKey) == 0 {
+		c.RoleBindingsKey = DefaultRoleBindingsKey
+	}
+	if len(c.ClusterRoleBindingsKey) == 0 {
+		c.ClusterRoleBindingsKey = DefaultClusterRoleBindingsKey
+	}
+	if len(c.GroupsKey) == 0 {
+		c.GroupsKey = DefaultGroupsKey
+	}
+	if c.RetryPeriod <= 0 {
+		c.RetryPeriod = defaultRetryPeriod
+	}
+
+	users, err := newResource("users", c.UsersKey, c.Users, rolesPath,
+		func() runtime.Object { return &model.UserList{} }, userToData)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := newResource("roles", c.RolesKey, c.Roles, permissionsPath,
+		func() runtime.Object { return &model.RoleList{} }, roleToData)
+	if err != nil {
+		return nil, err
+	}
+	clusterRoles, err := newResource("clusterroles", c.ClusterRolesKey, c.ClusterRoles, clusterRolesPath,
+		func() runtime.Object { return &model.ClusterRoleList{} }, clusterRoleToData)
+	if err != nil {
+		return nil, err
+	}
+	roleBindings, err := newResource("rolebindings", c.RoleBindingsKey, c.RoleBindings, roleBindingsPath,
+		func() runtime.Object { return &model.RoleBindingList{} }, roleBindingToData)
+	if err != nil {
+		return nil, err
+	}
+	clusterRoleBindings, err := newResource("clusterrolebindings", c.ClusterRoleBindingsKey, c.ClusterRoleBindings, clusterRoleBindingsPath,
+		func() runtime.Object { return &model.ClusterRoleBindingList{} }, clusterRoleBindingToData)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := newResource("groups", c.GroupsKey, c.Groups, groupsPath,
+		func() runtime.Object { return &model.GroupList{} }, groupToData)
+	if err != nil {
+		return nil, err
+	}
+	return &replicator{
+		store:       c.Store,
+		resources:   []*resource{users, roles, clusterRoles, roleBindings, clusterRoleBindings, groups},
+		retryPeriod: c.RetryPeriod,
+	}, nil
+}
+
+func newResource(name, key string, s storage.Interface, path opastorage.Path, newListFunc func() runtime.Object, toData func(runtime.Object) (string, interface{}, error)) (*resource, error) {
+	if s == nil {
+		return nil, fmt.Errorf("storage for %s is required", name)
+	}
+	return &resource{
+		name:      name,
+		informer:  cache.NewSharedInformer(cache.NewListWatchFromStorage(s, key, newListFunc), 0),
+		changes:   watch.NewBroadcaster(changeQueueLength, watch.WaitIfChannelFull),
+		path:      path,
+		toData:    toData,
+		rewriteCh: make(chan struct{}, 1),
+	}, nil
+}
+
+// Run implements Interface.
+func (r *replicator) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, res := range r.resources {
+		res := res
+		res.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj runtime.Object) { res.changes.Action(watch.Added, obj) },
+			UpdateFunc: func(_, obj runtime.Object) { res.changes.Action(watch.Modified, obj) },
+			DeleteFunc: func(obj runtime.Object) { res.changes.Action(watch.Deleted, obj) },
+		})
+		// the replicator watches the changes before the informer starts,
+		// it must not miss any of them
+		w := res.changes.Watch()
+		wg.Add(2)
+		go func(res *resource) {
+			defer wg.Done()
+			res.informer.Run(ctx.Done())
+		}(res)
+		go func(res *resource) {
+			defer wg.Done()
+			r.replicate(ctx, res, w)
+		}(res)
+	}
+	wg.Wait()
+
+	// the informers are stopped, no more changes are distributed
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stopped = true
+	for _, res := range r.resources {
+		res.changes.Shutdown()
+	}
+	return nil
+}
+
+// Resources implements Interface.
+func (r *replicator) Resources() []string {
+	names := make([]string, 0, len(r.resources))
+	for _, res := range r.resources {
+		names = append(names, res.name)
+	}
+	return names
+}
+
+// Watch implements Interface.
+func (r *replicator) Watch(resource string) (watch.Interface, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return nil, fmt.Errorf("the replicator is stopped")
+	}
+	for _, res := range r.resources {
+		if res.name == resource {
+			return res.changes.Watch(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown resource %q", resource)
+}
+
+// HasSynced implements Interface.
+func (r *replicator) HasSynced() bool {
+	for _, res := range r.resources {
+		if atomic.LoadInt32(&res.synced) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// replicate writes the whole subtree of res once its informer has synced,
+// and again whenever a change could not be written, until ctx is
+// cancelled. The changes in between, received from w, are written by
+// update.
+func (r *replicator) replicate(ctx context.Context, res *resource, w watch.Interface) {
+	defer w.Stop()
+	go func() {
+		if cache.WaitForNamedCacheSync("replicator "+res.name, ctx.Done(), res.informer.HasSynced) {
+			res.rewrite()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			r.update(res, event.Object)
+		case <-res.rewriteCh:
+			if err := r.sync(ctx, res); err != nil {
+				klog.ErrorS(err, "Replicating failed, retrying", "resource", res.name, "retryPeriod", r.retryPeriod)
+				time.AfterFunc(r.retryPeriod, res.rewrite)
+			}
+		}
+	}
+}
+
+// sync replaces the subtree of res with the objects of its informer cache.
+func (r *replicator) sync(ctx context.Context, res *resource) error {
+	res.lock.Lock()
+	defer res.lock.Unlock()
+
+	items := res.informer.GetStore().List()
+	data := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		name, value, err := res.toData(item)
+		if err != nil {
+			return err
+		}
+		data[name] = value
+	}
+	if err := r.write(ctx, opastorage.AddOp, res.path, data); err != nil {
+		return fmt.Errorf("failed to write %s to %v: %v", res.name, res.path, err)
+	}
+	atomic.StoreInt32(&res.synced, 1)
+	klog.V(2).InfoS("Replicated objects", "resource", res.name, "count", len(items), "resourceVersion", res.informer.LastSyncResourceVersion())
+	return nil
+}
+
+// update writes the object of res named like obj, as it is in the informer
+// cache, into the OPA store. The whole subtree is written again if that
+// fails, so that the change is not lost.
+func (r *replicator) update(res *resource, obj runtime.Object) {
+	name, err := cache.MetaNameKeyFunc(obj)
+	if err != nil {
+		klog.ErrorS(err, "Unable to replicate object", "resource", res.name)
+		return
+	}
+
+	res.lock.Lock()
+	defer res.lock.Unlock()
+	if atomic.LoadInt32(&res.synced) == 0 {
+		// the change is part of the cache the subtree is first written from
+		return
+	}
+	if err := r.apply(context.TODO(), res, name); err != nil {
+		klog.ErrorS(err, "Replicating failed, rewriting all objects", "resource", res.name, "name", name)
+		res.rewrite()
+	}
+}
+
+// apply writes the object of res named name into the OPA store, or removes
+// it if it is not in the informer cache anymore.
+func (r *replicator) apply(ctx context.Context, res *resource, name string) error {
+	obj, exists, err := res.informer.GetStore().GetByKey(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err := opastorage.WriteOne(ctx, r.store, opastorage.RemoveOp, childPath(res.path, name), nil)
+		if err != nil && !opastorage.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	name, value, err := res.toData(obj)
+	if err != nil {
+		return err
+	}
+	return r.write(ctx, opastorage.AddOp, childPath(res.path, name), value)
+}
+
+// write applies op at path, creating the parents of path if they are missing.
+func (r *replicator) write(ctx context.Context, op opastorage.PatchOp, path opastorage.Path, value interface{}) error {
+	return opastorage.Txn(ctx, r.store, opastorage.WriteParams, func(txn opastorage.Transaction) error {
+		if err := opastorage.MakeDir(ctx, r.store, txn, path[:len(path)-1]); err != nil {
+			return err
+		}
+		return r.store.Write(ctx, txn, op, path, value)
+	})
+}
+
+// childPath returns the path of the member name of path.
+func childPath(path opastorage.Path, name string) opastorage.Path {
+	child := make(opastorage.Path, len(path), len(path)+1)
+	copy(child, path)
+	return append(child, name)
+}
+
+func userToData(obj runtime.Object) (string, interface{}, error) {
+	user, ok := obj.(*model.User)
+	if !ok {
+		return "", nil, fmt.Errorf("expected *model.User, got %T", obj)
+	}
+	return user.Name, stringsToData(user.Roles), nil
+}
+
+func roleToData(obj runtime.Object) (string, interface{}, error) {
+	role, ok := obj.(*model.Role)
+	if !ok {
+		return "", nil, fmt.Errorf("expected *model.Role, got %T", obj)
+	}
+	return role.Name, rulesToData(role.Rules), nil
+}
+
+func clusterRoleToData(obj runtime.Object) (string, interface{}, error) {
+	role, ok := obj.(*model.ClusterRole)
+	if !ok {
+		return "", nil, fmt.Errorf("expected *model.ClusterRole, got %T", obj)
+	}
+	return role.Name, rulesToData(role.Rules), nil
+}
+
+func roleBindingToData(obj runtime.Object) (string, interface{}, error) {
+	binding, ok := obj.(*model.RoleBinding)
+	if !ok {
+		return "", nil, fmt.Errorf("expected *model.RoleBinding, got %T", obj)
+	}
+	return binding.Name, bindingToData(binding.Subjects, binding.RoleRef), nil
+}
+
+func clusterRoleBindingToData(obj runtime.Object) (string, interface{}, error) {
+	binding, ok := obj.(*model.ClusterRoleBinding)
+	if !ok {
+		return "", nil, fmt.Errorf("expected *model.ClusterRoleBinding, got %T", obj)
+	}
+	return binding.Name, bindingToData(binding.Subjects, binding.RoleRef), nil
+}
+
+func groupToData(obj runtime.Object) (string, interface{}, error) {
+	group, ok := obj.(*model.Group)
+	if !ok {
+		return "", nil, fmt.Errorf("expected *model.Group, got %T", obj)
+	}
+	return group.Name, stringsToData(group.Users), nil
+}
+
+// bindingToData converts the subjects and the role reference of a role or
+// cluster role binding into the binding api.rego resolves grants through.
+func bindingToData(subjects []model.Subject, roleRef model.RoleRef) map[string]interface{} {
+	subjectsData := make([]interface{}, 0, len(subjects))
+	for _, subject := range subjects {
+		subjectsData = append(subjectsData, map[string]interface{}{
+			"kind": subject.Kind,
+			"name": subject.Name,
+		})
+	}
+	return map[string]interface{}{
+		"subjects": subjectsData,
+		"roleRef": map[string]interface{}{
+			"kind": roleRef.Kind,
+			"name": roleRef.Name,
+		},
+	}
+}
+
+// rulesToData converts rules into the grants api.rego matches on. Every field
+// is always present, since the policy does not tolerate missing ones.
+func rulesToData(rules []model.PolicyRule) []interface{} {
+	grants := make([]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		grants = append(grants, map[string]interface{}{
+			"verbs":           stringsToData(rule.Verbs),
+			"apiGroups":       stringsToData(rule.APIGroups),
+			"resources":       stringsToData(rule.Resources),
+			"resourceNames":   stringsToData(rule.ResourceNames),
+			"nonResourceURLs": stringsToData(rule.NonResourceURLs),
+		})
+	}
+	return grants
+}
+
+func stringsToData(in []string) []interface{} {
+	out := make([]interface{}, 0, len(in))
+	for _, s := range in {
+		out = append(out, s)
+	}
+	return out
+}
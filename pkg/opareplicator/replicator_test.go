@@ -0,0 +1,222 @@
+package opareplicator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	opastorage "github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	"github.com/x893675/opa-server/pkg/watch"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newTestStorage(newFunc func() runtime.Object) storage.Interface {
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	return kvstore.New(memory.NewBackend(0), codec, newFunc, "/registry", value.IdentityTransformer, true)
+}
+
+// expectData waits for the OPA store to hold expected at path.
+func expectData(t *testing.T, store opastorage.Store, path string, expected interface{}) {
+	t.Helper()
+	var got string
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		equal := false
+		// the values are only read within the transaction, they are
+		// modified by the later writes
+		err := opastorage.Txn(context.TODO(), store, opastorage.TransactionParams{}, func(txn opastorage.Transaction) error {
+			value, err := store.Read(context.TODO(), txn, opastorage.MustParsePath(path))
+			if err != nil && !opastorage.IsNotFound(err) {
+				return err
+			}
+			got = fmt.Sprintf("%#v", value)
+			equal = reflect.DeepEqual(value, expected)
+			return nil
+		})
+		return equal, err
+	})
+	if err != nil {
+		t.Fatalf("expected %s to be %#v, got %s", path, expected, got)
+	}
+}
+
+func TestReplicator(t *testing.T) {
+	ctx := context.Background()
+	users := newTestStorage(func() runtime.Object { return &model.User{} })
+	groups := newTestStorage(func() runtime.Object { return &model.Group{} })
+	store := inmem.New()
+	r, err := New(Config{
+		Store:               store,
+		Users:               users,
+		Roles:               newTestStorage(func() runtime.Object { return &model.Role{} }),
+		ClusterRoles:        newTestStorage(func() runtime.Object { return &model.ClusterRole{} }),
+		RoleBindings:        newTestStorage(func() runtime.Object { return &model.RoleBinding{} }),
+		ClusterRoleBindings: newTestStorage(func() runtime.Object { return &model.ClusterRoleBinding{} }),
+		Groups:              groups,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// alice is created before the replicator runs, and written with the
+	// first list
+	alice := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Roles: []string{"admin"}}
+	if err := users.Create(ctx, "/users/alice", alice, alice, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- r.Run(runCtx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run failed: %v", err)
+		}
+	}()
+
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return r.HasSynced(), nil
+	}); err != nil {
+		t.Fatalf("expected the replicator to sync")
+	}
+	expectData(t, store, "/api/rbac/roles", map[string]interface{}{"alice": []interface{}{"admin"}})
+	expectData(t, store, "/api/rbac/permissions", map[string]interface{}{})
+
+	// the changes are written as they are watched
+	bob := &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}
+	if err := users.Create(ctx, "/users/bob", bob, bob, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	group := &model.Group{ObjectMeta: meta.ObjectMeta{Name: "ops"}, Users: []string{"bob"}}
+	if err := groups.Create(ctx, "/groups/ops", group, group, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	err = users.GuaranteedUpdate(ctx, "/users/alice", &model.User{}, false, nil, storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+		user := obj.(*model.User)
+		user.Roles = append(user.Roles, "viewer")
+		return user, nil
+	}), nil)
+	if err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	expectData(t, store, "/api/rbac/roles", map[string]interface{}{
+		"alice": []interface{}{"admin", "viewer"},
+		"bob":   []interface{}{},
+	})
+	expectData(t, store, "/api/rbac/groups", map[string]interface{}{"ops": []interface{}{"bob"}})
+
+	if err := users.Delete(ctx, "/users/alice", &model.User{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	expectData(t, store, "/api/rbac/roles", map[string]interface{}{"bob": []interface{}{}})
+}
+
+func TestReplicatorWatch(t *testing.T) {
+	ctx := context.Background()
+	users := newTestStorage(func() runtime.Object { return &model.User{} })
+	r, err := New(Config{
+		Store:               inmem.New(),
+		Users:               users,
+		Roles:               newTestStorage(func() runtime.Object { return &model.Role{} }),
+		ClusterRoles:        newTestStorage(func() runtime.Object { return &model.ClusterRole{} }),
+		RoleBindings:        newTestStorage(func() runtime.Object { return &model.RoleBinding{} }),
+		ClusterRoleBindings: newTestStorage(func() runtime.Object { return &model.ClusterRoleBinding{} }),
+		Groups:              newTestStorage(func() runtime.Object { return &model.Group{} }),
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := r.Watch("unknown"); err == nil {
+		t.Errorf("expected the watch of an unknown resource to fail")
+	}
+	alice := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}
+	if err := users.Create(ctx, "/users/alice", alice, alice, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// the watches started before the replicator runs are sent the first
+	// list, and share the watch of the replicator with the metrics
+	w, err := r.Watch("users")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	objectChanges.Reset()
+	if err := RecordChanges(ctx, r); err != nil {
+		t.Fatalf("RecordChanges failed: %v", err)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- r.Run(runCtx) }()
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return r.HasSynced(), nil
+	}); err != nil {
+		t.Fatalf("expected the replicator to sync")
+	}
+
+	bob := &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}
+	if err := users.Create(ctx, "/users/bob", bob, bob, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	bob.Roles = []string{"admin"}
+	if err := users.GuaranteedUpdate(ctx, "/users/bob", &model.User{}, false, nil, storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+		return bob, nil
+	}), nil); err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	if err := users.Delete(ctx, "/users/alice", &model.User{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	// the conditions are met in order, Until checks each of them against
+	// the event that met the previous one first
+	change := func(eventType watch.EventType, name string) watch.ConditionFunc {
+		return func(event watch.Event) (bool, error) {
+			return event.Type == eventType && event.Object.(*model.User).Name == name, nil
+		}
+	}
+	untilCtx, cancelUntil := context.WithTimeout(ctx, wait.ForeverTestTimeout)
+	defer cancelUntil()
+	if _, err := watch.Until(untilCtx, w,
+		change(watch.Added, "alice"),
+		change(watch.Added, "bob"),
+		change(watch.Modified, "bob"),
+		change(watch.Deleted, "alice"),
+	); err != nil {
+		t.Fatalf("Until failed: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return testutil.ToFloat64(objectChanges.WithLabelValues("users", string(watch.Deleted))) == 1, nil
+	}); err != nil {
+		t.Errorf("expected the deletion to be counted")
+	}
+	if added := testutil.ToFloat64(objectChanges.WithLabelValues("users", string(watch.Added))); added != 2 {
+		t.Errorf("expected 2 additions to be counted, got %v", added)
+	}
+
+	// the watches are closed once the replicator stops
+	w, err = r.Watch("groups")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+	if _, ok := <-w.ResultChan(); ok {
+		t.Errorf("expected the watch to be closed")
+	}
+	if _, err := r.Watch("users"); err == nil {
+		t.Errorf("expected the watch of a stopped replicator to fail")
+	}
+}
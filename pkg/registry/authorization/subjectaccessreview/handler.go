@@ -0,0 +1,50 @@
+package subjectaccessreview
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/x893675/opa-server/pkg/endpoints/handlers/responsewriters"
+	jsonserializer "github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// maxRequestBodyBytes is the limit on the size of a SubjectAccessReview.
+const maxRequestBodyBytes = 1024 * 1024
+
+// serializer encodes the Status of failed reviews, which are typed already.
+var serializer = jsonserializer.NewSerializerWithOptions(jsonserializer.DefaultMetaFactory, nil, nil, jsonserializer.SerializerOptions{})
+
+// ServeHTTP reviews the authorization.k8s.io/v1 SubjectAccessReview in the
+// request body and responds with it with its status set. It is the endpoint
+// of a kube-apiserver authorization webhook.
+func (r *REST) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		responsewriters.ErrorNegotiated(apierrors.NewMethodNotSupported(authorizationv1.Resource("subjectaccessreviews"), req.Method), serializer, w)
+		return
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{}
+	decoder := json.NewDecoder(io.LimitReader(req.Body, maxRequestBodyBytes))
+	if err := decoder.Decode(sar); err != nil {
+		responsewriters.ErrorNegotiated(apierrors.NewBadRequest(err.Error()), serializer, w)
+		return
+	}
+
+	result, err := r.Create(req.Context(), sar)
+	if err != nil {
+		responsewriters.ErrorNegotiated(err, serializer, w)
+		return
+	}
+	result.APIVersion = authorizationv1.SchemeGroupVersion.String()
+	result.Kind = "SubjectAccessReview"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.V(4).Infof("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package subjectaccessreview
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestServeHTTP(t *testing.T) {
+	server := httptest.NewServer(NewREST(&fakeAuthorizer{decision: authorizer.DecisionDeny, reason: "denied by data.api.rbac.deny"}))
+	defer server.Close()
+
+	testCases := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+		// status is the expected status of the review, if the request is
+		// expected to succeed.
+		status *authorizationv1.SubjectAccessReviewStatus
+		// reason is the expected reason of the failure Status otherwise.
+		reason metav1.StatusReason
+	}{
+		{
+			name:   "review",
+			method: http.MethodPost,
+			body:   `{"apiVersion": "authorization.k8s.io/v1", "kind": "SubjectAccessReview", "spec": {"user": "mallory", "resourceAttributes": {"verb": "get", "resource": "pods"}}}`,
+			code:   http.StatusOK,
+			status: &authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "denied by data.api.rbac.deny"},
+		},
+		{
+			name:   "review without type",
+			method: http.MethodPost,
+			body:   `{"spec": {"groups": ["dev"], "nonResourceAttributes": {"verb": "get", "path": "/metrics"}}}`,
+			code:   http.StatusOK,
+			status: &authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "denied by data.api.rbac.deny"},
+		},
+		{
+			name:   "invalid review",
+			method: http.MethodPost,
+			body:   `{"spec": {"resourceAttributes": {"verb": "get", "resource": "pods"}}}`,
+			code:   http.StatusUnprocessableEntity,
+			reason: metav1.StatusReasonInvalid,
+		},
+		{
+			name:   "malformed body",
+			method: http.MethodPost,
+			body:   `{"spec":`,
+			code:   http.StatusBadRequest,
+			reason: metav1.StatusReasonBadRequest,
+		},
+		{
+			name:   "method",
+			method: http.MethodGet,
+			code:   http.StatusMethodNotAllowed,
+			reason: metav1.StatusReasonMethodNotAllowed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, server.URL, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.code {
+				t.Errorf("expected status %d, got %d", tc.code, resp.StatusCode)
+			}
+
+			if tc.status == nil {
+				status := &metav1.Status{}
+				if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+					t.Fatalf("unable to decode the status: %v", err)
+				}
+				if status.Status != metav1.StatusFailure || status.Reason != tc.reason || int(status.Code) != tc.code {
+					t.Errorf("expected a %s failure status, got %#v", tc.reason, status)
+				}
+				return
+			}
+			sar := &authorizationv1.SubjectAccessReview{}
+			if err := json.NewDecoder(resp.Body).Decode(sar); err != nil {
+				t.Fatalf("unable to decode the review: %v", err)
+			}
+			if sar.APIVersion != "authorization.k8s.io/v1" || sar.Kind != "SubjectAccessReview" {
+				t.Errorf("expected an authorization.k8s.io/v1 SubjectAccessReview, got %#v", sar.TypeMeta)
+			}
+			if !reflect.DeepEqual(sar.Status, *tc.status) {
+				t.Errorf("expected the status %#v, got %#v", *tc.status, sar.Status)
+			}
+		})
+	}
+}
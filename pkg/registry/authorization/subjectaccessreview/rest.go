@@ -0,0 +1,67 @@
+package subjectaccessreview
+
+import (
+	"context"
+
+	authorizationutil "github.com/x893675/opa-server/pkg/registry/authorization/util"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// REST reviews SubjectAccessReviews with an authorizer.
+type REST struct {
+	authorizer authorizer.Authorizer
+}
+
+// NewREST returns a REST that reviews with authorizer.
+func NewREST(authorizer authorizer.Authorizer) *REST {
+	return &REST{authorizer}
+}
+
+// Create evaluates subjectAccessReview and returns it with its status set.
+func (r *REST) Create(ctx context.Context, subjectAccessReview *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	if errs := ValidateSubjectAccessReview(subjectAccessReview); len(errs) > 0 {
+		return nil, apierrors.NewInvalid(schema.GroupKind{Group: authorizationv1.GroupName, Kind: "SubjectAccessReview"}, "", errs)
+	}
+
+	authorizationAttributes := authorizationutil.AuthorizationAttributesFrom(subjectAccessReview.Spec)
+	decision, reason, evaluationErr := r.authorizer.Authorize(ctx, authorizationAttributes)
+
+	subjectAccessReview.Status = authorizationv1.SubjectAccessReviewStatus{
+		Allowed: (decision == authorizer.DecisionAllow),
+		Denied:  (decision == authorizer.DecisionDeny),
+		Reason:  reason,
+	}
+	if evaluationErr != nil {
+		subjectAccessReview.Status.EvaluationError = evaluationErr.Error()
+	}
+
+	return subjectAccessReview, nil
+}
+
+// ValidateSubjectAccessReview validates a SubjectAccessReview and returns an
+// ErrorList with any errors.
+func ValidateSubjectAccessReview(sar *authorizationv1.SubjectAccessReview) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if len(sar.APIVersion) > 0 && sar.APIVersion != authorizationv1.SchemeGroupVersion.String() {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("apiVersion"), sar.APIVersion, []string{authorizationv1.SchemeGroupVersion.String()}))
+	}
+	if len(sar.Kind) > 0 && sar.Kind != "SubjectAccessReview" {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("kind"), sar.Kind, []string{"SubjectAccessReview"}))
+	}
+
+	specPath := field.NewPath("spec")
+	if sar.Spec.ResourceAttributes != nil && sar.Spec.NonResourceAttributes != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("nonResourceAttributes"), sar.Spec.NonResourceAttributes, `cannot be specified in combination with resourceAttributes`))
+	}
+	if sar.Spec.ResourceAttributes == nil && sar.Spec.NonResourceAttributes == nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("resourceAttributes"), sar.Spec.NonResourceAttributes, `exactly one of nonResourceAttributes or resourceAttributes must be specified`))
+	}
+	if len(sar.Spec.User) == 0 && len(sar.Spec.Groups) == 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("user"), sar.Spec.User, `at least one of user or group must be specified`))
+	}
+	return allErrs
+}
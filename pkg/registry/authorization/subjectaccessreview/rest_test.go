@@ -0,0 +1,215 @@
+package subjectaccessreview
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// fakeAuthorizer returns its decision, reason and err, and records the
+// attributes it is asked to authorize.
+type fakeAuthorizer struct {
+	attrs authorizer.Attributes
+
+	decision authorizer.Decision
+	reason   string
+	err      error
+}
+
+func (a *fakeAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	a.attrs = attrs
+	return a.decision, a.reason, a.err
+}
+
+func TestCreateAttributes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     authorizationv1.SubjectAccessReviewSpec
+		expected authorizer.AttributesRecord
+	}{
+		{
+			name: "resource",
+			spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   "alice",
+				Groups: []string{"dev"},
+				UID:    "1",
+				Extra:  map[string]authorizationv1.ExtraValue{"scopes": {"view"}},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   "default",
+					Verb:        "get",
+					Group:       "apps",
+					Version:     "v1",
+					Resource:    "deployments",
+					Subresource: "scale",
+					Name:        "web",
+				},
+			},
+			expected: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{Name: "alice", Groups: []string{"dev"}, UID: "1", Extra: map[string][]string{"scopes": {"view"}}},
+				Verb:            "get",
+				Namespace:       "default",
+				APIGroup:        "apps",
+				APIVersion:      "v1",
+				Resource:        "deployments",
+				Subresource:     "scale",
+				Name:            "web",
+				ResourceRequest: true,
+			},
+		},
+		{
+			name: "resource of any version",
+			spec: authorizationv1.SubjectAccessReviewSpec{
+				Groups:             []string{"dev"},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods"},
+			},
+			expected: authorizer.AttributesRecord{
+				User:            &user.DefaultInfo{Groups: []string{"dev"}},
+				Verb:            "list",
+				APIVersion:      "*",
+				Resource:        "pods",
+				ResourceRequest: true,
+			},
+		},
+		{
+			name: "non resource",
+			spec: authorizationv1.SubjectAccessReviewSpec{
+				User:                  "alice",
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: "/metrics", Verb: "get"},
+			},
+			expected: authorizer.AttributesRecord{
+				User: &user.DefaultInfo{Name: "alice"},
+				Verb: "get",
+				Path: "/metrics",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &fakeAuthorizer{}
+			if _, err := NewREST(a).Create(context.TODO(), &authorizationv1.SubjectAccessReview{Spec: tc.spec}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(a.attrs, tc.expected) {
+				t.Errorf("expected the attributes %#v, got %#v", tc.expected, a.attrs)
+			}
+		})
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	resourceAttributes := &authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods"}
+	testCases := []struct {
+		name string
+		sar  *authorizationv1.SubjectAccessReview
+		// field is the field the error is expected to be about.
+		field string
+	}{
+		{
+			name: "no user or group",
+			sar: &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: resourceAttributes,
+			}},
+			field: "spec.user",
+		},
+		{
+			name: "no attributes",
+			sar: &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				User: "alice",
+			}},
+			field: "spec.resourceAttributes",
+		},
+		{
+			name: "resource and non resource attributes",
+			sar: &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:                  "alice",
+				ResourceAttributes:    resourceAttributes,
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: "/metrics", Verb: "get"},
+			}},
+			field: "spec.nonResourceAttributes",
+		},
+		{
+			name: "kind",
+			sar: &authorizationv1.SubjectAccessReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "authorization.k8s.io/v1", Kind: "LocalSubjectAccessReview"},
+				Spec:     authorizationv1.SubjectAccessReviewSpec{User: "alice", ResourceAttributes: resourceAttributes},
+			},
+			field: "kind",
+		},
+		{
+			name: "api version",
+			sar: &authorizationv1.SubjectAccessReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "authorization.k8s.io/v1beta1", Kind: "SubjectAccessReview"},
+				Spec:     authorizationv1.SubjectAccessReviewSpec{User: "alice", ResourceAttributes: resourceAttributes},
+			},
+			field: "apiVersion",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &fakeAuthorizer{}
+			_, err := NewREST(a).Create(context.TODO(), tc.sar)
+			if !apierrors.IsInvalid(err) {
+				t.Fatalf("expected an invalid error, got %v", err)
+			}
+			causes := err.(apierrors.APIStatus).Status().Details.Causes
+			if len(causes) != 1 || causes[0].Field != tc.field {
+				t.Errorf("expected an error about %s, got %#v", tc.field, causes)
+			}
+			if a.attrs != nil {
+				t.Errorf("expected an invalid review not to be authorized, got %#v", a.attrs)
+			}
+		})
+	}
+}
+
+func TestCreateStatus(t *testing.T) {
+	testCases := []struct {
+		name       string
+		authorizer *fakeAuthorizer
+		expected   authorizationv1.SubjectAccessReviewStatus
+	}{
+		{
+			name:       "allowed",
+			authorizer: &fakeAuthorizer{decision: authorizer.DecisionAllow, reason: "allowed by data.api.rbac.allow"},
+			expected:   authorizationv1.SubjectAccessReviewStatus{Allowed: true, Reason: "allowed by data.api.rbac.allow"},
+		},
+		{
+			name:       "denied",
+			authorizer: &fakeAuthorizer{decision: authorizer.DecisionDeny, reason: "denied by data.api.rbac.deny"},
+			expected:   authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "denied by data.api.rbac.deny"},
+		},
+		{
+			name:       "no opinion",
+			authorizer: &fakeAuthorizer{decision: authorizer.DecisionNoOpinion},
+			expected:   authorizationv1.SubjectAccessReviewStatus{},
+		},
+		{
+			name:       "evaluation error",
+			authorizer: &fakeAuthorizer{decision: authorizer.DecisionNoOpinion, err: errors.New("failed to evaluate data.api.rbac.allow")},
+			expected:   authorizationv1.SubjectAccessReviewStatus{EvaluationError: "failed to evaluate data.api.rbac.allow"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sar, err := NewREST(tc.authorizer).Create(context.TODO(), &authorizationv1.SubjectAccessReview{
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					User:               "alice",
+					ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(sar.Status, tc.expected) {
+				t.Errorf("expected the status %#v, got %#v", tc.expected, sar.Status)
+			}
+		})
+	}
+}
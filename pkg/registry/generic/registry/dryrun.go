@@ -1,11 +1,156 @@
 package registry
 
 import (
+	"context"
+	"reflect"
+
 	"github.com/x893675/opa-server/pkg/runtime"
-	"k8s.io/apiserver/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/watch"
 )
 
 type DryRunnableStorage struct {
 	Storage storage.Interface
 	Codec   runtime.Codec
 }
+
+func (s *DryRunnableStorage) Versioner() storage.Versioner {
+	return s.Storage.Versioner()
+}
+
+func (s *DryRunnableStorage) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64, dryRun bool) error {
+	if dryRun {
+		if err := s.Storage.Get(ctx, key, storage.GetOptions{}, out); err == nil {
+			return storage.NewKeyExistsError(key, 0)
+		}
+		return s.copyInto(obj, out)
+	}
+	return s.Storage.Create(ctx, key, obj, out, ttl)
+}
+
+func (s *DryRunnableStorage) Delete(ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions, deleteValidation storage.ValidateObjectFunc, dryRun bool, cachedExistingObject runtime.Object) error {
+	if dryRun {
+		if err := s.Storage.Get(ctx, key, storage.GetOptions{}, out); err != nil {
+			return err
+		}
+		if err := preconditions.Check(key, out); err != nil {
+			return err
+		}
+		return deleteValidation(ctx, out)
+	}
+	return s.Storage.Delete(ctx, key, out, preconditions, deleteValidation, cachedExistingObject)
+}
+
+func (s *DryRunnableStorage) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return s.Storage.Watch(ctx, key, opts)
+}
+
+func (s *DryRunnableStorage) WatchList(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return s.Storage.WatchList(ctx, key, opts)
+}
+
+func (s *DryRunnableStorage) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) error {
+	return s.Storage.Get(ctx, key, opts, objPtr)
+}
+
+func (s *DryRunnableStorage) GetToList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	return s.Storage.GetToList(ctx, key, opts, listObj)
+}
+
+func (s *DryRunnableStorage) List(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	return s.Storage.List(ctx, key, opts, listObj)
+}
+
+func (s *DryRunnableStorage) GuaranteedUpdate(
+	ctx context.Context, key string, ptrToType runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, dryRun bool, cachedExistingObject runtime.Object) error {
+	if dryRun {
+		err := s.Storage.Get(ctx, key, storage.GetOptions{IgnoreNotFound: ignoreNotFound}, ptrToType)
+		if err != nil {
+			return err
+		}
+		err = preconditions.Check(key, ptrToType)
+		if err != nil {
+			return err
+		}
+		rev, err := s.Versioner().ObjectResourceVersion(ptrToType)
+		if err != nil {
+			return err
+		}
+		out, _, err := tryUpdate(ptrToType, storage.ResponseMeta{ResourceVersion: rev})
+		if err != nil {
+			return err
+		}
+		return s.copyInto(out, ptrToType)
+	}
+	return s.Storage.GuaranteedUpdate(ctx, key, ptrToType, ignoreNotFound, preconditions, tryUpdate, cachedExistingObject)
+}
+
+func (s *DryRunnableStorage) Count(key string) (int64, error) {
+	return s.Storage.Count(key)
+}
+
+func (s *DryRunnableStorage) Txn(ctx context.Context, dryRun bool) storage.Txn {
+	if dryRun {
+		return storage.NewTxn(ctx, s.dryRunTxn)
+	}
+	return s.Storage.Txn(ctx)
+}
+
+// dryRunTxn checks ops against the current objects without applying them,
+// and sets the outputs of the operations as a commit would.
+func (s *DryRunnableStorage) dryRunTxn(ctx context.Context, ops []storage.TxnOp) error {
+	for i, op := range ops {
+		if op.Type == storage.TxnOpDelete {
+			if err := s.Storage.Get(ctx, op.Key, storage.GetOptions{}, op.Out); err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+			if err := op.Preconditions.Check(op.Key, op.Out); err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+			continue
+		}
+
+		existing := reflect.New(reflect.TypeOf(op.Obj).Elem()).Interface().(runtime.Object)
+		err := s.Storage.Get(ctx, op.Key, storage.GetOptions{}, existing)
+		switch {
+		case op.Type == storage.TxnOpCreate && err == nil:
+			return storage.NewTxnOpError(storage.NewKeyExistsError(op.Key, 0), i, op.Type)
+		case op.Type == storage.TxnOpCreate && storage.IsNotFound(err):
+		case err != nil:
+			return storage.NewTxnOpError(err, i, op.Type)
+		default:
+			version, err := s.Versioner().ObjectResourceVersion(op.Obj)
+			if err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+			rev, err := s.Versioner().ObjectResourceVersion(existing)
+			if err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+			if version != 0 && version != rev {
+				return storage.NewTxnConflictError(op.Key, int64(rev), i, op.Type)
+			}
+		}
+		if op.Out != nil {
+			if err := s.copyInto(op.Obj, op.Out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DryRunnableStorage) copyInto(in, out runtime.Object) error {
+	var data []byte
+
+	data, err := runtime.Encode(s.Codec, in)
+	if err != nil {
+		return err
+	}
+	if err := out.SetZeroValue(); err != nil {
+		return err
+	}
+	_, err = s.Codec.Decode(data, out)
+	return err
+}
@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+)
+
+func newTestDryRunnableStorage() *DryRunnableStorage {
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	s := kvstore.New(memory.NewBackend(0), codec, func() runtime.Object { return &model.User{} }, "/registry", value.IdentityTransformer, true)
+	return &DryRunnableStorage{Storage: s, Codec: codec}
+}
+
+func TestDryRunTxn(t *testing.T) {
+	s := newTestDryRunnableStorage()
+	ctx := context.Background()
+	alice := &model.User{}
+	if err := s.Create(ctx, "/users/alice", &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}, alice, 0, false); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	stale := *alice
+	stale.ResourceVersion = "1000"
+	otherUID := meta.UID("other")
+
+	testCases := []struct {
+		name string
+		txn  func(txn storage.Txn) storage.Txn
+		// check returns whether the error of the transaction is expected.
+		check func(err error) bool
+		// msg is the operation the error is expected to name.
+		msg string
+	}{
+		{
+			name: "valid",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.
+					Create("/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, &model.User{}, 0).
+					Update("/users/alice", alice, nil, 0)
+			},
+			check: func(err error) bool { return err == nil },
+		},
+		{
+			name: "create of an existing key",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.
+					Create("/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, nil, 0).
+					Create("/users/alice", &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}, nil, 0)
+			},
+			check: storage.IsNodeExist,
+			msg:   "operation 1 (create)",
+		},
+		{
+			name: "update of a stale version",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.Update("/users/alice", &stale, nil, 0)
+			},
+			check: storage.IsConflict,
+			msg:   "operation 0 (update)",
+		},
+		{
+			name: "update of a missing key",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.Update("/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, nil, 0)
+			},
+			check: storage.IsNotFound,
+			msg:   "operation 0 (update)",
+		},
+		{
+			name: "delete with failed preconditions",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.Delete("/users/alice", &model.User{}, &storage.Preconditions{UID: &otherUID})
+			},
+			check: storage.IsInvalidObj,
+			msg:   "operation 0 (delete)",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.txn(s.Txn(ctx, true)).Commit()
+			if !tc.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.msg) {
+				t.Errorf("expected the error to name %q, got %v", tc.msg, err)
+			}
+			// nothing is written
+			if count, err := s.Count("/users"); err != nil || count != 1 {
+				t.Errorf("expected a single user, got %d, %v", count, err)
+			}
+			current := &model.User{}
+			if err := s.Get(ctx, "/users/alice", storage.GetOptions{}, current); err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if current.ResourceVersion != alice.ResourceVersion {
+				t.Errorf("expected alice to be left at %s, got %s", alice.ResourceVersion, current.ResourceVersion)
+			}
+		})
+	}
+
+	// the objects a commit would return are set
+	bob, deleted := &model.User{}, &model.User{}
+	err := s.Txn(ctx, true).
+		Create("/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, bob, 0).
+		Delete("/users/alice", deleted, nil).
+		Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if bob.Name != "bob" || deleted.Name != "alice" || deleted.ResourceVersion != alice.ResourceVersion {
+		t.Errorf("expected the created and deleted objects, got %#v and %#v", bob, deleted)
+	}
+}
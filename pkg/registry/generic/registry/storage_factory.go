@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/cacher"
+	"github.com/x893675/opa-server/pkg/storage/etcd3/metrics"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend/factory"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const resourceCountPollPeriodJitter = 1.2
+
+// StorageDecorator is a function signature for producing a storage.Interface
+// and an associated DestroyFunc from given parameters.
+type StorageDecorator func(
+	config storagebackend.Config,
+	resourcePrefix string,
+	keyFunc func(obj runtime.Object) (string, error),
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	getAttrsFunc storage.AttrFunc,
+	indexers storage.IndexerFuncs) (storage.Interface, factory.DestroyFunc, error)
+
+// UndecoratedStorage returns a new storage from the given config
+// without any decoration.
+func UndecoratedStorage(
+	config storagebackend.Config,
+	resourcePrefix string,
+	keyFunc func(obj runtime.Object) (string, error),
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	getAttrsFunc storage.AttrFunc,
+	indexers storage.IndexerFuncs) (storage.Interface, factory.DestroyFunc, error) {
+	return factory.Create(config, newFunc)
+}
+
+// StorageWithCacher creates a cacher with a history window of capacity
+// events in front of the storage created from the given config.
+func StorageWithCacher(capacity int) StorageDecorator {
+	return func(
+		config storagebackend.Config,
+		resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		getAttrsFunc storage.AttrFunc,
+		indexers storage.IndexerFuncs) (storage.Interface, factory.DestroyFunc, error) {
+
+		s, d, err := factory.Create(config, newFunc)
+		if err != nil {
+			return s, d, err
+		}
+		cacherConfig := cacher.Config{
+			CacheCapacity:  capacity,
+			Storage:        s,
+			Versioner:      storage.APIObjectVersioner{},
+			ResourcePrefix: resourcePrefix,
+			KeyFunc:        keyFunc,
+			GetAttrsFunc:   getAttrsFunc,
+			IndexerFuncs:   indexers,
+			NewFunc:        newFunc,
+			NewListFunc:    newListFunc,
+			Codec:          config.Codec,
+		}
+		c, err := cacher.NewCacherFromConfig(cacherConfig)
+		if err != nil {
+			d()
+			return nil, nil, err
+		}
+		destroyFunc := func() {
+			c.Stop()
+			d()
+		}
+		return c, destroyFunc, nil
+	}
+}
+
+// NoNamespaceObjectKeyFunc returns the func that computes the key of a
+// cluster scoped object kept under prefix, the same key NoNamespaceKeyFunc
+// returns for its name.
+func NoNamespaceObjectKeyFunc(prefix string) func(obj runtime.Object) (string, error) {
+	return func(obj runtime.Object) (string, error) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return "", err
+		}
+		return NoNamespaceKeyFunc(context.TODO(), prefix, accessor.GetName())
+	}
+}
+
+// DefaultClusterScopedIndexers returns the indexers of the IndexFields of
+// the predicates made by the default PredicateFunc of Store.
+func DefaultClusterScopedIndexers() storage.IndexerFuncs {
+	return storage.IndexerFuncs{
+		storage.FieldIndex("metadata.name"): func(obj runtime.Object) string {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return ""
+			}
+			return accessor.GetName()
+		},
+	}
+}
+
+// StartObservingCount records the number of objects of resource kept under
+// prefix in s every period, until the returned func is called.
+func StartObservingCount(s storage.Interface, resource, prefix string, period time.Duration) func() {
+	klog.V(2).InfoS("Monitoring resource count", "resource", resource, "prefix", prefix)
+	stopCh := make(chan struct{})
+	go wait.JitterUntil(func() {
+		count, err := s.Count(prefix)
+		if err != nil {
+			klog.V(5).InfoS("Failed to update storage count metric", "resource", resource, "err", err)
+			metrics.UpdateObjectCount(resource, -1)
+		} else {
+			metrics.UpdateObjectCount(resource, count)
+		}
+	}, period, resourceCountPollPeriodJitter, true, stopCh)
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+	}
+}
@@ -0,0 +1,493 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// testStrategy is the strategy of the users of the test stores, they are
+// deleted gracefully if the delete options ask for it.
+type testStrategy struct {
+	rest.GracefulDeleteOnRequest
+	allowCreateOnUpdate      bool
+	allowUnconditionalUpdate bool
+}
+
+func (s testStrategy) AllowCreateOnUpdate() bool {
+	return s.allowCreateOnUpdate
+}
+
+func (testStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {}
+
+func (testStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {}
+
+func (testStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return nil
+}
+
+func (testStrategy) Canonicalize(obj runtime.Object) {}
+
+func (testStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return nil
+}
+
+func (s testStrategy) AllowUnconditionalUpdate() bool {
+	return s.allowUnconditionalUpdate
+}
+
+// newTestStore returns a Store of users kept in a memory backend.
+func newTestStore(t *testing.T, strategy testStrategy) *Store {
+	t.Helper()
+	store := &Store{
+		NewFunc:                  func() runtime.Object { return &model.User{} },
+		NewListFunc:              func() runtime.Object { return &model.UserList{} },
+		DefaultQualifiedResource: model.Resource("users"),
+		DeleteCollectionWorkers:  2,
+
+		CreateStrategy: strategy,
+		UpdateStrategy: strategy,
+		DeleteStrategy: strategy,
+
+		Storage: *newTestDryRunnableStorage(),
+	}
+	if err := store.Complete(); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	return store
+}
+
+// ttlRecorder records the TTLs the updates of the wrapped storage set.
+type ttlRecorder struct {
+	storage.Interface
+	ttls []uint64
+}
+
+func (r *ttlRecorder) GuaranteedUpdate(ctx context.Context, key string, ptrToType runtime.Object, ignoreNotFound bool, preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	return r.Interface.GuaranteedUpdate(ctx, key, ptrToType, ignoreNotFound, preconditions, func(input runtime.Object, res storage.ResponseMeta) (runtime.Object, *uint64, error) {
+		output, ttl, err := tryUpdate(input, res)
+		if err == nil {
+			var value uint64
+			if ttl != nil {
+				value = *ttl
+			}
+			r.ttls = append(r.ttls, value)
+		}
+		return output, ttl, err
+	}, cachedExistingObject)
+}
+
+// watchRecorder records the options of the watches of the wrapped storage.
+type watchRecorder struct {
+	storage.Interface
+	options []storage.ListOptions
+}
+
+func (r *watchRecorder) WatchList(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	r.options = append(r.options, opts)
+	return r.Interface.WatchList(ctx, key, opts)
+}
+
+func newTestUser(name string, labels map[string]string) *model.User {
+	return &model.User{ObjectMeta: meta.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// createUsers creates users in store and returns them as they were stored.
+func createUsers(t *testing.T, store *Store, users ...*model.User) []*model.User {
+	t.Helper()
+	created := make([]*model.User, 0, len(users))
+	for _, user := range users {
+		out, err := store.Create(context.TODO(), user, rest.ValidateAllObjectFunc, nil)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		created = append(created, out.(*model.User))
+	}
+	return created
+}
+
+// listNames returns the sorted names of the users of store.
+func listNames(t *testing.T, store *Store) []string {
+	t.Helper()
+	list, err := store.List(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	names := []string{}
+	for _, user := range list.(*model.UserList).Items {
+		names = append(names, user.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestStoreCreate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		user    *model.User
+		options *meta.CreateOptions
+		// check returns whether the error of the create is expected.
+		check    func(err error) bool
+		expected []string
+	}{
+		{
+			name:     "new",
+			user:     newTestUser("bob", nil),
+			check:    func(err error) bool { return err == nil },
+			expected: []string{"alice", "bob"},
+		},
+		{
+			name:     "already exists",
+			user:     newTestUser("alice", nil),
+			check:    apierrors.IsAlreadyExists,
+			expected: []string{"alice"},
+		},
+		{
+			name:     "dry run",
+			user:     newTestUser("bob", nil),
+			options:  &meta.CreateOptions{DryRun: []string{"All"}},
+			check:    func(err error) bool { return err == nil },
+			expected: []string{"alice"},
+		},
+		{
+			name:     "invalid name",
+			user:     newTestUser("a/b", nil),
+			check:    apierrors.IsInvalid,
+			expected: []string{"alice"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newTestStore(t, testStrategy{})
+			createUsers(t, store, newTestUser("alice", nil))
+
+			out, err := store.Create(context.TODO(), tc.user, rest.ValidateAllObjectFunc, tc.options)
+			if !tc.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && out.(*model.User).Name != tc.user.Name {
+				t.Errorf("expected %s to be returned, got %#v", tc.user.Name, out)
+			}
+			if names := listNames(t, store); !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected the users %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy testStrategy
+		// update returns the update of the stored alice.
+		update func(alice *model.User) *model.User
+		// check returns whether the error of the update is expected.
+		check    func(err error) bool
+		creating bool
+	}{
+		{
+			name: "current resource version",
+			update: func(alice *model.User) *model.User {
+				return alice
+			},
+			check: func(err error) bool { return err == nil },
+		},
+		{
+			name: "stale resource version",
+			update: func(alice *model.User) *model.User {
+				alice.ResourceVersion = "1"
+				return alice
+			},
+			check: apierrors.IsConflict,
+		},
+		{
+			name:     "unconditional",
+			strategy: testStrategy{allowUnconditionalUpdate: true},
+			update: func(alice *model.User) *model.User {
+				alice.ResourceVersion = ""
+				return alice
+			},
+			check: func(err error) bool { return err == nil },
+		},
+		{
+			name: "no resource version",
+			update: func(alice *model.User) *model.User {
+				alice.ResourceVersion = ""
+				return alice
+			},
+			check: apierrors.IsInvalid,
+		},
+		{
+			name:     "create on update",
+			strategy: testStrategy{allowCreateOnUpdate: true},
+			update: func(alice *model.User) *model.User {
+				return newTestUser("bob", nil)
+			},
+			check:    func(err error) bool { return err == nil },
+			creating: true,
+		},
+		{
+			name: "create on update not allowed",
+			update: func(alice *model.User) *model.User {
+				return newTestUser("bob", nil)
+			},
+			check: apierrors.IsNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newTestStore(t, tc.strategy)
+			alice := createUsers(t, store, newTestUser("alice", nil))[0]
+			// the store is written once more, so that the first version of
+			// alice is stale
+			createUsers(t, store, newTestUser("carol", nil))
+
+			user := tc.update(alice)
+			user.Roles = []string{"admin"}
+			out, creating, err := store.Update(context.TODO(), user.Name, rest.DefaultUpdatedObjectInfo(user), rest.ValidateAllObjectFunc, rest.ValidateAllObjectUpdateFunc, false, nil)
+			if !tc.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if creating != tc.creating {
+				t.Errorf("expected creating to be %v, got %v", tc.creating, creating)
+			}
+			if err != nil {
+				return
+			}
+			stored, err := store.Get(context.TODO(), user.Name, nil)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !reflect.DeepEqual(stored, out) || !reflect.DeepEqual(stored.(*model.User).Roles, []string{"admin"}) {
+				t.Errorf("expected the updated user %#v to be stored, got %#v", out, stored)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteCollection(t *testing.T) {
+	store := newTestStore(t, testStrategy{})
+	createUsers(t, store,
+		newTestUser("alice", map[string]string{"team": "a"}),
+		newTestUser("bob", map[string]string{"team": "a"}),
+		newTestUser("carol", map[string]string{"team": "b"}),
+	)
+
+	out, err := store.DeleteCollection(context.TODO(), rest.ValidateAllObjectFunc, nil, &meta.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"team": "a"}),
+	})
+	if err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	deleted := []string{}
+	for _, user := range out.(*model.UserList).Items {
+		deleted = append(deleted, user.Name)
+	}
+	sort.Strings(deleted)
+	if !reflect.DeepEqual(deleted, []string{"alice", "bob"}) {
+		t.Errorf("expected alice and bob to be deleted, got %v", deleted)
+	}
+	if names := listNames(t, store); !reflect.DeepEqual(names, []string{"carol"}) {
+		t.Errorf("expected carol to be left, got %v", names)
+	}
+
+	// a dry run deletes nothing
+	if _, err := store.DeleteCollection(context.TODO(), rest.ValidateAllObjectFunc, &meta.DeleteOptions{DryRun: []string{"All"}}, nil); err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	if names := listNames(t, store); !reflect.DeepEqual(names, []string{"carol"}) {
+		t.Errorf("expected carol to be left, got %v", names)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  *meta.ListOptions
+		expected []string
+	}{
+		{
+			name:     "everything",
+			options:  &meta.ListOptions{},
+			expected: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:     "label selector",
+			options:  &meta.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"team": "a"})},
+			expected: []string{"alice", "bob"},
+		},
+		{
+			name:     "field selector",
+			options:  &meta.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", "bob")},
+			expected: []string{"bob"},
+		},
+		{
+			name: "label and field selectors",
+			options: &meta.ListOptions{
+				LabelSelector: labels.SelectorFromSet(labels.Set{"team": "a"}),
+				FieldSelector: fields.OneTermNotEqualSelector("metadata.name", "bob"),
+			},
+			expected: []string{"alice"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newTestStore(t, testStrategy{})
+			w, err := store.Watch(context.TODO(), tc.options)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			defer w.Stop()
+
+			createUsers(t, store,
+				newTestUser("alice", map[string]string{"team": "a"}),
+				newTestUser("bob", map[string]string{"team": "a"}),
+				newTestUser("carol", map[string]string{"team": "b"}),
+			)
+			// the users created before the watch has started are sent as
+			// added too, the events come in the order of the creations
+			got := []string{}
+			for len(got) < len(tc.expected) {
+				select {
+				case event := <-w.ResultChan():
+					name := event.Object.(*model.User).Name
+					if event.Type != watch.Added {
+						t.Errorf("expected %s to be added, got %s", name, event.Type)
+					}
+					got = append(got, name)
+				case <-time.After(wait.ForeverTestTimeout):
+					t.Fatalf("timed out waiting for the events, got %v", got)
+				}
+			}
+			select {
+			case event := <-w.ResultChan():
+				t.Errorf("unexpected event: %#v", event)
+			case <-time.After(100 * time.Millisecond):
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected the events of %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStoreGracefulDelete(t *testing.T) {
+	gracePeriod := func(seconds int64) *meta.DeleteOptions {
+		return &meta.DeleteOptions{GracePeriodSeconds: &seconds}
+	}
+	testCases := []struct {
+		name    string
+		options *meta.DeleteOptions
+		deleted bool
+		// ttls are the TTLs the updates of the deletion set.
+		ttls []uint64
+	}{
+		{
+			name:    "grace period",
+			options: gracePeriod(1),
+			ttls:    []uint64{1},
+		},
+		{
+			name:    "zero grace period",
+			options: gracePeriod(0),
+			deleted: true,
+			ttls:    []uint64{0},
+		},
+		{
+			name:    "no grace period",
+			deleted: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newTestStore(t, testStrategy{})
+			recorder := &ttlRecorder{Interface: store.Storage.Storage}
+			store.Storage.Storage = recorder
+			createUsers(t, store, newTestUser("alice", nil))
+
+			out, deleted, err := store.Delete(context.TODO(), "alice", rest.ValidateAllObjectFunc, tc.options)
+			if err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if deleted != tc.deleted {
+				t.Errorf("expected deleted to be %v, got %v", tc.deleted, deleted)
+			}
+			if !reflect.DeepEqual(recorder.ttls, tc.ttls) {
+				t.Errorf("expected the TTLs %v, got %v", tc.ttls, recorder.ttls)
+			}
+			if deleted {
+				if _, err := store.Get(context.TODO(), "alice", nil); !apierrors.IsNotFound(err) {
+					t.Errorf("expected alice to be deleted, got %v", err)
+				}
+				return
+			}
+
+			// the user is kept until the grace period is over
+			alice := out.(*model.User)
+			if alice.DeletionTimestamp == nil || alice.DeletionGracePeriodSeconds == nil || *alice.DeletionGracePeriodSeconds != *tc.options.GracePeriodSeconds {
+				t.Errorf("expected alice to be deleted gracefully, got %#v", alice)
+			}
+			stored, err := store.Get(context.TODO(), "alice", nil)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !reflect.DeepEqual(stored, out) {
+				t.Errorf("expected %#v to be stored, got %#v", out, stored)
+			}
+
+			// a longer grace period leaves the deletion pending
+			if _, deleted, err := store.Delete(context.TODO(), "alice", rest.ValidateAllObjectFunc, gracePeriod(10)); err != nil || deleted {
+				t.Errorf("expected the deletion to be pending, got %v, %v", deleted, err)
+			}
+			if len(recorder.ttls) != len(tc.ttls) {
+				t.Errorf("expected alice not to be updated, got the TTLs %v", recorder.ttls)
+			}
+
+			err = wait.PollImmediate(100*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+				_, err := store.Get(context.TODO(), "alice", nil)
+				if apierrors.IsNotFound(err) {
+					return true, nil
+				}
+				return false, err
+			})
+			if err != nil {
+				t.Errorf("expected alice to expire: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreWatchBookmarks(t *testing.T) {
+	for _, allowWatchBookmarks := range []bool{false, true} {
+		store := newTestStore(t, testStrategy{})
+		recorder := &watchRecorder{Interface: store.Storage.Storage}
+		store.Storage.Storage = recorder
+
+		w, err := store.Watch(context.TODO(), &meta.ListOptions{AllowWatchBookmarks: allowWatchBookmarks})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		w.Stop()
+		// the storage sends the bookmarks
+		if len(recorder.options) != 1 {
+			t.Fatalf("expected a watch of the storage, got %#v", recorder.options)
+		}
+		if opts := recorder.options[0]; opts.ProgressNotify != allowWatchBookmarks || opts.Predicate.AllowWatchBookmarks != allowWatchBookmarks {
+			t.Errorf("expected the bookmarks of the storage to be %v, got %#v", allowWatchBookmarks, opts)
+		}
+	}
+}
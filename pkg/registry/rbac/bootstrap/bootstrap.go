@@ -0,0 +1,66 @@
+// Package bootstrap creates the RBAC objects a new server starts with.
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/x893675/opa-server/pkg/model"
+	rolestrategy "github.com/x893675/opa-server/pkg/registry/rbac/role"
+	rolebindingstrategy "github.com/x893675/opa-server/pkg/registry/rbac/rolebinding"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/klog/v2"
+)
+
+// AdminRoleName is the name of the Role, and of its RoleBinding, granting
+// the bootstrap admins every verb on the RBAC API.
+const AdminRoleName = "rbac-admin"
+
+// AdminRole returns the Role granting every verb on the RBAC resources.
+func AdminRole() *model.Role {
+	return &model.Role{
+		ObjectMeta: meta.ObjectMeta{Name: AdminRoleName},
+		Rules: []model.PolicyRule{{
+			Verbs:     []string{"*"},
+			APIGroups: []string{model.GroupName},
+			Resources: []string{"*"},
+		}},
+	}
+}
+
+// AdminRoleBinding returns the RoleBinding of users to the Role returned by
+// AdminRole.
+func AdminRoleBinding(users []string) *model.RoleBinding {
+	binding := &model.RoleBinding{
+		ObjectMeta: meta.ObjectMeta{Name: AdminRoleName},
+		RoleRef:    model.RoleRef{APIGroup: model.GroupName, Kind: model.RoleKind, Name: AdminRoleName},
+	}
+	for _, user := range users {
+		binding.Subjects = append(binding.Subjects, model.Subject{Kind: model.UserKind, APIGroup: model.GroupName, Name: user})
+	}
+	return binding
+}
+
+// EnsureRoleWithBinding creates role and binding in a single transaction of
+// s, so that a binding is never left referring to a missing role. The keys
+// are the ones of the REST storage of Roles and RoleBindings. If either of
+// them already exists nothing is created: the objects may have been changed
+// through the API since they were bootstrapped.
+func EnsureRoleWithBinding(ctx context.Context, s storage.Interface, role *model.Role, binding *model.RoleBinding) error {
+	if err := rest.BeforeCreate(rolestrategy.Strategy, ctx, role); err != nil {
+		return err
+	}
+	if err := rest.BeforeCreate(rolebindingstrategy.Strategy, ctx, binding); err != nil {
+		return err
+	}
+	err := s.Txn(ctx).
+		Create("/roles/"+role.Name, role, nil, 0).
+		Create("/rolebindings/"+binding.Name, binding, nil, 0).
+		Commit()
+	if storage.IsNodeExist(err) {
+		klog.V(2).InfoS("Skipping bootstrap, the objects already exist", "role", role.Name, "roleBinding", binding.Name, "err", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestEnsureRoleWithBinding(t *testing.T) {
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	s := kvstore.New(memory.NewBackend(0), codec, func() runtime.Object { return &model.Role{} }, "/registry", value.IdentityTransformer, true)
+	ctx := context.Background()
+
+	if err := EnsureRoleWithBinding(ctx, s, AdminRole(), AdminRoleBinding([]string{"alice"})); err != nil {
+		t.Fatalf("EnsureRoleWithBinding failed: %v", err)
+	}
+	role := &model.Role{}
+	if err := s.Get(ctx, "/roles/"+AdminRoleName, storage.GetOptions{}, role); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	binding := &model.RoleBinding{}
+	if err := s.Get(ctx, "/rolebindings/"+AdminRoleName, storage.GetOptions{}, binding); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(binding.Subjects) != 1 || binding.Subjects[0].Name != "alice" || binding.RoleRef.Name != role.Name {
+		t.Errorf("expected alice to be bound to %s, got %#v", role.Name, binding)
+	}
+	if len(role.UID) == 0 || role.CreationTimestamp.IsZero() {
+		t.Errorf("expected the role to be prepared for creation, got %#v", role.ObjectMeta)
+	}
+
+	// the existing objects are left alone, even if only one of them exists
+	if err := s.Delete(ctx, "/roles/"+AdminRoleName, &model.Role{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := EnsureRoleWithBinding(ctx, s, AdminRole(), AdminRoleBinding([]string{"bob"})); err != nil {
+		t.Fatalf("EnsureRoleWithBinding failed: %v", err)
+	}
+	if err := s.Get(ctx, "/roles/"+AdminRoleName, storage.GetOptions{}, &model.Role{}); !storage.IsNotFound(err) {
+		t.Errorf("expected the role not to be created without its binding, got %v", err)
+	}
+	existing := &model.RoleBinding{}
+	if err := s.Get(ctx, "/rolebindings/"+AdminRoleName, storage.GetOptions{}, existing); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if existing.ResourceVersion != binding.ResourceVersion {
+		t.Errorf("expected the binding to be left alone, got %#v", existing)
+	}
+
+	// invalid objects are rejected before the transaction
+	invalid := AdminRoleBinding(nil)
+	invalid.RoleRef.Kind = "Unknown"
+	if err := EnsureRoleWithBinding(ctx, s, AdminRole(), invalid); !apierrors.IsInvalid(err) {
+		t.Errorf("expected an invalid binding to be rejected, got %v", err)
+	}
+}
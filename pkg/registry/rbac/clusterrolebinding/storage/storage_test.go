@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func newTestREST(t *testing.T) *REST {
+	t.Helper()
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	s := kvstore.New(memory.NewBackend(0), codec, func() runtime.Object { return &model.ClusterRoleBinding{} }, "/registry", value.IdentityTransformer, true)
+	storage, err := NewREST(s, nil, codec)
+	if err != nil {
+		t.Fatalf("NewREST failed: %v", err)
+	}
+	return storage
+}
+
+// isRoleRefChange returns true if err rejects a change of the roleRef.
+func isRoleRefChange(err error) bool {
+	return apierrors.IsInvalid(err) && strings.Contains(err.Error(), "cannot change roleRef")
+}
+
+func TestUpdateRoleRef(t *testing.T) {
+	testCases := []struct {
+		name   string
+		update func(binding *model.ClusterRoleBinding)
+		// check returns whether the error of the update is expected.
+		check func(err error) bool
+	}{
+		{
+			name: "subjects",
+			update: func(binding *model.ClusterRoleBinding) {
+				binding.Subjects = append(binding.Subjects, model.Subject{Kind: model.UserKind, Name: "bob"})
+			},
+			check: func(err error) bool { return err == nil },
+		},
+		{
+			name: "role name",
+			update: func(binding *model.ClusterRoleBinding) {
+				binding.RoleRef.Name = "admin"
+			},
+			check: isRoleRefChange,
+		},
+		{
+			name: "role kind",
+			update: func(binding *model.ClusterRoleBinding) {
+				binding.RoleRef.Kind = model.RoleKind
+			},
+			check: isRoleRefChange,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := newTestREST(t)
+			ctx := context.TODO()
+			out, err := storage.Create(ctx, &model.ClusterRoleBinding{
+				ObjectMeta: meta.ObjectMeta{Name: "viewers"},
+				Subjects:   []model.Subject{{Kind: model.UserKind, Name: "alice"}},
+				RoleRef:    model.RoleRef{Kind: model.ClusterRoleKind, Name: "view"},
+			}, rest.ValidateAllObjectFunc, nil)
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			binding := out.(*model.ClusterRoleBinding)
+			updated := *binding
+			updated.Subjects = append([]model.Subject{}, binding.Subjects...)
+			tc.update(&updated)
+
+			_, _, err = storage.Update(ctx, updated.Name, rest.DefaultUpdatedObjectInfo(&updated), rest.ValidateAllObjectFunc, rest.ValidateAllObjectUpdateFunc, false, nil)
+			if !tc.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			stored, err := storage.Get(ctx, updated.Name, nil)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if expected := binding.RoleRef; !reflect.DeepEqual(stored.(*model.ClusterRoleBinding).RoleRef, expected) {
+				t.Errorf("expected the roleRef %#v to be kept, got %#v", expected, stored.(*model.ClusterRoleBinding).RoleRef)
+			}
+		})
+	}
+}
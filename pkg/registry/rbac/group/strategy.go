@@ -0,0 +1,57 @@
+package group
+
+import (
+	"context"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/registry/rbac/validation"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// strategy implements behavior for Groups. They are deleted gracefully if the
+// delete options set a grace period.
+type strategy struct {
+	rest.GracefulDeleteOnRequest
+}
+
+// Strategy is the default logic that applies when creating, updating and
+// deleting Group objects.
+var Strategy = strategy{}
+
+// AllowCreateOnUpdate is true for Groups.
+func (strategy) AllowCreateOnUpdate() bool {
+	return true
+}
+
+// PrepareForCreate clears fields that are not allowed to be set by end users
+// on creation.
+func (strategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {}
+
+// PrepareForUpdate clears fields that are not allowed to be set by end users on update.
+func (strategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {}
+
+// Validate validates a new Group.
+func (strategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	group := obj.(*model.Group)
+	return validation.ValidateGroup(group)
+}
+
+// Canonicalize normalizes the object after validation.
+func (strategy) Canonicalize(obj runtime.Object) {}
+
+// ValidateUpdate is the default update validation for an end user.
+func (strategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	newGroup := obj.(*model.Group)
+	return validation.ValidateGroupUpdate(newGroup, old.(*model.Group))
+}
+
+// If AllowUnconditionalUpdate() is true and the object specified by
+// the user does not have a resource version, then generic Update()
+// populates it with the latest version. Else, it checks that the
+// version specified by the user matches the version of latest etcd
+// object.
+func (strategy) AllowUnconditionalUpdate() bool {
+	return true
+}
@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"fmt"
+
+	"github.com/x893675/opa-server/pkg/model"
+	clusterrolestore "github.com/x893675/opa-server/pkg/registry/rbac/clusterrole/storage"
+	clusterrolebindingstore "github.com/x893675/opa-server/pkg/registry/rbac/clusterrolebinding/storage"
+	groupstore "github.com/x893675/opa-server/pkg/registry/rbac/group/storage"
+	rolestore "github.com/x893675/opa-server/pkg/registry/rbac/role/storage"
+	rolebindingstore "github.com/x893675/opa-server/pkg/registry/rbac/rolebinding/storage"
+	userstore "github.com/x893675/opa-server/pkg/registry/rbac/user/storage"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend/factory"
+)
+
+// StorageFunc returns the storage the objects of resource, created by newFunc
+// and listed into the lists created by newListFunc, are kept in, and the
+// func that destroys it.
+type StorageFunc func(resource string, newFunc, newListFunc func() runtime.Object) (storage.Interface, factory.DestroyFunc, error)
+
+// NewRESTStorage returns the REST storage of every RBAC resource, keyed by
+// the plural resource name. If it fails, the storage that was already
+// created is destroyed.
+func NewRESTStorage(storageFor StorageFunc, codec runtime.Codec) (_ map[string]rest.StandardStorage, err error) {
+	restStorage := map[string]rest.StandardStorage{}
+	var (
+		s           storage.Interface
+		destroyFunc factory.DestroyFunc
+	)
+	defer func() {
+		if err != nil {
+			for _, r := range restStorage {
+				r.Destroy()
+			}
+		}
+	}()
+
+	// users
+	s, destroyFunc, err = storageFor("users",
+		func() runtime.Object { return &model.User{} },
+		func() runtime.Object { return &model.UserList{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage for users: %v", err)
+	}
+	userStorage, err := userstore.NewREST(s, destroyFunc, codec)
+	if err != nil {
+		destroyFunc()
+		return nil, fmt.Errorf("failed to create REST storage for users: %v", err)
+	}
+	restStorage["users"] = userStorage
+
+	// groups
+	s, destroyFunc, err = storageFor("groups",
+		func() runtime.Object { return &model.Group{} },
+		func() runtime.Object { return &model.GroupList{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage for groups: %v", err)
+	}
+	groupStorage, err := groupstore.NewREST(s, destroyFunc, codec)
+	if err != nil {
+		destroyFunc()
+		return nil, fmt.Errorf("failed to create REST storage for groups: %v", err)
+	}
+	restStorage["groups"] = groupStorage
+
+	// roles
+	s, destroyFunc, err = storageFor("roles",
+		func() runtime.Object { return &model.Role{} },
+		func() runtime.Object { return &model.RoleList{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage for roles: %v", err)
+	}
+	roleStorage, err := rolestore.NewREST(s, destroyFunc, codec)
+	if err != nil {
+		destroyFunc()
+		return nil, fmt.Errorf("failed to create REST storage for roles: %v", err)
+	}
+	restStorage["roles"] = roleStorage
+
+	// clusterroles
+	s, destroyFunc, err = storageFor("clusterroles",
+		func() runtime.Object { return &model.ClusterRole{} },
+		func() runtime.Object { return &model.ClusterRoleList{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage for clusterroles: %v", err)
+	}
+	clusterroleStorage, err := clusterrolestore.NewREST(s, destroyFunc, codec)
+	if err != nil {
+		destroyFunc()
+		return nil, fmt.Errorf("failed to create REST storage for clusterroles: %v", err)
+	}
+	restStorage["clusterroles"] = clusterroleStorage
+
+	// rolebindings
+	s, destroyFunc, err = storageFor("rolebindings",
+		func() runtime.Object { return &model.RoleBinding{} },
+		func() runtime.Object { return &model.RoleBindingList{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage for rolebindings: %v", err)
+	}
+	rolebindingStorage, err := rolebindingstore.NewREST(s, destroyFunc, codec)
+	if err != nil {
+		destroyFunc()
+		return nil, fmt.Errorf("failed to create REST storage for rolebindings: %v", err)
+	}
+	restStorage["rolebindings"] = rolebindingStorage
+
+	// clusterrolebindings
+	s, destroyFunc, err = storageFor("clusterrolebindings",
+		func() runtime.Object { return &model.ClusterRoleBinding{} },
+		func() runtime.Object { return &model.ClusterRoleBindingList{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage for clusterrolebindings: %v", err)
+	}
+	clusterrolebindingStorage, err := clusterrolebindingstore.NewREST(s, destroyFunc, codec)
+	if err != nil {
+		destroyFunc()
+		return nil, fmt.Errorf("failed to create REST storage for clusterrolebindings: %v", err)
+	}
+	restStorage["clusterrolebindings"] = clusterrolebindingStorage
+
+	return restStorage, nil
+}
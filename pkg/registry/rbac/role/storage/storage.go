@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"github.com/x893675/opa-server/pkg/model"
+	genericregistry "github.com/x893675/opa-server/pkg/registry/generic/registry"
+	"github.com/x893675/opa-server/pkg/registry/rbac/role"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend/factory"
+)
+
+// REST implements a RESTStorage for Roles.
+type REST struct {
+	*genericregistry.Store
+}
+
+// NewREST returns a RESTStorage object that will work against Roles kept in s.
+// destroyFunc is called when the RESTStorage is destroyed.
+func NewREST(s storage.Interface, destroyFunc factory.DestroyFunc, codec runtime.Codec) (*REST, error) {
+	store := &genericregistry.Store{
+		NewFunc:                  func() runtime.Object { return &model.Role{} },
+		NewListFunc:              func() runtime.Object { return &model.RoleList{} },
+		DefaultQualifiedResource: model.Resource("roles"),
+
+		CreateStrategy: role.Strategy,
+		UpdateStrategy: role.Strategy,
+		DeleteStrategy: role.Strategy,
+
+		Storage:     genericregistry.DryRunnableStorage{Storage: s, Codec: codec},
+		DestroyFunc: destroyFunc,
+	}
+	if err := store.Complete(); err != nil {
+		return nil, err
+	}
+
+	return &REST{store}, nil
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"github.com/x893675/opa-server/pkg/model"
+	genericregistry "github.com/x893675/opa-server/pkg/registry/generic/registry"
+	"github.com/x893675/opa-server/pkg/registry/rbac/rolebinding"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend/factory"
+)
+
+// REST implements a RESTStorage for RoleBindings.
+type REST struct {
+	*genericregistry.Store
+}
+
+// NewREST returns a RESTStorage object that will work against RoleBindings kept in s.
+// destroyFunc is called when the RESTStorage is destroyed.
+func NewREST(s storage.Interface, destroyFunc factory.DestroyFunc, codec runtime.Codec) (*REST, error) {
+	store := &genericregistry.Store{
+		NewFunc:                  func() runtime.Object { return &model.RoleBinding{} },
+		NewListFunc:              func() runtime.Object { return &model.RoleBindingList{} },
+		DefaultQualifiedResource: model.Resource("rolebindings"),
+
+		CreateStrategy: rolebinding.Strategy,
+		UpdateStrategy: rolebinding.Strategy,
+		DeleteStrategy: rolebinding.Strategy,
+
+		Storage:     genericregistry.DryRunnableStorage{Storage: s, Codec: codec},
+		DestroyFunc: destroyFunc,
+	}
+	if err := store.Complete(); err != nil {
+		return nil, err
+	}
+
+	return &REST{store}, nil
+}
@@ -0,0 +1,76 @@
+package rolebinding
+
+import (
+	"context"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/registry/rbac/validation"
+	"github.com/x893675/opa-server/pkg/registry/rest"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// strategy implements behavior for RoleBindings. They are deleted gracefully if the
+// delete options set a grace period.
+type strategy struct {
+	rest.GracefulDeleteOnRequest
+}
+
+// Strategy is the default logic that applies when creating, updating and
+// deleting RoleBinding objects.
+var Strategy = strategy{}
+
+// AllowCreateOnUpdate is true for RoleBindings.
+func (strategy) AllowCreateOnUpdate() bool {
+	return true
+}
+
+// PrepareForCreate clears fields that are not allowed to be set by end users
+// on creation.
+func (strategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	roleBinding := obj.(*model.RoleBinding)
+	setDefaults(&roleBinding.RoleRef, roleBinding.Subjects)
+}
+
+// PrepareForUpdate clears fields that are not allowed to be set by end users on update.
+func (strategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	newRoleBinding := obj.(*model.RoleBinding)
+	setDefaults(&newRoleBinding.RoleRef, newRoleBinding.Subjects)
+}
+
+// Validate validates a new RoleBinding.
+func (strategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	roleBinding := obj.(*model.RoleBinding)
+	return validation.ValidateRoleBinding(roleBinding)
+}
+
+// Canonicalize normalizes the object after validation.
+func (strategy) Canonicalize(obj runtime.Object) {}
+
+// ValidateUpdate is the default update validation for an end user.
+func (strategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	newRoleBinding := obj.(*model.RoleBinding)
+	return validation.ValidateRoleBindingUpdate(newRoleBinding, old.(*model.RoleBinding))
+}
+
+// If AllowUnconditionalUpdate() is true and the object specified by
+// the user does not have a resource version, then generic Update()
+// populates it with the latest version. Else, it checks that the
+// version specified by the user matches the version of latest etcd
+// object.
+func (strategy) AllowUnconditionalUpdate() bool {
+	return true
+}
+
+// setDefaults defaults the API group of roleRef and subjects to the RBAC API
+// group.
+func setDefaults(roleRef *model.RoleRef, subjects []model.Subject) {
+	if len(roleRef.APIGroup) == 0 {
+		roleRef.APIGroup = model.GroupName
+	}
+	for i := range subjects {
+		if len(subjects[i].APIGroup) == 0 {
+			subjects[i].APIGroup = model.GroupName
+		}
+	}
+}
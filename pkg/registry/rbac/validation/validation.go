@@ -0,0 +1,210 @@
+package validation
+
+import (
+	"github.com/x893675/opa-server/pkg/model"
+	"k8s.io/apimachinery/pkg/api/validation/path"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateRBACName is the minimal validation of the names of roles, users
+// and groups: they are used as path segments, in storage keys and in the
+// OPA data.
+func ValidateRBACName(name string, prefix bool) []string {
+	return path.IsValidPathSegmentName(name)
+}
+
+// ValidateUser validates a User.
+func ValidateUser(user *model.User) field.ErrorList {
+	allErrs := field.ErrorList{}
+	allErrs = append(allErrs, validateNames(user.Roles, field.NewPath("roles"))...)
+	return allErrs
+}
+
+// ValidateUserUpdate validates an update of a User.
+func ValidateUserUpdate(user *model.User, oldUser *model.User) field.ErrorList {
+	return ValidateUser(user)
+}
+
+// ValidateGroup validates a Group.
+func ValidateGroup(group *model.Group) field.ErrorList {
+	allErrs := field.ErrorList{}
+	allErrs = append(allErrs, validateNames(group.Users, field.NewPath("users"))...)
+	return allErrs
+}
+
+// ValidateGroupUpdate validates an update of a Group.
+func ValidateGroupUpdate(group *model.Group, oldGroup *model.Group) field.ErrorList {
+	return ValidateGroup(group)
+}
+
+// ValidateRole validates a Role.
+func ValidateRole(role *model.Role) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, rule := range role.Rules {
+		allErrs = append(allErrs, ValidatePolicyRule(rule, field.NewPath("rules").Index(i))...)
+	}
+	return allErrs
+}
+
+// ValidateRoleUpdate validates an update of a Role.
+func ValidateRoleUpdate(role *model.Role, oldRole *model.Role) field.ErrorList {
+	return ValidateRole(role)
+}
+
+// ValidateClusterRole validates a ClusterRole.
+func ValidateClusterRole(role *model.ClusterRole) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, rule := range role.Rules {
+		allErrs = append(allErrs, ValidatePolicyRule(rule, field.NewPath("rules").Index(i))...)
+	}
+	return allErrs
+}
+
+// ValidateClusterRoleUpdate validates an update of a ClusterRole.
+func ValidateClusterRoleUpdate(role *model.ClusterRole, oldRole *model.ClusterRole) field.ErrorList {
+	return ValidateClusterRole(role)
+}
+
+// ValidatePolicyRule validates a PolicyRule. A rule either grants access to
+// resources, in which case it names at least one API group and resource, or
+// to non-resource URLs.
+func ValidatePolicyRule(rule model.PolicyRule, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if len(rule.Verbs) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("verbs"), "verbs must contain at least one value"))
+	}
+
+	if len(rule.NonResourceURLs) > 0 {
+		for i, url := range rule.NonResourceURLs {
+			if len(url) == 0 || (url[0] != '/' && url != "*") {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("nonResourceURLs").Index(i), url, "must be * or begin with /"))
+			}
+		}
+		return allErrs
+	}
+
+	if len(rule.APIGroups) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("apiGroups"), "resource rules must supply at least one api group"))
+	}
+	if len(rule.Resources) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resources"), "resource rules must supply at least one resource"))
+	}
+	return allErrs
+}
+
+// ValidateRoleBinding validates a RoleBinding.
+func ValidateRoleBinding(roleBinding *model.RoleBinding) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	// TODO allow multiple API groups.  For now, restrict to one, but I can envision other experimental roles in other groups taking
+	// advantage of the binding infrastructure
+	if roleBinding.RoleRef.APIGroup != model.GroupName {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("roleRef", "apiGroup"), roleBinding.RoleRef.APIGroup, []string{model.GroupName}))
+	}
+
+	switch roleBinding.RoleRef.Kind {
+	case model.RoleKind, model.ClusterRoleKind:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("roleRef", "kind"), roleBinding.RoleRef.Kind, []string{model.RoleKind, model.ClusterRoleKind}))
+	}
+
+	allErrs = append(allErrs, validateRoleRefName(roleBinding.RoleRef.Name, field.NewPath("roleRef", "name"))...)
+
+	subjectsPath := field.NewPath("subjects")
+	for i, subject := range roleBinding.Subjects {
+		allErrs = append(allErrs, ValidateRBACSubject(&subject, subjectsPath.Index(i))...)
+	}
+
+	return allErrs
+}
+
+// ValidateRoleBindingUpdate validates an update of a RoleBinding. The
+// roleRef of a binding cannot change.
+func ValidateRoleBindingUpdate(roleBinding *model.RoleBinding, oldRoleBinding *model.RoleBinding) field.ErrorList {
+	allErrs := ValidateRoleBinding(roleBinding)
+
+	if oldRoleBinding.RoleRef != roleBinding.RoleRef {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("roleRef"), roleBinding.RoleRef, "cannot change roleRef"))
+	}
+
+	return allErrs
+}
+
+// ValidateClusterRoleBinding validates a ClusterRoleBinding.
+func ValidateClusterRoleBinding(roleBinding *model.ClusterRoleBinding) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	// TODO allow multiple API groups.  For now, restrict to one, but I can envision other experimental roles in other groups taking
+	// advantage of the binding infrastructure
+	if roleBinding.RoleRef.APIGroup != model.GroupName {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("roleRef", "apiGroup"), roleBinding.RoleRef.APIGroup, []string{model.GroupName}))
+	}
+
+	switch roleBinding.RoleRef.Kind {
+	case model.ClusterRoleKind:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("roleRef", "kind"), roleBinding.RoleRef.Kind, []string{model.ClusterRoleKind}))
+	}
+
+	allErrs = append(allErrs, validateRoleRefName(roleBinding.RoleRef.Name, field.NewPath("roleRef", "name"))...)
+
+	subjectsPath := field.NewPath("subjects")
+	for i, subject := range roleBinding.Subjects {
+		allErrs = append(allErrs, ValidateRBACSubject(&subject, subjectsPath.Index(i))...)
+	}
+
+	return allErrs
+}
+
+// ValidateClusterRoleBindingUpdate validates an update of a
+// ClusterRoleBinding. The roleRef of a binding cannot change.
+func ValidateClusterRoleBindingUpdate(roleBinding *model.ClusterRoleBinding, oldRoleBinding *model.ClusterRoleBinding) field.ErrorList {
+	allErrs := ValidateClusterRoleBinding(roleBinding)
+
+	if oldRoleBinding.RoleRef != roleBinding.RoleRef {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("roleRef"), roleBinding.RoleRef, "cannot change roleRef"))
+	}
+
+	return allErrs
+}
+
+// ValidateRBACSubject validates a Subject of a binding.
+func ValidateRBACSubject(subject *model.Subject, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(subject.Name) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+
+	switch subject.Kind {
+	case model.UserKind, model.GroupKind:
+		if subject.APIGroup != model.GroupName {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("apiGroup"), subject.APIGroup, []string{model.GroupName}))
+		}
+
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("kind"), subject.Kind, []string{model.UserKind, model.GroupKind}))
+	}
+
+	return allErrs
+}
+
+func validateRoleRefName(name string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if len(name) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath, ""))
+	} else {
+		for _, msg := range ValidateRBACName(name, false) {
+			allErrs = append(allErrs, field.Invalid(fldPath, name, msg))
+		}
+	}
+	return allErrs
+}
+
+func validateNames(names []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, name := range names {
+		allErrs = append(allErrs, validateRoleRefName(name, fldPath.Index(i))...)
+	}
+	return allErrs
+}
@@ -0,0 +1,12 @@
+package rest
+
+import (
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// FillObjectMetaSystemFields populates fields that are managed by the system on ObjectMeta.
+func FillObjectMetaSystemFields(objectMeta meta.Object) {
+	objectMeta.SetCreationTimestamp(meta.Now())
+	objectMeta.SetUID(meta.UID(uuid.NewUUID()))
+}
@@ -5,10 +5,21 @@ import (
 
 	"github.com/x893675/opa-server/pkg/runtime"
 	"github.com/x893675/opa-server/pkg/storage/meta"
-	"k8s.io/apimachinery/pkg/watch"
+	"github.com/x893675/opa-server/pkg/watch"
 )
 
+// Storage is a generic interface for RESTful storage services.
+type Storage interface {
+	// Destroy cleans up its resources on shutdown.
+	// Destroy has to be implemented in thread-safe way and be prepared
+	// for being called more than once.
+	Destroy()
+}
+
+// StandardStorage is an interface covering the common verbs. Provided for testing whether a
+// resource satisfies the normal storage methods. Use Storage when passing opaque storage objects.
 type StandardStorage interface {
+	Storage
 	Getter
 	Lister
 	CreaterUpdater
@@ -22,11 +33,21 @@ type StandardStorage interface {
 // object.
 type ValidateObjectFunc func(ctx context.Context, obj runtime.Object) error
 
+// ValidateAllObjectFunc is a "admit everything" instance of ValidateObjectFunc.
+func ValidateAllObjectFunc(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
 // ValidateObjectUpdateFunc is a function to act on a given object and its predecessor.
 // An error may be returned if the hook cannot be completed. An UpdateObjectFunc
 // may NOT transform the provided object.
 type ValidateObjectUpdateFunc func(ctx context.Context, obj, old runtime.Object) error
 
+// ValidateAllObjectUpdateFunc is a "admit everything" instance of ValidateObjectUpdateFunc.
+func ValidateAllObjectUpdateFunc(ctx context.Context, obj, old runtime.Object) error {
+	return nil
+}
+
 // Getter is an object that can retrieve a named RESTful resource.
 type Getter interface {
 	// Get finds a resource in the storage by name and returns it.
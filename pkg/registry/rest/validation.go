@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	genericvalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/api/validation/path"
+	v1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateObjectMeta validates an object's metadata on creation. The name is
+// required and must be usable as a path segment.
+func ValidateObjectMeta(objectMeta meta.Object, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	name := objectMeta.GetName()
+	if len(name) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), "name is required"))
+	}
+	for _, msg := range path.ValidatePathSegmentName(name, false) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), name, msg))
+	}
+	allErrs = append(allErrs, v1validation.ValidateLabels(objectMeta.GetLabels(), fldPath.Child("labels"))...)
+	allErrs = append(allErrs, genericvalidation.ValidateAnnotations(objectMeta.GetAnnotations(), fldPath.Child("annotations"))...)
+	return allErrs
+}
+
+// ValidateObjectMetaUpdate validates an object's metadata when updated.
+func ValidateObjectMetaUpdate(newMeta, oldMeta meta.Object, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	// Reject updates that don't specify a resource version
+	if len(newMeta.GetResourceVersion()) == 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("resourceVersion"), newMeta.GetResourceVersion(), "must be specified for an update"))
+	}
+
+	allErrs = append(allErrs, ValidateImmutableField(newMeta.GetName(), oldMeta.GetName(), fldPath.Child("name"))...)
+	allErrs = append(allErrs, ValidateImmutableField(newMeta.GetUID(), oldMeta.GetUID(), fldPath.Child("uid"))...)
+	allErrs = append(allErrs, ValidateImmutableField(newMeta.GetCreationTimestamp(), oldMeta.GetCreationTimestamp(), fldPath.Child("creationTimestamp"))...)
+	allErrs = append(allErrs, ValidateImmutableField(newMeta.GetDeletionTimestamp(), oldMeta.GetDeletionTimestamp(), fldPath.Child("deletionTimestamp"))...)
+	allErrs = append(allErrs, ValidateImmutableField(newMeta.GetDeletionGracePeriodSeconds(), oldMeta.GetDeletionGracePeriodSeconds(), fldPath.Child("deletionGracePeriodSeconds"))...)
+
+	allErrs = append(allErrs, v1validation.ValidateLabels(newMeta.GetLabels(), fldPath.Child("labels"))...)
+	allErrs = append(allErrs, genericvalidation.ValidateAnnotations(newMeta.GetAnnotations(), fldPath.Child("annotations"))...)
+
+	return allErrs
+}
+
+// semantic can do semantic deep equality checks for API objects.
+var semantic = conversion.EqualitiesOrDie(
+	func(a, b meta.Time) bool {
+		return a.UTC() == b.UTC()
+	},
+)
+
+// ValidateImmutableField returns an error if newVal differs from oldVal.
+func ValidateImmutableField(newVal, oldVal interface{}, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if !semantic.DeepEqual(oldVal, newVal) {
+		allErrs = append(allErrs, field.Invalid(fldPath, newVal, genericvalidation.FieldImmutableErrorMsg))
+	}
+	return allErrs
+}
+
+// ValidateDeleteOptions validates the options of a delete request.
+func ValidateDeleteOptions(options *meta.DeleteOptions) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if options.GracePeriodSeconds != nil && *options.GracePeriodSeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("gracePeriodSeconds"), *options.GracePeriodSeconds, "must be greater than or equal to 0"))
+	}
+	return allErrs
+}
@@ -1,12 +1,10 @@
 package runtime
 
-import "bytes"
+import (
+	"bytes"
 
-// Decode is a convenience wrapper for decoding data into an Object.
-//func Decode(d Decoder, data []byte) (Object, error) {
-//	obj, _, err := d.Decode(data, nil, nil)
-//	return obj, err
-//}
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
 
 // Encode is a convenience wrapper for encoding to a []byte from an Encoder
 func Encode(e Encoder, obj Object) ([]byte, error) {
@@ -18,8 +16,26 @@ func Encode(e Encoder, obj Object) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Decode is a convenience wrapper for decoding data into an Object.
+// Decode is a convenience wrapper for decoding data into an Object. The type
+// of the Object is the kind the data is typed with.
 func Decode(d Decoder, data []byte) (Object, error) {
 	obj, err := d.Decode(data, nil)
 	return obj, err
 }
+
+// UseOrCreateObject returns obj if the canonical ObjectKind returned by the provided typer matches gvk, or
+// invokes the ObjectCreator to instantiate a new gvk. Returns an error if the typer cannot find the object.
+func UseOrCreateObject(t ObjectTyper, c ObjectCreater, gvk schema.GroupVersionKind, obj Object) (Object, error) {
+	if obj != nil {
+		kinds, err := t.ObjectKinds(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, kind := range kinds {
+			if gvk == kind {
+				return obj, nil
+			}
+		}
+	}
+	return c.New(gvk)
+}
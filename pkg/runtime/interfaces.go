@@ -2,6 +2,8 @@ package runtime
 
 import (
 	"io"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // Object interface must be supported by all API types registered with Scheme. Since objects in a scheme are
@@ -9,11 +11,28 @@ import (
 // serializers to set the kind, version, and group the object is represented as. An Object may choose
 // to return a no-op ObjectKindAccessor in cases where it is not expected to be serialized.
 type Object interface {
-	//GetObjectKind() schema.ObjectKind
+	GetObjectKind() schema.ObjectKind
 	//DeepCopyObject() Object
 	SetZeroValue() error
 }
 
+// ObjectTyper contains methods for extracting the APIVersion and Kind
+// of objects.
+type ObjectTyper interface {
+	// ObjectKinds returns all possible group,version,kind of the provided object. Objects whose
+	// type is not registered return an error that IsNotRegisteredError is true for.
+	ObjectKinds(Object) ([]schema.GroupVersionKind, error)
+	// Recognizes returns true if the scheme is able to handle the provided version and kind,
+	// or more precisely that the provided version is a possible conversion or decoding
+	// target.
+	Recognizes(gvk schema.GroupVersionKind) bool
+}
+
+// ObjectCreater contains methods for instantiating an object by kind and version.
+type ObjectCreater interface {
+	New(kind schema.GroupVersionKind) (out Object, err error)
+}
+
 // Serializer is the core interface for transforming objects into a serialized format and back.
 // Implementations may choose to perform conversion of the object, but no assumptions should be made.
 type Serializer interface {
@@ -2,10 +2,13 @@ package json
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"reflect"
 	"strconv"
 
 	"github.com/x893675/opa-server/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // SerializerOptions holds the options which are used to configure a JSON/YAML serializer.
@@ -32,7 +35,10 @@ var _ runtime.Serializer = (*Serializer)(nil)
 
 // Serializer handles encoding versioned objects into the proper JSON form
 type Serializer struct {
+	meta       MetaFactory
 	options    SerializerOptions
+	creater    runtime.ObjectCreater
+	typer      runtime.ObjectTyper
 	identifier runtime.Identifier
 }
 
@@ -70,25 +76,125 @@ func (s *Serializer) doEncode(obj runtime.Object, w io.Writer) error {
 	//	_, err = w.Write(data)
 	//	return err
 	//}
+	if s.typer != nil {
+		kinds, err := s.typer.ObjectKinds(obj)
+		switch {
+		case runtime.IsNotRegisteredError(err):
+			// objects that are not registered, e.g. watch events, are
+			// written as they are
+		case err != nil:
+			return err
+		default:
+			// obj may be shared, e.g. with the watchers of a cacher that
+			// encode it concurrently, the kind is set on a copy
+			if !hasKind(kinds, obj.GetObjectKind().GroupVersionKind()) {
+				obj = shallowCopy(obj)
+				obj.GetObjectKind().SetGroupVersionKind(kinds[0])
+			}
+		}
+	}
 	encoder := json.NewEncoder(w)
 	return encoder.Encode(obj)
 }
 
+// shallowCopy returns a copy of the struct obj points to, the kind of the
+// registered types is held by value and can be set on it without affecting
+// obj.
+func shallowCopy(obj runtime.Object) runtime.Object {
+	v := reflect.ValueOf(obj).Elem()
+	out := reflect.New(v.Type())
+	out.Elem().Set(v)
+	return out.Interface().(runtime.Object)
+}
+
+func hasKind(kinds []schema.GroupVersionKind, kind schema.GroupVersionKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// gvkWithDefaults returns group kind and version defaulting from provided default
+func gvkWithDefaults(actual, defaultGVK schema.GroupVersionKind) schema.GroupVersionKind {
+	if len(actual.Kind) == 0 {
+		actual.Kind = defaultGVK.Kind
+	}
+	if len(actual.Version) == 0 && len(actual.Group) == 0 {
+		actual.Group = defaultGVK.Group
+		actual.Version = defaultGVK.Version
+	}
+	if len(actual.Version) == 0 && actual.Group == defaultGVK.Group {
+		actual.Version = defaultGVK.Version
+	}
+	return actual
+}
+
+// Decode attempts to convert the provided data into JSON, and then the apiVersion
+// and kind of the data pick the type of the returned object: into is used if it
+// has that type or if it is nil, otherwise a new object is created. The apiVersion
+// and kind missing from the data default to the ones into is registered with,
+// and are set on the returned object. If into is not registered, or the serializer
+// has no typer, the data is unmarshalled into into as it is.
 func (s Serializer) Decode(data []byte, into runtime.Object) (runtime.Object, error) {
-	//obj := reflect.New(reflect.TypeOf(into).Elem())
-	//TODO: deepcopy ?
-	if err := json.Unmarshal(data, into); err != nil {
+	if s.typer == nil {
+		if into == nil {
+			return nil, fmt.Errorf("no object to decode into")
+		}
+		if err := json.Unmarshal(data, into); err != nil {
+			return nil, err
+		}
+		return into, nil
+	}
+
+	actual, err := s.meta.Interpret(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if into != nil {
+		types, err := s.typer.ObjectKinds(into)
+		switch {
+		case runtime.IsNotRegisteredError(err):
+			if err := json.Unmarshal(data, into); err != nil {
+				return nil, err
+			}
+			return into, nil
+		case err != nil:
+			return nil, err
+		default:
+			*actual = gvkWithDefaults(*actual, types[0])
+		}
+	}
+
+	if len(actual.Kind) == 0 {
+		return nil, runtime.NewMissingKindErr(string(data))
+	}
+	if len(actual.Version) == 0 {
+		return nil, runtime.NewMissingVersionErr(string(data))
+	}
+
+	obj, err := runtime.UseOrCreateObject(s.typer, s.creater, *actual, into)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
 		return nil, err
 	}
-	return into, nil
+	obj.GetObjectKind().SetGroupVersionKind(*actual)
+	return obj, nil
 }
 
 // NewSerializerWithOptions creates a JSON/YAML serializer that handles encoding versioned objects into the proper JSON/YAML
 // form. If typer is not nil, the object has the group, version, and kind fields set. Options are copied into the Serializer
 // and are immutable.
-func NewSerializerWithOptions(options SerializerOptions) *Serializer {
+func NewSerializerWithOptions(meta MetaFactory, creater runtime.ObjectCreater, typer runtime.ObjectTyper, options SerializerOptions) *Serializer {
 	return &Serializer{
+		meta:       meta,
 		options:    options,
+		creater:    creater,
+		typer:      typer,
 		identifier: identifier(options),
 	}
 }
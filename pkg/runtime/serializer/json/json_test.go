@@ -0,0 +1,116 @@
+package json
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newTestSerializer(t *testing.T) *Serializer {
+	scheme := runtime.NewScheme()
+	if err := model.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return NewSerializerWithOptions(DefaultMetaFactory, scheme, scheme, SerializerOptions{})
+}
+
+func TestEncodeDoesNotModifyObject(t *testing.T) {
+	s := newTestSerializer(t)
+	user := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Roles: []string{"admin"}}
+
+	// objects may be shared, e.g. with the watchers of a cacher
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := runtime.Encode(s, user)
+			if err != nil {
+				t.Errorf("Encode failed: %v", err)
+				return
+			}
+			if !strings.Contains(string(data), `"kind":"User","apiVersion":"rbac.opa.io/v1"`) {
+				t.Errorf("expected the kind to be encoded, got %s", data)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if gvk := user.GetObjectKind().GroupVersionKind(); !gvk.Empty() {
+		t.Errorf("expected the encoded object not to be modified, got kind %v", gvk)
+	}
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	s := newTestSerializer(t)
+	userKind := model.SchemeGroupVersion.WithKind("User")
+	groupKind := model.SchemeGroupVersion.WithKind("Group")
+
+	testCases := []struct {
+		name       string
+		obj        runtime.Object
+		into       runtime.Object
+		expectKind schema.GroupVersionKind
+	}{
+		{
+			name:       "into nil",
+			obj:        &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Username: "alice", Roles: []string{"admin"}},
+			expectKind: userKind,
+		},
+		{
+			name:       "into the same type",
+			obj:        &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Username: "alice"},
+			into:       &model.User{},
+			expectKind: userKind,
+		},
+		{
+			name:       "into another registered type",
+			obj:        &model.Group{ObjectMeta: meta.ObjectMeta{Name: "dev"}, Users: []string{"alice"}},
+			into:       &model.User{},
+			expectKind: groupKind,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := runtime.Encode(s, tc.obj)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			got, err := s.Decode(data, tc.into)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if gvk := got.GetObjectKind().GroupVersionKind(); gvk != tc.expectKind {
+				t.Errorf("expected kind %v, got %v", tc.expectKind, gvk)
+			}
+			// the decoded object only differs from the encoded one by its kind
+			got.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+			if !reflect.DeepEqual(got, tc.obj) {
+				t.Errorf("expected %#v, got %#v", tc.obj, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMissingKind(t *testing.T) {
+	s := newTestSerializer(t)
+	data := []byte(`{"name":"alice"}`)
+
+	if _, err := s.Decode(data, nil); !runtime.IsMissingKind(err) {
+		t.Errorf("expected a missing kind error, got %v", err)
+	}
+	// the kind defaults to the one into is registered with
+	got, err := s.Decode(data, &model.User{})
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if gvk := got.GetObjectKind().GroupVersionKind(); gvk != model.SchemeGroupVersion.WithKind("User") {
+		t.Errorf("expected the kind of User, got %v", gvk)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/open-policy-agent/opa/plugins"
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+// AddHealthChecks adds checks to /healthz and /readyz. They are not added to
+// /livez, which only reports whether requests are served: the policy is
+// evaluated from memory, restarting the server because e.g. the storage is
+// unavailable would only make matters worse.
+func (s *Server) AddHealthChecks(checks ...healthz.HealthChecker) {
+	s.healthzChecks = append(s.healthzChecks, checks...)
+	s.readyzChecks = append(s.readyzChecks, checks...)
+}
+
+// AddReadyzChecks adds checks to /readyz only, e.g. ones that fail until the
+// server has started up.
+func (s *Server) AddReadyzChecks(checks ...healthz.HealthChecker) {
+	s.readyzChecks = append(s.readyzChecks, checks...)
+}
+
+// installHealthChecks serves /healthz, /livez and /readyz on router. Every
+// check is also served at <path>/<check name>.
+func (s *Server) installHealthChecks(router *mux.Router) {
+	m := routerMux{router}
+	healthz.InstallHandler(m, s.healthzChecks...)
+	healthz.InstallLivezHandler(m, healthz.PingHealthz)
+	healthz.InstallReadyzHandler(m, s.readyzChecks...)
+}
+
+// routerMux adapts a router to the mux the health checks are installed on.
+type routerMux struct {
+	router *mux.Router
+}
+
+func (m routerMux) Handle(path string, handler http.Handler) {
+	m.router.Handle(path, handler)
+}
+
+// policyHealthz fails until the policies are compiled and as long as a plugin
+// of the runtime, e.g. one activating bundles, is not in OK state.
+type policyHealthz struct {
+	manager *plugins.Manager
+}
+
+func (policyHealthz) Name() string {
+	return "policy"
+}
+
+func (p policyHealthz) Check(_ *http.Request) error {
+	compiler := p.manager.GetCompiler()
+	if compiler == nil || len(compiler.Modules) == 0 {
+		return fmt.Errorf("no policy loaded")
+	}
+	for name, status := range p.manager.PluginStatus() {
+		if status != nil && status.State != plugins.StateOK {
+			return fmt.Errorf("plugin %s is in %s state", name, status.State)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics implements the OPA server.Metrics interface. It instruments the
+// handlers of the OPA server and serves /metrics from registry.
+type metrics struct {
+	registry          *prometheus.Registry
+	durationHistogram *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector())
+	durationHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "http_request_duration_seconds",
+			Help: "A histogram of duration for requests.",
+			Buckets: []float64{
+				1e-6, // 1 microsecond
+				5e-6,
+				1e-5,
+				5e-5,
+				1e-4,
+				5e-4,
+				1e-3, // 1 millisecond
+				0.01,
+				0.1,
+				1, // 1 second
+			},
+		},
+		[]string{"code", "handler", "method"},
+	)
+	registry.MustRegister(durationHistogram)
+	return &metrics{
+		registry:          registry,
+		durationHistogram: durationHistogram,
+	}
+}
+
+// RegisterEndpoints registers the /metrics endpoint.
+func (m *metrics) RegisterEndpoints(registrar func(path, method string, handler http.Handler)) {
+	registrar("/metrics", http.MethodGet, promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// InstrumentHandler returns handler wrapped with a request duration histogram.
+func (m *metrics) InstrumentHandler(handler http.Handler, label string) http.Handler {
+	return promhttp.InstrumentHandlerDuration(m.durationHistogram.MustCurryWith(prometheus.Labels{"handler": label}), handler)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/open-policy-agent/opa/plugins/logs"
+	oparuntime "github.com/open-policy-agent/opa/runtime"
+	opaserver "github.com/open-policy-agent/opa/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/x893675/opa-server/pkg/endpoints"
+	"github.com/x893675/opa-server/pkg/endpoints/handlers"
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+// Server serves the REST API of an OPA runtime. Unlike runtime.Serve it
+// neither handles signals nor stops the runtime plugins on its own, so that
+// the caller decides when and in which order things are shut down.
+type Server struct {
+	rt        *oparuntime.Runtime
+	apiGroups []*endpoints.APIGroupVersion
+	handlers  []pathHandler
+	server    *opaserver.Server
+	errCh     chan error
+
+	metrics       *metrics
+	healthzChecks []healthz.HealthChecker
+	readyzChecks  []healthz.HealthChecker
+}
+
+// New returns a Server for rt. rt.Params.Addrs must be set. It serves
+// /healthz, /livez and /readyz, the latter fails until the policies of rt
+// are loaded.
+func New(rt *oparuntime.Runtime) *Server {
+	return &Server{
+		rt:            rt,
+		metrics:       newMetrics(),
+		healthzChecks: []healthz.HealthChecker{healthz.PingHealthz},
+		readyzChecks:  []healthz.HealthChecker{healthz.PingHealthz, policyHealthz{rt.Manager}},
+	}
+}
+
+// InstallAPIGroup serves apiGroup next to the OPA REST API. It must be
+// called before Start. Requests to apiGroup are cancelled once the context
+// passed to Start is done.
+func (s *Server) InstallAPIGroup(apiGroup *endpoints.APIGroupVersion) {
+	s.apiGroups = append(s.apiGroups, apiGroup)
+}
+
+// pathHandler is a handler served at an exact path.
+type pathHandler struct {
+	path    string
+	handler http.Handler
+}
+
+// Handle serves handler at path next to the OPA REST API, like the API
+// groups installed with InstallAPIGroup. It must be called before Start.
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.handlers = append(s.handlers, pathHandler{path, handler})
+}
+
+// MetricsRegistry returns the registry /metrics is served from, on both the
+// API and the diagnostic addresses.
+func (s *Server) MetricsRegistry() prometheus.Registerer {
+	return s.metrics.registry
+}
+
+// Start starts the runtime plugins and the listeners and returns once they
+// are started. Listener failures are reported through Err.
+func (s *Server) Start(ctx context.Context) error {
+	params := s.rt.Params
+	if params.Addrs == nil {
+		return fmt.Errorf("at least one address must be configured in runtime parameters")
+	}
+
+	if err := s.rt.Manager.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start plugins: %v", err)
+	}
+
+	// the subrouter copies the settings of router when it is created, the
+	// OPA server only applies them to router later on
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	router.UseEncodedPath()
+	apis := router.NewRoute().Subrouter()
+	apis.Use(cancelOnDone(ctx))
+	for _, apiGroup := range s.apiGroups {
+		if err := apiGroup.InstallREST(apis); err != nil {
+			return fmt.Errorf("failed to install API group %s: %v", apiGroup.GroupVersion, err)
+		}
+	}
+	for _, h := range s.handlers {
+		apis.Handle(h.path, h.handler)
+	}
+	s.installHealthChecks(apis)
+
+	srv := opaserver.New().
+		WithRouter(router).
+		WithStore(s.rt.Store).
+		WithManager(s.rt.Manager).
+		WithCompilerErrorLimit(params.ErrorLimit).
+		WithPprofEnabled(params.PprofEnabled).
+		WithAddresses(*params.Addrs).
+		WithH2CEnabled(params.H2CEnabled).
+		WithCertificate(params.Certificate).
+		WithCertPool(params.CertPool).
+		WithAuthentication(params.Authentication).
+		WithAuthorization(params.Authorization).
+		WithDecisionIDFactory(s.decisionID).
+		WithDecisionLoggerWithErr(s.logDecision).
+		WithRuntime(s.rt.Manager.Info).
+		WithMetrics(s.metrics)
+	if params.DiagnosticAddrs != nil {
+		srv = srv.WithDiagnosticAddresses(*params.DiagnosticAddrs)
+	}
+
+	srv, err := srv.Init(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize server: %v", err)
+	}
+	srv.Handler = withLogging(srv.Handler)
+	srv.DiagnosticHandler = oparuntime.NewLoggingHandler(srv.DiagnosticHandler)
+
+	loops, err := srv.Listeners()
+	if err != nil {
+		return fmt.Errorf("failed to create listeners: %v", err)
+	}
+	s.server = srv
+	s.errCh = make(chan error, len(loops))
+	for _, loop := range loops {
+		go func(serverLoop opaserver.Loop) {
+			if err := serverLoop(); err != nil && err != http.ErrServerClosed {
+				s.errCh <- err
+			}
+		}(loop)
+	}
+	return nil
+}
+
+// Err returns a channel that receives the error of every listener that
+// fails after Start.
+func (s *Server) Err() <-chan error {
+	return s.errCh
+}
+
+// Shutdown stops the listeners and waits for in-flight requests to finish
+// until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// withLogging logs the requests to handler like the OPA runtime does, except
+// for watches. The OPA logging handler records whole responses and cannot
+// flush the events of a watch as they happen.
+func withLogging(handler http.Handler) http.Handler {
+	logging := oparuntime.NewLoggingHandler(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if handlers.IsWatch(req) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		logging.ServeHTTP(w, req)
+	})
+}
+
+// cancelOnDone returns a middleware that cancels the requests it handles once
+// ctx is done. Watches never complete on their own, without it they would
+// hold up Shutdown until it gives up.
+func cancelOnDone(ctx context.Context) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			reqCtx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-ctx.Done():
+					cancel()
+				case <-reqCtx.Done():
+				}
+			}()
+			next.ServeHTTP(w, req.WithContext(reqCtx))
+		})
+	}
+}
+
+func (s *Server) decisionID() string {
+	if s.rt.Params.DecisionIDFactory != nil {
+		return s.rt.Params.DecisionIDFactory()
+	}
+	if logs.Lookup(s.rt.Manager) != nil {
+		return uuid.New().String()
+	}
+	return ""
+}
+
+func (s *Server) logDecision(ctx context.Context, event *opaserver.Info) error {
+	plugin := logs.Lookup(s.rt.Manager)
+	if plugin == nil {
+		return nil
+	}
+	return plugin.Log(ctx, event)
+}
@@ -1,11 +1,10 @@
-package etcd3
+package storage
 
 import (
 	"fmt"
 	"strconv"
 
 	"github.com/x893675/opa-server/pkg/runtime"
-	"github.com/x893675/opa-server/pkg/storage"
 	"github.com/x893675/opa-server/pkg/storage/meta"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
@@ -77,7 +76,7 @@ func (a APIObjectVersioner) ParseResourceVersion(resourceVersion string) (uint64
 	}
 	version, err := strconv.ParseUint(resourceVersion, 10, 64)
 	if err != nil {
-		return 0, storage.NewInvalidError(field.ErrorList{
+		return 0, NewInvalidError(field.ErrorList{
 			// Validation errors are supposed to return version-specific field
 			// paths, but this is probably close enough.
 			field.Invalid(field.NewPath("resourceVersion"), resourceVersion, err.Error()),
@@ -86,18 +85,18 @@ func (a APIObjectVersioner) ParseResourceVersion(resourceVersion string) (uint64
 	return version, nil
 }
 
-// Versioner implements Versioner
-var Versioner storage.Versioner = APIObjectVersioner{}
+// APIObjectVersioner implements Versioner
+var _ Versioner = APIObjectVersioner{}
 
 // CompareResourceVersion compares etcd resource versions.  Outside this API they are all strings,
 // but etcd resource versions are special, they're actually ints, so we can easily compare them.
 func (a APIObjectVersioner) CompareResourceVersion(lhs, rhs runtime.Object) int {
-	lhsVersion, err := Versioner.ObjectResourceVersion(lhs)
+	lhsVersion, err := a.ObjectResourceVersion(lhs)
 	if err != nil {
 		// coder error
 		panic(err)
 	}
-	rhsVersion, err := Versioner.ObjectResourceVersion(rhs)
+	rhsVersion, err := a.ObjectResourceVersion(rhs)
 	if err != nil {
 		// coder error
 		panic(err)
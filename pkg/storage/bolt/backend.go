@@ -0,0 +1,629 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	bbolt "go.etcd.io/bbolt"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultHistorySize is the number of revisions a backend keeps in its
+	// change log for lists at an older resource version and watches that
+	// start in the past.
+	DefaultHistorySize = 10000
+
+	// openTimeout is how long Open waits for the lock of a file that is
+	// used by another process.
+	openTimeout = 10 * time.Second
+
+	// maxEventsBatch is the maximum number of change log entries read at
+	// once by a watch.
+	maxEventsBatch = 1000
+)
+
+var (
+	metaBucket = []byte("meta")
+	kvBucket   = []byte("kv")
+	logBucket  = []byte("log")
+
+	revKey        = []byte("rev")
+	compactRevKey = []byte("compactRev")
+)
+
+var _ kvstore.Backend = &Backend{}
+
+// keyValue is a version of a key as it is persisted.
+type keyValue struct {
+	kvstore.KeyValue
+	// Expires is the time in unix seconds the key is deleted at, 0 if it
+	// was written without a ttl.
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// change is an entry of the change log.
+type change struct {
+	Rev int64  `json:"rev"`
+	Key string `json:"key"`
+	// KV is the version of the key written at Rev, nil if the key was
+	// deleted.
+	KV *keyValue `json:"kv,omitempty"`
+	// Prev is the version of the key before Rev, nil if the key was
+	// created.
+	Prev *keyValue `json:"prev,omitempty"`
+}
+
+// timer expires a key written with a ttl at rev.
+type timer struct {
+	rev   int64
+	timer *time.Timer
+}
+
+// Backend is an implementation of kvstore.Backend persisted to a bbolt file.
+// All the stores created on a backend share its revision.
+//
+// Every write is recorded in a change log, which serves lists at an older
+// resource version and the watches that start in the past, including after
+// a restart. The log is compacted to the last revisions.
+type Backend struct {
+	db          *bbolt.DB
+	historySize int
+
+	lock sync.Mutex
+	// changed is closed and replaced on every write.
+	changed chan struct{}
+	// timers expire the keys written with a ttl.
+	timers map[string]*timer
+}
+
+// Open opens the bbolt file at path, creating it if it does not exist, and
+// returns a backend on it that keeps historySize revisions. If historySize
+// is not positive DefaultHistorySize is used.
+func Open(path string, historySize int) (*Backend, error) {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	b := &Backend{
+		db:          db,
+		historySize: historySize,
+		changed:     make(chan struct{}),
+		timers:      map[string]*timer{},
+	}
+
+	var expiring []*keyValue
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, kvBucket, logBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(revKey) == nil {
+			// like etcd start at revision 1, 0 is not a valid resource version
+			if err := meta.Put(revKey, itob(1)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(kvBucket).ForEach(func(k, v []byte) error {
+			kv, err := decodeKeyValue(v)
+			if err != nil {
+				return err
+			}
+			if kv.Expires != 0 {
+				expiring = append(expiring, kv)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize %s: %v", path, err)
+	}
+
+	// the keys that expired while the file was closed are deleted right away
+	for _, kv := range expiring {
+		b.setTimer(kv.Key, kv.ModRevision, time.Unix(kv.Expires, 0))
+	}
+	return b, nil
+}
+
+// Close stops expiring keys and closes the file.
+func (b *Backend) Close() error {
+	b.lock.Lock()
+	for key, t := range b.timers {
+		t.timer.Stop()
+		delete(b.timers, key)
+	}
+	b.lock.Unlock()
+	return b.db.Close()
+}
+
+// Revision returns the current revision of the backend.
+func (b *Backend) Revision() (int64, error) {
+	var rev int64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		rev = getRev(tx, revKey)
+		return nil
+	})
+	return rev, err
+}
+
+// Compact discards the change log before rev. Lists at a resource version
+// older than rev and watches starting before it fail with a "resource
+// version too old" error afterwards.
+func (b *Backend) Compact(rev int64) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if current := getRev(tx, revKey); rev > current {
+			return fmt.Errorf("bolt: cannot compact revision %d, current revision is %d", rev, current)
+		}
+		return compact(tx, rev)
+	})
+}
+
+func compact(tx *bbolt.Tx, rev int64) error {
+	if rev <= getRev(tx, compactRevKey) {
+		return nil
+	}
+
+	// deleting while iterating a cursor skips entries, collect the keys first
+	var keys [][]byte
+	c := tx.Bucket(logBucket).Cursor()
+	for k, _ := c.First(); k != nil && btoi(k) <= rev; k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := tx.Bucket(logBucket).Delete(k); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(metaBucket).Put(compactRevKey, itob(rev))
+}
+
+// checkRevision returns an error if the state at rev can not be read. A rev
+// of 0 stands for the current revision.
+func checkRevision(tx *bbolt.Tx, rev int64) error {
+	if current := getRev(tx, revKey); rev > current {
+		return storage.NewTooLargeResourceVersionError(uint64(rev), uint64(current), 0)
+	}
+	if rev > 0 && rev < getRev(tx, compactRevKey) {
+		return kvstore.ErrCompacted
+	}
+	return nil
+}
+
+// Get implements kvstore.Backend.Get.
+func (b *Backend) Get(key string, rev int64) (*kvstore.KeyValue, int64, error) {
+	var kv *keyValue
+	var readRev int64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if err := checkRevision(tx, rev); err != nil {
+			return err
+		}
+		current := getRev(tx, revKey)
+		if rev == 0 {
+			rev = current
+		}
+		readRev = rev
+		var err error
+		if kv, err = getKeyValue(tx, key); err != nil {
+			return err
+		}
+		if rev == current {
+			return nil
+		}
+
+		// undo the changes after rev, the first change of the key holds its
+		// version at rev
+		c := tx.Bucket(logBucket).Cursor()
+		for k, v := c.Seek(itob(rev + 1)); k != nil; k, v = c.Next() {
+			ch, err := decodeChange(v)
+			if err != nil {
+				return err
+			}
+			if ch.Key == key {
+				kv = ch.Prev
+				return nil
+			}
+		}
+		return nil
+	})
+	return toKeyValue(kv), readRev, err
+}
+
+// List implements kvstore.Backend.List.
+func (b *Backend) List(prefix, from string, rev, limit int64) (kvs []*kvstore.KeyValue, count, readRev int64, err error) {
+	if from < prefix {
+		from = prefix
+	}
+	err = b.db.View(func(tx *bbolt.Tx) error {
+		if err := checkRevision(tx, rev); err != nil {
+			return err
+		}
+		current := getRev(tx, revKey)
+		if rev == 0 {
+			rev = current
+		}
+		readRev = rev
+
+		items := map[string]*keyValue{}
+		c := tx.Bucket(kvBucket).Cursor()
+		for k, v := c.Seek([]byte(from)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			kv, err := decodeKeyValue(v)
+			if err != nil {
+				return err
+			}
+			items[kv.Key] = kv
+		}
+
+		if rev < current {
+			// undo the changes after rev, the first change of each key holds
+			// its version at rev
+			undone := map[string]bool{}
+			c := tx.Bucket(logBucket).Cursor()
+			for k, v := c.Seek(itob(rev + 1)); k != nil; k, v = c.Next() {
+				ch, err := decodeChange(v)
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(ch.Key, prefix) || ch.Key < from || undone[ch.Key] {
+					continue
+				}
+				undone[ch.Key] = true
+				if ch.Prev == nil {
+					delete(items, ch.Key)
+				} else {
+					items[ch.Key] = ch.Prev
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		count = int64(len(keys))
+		if limit > 0 && int64(len(keys)) > limit {
+			keys = keys[:limit]
+		}
+		for _, key := range keys {
+			kvs = append(kvs, toKeyValue(items[key]))
+		}
+		return nil
+	})
+	return kvs, count, readRev, err
+}
+
+// Put implements kvstore.Backend.Put.
+func (b *Backend) Put(key string, value []byte, modRev int64, ttl uint64) (rev int64, cur *kvstore.KeyValue, ok bool, err error) {
+	var expires time.Time
+	if ttl != 0 {
+		expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		prev, err := getKeyValue(tx, key)
+		if err != nil {
+			return err
+		}
+		if prev == nil && modRev != 0 || prev != nil && prev.ModRevision != modRev {
+			cur = toKeyValue(prev)
+			return nil
+		}
+
+		rev = getRev(tx, revKey) + 1
+		kv := &keyValue{KeyValue: kvstore.KeyValue{Key: key, Value: value, CreateRevision: rev, ModRevision: rev}}
+		if prev != nil {
+			kv.CreateRevision = prev.CreateRevision
+		}
+		if !expires.IsZero() {
+			kv.Expires = expires.Unix()
+		}
+		if err := b.write(tx, &change{Rev: rev, Key: key, KV: kv, Prev: prev}, 0); err != nil {
+			return err
+		}
+		cur, ok = toKeyValue(kv), true
+		return nil
+	})
+	if err != nil || !ok {
+		return 0, cur, false, err
+	}
+	b.setTimer(key, rev, expires)
+	b.notify()
+	return rev, cur, true, nil
+}
+
+// Remove implements kvstore.Backend.Remove.
+func (b *Backend) Remove(key string, modRev int64) (rev int64, cur *kvstore.KeyValue, ok bool, err error) {
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		prev, err := getKeyValue(tx, key)
+		if err != nil {
+			return err
+		}
+		cur = toKeyValue(prev)
+		if prev == nil || prev.ModRevision != modRev {
+			return nil
+		}
+
+		rev = getRev(tx, revKey) + 1
+		if err := b.write(tx, &change{Rev: rev, Key: key, Prev: prev}, 0); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	if err != nil || !ok {
+		return 0, cur, false, err
+	}
+	b.setTimer(key, rev, time.Time{})
+	b.notify()
+	return rev, cur, true, nil
+}
+
+// Txn implements kvstore.Backend.Txn.
+func (b *Backend) Txn(ops []kvstore.TxnOp) (rev int64, failed int, cur *kvstore.KeyValue, ok bool, err error) {
+	expires := make([]time.Time, len(ops))
+	for i, op := range ops {
+		if !op.Delete && op.TTL != 0 {
+			expires[i] = time.Now().Add(time.Duration(op.TTL) * time.Second)
+		}
+	}
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		prevs := make([]*keyValue, len(ops))
+		for i, op := range ops {
+			prev, err := getKeyValue(tx, op.Key)
+			if err != nil {
+				return err
+			}
+			if !op.Matches(toKeyValue(prev)) {
+				failed, cur = i, toKeyValue(prev)
+				return nil
+			}
+			prevs[i] = prev
+		}
+
+		rev = getRev(tx, revKey) + 1
+		for i, op := range ops {
+			ch := &change{Rev: rev, Key: op.Key, Prev: prevs[i]}
+			if !op.Delete {
+				ch.KV = &keyValue{KeyValue: kvstore.KeyValue{Key: op.Key, Value: op.Value, CreateRevision: rev, ModRevision: rev}}
+				if prevs[i] != nil {
+					ch.KV.CreateRevision = prevs[i].CreateRevision
+				}
+				if !expires[i].IsZero() {
+					ch.KV.Expires = expires[i].Unix()
+				}
+			}
+			if err := b.write(tx, ch, i); err != nil {
+				return err
+			}
+		}
+		ok = true
+		return nil
+	})
+	if err != nil || !ok {
+		return 0, failed, cur, false, err
+	}
+	for i, op := range ops {
+		b.setTimer(op.Key, rev, expires[i])
+	}
+	b.notify()
+	return rev, 0, nil, true, nil
+}
+
+// expire deletes key if it has not been written since rev.
+func (b *Backend) expire(key string, rev int64) {
+	if _, _, _, err := b.Remove(key, rev); err != nil {
+		klog.ErrorS(err, "Failed to delete expired key", "key", key)
+	}
+}
+
+// write applies ch, the i-th change of a write at the next revision, and
+// compacts the change log in batches once it grew to twice the history size.
+func (b *Backend) write(tx *bbolt.Tx, ch *change, i int) error {
+	kvs := tx.Bucket(kvBucket)
+	if ch.KV != nil {
+		data, err := json.Marshal(ch.KV)
+		if err != nil {
+			return err
+		}
+		if err := kvs.Put([]byte(ch.Key), data); err != nil {
+			return err
+		}
+	} else if err := kvs.Delete([]byte(ch.Key)); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(logBucket).Put(logKey(ch.Rev, i), data); err != nil {
+		return err
+	}
+	if err := tx.Bucket(metaBucket).Put(revKey, itob(ch.Rev)); err != nil {
+		return err
+	}
+
+	if ch.Rev-getRev(tx, compactRevKey) >= int64(2*b.historySize) {
+		return compact(tx, ch.Rev-int64(b.historySize))
+	}
+	return nil
+}
+
+// setTimer expires key at expires, unless it is zero, if rev is not older
+// than the revision of its current timer.
+func (b *Backend) setTimer(key string, rev int64, expires time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if t, ok := b.timers[key]; ok {
+		if t.rev > rev {
+			return
+		}
+		t.timer.Stop()
+		delete(b.timers, key)
+	}
+	if expires.IsZero() {
+		return
+	}
+	b.timers[key] = &timer{
+		rev: rev,
+		timer: time.AfterFunc(time.Until(expires), func() {
+			b.expire(key, rev)
+		}),
+	}
+}
+
+// notify wakes up the watchers waiting for a write.
+func (b *Backend) notify() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Snapshot implements kvstore.Backend.Snapshot.
+func (b *Backend) Snapshot(key string, recursive bool) (kvs []*kvstore.KeyValue, rev int64, err error) {
+	err = b.db.View(func(tx *bbolt.Tx) error {
+		rev = getRev(tx, revKey)
+		if !recursive {
+			kv, err := getKeyValue(tx, key)
+			if kv != nil {
+				kvs = append(kvs, toKeyValue(kv))
+			}
+			return err
+		}
+		c := tx.Bucket(kvBucket).Cursor()
+		for k, v := c.Seek([]byte(key)); k != nil && bytes.HasPrefix(k, []byte(key)); k, v = c.Next() {
+			kv, err := decodeKeyValue(v)
+			if err != nil {
+				return err
+			}
+			kvs = append(kvs, toKeyValue(kv))
+		}
+		return nil
+	})
+	return kvs, rev, err
+}
+
+// EventsAfter implements kvstore.Backend.EventsAfter.
+func (b *Backend) EventsAfter(rev int64) ([]*kvstore.Event, <-chan struct{}, error) {
+	// get the channel first to not miss a write that happens after the read
+	b.lock.Lock()
+	changed := b.changed
+	b.lock.Unlock()
+
+	var events []*kvstore.Event
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if rev < getRev(tx, compactRevKey) {
+			return kvstore.ErrCompacted
+		}
+		c := tx.Bucket(logBucket).Cursor()
+		for k, v := c.Seek(itob(rev + 1)); k != nil; k, v = c.Next() {
+			// the changes of a transaction share a revision, a batch does
+			// not split them as the next one starts after the revision
+			if len(events) >= maxEventsBatch && btoi(k) != events[len(events)-1].Rev {
+				break
+			}
+			ch, err := decodeChange(v)
+			if err != nil {
+				return err
+			}
+			events = append(events, parseChange(ch))
+		}
+		return nil
+	})
+	return events, changed, err
+}
+
+// toKeyValue returns the version of kv without its expiry, nil if kv is nil.
+func toKeyValue(kv *keyValue) *kvstore.KeyValue {
+	if kv == nil {
+		return nil
+	}
+	return &kv.KeyValue
+}
+
+// parseChange converts an entry of the change log to an event.
+func parseChange(ch *change) *kvstore.Event {
+	e := &kvstore.Event{
+		Key:       ch.Key,
+		Rev:       ch.Rev,
+		IsDeleted: ch.KV == nil,
+		IsCreated: ch.Prev == nil,
+	}
+	if ch.KV != nil {
+		e.Value = ch.KV.Value
+	}
+	if ch.Prev != nil {
+		e.PrevValue = ch.Prev.Value
+	}
+	return e
+}
+
+func getKeyValue(tx *bbolt.Tx, key string) (*keyValue, error) {
+	data := tx.Bucket(kvBucket).Get([]byte(key))
+	if data == nil {
+		return nil, nil
+	}
+	return decodeKeyValue(data)
+}
+
+func decodeKeyValue(data []byte) (*keyValue, error) {
+	kv := &keyValue{}
+	if err := json.Unmarshal(data, kv); err != nil {
+		return nil, fmt.Errorf("invalid key value: %v", err)
+	}
+	return kv, nil
+}
+
+func decodeChange(data []byte) (*change, error) {
+	ch := &change{}
+	if err := json.Unmarshal(data, ch); err != nil {
+		return nil, fmt.Errorf("invalid change log entry: %v", err)
+	}
+	return ch, nil
+}
+
+func getRev(tx *bbolt.Tx, key []byte) int64 {
+	data := tx.Bucket(metaBucket).Get(key)
+	if data == nil {
+		return 0
+	}
+	return btoi(data)
+}
+
+// itob encodes a revision so that the keys of the change log sort by
+// revision.
+func itob(rev int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(rev))
+	return b
+}
+
+// logKey returns the key of the i-th change of the change log at rev. The
+// first change of a revision is keyed by the revision alone, the next ones
+// of a transaction sort after it and before the next revision.
+func logKey(rev int64, i int) []byte {
+	if i == 0 {
+		return itob(rev)
+	}
+	return append(itob(rev), itob(int64(i))...)
+}
+
+// btoi decodes the revision of a key of the change log.
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
@@ -0,0 +1,286 @@
+package cacher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+type filterWithAttrsFunc func(key string, l labels.Set, f fields.Set) bool
+
+func filterWithAttrsFunction(key string, p storage.SelectionPredicate) filterWithAttrsFunc {
+	filterFunc := func(objKey string, label labels.Set, field fields.Set) bool {
+		if !hasPathPrefix(objKey, key) {
+			return false
+		}
+		return p.MatchesObjectAttributes(label, field)
+	}
+	return filterFunc
+}
+
+// hasPathPrefix returns true if the string matches pathPrefix exactly, or if is prefixed with pathPrefix at a path segment boundary
+func hasPathPrefix(s, pathPrefix string) bool {
+	// Short circuit if s doesn't contain the prefix at all
+	if len(s) < len(pathPrefix) || s[:len(pathPrefix)] != pathPrefix {
+		return false
+	}
+
+	sRemainder := s[len(pathPrefix):]
+	// Short circuit on exact match (s == pathPrefix)
+	if len(sRemainder) == 0 {
+		return true
+	}
+	// Ensure the remainder starts with a path separator
+	if pathPrefix[len(pathPrefix)-1] == '/' || sRemainder[0] == '/' {
+		return true
+	}
+	return false
+}
+
+// cacheWatcher implements watch.Interface
+type cacheWatcher struct {
+	input  chan *watchCacheEvent
+	result chan watch.Event
+	// done is closed when the watcher is stopped. input is never closed, so
+	// dispatching an event to a watcher that was stopped concurrently is
+	// safe.
+	done     chan struct{}
+	stopOnce sync.Once
+	filter   filterWithAttrsFunc
+	// forget removes the watcher from the cacher.
+	forget func()
+	// copyObject returns a copy of an object of the cache that may be
+	// modified.
+	copyObject          func(runtime.Object) (runtime.Object, error)
+	versioner           storage.Versioner
+	allowWatchBookmarks bool
+}
+
+func newCacheWatcher(chanSize int, filter filterWithAttrsFunc, forget func(), copyObject func(runtime.Object) (runtime.Object, error), versioner storage.Versioner, allowWatchBookmarks bool) *cacheWatcher {
+	return &cacheWatcher{
+		input:               make(chan *watchCacheEvent, chanSize),
+		result:              make(chan watch.Event, chanSize),
+		done:                make(chan struct{}),
+		filter:              filter,
+		forget:              forget,
+		copyObject:          copyObject,
+		versioner:           versioner,
+		allowWatchBookmarks: allowWatchBookmarks,
+	}
+}
+
+// Implements watch.Interface.
+func (c *cacheWatcher) ResultChan() <-chan watch.Event {
+	return c.result
+}
+
+// Implements watch.Interface.
+func (c *cacheWatcher) Stop() {
+	c.forget()
+	c.stop()
+}
+
+func (c *cacheWatcher) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *cacheWatcher) nonblockingAdd(event *watchCacheEvent) bool {
+	select {
+	case c.input <- event:
+		return true
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// add sends event to the watcher, waiting at most until timer fires, or not
+// at all if timer is nil. A watcher that cannot keep up is stopped, its
+// client has to watch again.
+func (c *cacheWatcher) add(event *watchCacheEvent, timer *time.Timer) bool {
+	// Try to send the event immediately, without blocking.
+	if c.nonblockingAdd(event) {
+		return true
+	}
+
+	closeFunc := func() {
+		// This means that we couldn't send event to that watcher.
+		// Since we don't want to block on it infinitely,
+		// we simply terminate it.
+		klog.V(1).InfoS("Forcing watcher close due to unresponsiveness", "key", event.Key, "rev", event.ResourceVersion)
+		c.Stop()
+	}
+
+	if timer == nil {
+		closeFunc()
+		return false
+	}
+
+	// OK, block sending, but only until timer fires.
+	select {
+	case c.input <- event:
+		return true
+	case <-c.done:
+		return true
+	case <-timer.C:
+		closeFunc()
+		return false
+	}
+}
+
+func (c *cacheWatcher) convertToWatchEvent(event *watchCacheEvent) *watch.Event {
+	if event.Type == watch.Bookmark {
+		if !c.allowWatchBookmarks {
+			return nil
+		}
+		return &watch.Event{Type: watch.Bookmark, Object: event.Object}
+	}
+
+	curObjPasses := event.Type != watch.Deleted && c.filter(event.Key, event.ObjLabels, event.ObjFields)
+	oldObjPasses := false
+	if event.PrevObject != nil {
+		oldObjPasses = c.filter(event.Key, event.PrevObjLabels, event.PrevObjFields)
+	}
+	if !curObjPasses && !oldObjPasses {
+		// Watcher is not interested in that object.
+		return nil
+	}
+
+	switch {
+	case curObjPasses && !oldObjPasses:
+		return &watch.Event{Type: watch.Added, Object: event.Object}
+	case curObjPasses && oldObjPasses:
+		return &watch.Event{Type: watch.Modified, Object: event.Object}
+	case event.Type == watch.Deleted:
+		// the deleted object already carries the resource version of the
+		// delete
+		return &watch.Event{Type: watch.Deleted, Object: event.Object}
+	default:
+		// return a delete event with the previous object content, but with
+		// the event's resource version. The previous object is shared with
+		// the cache and the other watchers, so it is copied first.
+		oldObj, err := c.copyObject(event.PrevObject)
+		if err != nil {
+			klog.ErrorS(err, "Failed to copy object", "key", event.Key, "rev", event.ResourceVersion)
+			return &watch.Event{Type: watch.Error, Object: statusForError(err)}
+		}
+		if err := c.versioner.UpdateObject(oldObj, event.ResourceVersion); err != nil {
+			klog.ErrorS(err, "Failed to update resource version", "key", event.Key, "rev", event.ResourceVersion)
+		}
+		return &watch.Event{Type: watch.Deleted, Object: oldObj}
+	}
+}
+
+// sendWatchCacheEvent sends event to the result channel if the watcher is
+// interested in it. It returns false if the watcher has been stopped.
+func (c *cacheWatcher) sendWatchCacheEvent(ctx context.Context, event *watchCacheEvent) bool {
+	watchEvent := c.convertToWatchEvent(event)
+	if watchEvent == nil {
+		// Watcher is not interested in that object.
+		return true
+	}
+
+	// We need to ensure that if we put event X to the c.result, all
+	// previous events were already put into it before, no matter whether
+	// c.done is close or not.
+	// Thus we cannot simply select from c.done and c.result and this
+	// would give us non-determinism.
+	// At the same time, we don't want to block infinitely on putting
+	// to c.result, when c.done is already closed.
+	//
+	// This ensures that with c.done already close, we at most once go
+	// into the next select after this. With that, no matter which
+	// statement we choose there, we will deliver only consecutive
+	// events.
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.result <- *watchEvent:
+		return watchEvent.Type != watch.Error
+	case <-c.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *cacheWatcher) process(ctx context.Context, initEvents []*watchCacheEvent, resourceVersion uint64) {
+	defer close(c.result)
+	defer c.Stop()
+
+	for _, event := range initEvents {
+		if !c.sendWatchCacheEvent(ctx, event) {
+			return
+		}
+	}
+	if len(initEvents) > 0 {
+		resourceVersion = initEvents[len(initEvents)-1].ResourceVersion
+	}
+
+	for {
+		select {
+		case event := <-c.input:
+			// only send events newer than resourceVersion
+			if event.ResourceVersion > resourceVersion {
+				if !c.sendWatchCacheEvent(ctx, event) {
+					return
+				}
+				resourceVersion = event.ResourceVersion
+			}
+		case <-c.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// errWatcher implements watch.Interface to return a single error
+type errWatcher struct {
+	result chan watch.Event
+}
+
+func newErrWatcher(err error) *errWatcher {
+	// Create an error event
+	errEvent := watch.Event{Type: watch.Error, Object: statusForError(err)}
+
+	// Create a watcher with room for a single event, populate it, and close the channel
+	watcher := &errWatcher{result: make(chan watch.Event, 1)}
+	watcher.result <- errEvent
+	close(watcher.result)
+
+	return watcher
+}
+
+// Implements watch.Interface.
+func (c *errWatcher) ResultChan() <-chan watch.Event {
+	return c.result
+}
+
+// Implements watch.Interface.
+func (c *errWatcher) Stop() {
+	// no-op
+}
+
+func statusForError(err error) *meta.Status {
+	if _, ok := err.(apierrors.APIStatus); !ok {
+		err = apierrors.NewInternalError(err)
+	}
+	return &meta.Status{Status: err.(apierrors.APIStatus).Status()}
+}
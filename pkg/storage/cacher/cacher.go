@@ -0,0 +1,813 @@
+package cacher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// incomingBufSize is the size of the buffer of the events that wait to
+	// be dispatched to the watchers.
+	incomingBufSize = 100
+	// watcherBufSize is the size of the buffers of a single watcher.
+	watcherBufSize = 100
+
+	// dispatchTimeout is how long dispatching an event waits for the
+	// watchers whose buffers are full before they are closed.
+	dispatchTimeout = 100 * time.Millisecond
+
+	// bookmarkFrequency is how often watchers that allow bookmarks are sent
+	// the resource version the cache has progressed to.
+	bookmarkFrequency = time.Minute
+)
+
+// errStopped is returned by the requests that wait for a cacher that has
+// been stopped.
+var errStopped = errors.New("cacher is stopped")
+
+// Config contains the configuration for a given Cache.
+type Config struct {
+	// Maximum size of the history cached in memory.
+	CacheCapacity int
+
+	// An underlying storage.Interface.
+	Storage storage.Interface
+
+	// An underlying storage.Versioner.
+	Versioner storage.Versioner
+
+	// The Cache will be caching objects of a given Type and assumes that they
+	// are all stored under ResourcePrefix directory in the underlying database.
+	ResourcePrefix string
+
+	// KeyFunc is used to get a key in the underlying storage for a given object.
+	KeyFunc func(runtime.Object) (string, error)
+
+	// GetAttrsFunc is used to get object labels and fields.
+	GetAttrsFunc storage.AttrFunc
+
+	// IndexerFuncs index the cached objects by the index names returned by
+	// SelectionPredicate.MatcherIndex, i.e. storage.FieldIndex(field) for
+	// the IndexFields and storage.LabelIndex(label) for the IndexLabels of
+	// the predicates. Lists and watches whose predicate requires an exact
+	// value of an index only look at, and are only notified about, the
+	// objects with that value.
+	IndexerFuncs storage.IndexerFuncs
+
+	// NewFunc is a function that creates new empty object storing a object of type Type.
+	NewFunc func() runtime.Object
+
+	// NewListFunc is a function that creates new empty object storing a list of
+	// objects of type Type.
+	NewListFunc func() runtime.Object
+
+	// Codec is used to copy cached objects that have to be modified before
+	// being sent to a watcher.
+	Codec runtime.Codec
+}
+
+type watchersMap map[int]*cacheWatcher
+
+// indexedWatchers holds the watchers that are notified about all the events,
+// and the watchers that are only notified about the events of objects with a
+// given value of an index.
+type indexedWatchers struct {
+	allWatchers   watchersMap
+	valueWatchers map[storage.MatchValue]watchersMap
+}
+
+func (i *indexedWatchers) addWatcher(w *cacheWatcher, number int, value storage.MatchValue, supported bool) {
+	if !supported {
+		i.allWatchers[number] = w
+		return
+	}
+	if _, ok := i.valueWatchers[value]; !ok {
+		i.valueWatchers[value] = watchersMap{}
+	}
+	i.valueWatchers[value][number] = w
+}
+
+func (i *indexedWatchers) deleteWatcher(number int, value storage.MatchValue, supported bool) {
+	if !supported {
+		delete(i.allWatchers, number)
+		return
+	}
+	delete(i.valueWatchers[value], number)
+	if len(i.valueWatchers[value]) == 0 {
+		delete(i.valueWatchers, value)
+	}
+}
+
+func (i *indexedWatchers) terminateAll() {
+	for _, w := range i.allWatchers {
+		w.stop()
+	}
+	for _, watchers := range i.valueWatchers {
+		for _, w := range watchers {
+			w.stop()
+		}
+	}
+	i.allWatchers = watchersMap{}
+	i.valueWatchers = map[storage.MatchValue]watchersMap{}
+}
+
+// ready is closed while the cache is initialized and can serve requests.
+type ready struct {
+	lock sync.Mutex
+	ok   bool
+	c    chan struct{}
+}
+
+func newReady() *ready {
+	return &ready{c: make(chan struct{})}
+}
+
+// wait blocks until the cache is ready, ctx is done or stopCh is closed.
+func (r *ready) wait(ctx context.Context, stopCh <-chan struct{}) error {
+	r.lock.Lock()
+	c := r.c
+	r.lock.Unlock()
+	select {
+	case <-c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stopCh:
+		return errStopped
+	}
+}
+
+func (r *ready) check() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.ok
+}
+
+func (r *ready) set(ok bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.ok == ok {
+		return
+	}
+	r.ok = ok
+	if ok {
+		close(r.c)
+	} else {
+		r.c = make(chan struct{})
+	}
+}
+
+// Cacher is responsible for serving WATCH and LIST requests for a given
+// resource from its internal cache and updating its cache in the background
+// based on the underlying storage contents.
+// Cacher implements storage.Interface (although most of the calls are just
+// delegated to the underlying storage).
+type Cacher struct {
+	// incoming events that should be dispatched to watchers.
+	incoming chan *watchCacheEvent
+
+	sync.RWMutex
+
+	// Before accessing the cacher's cache, wait for the ready to be ok.
+	// This is necessary to prevent users from accessing structures that are
+	// uninitialized or are being repopulated right now.
+	// ready needs to be set to false when the cacher is paused or stopped.
+	// ready needs to be set to true when the cacher is ready to use after
+	// initialization.
+	ready *ready
+
+	// Underlying storage.Interface.
+	storage storage.Interface
+
+	// Expected type of objects in the underlying cache.
+	objectType reflect.Type
+
+	// "sliding window" of recent changes of objects and the current state.
+	watchCache *watchCache
+
+	// Versioner is used to handle resource versions.
+	versioner storage.Versioner
+
+	// newFunc is a function that creates new empty object storing a object of type Type.
+	newFunc func() runtime.Object
+	// newListFunc is a function that creates new empty list of objects of
+	// type Type.
+	newListFunc func() runtime.Object
+
+	codec          runtime.Codec
+	resourcePrefix string
+	indexerFuncs   storage.IndexerFuncs
+
+	watcherIdx int
+	watchers   indexedWatchers
+
+	// Handling graceful termination.
+	stopLock sync.RWMutex
+	stopped  bool
+	stopCh   chan struct{}
+	stopWg   sync.WaitGroup
+}
+
+// NewCacherFromConfig creates a new Cacher responsible for servicing WATCH and LIST requests from
+// its internal cache and updating its cache in the background based on the
+// given configuration.
+func NewCacherFromConfig(config Config) (*Cacher, error) {
+	if config.CacheCapacity <= 0 {
+		return nil, fmt.Errorf("cache capacity must be positive, got %d", config.CacheCapacity)
+	}
+	if config.GetAttrsFunc == nil {
+		config.GetAttrsFunc = storage.DefaultClusterScopedAttr
+	}
+
+	cacher := &Cacher{
+		incoming:       make(chan *watchCacheEvent, incomingBufSize),
+		ready:          newReady(),
+		storage:        config.Storage,
+		objectType:     reflect.TypeOf(config.NewFunc()),
+		versioner:      config.Versioner,
+		newFunc:        config.NewFunc,
+		newListFunc:    config.NewListFunc,
+		codec:          config.Codec,
+		resourcePrefix: config.ResourcePrefix,
+		indexerFuncs:   config.IndexerFuncs,
+		watchers: indexedWatchers{
+			allWatchers:   watchersMap{},
+			valueWatchers: map[storage.MatchValue]watchersMap{},
+		},
+		stopCh: make(chan struct{}),
+	}
+	cacher.watchCache = newWatchCache(
+		config.CacheCapacity, config.KeyFunc, cacher.processEvent, config.GetAttrsFunc, config.Versioner, config.IndexerFuncs)
+
+	cacher.stopWg.Add(2)
+	go func() {
+		defer cacher.stopWg.Done()
+		cacher.dispatchEvents()
+	}()
+	go func() {
+		defer cacher.stopWg.Done()
+		wait.Until(
+			func() {
+				if !cacher.isStopped() {
+					cacher.startCaching(cacher.stopCh)
+				}
+			}, time.Second, cacher.stopCh,
+		)
+	}()
+
+	return cacher, nil
+}
+
+func (c *Cacher) startCaching(stopChannel <-chan struct{}) {
+	// The 'usable' lock is always 'RLock'able when it is safe to use the cache.
+	// It is safe to use the cache after a successful list until a disconnection.
+	// We start with usable (write) locked. The below OnReplace function will
+	// unlock it after a successful list. The below defer will then re-lock
+	// it when this function exits (always due to disconnection), only if
+	// we actually got a successful list. This cycle will repeat as needed.
+	successfulList := false
+	c.watchCache.SetOnReplace(func() {
+		successfulList = true
+		c.ready.set(true)
+		klog.V(1).InfoS("Cacher initialized", "objectType", c.objectType)
+	})
+	defer func() {
+		if successfulList {
+			c.ready.set(false)
+		}
+	}()
+
+	c.terminateAllWatchers()
+	// Note that since onReplace may be not called due to errors, we explicitly
+	// need to retry it on errors under lock.
+	// Also note that startCaching is called in a loop, so there's no need
+	// to have another loop here.
+	if err := c.listAndWatch(stopChannel); err != nil {
+		klog.ErrorS(err, "Unexpected ListAndWatch error, reinitializing", "objectType", c.objectType)
+	}
+}
+
+// listAndWatch lists all the objects under the resource prefix to fill the
+// cache and then keeps it up to date with the events of the underlying
+// storage. It returns when stopCh is closed or the watch fails, after which
+// the cache has to be filled again.
+func (c *Cacher) listAndWatch(stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	list := c.newListFunc()
+	if err := c.storage.List(ctx, c.resourcePrefix, storage.ListOptions{Predicate: storage.Everything}, list); err != nil {
+		return fmt.Errorf("failed to list %v: %v", c.objectType, err)
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	resourceVersion := listMeta.GetResourceVersion()
+	if err := c.watchCache.Replace(items, resourceVersion); err != nil {
+		return fmt.Errorf("failed to replace the cache of %v: %v", c.objectType, err)
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+		w, err := c.storage.WatchList(ctx, c.resourcePrefix, storage.ListOptions{
+			ResourceVersion: resourceVersion,
+			Predicate:       storage.Everything,
+			ProgressNotify:  true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to watch %v: %v", c.objectType, err)
+		}
+		// a watch that ends without an error is resumed from the last
+		// resource version that was seen
+		err = c.watchHandler(w, &resourceVersion, stopCh)
+		w.Stop()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watchHandler applies the events of w to the cache until w ends or stopCh is
+// closed, and keeps resourceVersion at the version of the last event.
+func (c *Cacher) watchHandler(w watch.Interface, resourceVersion *string, stopCh <-chan struct{}) error {
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				if status, ok := event.Object.(*meta.Status); ok {
+					return &apierrors.StatusError{ErrStatus: status.Status}
+				}
+				return fmt.Errorf("watch of %v ended with an error event: %#v", c.objectType, event.Object)
+			}
+			accessor, err := meta.Accessor(event.Object)
+			if err != nil {
+				return err
+			}
+			newResourceVersion := accessor.GetResourceVersion()
+			switch event.Type {
+			case watch.Added:
+				err = c.watchCache.Add(event.Object)
+			case watch.Modified:
+				err = c.watchCache.Update(event.Object)
+			case watch.Deleted:
+				err = c.watchCache.Delete(event.Object)
+			case watch.Bookmark:
+				c.watchCache.UpdateResourceVersion(newResourceVersion)
+			default:
+				err = fmt.Errorf("unexpected watch event type %q", event.Type)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to apply %s event of %v: %v", event.Type, c.objectType, err)
+			}
+			*resourceVersion = newResourceVersion
+		}
+	}
+}
+
+// Versioner implements storage.Interface.
+func (c *Cacher) Versioner() storage.Versioner {
+	return c.storage.Versioner()
+}
+
+// Create implements storage.Interface.
+func (c *Cacher) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	return c.storage.Create(ctx, key, obj, out, ttl)
+}
+
+// Delete implements storage.Interface.
+func (c *Cacher) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
+	return c.storage.Delete(ctx, key, out, preconditions, validateDeletion, cachedExistingObject)
+}
+
+// Watch implements storage.Interface.
+func (c *Cacher) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	pred := opts.Predicate
+	watchRV, err := c.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.ready.wait(ctx, c.stopCh); err != nil {
+		return nil, err
+	}
+
+	value, supported := c.indexedValue(pred)
+
+	// We explicitly use thread unsafe version and do locking ourself to ensure that
+	// no new events will be processed in the meantime. The watchCache will be unlocked
+	// on return from this function.
+	// Note that we cannot do it under Cacher lock, to avoid a deadlock, since the
+	// underlying watchCache is calling processEvent under its lock.
+	c.watchCache.RLock()
+	defer c.watchCache.RUnlock()
+	initEvents, err := c.watchCache.GetAllEventsSinceThreadUnsafe(watchRV)
+	if err != nil {
+		// To match the uncached watch implementation, once we have passed authn/authz/admission,
+		// and successfully parsed a resource version, other errors must fail with a watch event of type ERROR,
+		// rather than a directly returned error.
+		return newErrWatcher(err), nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	identifier := c.watcherIdx
+	forget := func() {
+		c.Lock()
+		defer c.Unlock()
+		c.watchers.deleteWatcher(identifier, value, supported)
+	}
+	watcher := newCacheWatcher(watcherBufSize, filterWithAttrsFunction(key, pred), forget, c.copyObject, c.versioner, pred.AllowWatchBookmarks)
+	c.watchers.addWatcher(watcher, identifier, value, supported)
+	c.watcherIdx++
+
+	go watcher.process(ctx, initEvents, watchRV)
+	return watcher, nil
+}
+
+// WatchList implements storage.Interface.
+func (c *Cacher) WatchList(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return c.Watch(ctx, key, opts)
+}
+
+// Get implements storage.Interface.
+func (c *Cacher) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) error {
+	if opts.ResourceVersion == "" {
+		// If resourceVersion is not specified, serve it from underlying
+		// storage (for backward compatibility).
+		return c.storage.Get(ctx, key, opts, objPtr)
+	}
+
+	// If resourceVersion is specified, serve it from cache.
+	// It's guaranteed that the returned value is at least that
+	// fresh as the given resourceVersion.
+	getRV, err := c.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return err
+	}
+
+	if getRV == 0 && !c.ready.check() {
+		// If Cacher is not yet initialized and we don't require any specific
+		// minimal resource version, simply forward the request to storage.
+		return c.storage.Get(ctx, key, opts, objPtr)
+	}
+
+	if err := c.ready.wait(ctx, c.stopCh); err != nil {
+		return err
+	}
+
+	objVal, err := conversion.EnforcePtr(objPtr)
+	if err != nil {
+		return err
+	}
+
+	elem, exists, readResourceVersion, err := c.watchCache.WaitUntilFreshAndGet(getRV, key)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		// the object is shared with the cache, only its top level fields
+		// are copied
+		objVal.Set(reflect.ValueOf(elem.Object).Elem())
+	} else {
+		objVal.Set(reflect.Zero(objVal.Type()))
+		if opts.IgnoreNotFound {
+			return nil
+		}
+		return storage.NewKeyNotFoundError(key, int64(readResourceVersion))
+	}
+	return nil
+}
+
+func shouldDelegateList(opts storage.ListOptions) bool {
+	resourceVersion := opts.ResourceVersion
+	pred := opts.Predicate
+	hasContinuation := len(pred.Continue) > 0
+	hasLimit := pred.Limit > 0 && resourceVersion != "0"
+	return resourceVersion == "" || hasContinuation || hasLimit || opts.ResourceVersionMatch == meta.ResourceVersionMatchExact
+}
+
+// GetToList implements storage.Interface.
+func (c *Cacher) GetToList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	if shouldDelegateList(opts) {
+		return c.storage.GetToList(ctx, key, opts, listObj)
+	}
+
+	// If resourceVersion is specified, serve it from cache.
+	// It's guaranteed that the returned value is at least that
+	// fresh as the given resourceVersion.
+	listRV, err := c.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return err
+	}
+
+	if listRV == 0 && !c.ready.check() {
+		// If Cacher is not yet initialized and we don't require any specific
+		// minimal resource version, simply forward the request to storage.
+		return c.storage.GetToList(ctx, key, opts, listObj)
+	}
+
+	if err := c.ready.wait(ctx, c.stopCh); err != nil {
+		return err
+	}
+
+	listVal, err := c.listValue(listObj)
+	if err != nil {
+		return err
+	}
+	elem, exists, readResourceVersion, err := c.watchCache.WaitUntilFreshAndGet(listRV, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		filter := filterWithAttrsFunction(key, opts.Predicate)
+		if filter(elem.Key, elem.Labels, elem.Fields) {
+			listVal.Set(reflect.Append(listVal, reflect.ValueOf(elem.Object).Elem()))
+		}
+	}
+	return c.versioner.UpdateList(listObj, readResourceVersion, "", nil)
+}
+
+// List implements storage.Interface.
+func (c *Cacher) List(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	if shouldDelegateList(opts) {
+		return c.storage.List(ctx, key, opts, listObj)
+	}
+
+	// If resourceVersion is specified, serve it from cache.
+	// It's guaranteed that the returned value is at least that
+	// fresh as the given resourceVersion.
+	listRV, err := c.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return err
+	}
+
+	if listRV == 0 && !c.ready.check() {
+		// If Cacher is not yet initialized and we don't require any specific
+		// minimal resource version, simply forward the request to storage.
+		return c.storage.List(ctx, key, opts, listObj)
+	}
+
+	if err := c.ready.wait(ctx, c.stopCh); err != nil {
+		return err
+	}
+
+	listVal, err := c.listValue(listObj)
+	if err != nil {
+		return err
+	}
+	pred := opts.Predicate
+	elems, readResourceVersion, err := c.watchCache.WaitUntilFreshAndList(listRV, pred.MatcherIndex())
+	if err != nil {
+		return err
+	}
+	filter := filterWithAttrsFunction(key, pred)
+	for _, elem := range elems {
+		if filter(elem.Key, elem.Labels, elem.Fields) {
+			listVal.Set(reflect.Append(listVal, reflect.ValueOf(elem.Object).Elem()))
+		}
+	}
+	return c.versioner.UpdateList(listObj, readResourceVersion, "", nil)
+}
+
+// listValue returns the items slice of listObj.
+func (c *Cacher) listValue(listObj runtime.Object) (reflect.Value, error) {
+	listPtr, err := meta.GetItemsPtr(listObj)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	listVal, err := conversion.EnforcePtr(listPtr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if listVal.Kind() != reflect.Slice {
+		return reflect.Value{}, fmt.Errorf("need a pointer to slice, got %v", listVal.Kind())
+	}
+	return listVal, nil
+}
+
+// GuaranteedUpdate implements storage.Interface.
+func (c *Cacher) GuaranteedUpdate(
+	ctx context.Context, key string, ptrToType runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	// the cached objects are shared, they are never handed to the underlying
+	// storage which may modify the object it starts from
+	return c.storage.GuaranteedUpdate(ctx, key, ptrToType, ignoreNotFound, preconditions, tryUpdate, cachedExistingObject)
+}
+
+// Txn implements storage.Interface.
+func (c *Cacher) Txn(ctx context.Context) storage.Txn {
+	return c.storage.Txn(ctx)
+}
+
+// Count implements storage.Interface.
+func (c *Cacher) Count(pathPrefix string) (int64, error) {
+	return c.storage.Count(pathPrefix)
+}
+
+// indexedValue returns the first index value pred requires an exact match
+// on that the cache has an indexer for.
+func (c *Cacher) indexedValue(pred storage.SelectionPredicate) (storage.MatchValue, bool) {
+	for _, value := range pred.MatcherIndex() {
+		if _, ok := c.indexerFuncs[value.IndexName]; ok {
+			return value, true
+		}
+	}
+	return storage.MatchValue{}, false
+}
+
+// processEvent is the event handler of the watch cache, it queues event to
+// be dispatched to the watchers.
+func (c *Cacher) processEvent(event *watchCacheEvent) {
+	select {
+	case c.incoming <- event:
+	case <-c.stopCh:
+	}
+}
+
+func (c *Cacher) dispatchEvents() {
+	bookmarkTimer := time.NewTicker(bookmarkFrequency)
+	defer bookmarkTimer.Stop()
+
+	lastProcessedResourceVersion := uint64(0)
+	for {
+		select {
+		case event, ok := <-c.incoming:
+			if !ok {
+				return
+			}
+			// Don't dispatch bookmarks coming from the storage layer.
+			// They can be very frequent (even to the level of subseconds)
+			// to allow efficient watch resumption on kube-apiserver restarts,
+			// and propagating them down may overload the whole system.
+			if event.Type != watch.Bookmark {
+				c.dispatchEvent(event)
+			}
+			lastProcessedResourceVersion = event.ResourceVersion
+		case <-bookmarkTimer.C:
+			if lastProcessedResourceVersion == 0 {
+				continue
+			}
+			bookmarkEvent := &watchCacheEvent{
+				Type:            watch.Bookmark,
+				Object:          c.newFunc(),
+				ResourceVersion: lastProcessedResourceVersion,
+			}
+			if err := c.versioner.UpdateObject(bookmarkEvent.Object, bookmarkEvent.ResourceVersion); err != nil {
+				klog.ErrorS(err, "Failed to set resourceVersion on bookmark event", "rev", bookmarkEvent.ResourceVersion, "objectType", c.objectType)
+				continue
+			}
+			c.dispatchBookmark(bookmarkEvent)
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+func (c *Cacher) dispatchEvent(event *watchCacheEvent) {
+	var blocked []*cacheWatcher
+	for _, watcher := range c.watchersFor(event) {
+		if !watcher.nonblockingAdd(event) {
+			blocked = append(blocked, watcher)
+		}
+	}
+	if len(blocked) == 0 {
+		return
+	}
+
+	// dispatchTimeout is shared by all the watchers the event could not be
+	// added to without blocking. Once it is used up, the remaining watchers
+	// that cannot take the event are closed right away.
+	timer := time.NewTimer(dispatchTimeout)
+	defer timer.Stop()
+	for _, watcher := range blocked {
+		if !watcher.add(event, timer) {
+			// fired, the remaining watchers are not waited for
+			timer = nil
+		}
+	}
+}
+
+func (c *Cacher) dispatchBookmark(event *watchCacheEvent) {
+	c.RLock()
+	defer c.RUnlock()
+	for _, watcher := range c.watchers.allWatchers {
+		if watcher.allowWatchBookmarks {
+			watcher.nonblockingAdd(event)
+		}
+	}
+	for _, watchers := range c.watchers.valueWatchers {
+		for _, watcher := range watchers {
+			if watcher.allowWatchBookmarks {
+				watcher.nonblockingAdd(event)
+			}
+		}
+	}
+}
+
+// watchersFor returns the watchers that have to be notified about event: the
+// ones that are not indexed, and the indexed ones interested in the value of
+// the index of either the current or the previous version of the object.
+func (c *Cacher) watchersFor(event *watchCacheEvent) []*cacheWatcher {
+	c.RLock()
+	defer c.RUnlock()
+
+	result := make([]*cacheWatcher, 0, len(c.watchers.allWatchers))
+	for _, watcher := range c.watchers.allWatchers {
+		result = append(result, watcher)
+	}
+	if len(c.watchers.valueWatchers) == 0 {
+		return result
+	}
+	for name, indexFunc := range c.indexerFuncs {
+		var values []string
+		if event.Object != nil {
+			values = append(values, indexFunc(event.Object))
+		}
+		if event.PrevObject != nil {
+			if value := indexFunc(event.PrevObject); len(values) == 0 || value != values[0] {
+				values = append(values, value)
+			}
+		}
+		for _, value := range values {
+			for _, watcher := range c.watchers.valueWatchers[storage.MatchValue{IndexName: name, Value: value}] {
+				result = append(result, watcher)
+			}
+		}
+	}
+	return result
+}
+
+// copyObject returns a copy of obj that can be modified without affecting
+// the cache.
+func (c *Cacher) copyObject(obj runtime.Object) (runtime.Object, error) {
+	data, err := runtime.Encode(c.codec, obj)
+	if err != nil {
+		return nil, err
+	}
+	return c.codec.Decode(data, c.newFunc())
+}
+
+func (c *Cacher) terminateAllWatchers() {
+	c.Lock()
+	defer c.Unlock()
+	c.watchers.terminateAll()
+}
+
+func (c *Cacher) isStopped() bool {
+	c.stopLock.RLock()
+	defer c.stopLock.RUnlock()
+	return c.stopped
+}
+
+// Stop implements the graceful termination.
+func (c *Cacher) Stop() {
+	c.stopLock.Lock()
+	if c.stopped {
+		// avoid stopping twice, the destroy func of a storage may be
+		// called more than once
+		c.stopLock.Unlock()
+		return
+	}
+	c.stopped = true
+	c.stopLock.Unlock()
+	close(c.stopCh)
+	c.stopWg.Wait()
+	c.terminateAllWatchers()
+}
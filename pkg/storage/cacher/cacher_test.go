@@ -0,0 +1,363 @@
+package cacher
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// countingStorage counts the reads that reach the underlying storage.
+type countingStorage struct {
+	storage.Interface
+
+	lock  sync.Mutex
+	gets  int
+	lists int
+}
+
+func (s *countingStorage) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) error {
+	s.lock.Lock()
+	s.gets++
+	s.lock.Unlock()
+	return s.Interface.Get(ctx, key, opts, objPtr)
+}
+
+func (s *countingStorage) List(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	s.lock.Lock()
+	s.lists++
+	s.lock.Unlock()
+	return s.Interface.List(ctx, key, opts, listObj)
+}
+
+func (s *countingStorage) reads() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.gets, s.lists
+}
+
+func testKeyFunc(obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	return "/users/" + accessor.GetName(), nil
+}
+
+var testIndexers = storage.IndexerFuncs{
+	storage.FieldIndex("metadata.name"): func(obj runtime.Object) string {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return ""
+		}
+		return accessor.GetName()
+	},
+}
+
+// newTestCacher returns a ready cacher with a history window of capacity
+// events in front of an in-memory storage.
+func newTestCacher(t *testing.T, capacity int) (*Cacher, *countingStorage) {
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	newFunc := func() runtime.Object { return &model.User{} }
+	s := &countingStorage{Interface: kvstore.New(memory.NewBackend(0), codec, newFunc, "/registry", value.IdentityTransformer, true)}
+	c, err := NewCacherFromConfig(Config{
+		CacheCapacity:  capacity,
+		Storage:        s,
+		Versioner:      storage.APIObjectVersioner{},
+		ResourcePrefix: "/users",
+		KeyFunc:        testKeyFunc,
+		IndexerFuncs:   testIndexers,
+		NewFunc:        newFunc,
+		NewListFunc:    func() runtime.Object { return &model.UserList{} },
+		Codec:          codec,
+	})
+	if err != nil {
+		t.Fatalf("NewCacherFromConfig failed: %v", err)
+	}
+	t.Cleanup(c.Stop)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return c.ready.check(), nil
+	}); err != nil {
+		t.Fatalf("cacher did not become ready: %v", err)
+	}
+	return c, s
+}
+
+// testCreate creates a user named name and waits for the cacher to observe
+// it.
+func testCreate(t *testing.T, c *Cacher, name string) *model.User {
+	out := &model.User{}
+	obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: name}}
+	if err := c.Create(context.TODO(), "/users/"+name, obj, out, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	testWaitFresh(t, c, out.ResourceVersion)
+	return out
+}
+
+// testUpdate sets the roles of the user named name and waits for the cacher
+// to observe it.
+func testUpdate(t *testing.T, c *Cacher, name string, roles ...string) *model.User {
+	out := &model.User{}
+	err := c.GuaranteedUpdate(context.TODO(), "/users/"+name, out, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			user := obj.(*model.User)
+			user.Roles = roles
+			return user, nil
+		}), nil)
+	if err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	testWaitFresh(t, c, out.ResourceVersion)
+	return out
+}
+
+func testWaitFresh(t *testing.T, c *Cacher, rv string) {
+	t.Helper()
+	list := &model.UserList{}
+	if err := c.List(context.TODO(), "/users", storage.ListOptions{ResourceVersion: rv, Predicate: storage.Everything}, list); err != nil {
+		t.Fatalf("List at resource version %s failed: %v", rv, err)
+	}
+}
+
+func namePredicate(name string) storage.SelectionPredicate {
+	return storage.SelectionPredicate{
+		Label:       labels.Everything(),
+		Field:       fields.OneTermEqualSelector("metadata.name", name),
+		GetAttrs:    storage.DefaultClusterScopedAttr,
+		IndexFields: []string{"metadata.name"},
+	}
+}
+
+func TestReadsServedFromCache(t *testing.T) {
+	c, s := newTestCacher(t, 10)
+	testCreate(t, c, "alice")
+	testCreate(t, c, "bob")
+	gets, lists := s.reads()
+
+	list := &model.UserList{}
+	if err := c.List(context.TODO(), "/users", storage.ListOptions{ResourceVersion: "0", Predicate: storage.Everything}, list); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("expected 2 users, got %d", len(list.Items))
+	}
+	list = &model.UserList{}
+	if err := c.List(context.TODO(), "/users", storage.ListOptions{ResourceVersion: "0", Predicate: namePredicate("bob")}, list); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "bob" {
+		t.Errorf("expected only bob, got %+v", list.Items)
+	}
+	user := &model.User{}
+	if err := c.Get(context.TODO(), "/users/alice", storage.GetOptions{ResourceVersion: "0"}, user); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if user.Name != "alice" {
+		t.Errorf("expected alice, got %+v", user)
+	}
+	if err := c.Get(context.TODO(), "/users/carol", storage.GetOptions{ResourceVersion: "0"}, &model.User{}); !storage.IsNotFound(err) {
+		t.Errorf("expected carol not to be found, got %v", err)
+	}
+	if g, l := s.reads(); g != gets || l != lists {
+		t.Errorf("expected the reads at resource version 0 to be served from the cache, storage got %d gets and %d lists", g-gets, l-lists)
+	}
+
+	// reads without a resource version are delegated to the storage
+	if err := c.Get(context.TODO(), "/users/alice", storage.GetOptions{}, &model.User{}); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := c.List(context.TODO(), "/users", storage.ListOptions{Predicate: storage.Everything}, &model.UserList{}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if g, l := s.reads(); g != gets+1 || l != lists+1 {
+		t.Errorf("expected the reads without a resource version to be delegated, storage got %d gets and %d lists", g-gets, l-lists)
+	}
+}
+
+func TestIndexedWatchers(t *testing.T) {
+	c, _ := newTestCacher(t, 10)
+	alice := testCreate(t, c, "alice")
+
+	watchAlice, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: alice.ResourceVersion, Predicate: namePredicate("alice")})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer watchAlice.Stop()
+	watchBob, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: alice.ResourceVersion, Predicate: namePredicate("bob")})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer watchBob.Stop()
+	watchAll, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: alice.ResourceVersion, Predicate: storage.Everything})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer watchAll.Stop()
+
+	c.RLock()
+	indexed, all := len(c.watchers.valueWatchers), len(c.watchers.allWatchers)
+	c.RUnlock()
+	if indexed != 2 || all != 1 {
+		t.Fatalf("expected 2 indexed values and 1 unindexed watcher, got %d and %d", indexed, all)
+	}
+	aliceEvent := &watchCacheEvent{Object: alice}
+	if got := c.watchersFor(aliceEvent); len(got) != 2 {
+		t.Errorf("expected an event of alice to be dispatched to 2 watchers, got %d", len(got))
+	}
+
+	testUpdate(t, c, "alice", "admin")
+	testCreate(t, c, "bob")
+	testUpdate(t, c, "bob", "dev")
+
+	expectEvent(t, watchAlice, watch.Modified, "alice")
+	expectNoEvent(t, watchAlice)
+	expectEvent(t, watchBob, watch.Added, "bob")
+	expectEvent(t, watchBob, watch.Modified, "bob")
+	expectNoEvent(t, watchBob)
+	expectEvent(t, watchAll, watch.Modified, "alice")
+	expectEvent(t, watchAll, watch.Added, "bob")
+	expectEvent(t, watchAll, watch.Modified, "bob")
+
+	// a stopped watcher is removed from its index
+	watchBob.Stop()
+	c.RLock()
+	_, ok := c.watchers.valueWatchers[storage.MatchValue{IndexName: storage.FieldIndex("metadata.name"), Value: "bob"}]
+	c.RUnlock()
+	if ok {
+		t.Errorf("expected the stopped watcher to be removed from the index")
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	c, _ := newTestCacher(t, 10)
+	alice := testCreate(t, c, "alice")
+
+	pred := storage.Everything
+	pred.AllowWatchBookmarks = true
+	withBookmarks, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: alice.ResourceVersion, Predicate: pred})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer withBookmarks.Stop()
+	pred = namePredicate("bob")
+	pred.AllowWatchBookmarks = true
+	indexedWithBookmarks, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: alice.ResourceVersion, Predicate: pred})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer indexedWithBookmarks.Stop()
+	withoutBookmarks, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: alice.ResourceVersion, Predicate: storage.Everything})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer withoutBookmarks.Stop()
+
+	// a progress notification of the storage moves the cache forward, but is
+	// not sent to the watchers
+	rv, err := strconv.ParseUint(alice.ResourceVersion, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.watchCache.UpdateResourceVersion(strconv.FormatUint(rv+1, 10))
+	testWaitFresh(t, c, strconv.FormatUint(rv+1, 10))
+	expectNoEvent(t, withBookmarks)
+
+	// the bookmark timer fires every bookmarkFrequency, the bookmark it
+	// creates is dispatched directly
+	bookmark := &watchCacheEvent{Type: watch.Bookmark, Object: &model.User{}, ResourceVersion: rv + 1}
+	if err := c.versioner.UpdateObject(bookmark.Object, bookmark.ResourceVersion); err != nil {
+		t.Fatal(err)
+	}
+	c.dispatchBookmark(bookmark)
+	for _, w := range []watch.Interface{withBookmarks, indexedWithBookmarks} {
+		event := expectEvent(t, w, watch.Bookmark, "")
+		if got := event.Object.(*model.User).ResourceVersion; got != strconv.FormatUint(rv+1, 10) {
+			t.Errorf("expected a bookmark at resource version %d, got %s", rv+1, got)
+		}
+	}
+	expectNoEvent(t, withoutBookmarks)
+}
+
+func TestWatchTooOld(t *testing.T) {
+	c, _ := newTestCacher(t, 2)
+	listed := testCreate(t, c, "alice")
+	first := testUpdate(t, c, "alice", "dev")
+	testUpdate(t, c, "alice", "ops")
+	testUpdate(t, c, "alice", "admin")
+
+	// the window holds the last two updates, a watch can start right before
+	// the oldest of them
+	w, err := c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: first.ResourceVersion, Predicate: storage.Everything})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+	expectEvent(t, w, watch.Modified, "alice")
+	if event := expectEvent(t, w, watch.Modified, "alice"); event.Object.(*model.User).Roles[0] != "admin" {
+		t.Errorf("expected the last update, got %+v", event.Object)
+	}
+
+	w, err = c.Watch(context.TODO(), "/users", storage.ListOptions{ResourceVersion: listed.ResourceVersion, Predicate: storage.Everything})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+	event := expectEvent(t, w, watch.Error, "")
+	status, ok := event.Object.(*meta.Status)
+	if !ok {
+		t.Fatalf("expected a status, got %+v", event.Object)
+	}
+	if err := apierrors.FromObject(&status.Status); !apierrors.IsResourceExpired(err) || !strings.Contains(err.Error(), "too old") {
+		t.Errorf("expected a watch before the window to be too old, got %v", err)
+	}
+}
+
+func expectEvent(t *testing.T, w watch.Interface, eventType watch.EventType, name string) watch.Event {
+	t.Helper()
+	select {
+	case event, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatalf("expected a %s event, the watch was closed", eventType)
+		}
+		if event.Type != eventType {
+			t.Fatalf("expected a %s event, got %s: %+v", eventType, event.Type, event.Object)
+		}
+		if len(name) > 0 {
+			if user, ok := event.Object.(*model.User); !ok || user.Name != name {
+				t.Fatalf("expected a %s event of %s, got %+v", eventType, name, event.Object)
+			}
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a %s event, got none", eventType)
+	}
+	return watch.Event{}
+}
+
+func expectNoEvent(t *testing.T, w watch.Interface) {
+	t.Helper()
+	select {
+	case event, ok := <-w.ResultChan():
+		if ok {
+			t.Fatalf("expected no event, got %s: %+v", event.Type, event.Object)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,137 @@
+package cacher
+
+import (
+	"sort"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// storeElement is an object kept in the watch cache together with its key
+// and the attributes selectors are matched against.
+type storeElement struct {
+	Key    string
+	Object runtime.Object
+	Labels labels.Set
+	Fields fields.Set
+}
+
+// indexedStore keeps the current storeElements by key and maintains an index
+// for every indexer. It is not thread-safe, the watch cache guards it with
+// its own lock.
+type indexedStore struct {
+	items    map[string]*storeElement
+	indexers storage.IndexerFuncs
+	// indices maps an index name to the keys of the elements for each
+	// value of the index.
+	indices map[string]map[string]sets.String
+}
+
+func newIndexedStore(indexers storage.IndexerFuncs) *indexedStore {
+	s := &indexedStore{
+		items:    map[string]*storeElement{},
+		indexers: indexers,
+		indices:  map[string]map[string]sets.String{},
+	}
+	for name := range indexers {
+		s.indices[name] = map[string]sets.String{}
+	}
+	return s
+}
+
+// Get returns the element with key.
+func (s *indexedStore) Get(key string) (*storeElement, bool) {
+	elem, ok := s.items[key]
+	return elem, ok
+}
+
+// Add adds elem or replaces the element with the same key.
+func (s *indexedStore) Add(elem *storeElement) {
+	if old, ok := s.items[elem.Key]; ok {
+		s.deleteFromIndices(old)
+	}
+	s.items[elem.Key] = elem
+	s.addToIndices(elem)
+}
+
+// Delete removes the element with the key of elem.
+func (s *indexedStore) Delete(elem *storeElement) {
+	old, ok := s.items[elem.Key]
+	if !ok {
+		return
+	}
+	s.deleteFromIndices(old)
+	delete(s.items, elem.Key)
+}
+
+// Replace replaces the contents of the store with elems.
+func (s *indexedStore) Replace(elems []*storeElement) {
+	s.items = make(map[string]*storeElement, len(elems))
+	for name := range s.indexers {
+		s.indices[name] = map[string]sets.String{}
+	}
+	for _, elem := range elems {
+		s.Add(elem)
+	}
+}
+
+// List returns all the elements ordered by key.
+func (s *indexedStore) List() []*storeElement {
+	result := make([]*storeElement, 0, len(s.items))
+	for _, elem := range s.items {
+		result = append(result, elem)
+	}
+	sortElements(result)
+	return result
+}
+
+// ByIndex returns the elements for which the indexer indexName returns
+// value, ordered by key. It returns false if there is no such indexer.
+func (s *indexedStore) ByIndex(indexName, value string) ([]*storeElement, bool) {
+	index, ok := s.indices[indexName]
+	if !ok {
+		return nil, false
+	}
+	keys := index[value]
+	result := make([]*storeElement, 0, len(keys))
+	for key := range keys {
+		result = append(result, s.items[key])
+	}
+	sortElements(result)
+	return result, true
+}
+
+func (s *indexedStore) addToIndices(elem *storeElement) {
+	for name, indexFunc := range s.indexers {
+		value := indexFunc(elem.Object)
+		keys, ok := s.indices[name][value]
+		if !ok {
+			keys = sets.NewString()
+			s.indices[name][value] = keys
+		}
+		keys.Insert(elem.Key)
+	}
+}
+
+func (s *indexedStore) deleteFromIndices(elem *storeElement) {
+	for name, indexFunc := range s.indexers {
+		value := indexFunc(elem.Object)
+		keys, ok := s.indices[name][value]
+		if !ok {
+			continue
+		}
+		keys.Delete(elem.Key)
+		if keys.Len() == 0 {
+			delete(s.indices[name], value)
+		}
+	}
+}
+
+// sortElements sorts elems by key, the order lists are served in by the
+// storage backends.
+func sortElements(elems []*storeElement) {
+	sort.Slice(elems, func(i, j int) bool { return elems[i].Key < elems[j].Key })
+}
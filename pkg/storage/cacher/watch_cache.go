@@ -0,0 +1,408 @@
+package cacher
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/watch"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// blockTimeout determines how long we're willing to block the request
+	// to wait for a given resource version to be propagated to cache,
+	// before terminating request and returning Timeout error with retry
+	// after suggestion.
+	blockTimeout = 3 * time.Second
+
+	// resourceVersionTooHighRetrySeconds is the seconds before a operation should be retried by the client
+	// after receiving a 'too high resource version' error.
+	resourceVersionTooHighRetrySeconds = 1
+)
+
+// watchCacheEvent is a single "watch event" that is send to users of
+// watchCache. Additionally to a typical "watch.Event" it contains
+// the previous value of the object to enable proper filtering in the
+// upper layers.
+type watchCacheEvent struct {
+	Type            watch.EventType
+	Object          runtime.Object
+	ObjLabels       labels.Set
+	ObjFields       fields.Set
+	PrevObject      runtime.Object
+	PrevObjLabels   labels.Set
+	PrevObjFields   fields.Set
+	Key             string
+	ResourceVersion uint64
+}
+
+// watchCache implements a Store interface.
+// However, it depends on the elements implementing runtime.Object interface.
+//
+// watchCache is a "sliding window" (with a limited capacity) of objects
+// observed from a watch.
+type watchCache struct {
+	sync.RWMutex
+
+	// Condition on which lists are waiting for the fresh enough
+	// resource version.
+	cond *sync.Cond
+
+	// Maximum size of history window.
+	capacity int
+
+	// keyFunc is used to get a key in the underlying storage for a given object.
+	keyFunc func(runtime.Object) (string, error)
+
+	// getAttrsFunc is used to get labels and fields of an object.
+	getAttrsFunc storage.AttrFunc
+
+	// cache is used a cyclic buffer - its first element (with the smallest
+	// resourceVersion) is defined by startIndex, its last element is defined
+	// by endIndex (if cache is full it will be startIndex + capacity).
+	// Both startIndex and endIndex can be greater than buffer capacity -
+	// you should always apply modulo capacity to get an index in cache array.
+	cache      []*watchCacheEvent
+	startIndex int
+	endIndex   int
+
+	// store will effectively support LIST operation from the "end of cache
+	// history" i.e. from the moment just after the newest cached watched event.
+	// It is necessary to effectively allow clients to start watching at now.
+	store *indexedStore
+
+	// ResourceVersion up to which the watchCache is propagated.
+	resourceVersion uint64
+
+	// ResourceVersion of the last list result (populated via Replace() method).
+	listResourceVersion uint64
+
+	// This handler is run at the end of every successful Replace() method.
+	onReplace func()
+
+	// This handler is run at the end of every Add/Update/Delete method
+	// and additionally gets the previous value of the object.
+	eventHandler func(*watchCacheEvent)
+
+	// An underlying storage.Versioner.
+	versioner storage.Versioner
+}
+
+func newWatchCache(
+	capacity int,
+	keyFunc func(runtime.Object) (string, error),
+	eventHandler func(*watchCacheEvent),
+	getAttrsFunc storage.AttrFunc,
+	versioner storage.Versioner,
+	indexers storage.IndexerFuncs) *watchCache {
+	wc := &watchCache{
+		capacity:            capacity,
+		keyFunc:             keyFunc,
+		getAttrsFunc:        getAttrsFunc,
+		cache:               make([]*watchCacheEvent, capacity),
+		startIndex:          0,
+		endIndex:            0,
+		store:               newIndexedStore(indexers),
+		resourceVersion:     0,
+		listResourceVersion: 0,
+		eventHandler:        eventHandler,
+		versioner:           versioner,
+	}
+	wc.cond = sync.NewCond(wc.RLocker())
+	return wc
+}
+
+// Add takes runtime.Object as an argument.
+func (w *watchCache) Add(obj runtime.Object) error {
+	return w.processEvent(watch.Event{Type: watch.Added, Object: obj}, func(elem *storeElement) {
+		w.store.Add(elem)
+	})
+}
+
+// Update takes runtime.Object as an argument.
+func (w *watchCache) Update(obj runtime.Object) error {
+	return w.processEvent(watch.Event{Type: watch.Modified, Object: obj}, func(elem *storeElement) {
+		w.store.Add(elem)
+	})
+}
+
+// Delete takes runtime.Object as an argument.
+func (w *watchCache) Delete(obj runtime.Object) error {
+	return w.processEvent(watch.Event{Type: watch.Deleted, Object: obj}, func(elem *storeElement) {
+		w.store.Delete(elem)
+	})
+}
+
+// processEvent is safe as long as there is at most one call to it in flight
+// at any point in time.
+func (w *watchCache) processEvent(event watch.Event, updateFunc func(*storeElement)) error {
+	resourceVersion, err := w.versioner.ObjectResourceVersion(event.Object)
+	if err != nil {
+		return err
+	}
+	key, err := w.keyFunc(event.Object)
+	if err != nil {
+		return fmt.Errorf("couldn't compute key: %v", err)
+	}
+	elem := &storeElement{Key: key, Object: event.Object}
+	elem.Labels, elem.Fields, err = w.getAttrsFunc(event.Object)
+	if err != nil {
+		return err
+	}
+
+	wcEvent := &watchCacheEvent{
+		Type:            event.Type,
+		Object:          elem.Object,
+		ObjLabels:       elem.Labels,
+		ObjFields:       elem.Fields,
+		Key:             key,
+		ResourceVersion: resourceVersion,
+	}
+
+	func() {
+		w.Lock()
+		defer w.Unlock()
+
+		if previous, exists := w.store.Get(key); exists {
+			wcEvent.PrevObject = previous.Object
+			wcEvent.PrevObjLabels = previous.Labels
+			wcEvent.PrevObjFields = previous.Fields
+		}
+
+		w.updateCache(wcEvent)
+		w.resourceVersion = resourceVersion
+		defer w.cond.Broadcast()
+
+		updateFunc(elem)
+	}()
+
+	// Avoid calling event handler under lock.
+	// This is safe as long as there is at most one call to processEvent in flight
+	// at any point in time.
+	if w.eventHandler != nil {
+		w.eventHandler(wcEvent)
+	}
+	return nil
+}
+
+// Assumes that lock is already held for write.
+func (w *watchCache) updateCache(event *watchCacheEvent) {
+	if w.endIndex == w.startIndex+w.capacity {
+		// Cache is full - remove the oldest element.
+		w.startIndex++
+	}
+	w.cache[w.endIndex%w.capacity] = event
+	w.endIndex++
+}
+
+// waitUntilFreshAndBlock waits until cache is at least as fresh as given resourceVersion.
+// NOTE: This function acquired lock and doesn't release it.
+// You HAVE TO explicitly call w.RUnlock() after this function.
+func (w *watchCache) waitUntilFreshAndBlock(resourceVersion uint64) error {
+	startTime := time.Now()
+
+	// In case resourceVersion is 0, we accept arbitrarily stale result.
+	// As a result, the condition in the below for loop will never be
+	// satisfied (w.resourceVersion is never negative), this call will
+	// never hit the w.cond.Wait().
+	// As a result - we can optimize the code by not firing the wakeup
+	// function (and avoid starting a gorotuine), especially given that
+	// resourceVersion=0 is the most common case.
+	if resourceVersion > 0 {
+		go func() {
+			// Wake us up when the time limit has expired.  The docs
+			// promise that time.After (well, NewTimer, which it calls)
+			// will wait *at least* the duration given. Since this go
+			// routine starts sometime after we record the start time, and
+			// it will wake up the loop below sometime after the broadcast,
+			// we don't need to worry about waking it up before the time
+			// has expired accidentally.
+			<-time.After(blockTimeout)
+			w.cond.Broadcast()
+		}()
+	}
+
+	w.RLock()
+	for w.resourceVersion < resourceVersion {
+		if time.Since(startTime) >= blockTimeout {
+			// Request that the client retry after 'resourceVersionTooHighRetrySeconds' seconds.
+			return storage.NewTooLargeResourceVersionError(resourceVersion, w.resourceVersion, resourceVersionTooHighRetrySeconds)
+		}
+		w.cond.Wait()
+	}
+	return nil
+}
+
+// WaitUntilFreshAndList returns list of pointers to <storeElement> objects.
+func (w *watchCache) WaitUntilFreshAndList(resourceVersion uint64, matchValues []storage.MatchValue) ([]*storeElement, uint64, error) {
+	err := w.waitUntilFreshAndBlock(resourceVersion)
+	defer w.RUnlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// This isn't the place where we do "final filtering" - only some "prefiltering" is happening here. So the only
+	// requirement here is to NOT miss anything that should be returned. We can return as many non-matching items as we
+	// want - they will be filtered out later. The fact that we return less things is only further performance improvement.
+	// TODO: if multiple indexes match, return the one with the fewest items, so as to do as much filtering as possible.
+	for _, matchValue := range matchValues {
+		if result, ok := w.store.ByIndex(matchValue.IndexName, matchValue.Value); ok {
+			return result, w.resourceVersion, nil
+		}
+	}
+	return w.store.List(), w.resourceVersion, nil
+}
+
+// WaitUntilFreshAndGet returns a pointers to <storeElement> object.
+func (w *watchCache) WaitUntilFreshAndGet(resourceVersion uint64, key string) (*storeElement, bool, uint64, error) {
+	err := w.waitUntilFreshAndBlock(resourceVersion)
+	defer w.RUnlock()
+	if err != nil {
+		return nil, false, 0, err
+	}
+	value, exists := w.store.Get(key)
+	return value, exists, w.resourceVersion, nil
+}
+
+// Replace replaces the contents of the cache with objs listed at
+// resourceVersion and drops the history of events.
+func (w *watchCache) Replace(objs []runtime.Object, resourceVersion string) error {
+	version, err := w.versioner.ParseResourceVersion(resourceVersion)
+	if err != nil {
+		return err
+	}
+
+	toReplace := make([]*storeElement, 0, len(objs))
+	for _, obj := range objs {
+		key, err := w.keyFunc(obj)
+		if err != nil {
+			return fmt.Errorf("couldn't compute key: %v", err)
+		}
+		objLabels, objFields, err := w.getAttrsFunc(obj)
+		if err != nil {
+			return err
+		}
+		toReplace = append(toReplace, &storeElement{
+			Key:    key,
+			Object: obj,
+			Labels: objLabels,
+			Fields: objFields,
+		})
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.startIndex = 0
+	w.endIndex = 0
+	w.store.Replace(toReplace)
+	w.listResourceVersion = version
+	w.resourceVersion = version
+	if w.onReplace != nil {
+		w.onReplace()
+	}
+	w.cond.Broadcast()
+	return nil
+}
+
+// SetOnReplace sets the handler that is run at the end of every successful
+// Replace.
+func (w *watchCache) SetOnReplace(onReplace func()) {
+	w.Lock()
+	defer w.Unlock()
+	w.onReplace = onReplace
+}
+
+// UpdateResourceVersion moves the cache forward to resourceVersion, e.g. on
+// a progress notification of the underlying watch, without an object
+// having changed.
+func (w *watchCache) UpdateResourceVersion(resourceVersion string) {
+	rv, err := w.versioner.ParseResourceVersion(resourceVersion)
+	if err != nil {
+		return
+	}
+
+	updated := func() bool {
+		w.Lock()
+		defer w.Unlock()
+		if rv <= w.resourceVersion {
+			return false
+		}
+		w.resourceVersion = rv
+		w.cond.Broadcast()
+		return true
+	}()
+
+	// Avoid calling event handler under lock.
+	if updated && w.eventHandler != nil {
+		w.eventHandler(&watchCacheEvent{
+			Type:            watch.Bookmark,
+			ResourceVersion: rv,
+		})
+	}
+}
+
+// GetAllEventsSinceThreadUnsafe returns the events after resourceVersion
+// that are still in the history window. If resourceVersion is 0 it returns
+// the current contents of the cache as ADDED events instead.
+func (w *watchCache) GetAllEventsSinceThreadUnsafe(resourceVersion uint64) ([]*watchCacheEvent, error) {
+	size := w.endIndex - w.startIndex
+	var oldest uint64
+	switch {
+	case w.listResourceVersion > 0 && w.startIndex == 0:
+		// If no event was removed from the buffer since last relist, the oldest watch
+		// event we can deliver is one greater than the resource version of the list.
+		oldest = w.listResourceVersion + 1
+	case size > 0:
+		// If the previous condition is not satisfied: either some event was already
+		// removed from the buffer or we've never completed a list (the latter can
+		// only happen in unit tests that populate the buffer without performing
+		// list/replace operations), the oldest watch event we can deliver is the first
+		// one in the buffer.
+		oldest = w.cache[w.startIndex%w.capacity].ResourceVersion
+	default:
+		return nil, fmt.Errorf("watch cache isn't correctly initialized")
+	}
+
+	if resourceVersion == 0 {
+		// resourceVersion = 0 means that we don't require any specific starting point
+		// and we would like to start watching from ~now.
+		// However, to keep backward compatibility, we additionally need to return the
+		// current state and only then start watching from that point.
+		//
+		// TODO: In v2 api, we should stop returning the current state - #13969.
+		allItems := w.store.List()
+		result := make([]*watchCacheEvent, len(allItems))
+		for i, elem := range allItems {
+			result[i] = &watchCacheEvent{
+				Type:            watch.Added,
+				Object:          elem.Object,
+				ObjLabels:       elem.Labels,
+				ObjFields:       elem.Fields,
+				Key:             elem.Key,
+				ResourceVersion: w.resourceVersion,
+			}
+		}
+		return result, nil
+	}
+	if resourceVersion < oldest-1 {
+		return nil, errors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", resourceVersion, oldest-1))
+	}
+
+	// Binary search the smallest index at which resourceVersion is greater than the given one.
+	f := func(i int) bool {
+		return w.cache[(w.startIndex+i)%w.capacity].ResourceVersion > resourceVersion
+	}
+	first := sort.Search(size, f)
+	result := make([]*watchCacheEvent, size-first)
+	for i := 0; i < size-first; i++ {
+		result[i] = w.cache[(w.startIndex+first+i)%w.capacity]
+	}
+	return result, nil
+}
@@ -17,6 +17,16 @@ func (e InternalError) Error() string {
 	return e.Reason
 }
 
+// IsInternalError returns true if and only if err is an InternalError.
+func IsInternalError(err error) bool {
+	_, ok := err.(InternalError)
+	return ok
+}
+
+func NewInternalError(reason string) InternalError {
+	return InternalError{reason}
+}
+
 func NewInternalErrorf(format string, a ...interface{}) InternalError {
 	return InternalError{fmt.Sprintf(format, a...)}
 }
@@ -31,6 +41,12 @@ func (e InvalidError) Error() string {
 	return e.Errs.ToAggregate().Error()
 }
 
+// IsInvalidError returns true if and only if err is an InvalidError.
+func IsInvalidError(err error) bool {
+	_, ok := err.(InvalidError)
+	return ok
+}
+
 func NewInvalidError(errors field.ErrorList) InvalidError {
 	return InvalidError{errors}
 }
@@ -103,6 +119,73 @@ func NewInvalidObjError(key, msg string) *StorageError {
 	}
 }
 
+// NewTxnOpError returns err, the error of the i-th operation of a transaction,
+// annotated with the operation that failed. A StorageError keeps its code.
+func NewTxnOpError(err error, i int, opType TxnOpType) error {
+	msg := fmt.Sprintf("operation %d (%s) of the transaction failed", i, opType)
+	e, ok := err.(*StorageError)
+	if !ok {
+		return fmt.Errorf("%s: %v", msg, err)
+	}
+	annotated := *e
+	if len(annotated.AdditionalErrorMsg) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, annotated.AdditionalErrorMsg)
+	}
+	annotated.AdditionalErrorMsg = msg
+	return &annotated
+}
+
+// NewTxnConflictError returns the error of the i-th operation of a
+// transaction, whose condition failed because key was last modified at rev,
+// 0 meaning that key does not exist.
+func NewTxnConflictError(key string, rev int64, i int, opType TxnOpType) error {
+	var err *StorageError
+	switch {
+	case opType == TxnOpCreate:
+		err = NewKeyExistsError(key, rev)
+	case rev == 0:
+		err = NewKeyNotFoundError(key, 0)
+	default:
+		err = NewResourceVersionConflictsError(key, rev)
+	}
+	return NewTxnOpError(err, i, opType)
+}
+
+// IsNotFound returns true if and only if err is "key" not found error.
+func IsNotFound(err error) bool {
+	return isErrCode(err, ErrCodeKeyNotFound)
+}
+
+// IsNodeExist returns true if and only if err is an node already exist error.
+func IsNodeExist(err error) bool {
+	return isErrCode(err, ErrCodeKeyExists)
+}
+
+// IsUnreachable returns true if and only if err indicates the server could not be reached.
+func IsUnreachable(err error) bool {
+	return isErrCode(err, ErrCodeUnreachable)
+}
+
+// IsConflict returns true if and only if err is a write conflict.
+func IsConflict(err error) bool {
+	return isErrCode(err, ErrCodeResourceVersionConflicts)
+}
+
+// IsInvalidObj returns true if and only if err is invalid error
+func IsInvalidObj(err error) bool {
+	return isErrCode(err, ErrCodeInvalidObj)
+}
+
+func isErrCode(err error, code int) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*StorageError); ok {
+		return e.Code == code
+	}
+	return false
+}
+
 var tooLargeResourceVersionCauseMsg = "Too large resource version"
 
 // NewTooLargeResourceVersionError returns a timeout error with the given retrySeconds for a request for
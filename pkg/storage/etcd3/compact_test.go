@@ -0,0 +1,151 @@
+package etcd3
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.etcd.io/etcd/integration"
+)
+
+func TestCompact(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	client := cluster.RandClient()
+	ctx := context.Background()
+
+	putResp, err := client.Put(ctx, "/somekey", "data")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	putResp2, err := client.Put(ctx, "/somekey", "data2")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	_, _, err = compact(ctx, client, 0, putResp2.Header.Revision)
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+
+	_, err = client.Get(ctx, "/somekey", clientv3.WithRev(putResp.Header.Revision))
+	if err != rpctypes.ErrCompacted {
+		t.Errorf("Expecting ErrCompacted, but get=%v", err)
+	}
+	if _, err := client.Get(ctx, "/somekey", clientv3.WithRev(putResp2.Header.Revision)); err != nil {
+		t.Errorf("Expect the compacted revision itself to be kept, get=%v", err)
+	}
+}
+
+// TestCompactConflict tests that only one of the compactors sharing the
+// compact time does the compaction.
+func TestCompactConflict(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	client := cluster.RandClient()
+	ctx := context.Background()
+
+	putResp, err := client.Put(ctx, "/somekey", "data")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Compact first. It would do the compaction and return compact time which is incremented by 1.
+	curTime, _, err := compact(ctx, client, 0, putResp.Header.Revision)
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if curTime != 1 {
+		t.Errorf("Expect current logical time = 1, get = %v", curTime)
+	}
+
+	// Compact again with the same parameters, as a second compactor that has
+	// not seen the first compaction would. It loses the CAS on
+	// compact_rev_key, doesn't compact and learns the latest compact time.
+	putResp2, err := client.Put(ctx, "/somekey", "data2")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	curTime2, curRev2, err := compact(ctx, client, 0, putResp2.Header.Revision)
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if curTime != curTime2 {
+		t.Errorf("Unexpected curTime (%v) != curTime2 (%v)", curTime, curTime2)
+	}
+	if curRev2 != putResp2.Header.Revision {
+		t.Errorf("Expect the failed CAS not to write, current revision = %v, get = %v", putResp2.Header.Revision, curRev2)
+	}
+	if _, err := client.Get(ctx, "/somekey", clientv3.WithRev(putResp.Header.Revision)); err != nil {
+		t.Errorf("Expect no compaction to the revision of the losing compactor, get=%v", err)
+	}
+	getResp, err := client.Get(ctx, compactRevKey)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if kv := getResp.Kvs[0]; kv.Version != 1 || string(kv.Value) != strconv.FormatInt(putResp.Header.Revision, 10) {
+		t.Errorf("Expect %s to be written once with the revision of the winning compactor, get version=%v value=%s", compactRevKey, kv.Version, kv.Value)
+	}
+
+	// With the learned compact time the second compactor wins the next CAS.
+	curTime3, _, err := compact(ctx, client, curTime2, putResp2.Header.Revision)
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if curTime3 != 2 {
+		t.Errorf("Expect current logical time = 2, get = %v", curTime3)
+	}
+}
+
+// TestCompactPreviousInterval tests that a compactor compacts to the
+// revision it saw in its previous interval.
+func TestCompactPreviousInterval(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	client := cluster.RandClient()
+	ctx := context.Background()
+
+	putResp, err := client.Put(ctx, "/somekey", "data")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// The first interval doesn't compact, it only records the revision.
+	curTime, curRev, err := compact(ctx, client, 0, 0)
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if _, err := client.Get(ctx, "/somekey", clientv3.WithRev(putResp.Header.Revision)); err != nil {
+		t.Errorf("Expect no compaction on bootstrap, get=%v", err)
+	}
+
+	putResp2, err := client.Put(ctx, "/somekey", "data2")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// The next interval compacts to the revision of the previous one, the
+	// writes since are kept.
+	if _, _, err = compact(ctx, client, curTime, curRev); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if _, err := client.Get(ctx, "/somekey", clientv3.WithRev(putResp.Header.Revision)); err != rpctypes.ErrCompacted {
+		t.Errorf("Expecting ErrCompacted before revision %v, but get=%v", curRev, err)
+	}
+	getResp, err := client.Get(ctx, "/somekey", clientv3.WithRev(putResp2.Header.Revision))
+	if err != nil {
+		t.Fatalf("Expect the revision of the current interval to be kept, get=%v", err)
+	}
+	if string(getResp.Kvs[0].Value) != "data2" {
+		t.Errorf("Expect data2, get %s", getResp.Kvs[0].Value)
+	}
+	getResp, err = client.Get(ctx, compactRevKey)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(getResp.Kvs[0].Value) != strconv.FormatInt(curRev, 10) {
+		t.Errorf("Expect %s = %v, get %s", compactRevKey, curRev, getResp.Kvs[0].Value)
+	}
+}
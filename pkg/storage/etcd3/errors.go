@@ -1,8 +1,10 @@
 package etcd3
 
 import (
+	"github.com/x893675/opa-server/pkg/storage"
 	etcdrpc "go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
 )
 
 func interpretWatchError(err error) error {
@@ -42,10 +44,9 @@ func handleCompactedErrorForPaging(continueKey, keyPrefix string) error {
 	// continueToken.ResoureVersion=-1 means that the apiserver can
 	// continue the list at the latest resource version. We don't use rv=0
 	// for this purpose to distinguish from a bad token that has empty rv.
-	newToken, err := encodeContinue(continueKey, keyPrefix, -1)
+	newToken, err := storage.EncodeContinue(continueKey, keyPrefix, -1)
 	if err != nil {
-		//utilruntime.HandleError(err)
-		//TODO: log error
+		klog.ErrorS(err, "Failed to encode continue token", "continueKey", continueKey, "keyPrefix", keyPrefix)
 		return errors.NewResourceExpired(continueExpired)
 	}
 	statusError := errors.NewResourceExpired(inconsistentContinue)
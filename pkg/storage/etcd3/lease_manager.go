@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/x893675/opa-server/pkg/storage/etcd3/metrics"
 	"go.etcd.io/etcd/clientv3"
 )
 
@@ -97,7 +98,7 @@ func (l *leaseManager) GetLease(ctx context.Context, ttl int64) (clientv3.LeaseI
 	l.prevLeaseID = lcr.ID
 	l.prevLeaseExpirationTime = now.Add(time.Duration(ttl) * time.Second)
 	// refresh count
-	//metrics.UpdateLeaseObjectCount(l.leaseAttachedObjectCount)
+	metrics.UpdateLeaseObjectCount(l.leaseAttachedObjectCount)
 	l.leaseAttachedObjectCount = 1
 	return lcr.ID, nil
 }
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	etcdRequestLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "etcd_request_duration_seconds",
+			Help:    "Etcd request latency in seconds for each operation and object type.",
+			Buckets: []float64{0.005, 0.025, 0.1, 0.25, 0.5, 1.0, 2.0, 4.0, 15.0, 30.0, 60.0},
+		},
+		[]string{"operation", "type"},
+	)
+	objectCounts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "opa_server_storage_object_counts",
+			Help: "Number of stored objects at the time of last check split by kind.",
+		},
+		[]string{"resource"},
+	)
+	dbTotalSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "opa_server_storage_db_total_size_in_bytes",
+			Help: "Total size of the storage database file physically allocated in bytes.",
+		},
+		[]string{"endpoint"},
+	)
+	etcdBookmarkCounts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "etcd_bookmark_counts",
+			Help: "Number of etcd bookmarks (progress notify events) split by kind.",
+		},
+		[]string{"resource"},
+	)
+	etcdLeaseObjectCounts = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "etcd_lease_object_counts",
+			Help:    "Number of objects attached to a single etcd lease.",
+			Buckets: []float64{10, 50, 100, 500, 1000, 2500, 5000},
+		},
+		[]string{},
+	)
+	etcdWatchChannelBacklog = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "etcd_watch_channel_backlog",
+			Help: "Number of events buffered in a watch channel when another event is sent to it, " +
+				"split by channel and kind. A channel holds at most 100 events.",
+			Buckets: []float64{0, 1, 10, 25, 50, 75, 99, 100},
+		},
+		[]string{"channel", "type"},
+	)
+)
+
+// Register registers all metrics with registerer.
+func Register(registerer prometheus.Registerer) {
+	registerer.MustRegister(etcdRequestLatency)
+	registerer.MustRegister(objectCounts)
+	registerer.MustRegister(dbTotalSize)
+	registerer.MustRegister(etcdBookmarkCounts)
+	registerer.MustRegister(etcdLeaseObjectCounts)
+	registerer.MustRegister(etcdWatchChannelBacklog)
+}
+
+// UpdateObjectCount sets the opa_server_storage_object_counts metric.
+func UpdateObjectCount(resourcePrefix string, count int64) {
+	objectCounts.WithLabelValues(resourcePrefix).Set(float64(count))
+}
+
+// RecordEtcdRequestLatency sets the etcd_request_duration_seconds metrics.
+func RecordEtcdRequestLatency(verb, resource string, startTime time.Time) {
+	etcdRequestLatency.WithLabelValues(verb, resource).Observe(sinceInSeconds(startTime))
+}
+
+// RecordEtcdBookmark updates the etcd_bookmark_counts metric.
+func RecordEtcdBookmark(resource string) {
+	etcdBookmarkCounts.WithLabelValues(resource).Inc()
+}
+
+// RecordEtcdWatchChannelBacklog updates the etcd_watch_channel_backlog metric.
+func RecordEtcdWatchChannelBacklog(channel, resource string, backlog int) {
+	etcdWatchChannelBacklog.WithLabelValues(channel, resource).Observe(float64(backlog))
+}
+
+// Reset resets the etcd_request_duration_seconds metric.
+func Reset() {
+	etcdRequestLatency.Reset()
+}
+
+// sinceInSeconds gets the time since the specified start in seconds.
+func sinceInSeconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// UpdateEtcdDbSize sets the opa_server_storage_db_total_size_in_bytes metric.
+func UpdateEtcdDbSize(ep string, size int64) {
+	dbTotalSize.WithLabelValues(ep).Set(float64(size))
+}
+
+// UpdateLeaseObjectCount sets the etcd_lease_object_counts metric.
+func UpdateLeaseObjectCount(count int64) {
+	// Currently we only store one previous lease, since all the events have the same ttl.
+	// See pkg/storage/etcd3/lease_manager.go
+	etcdLeaseObjectCounts.WithLabelValues().Observe(float64(count))
+}
@@ -3,17 +3,18 @@ package etcd3
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"path"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/x893675/opa-server/pkg/runtime"
 	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/etcd3/metrics"
 	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
 	"github.com/x893675/opa-server/pkg/watch"
 	"go.etcd.io/etcd/clientv3"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -21,31 +22,27 @@ import (
 	"k8s.io/klog/v2"
 )
 
-//// authenticatedDataString satisfies the value.Context interface. It uses the key to
-//// authenticate the stored data. This does not defend against reuse of previously
-//// encrypted values under the same key, but will prevent an attacker from using an
-//// encrypted value from a different key. A stronger authenticated data segment would
-//// include the etcd3 Version field (which is incremented on each write to a key and
-//// reset when the key is deleted), but an attacker with write access to etcd can
-//// force deletion and recreation of keys to weaken that angle.
-//type authenticatedDataString string
-//
-//// AuthenticatedData implements the value.Context interface.
-//func (d authenticatedDataString) AuthenticatedData() []byte {
-//	return []byte(d)
-//}
-//
-//var _ value.Context = authenticatedDataString("")
-
-const (
-	continueTokenVersion = "meta.io/v1"
-)
+// authenticatedDataString satisfies the value.Context interface. It uses the key to
+// authenticate the stored data. This does not defend against reuse of previously
+// encrypted values under the same key, but will prevent an attacker from using an
+// encrypted value from a different key. A stronger authenticated data segment would
+// include the etcd3 Version field (which is incremented on each write to a key and
+// reset when the key is deleted), but an attacker with write access to etcd can
+// force deletion and recreation of keys to weaken that angle.
+type authenticatedDataString string
+
+// AuthenticatedData implements the value.Context interface.
+func (d authenticatedDataString) AuthenticatedData() []byte {
+	return []byte(d)
+}
+
+var _ value.Context = authenticatedDataString("")
 
 type store struct {
-	client    *clientv3.Client
-	codec     runtime.Codec
-	versioner storage.Versioner
-	//transformer   value.Transformer
+	client        *clientv3.Client
+	codec         runtime.Codec
+	versioner     storage.Versioner
+	transformer   value.Transformer
 	pathPrefix    string
 	watcher       *watcher
 	pagingEnabled bool
@@ -60,23 +57,41 @@ type objState struct {
 	stale bool
 }
 
+// txnOpState is the state of an operation of a transaction.
+type txnOpState struct {
+	key string
+	// data is the encoded object written by the operation, or the deleted one.
+	data []byte
+	// newData is data transformed for storage.
+	newData []byte
+	opts    []clientv3.OpOption
+	// rev is the revision the key has to be last modified at, 0 meaning that
+	// it must not exist.
+	rev int64
+	// fixed is set if rev is given by the operation rather than read from
+	// etcd, a conflict is then an error of the transaction instead of a
+	// reason to retry it.
+	fixed bool
+}
+
 // New returns an etcd3 implementation of storage.Interface.
-func New(c *clientv3.Client, codec runtime.Codec, newFunc func() runtime.Object, prefix string, pagingEnabled bool, leaseManagerConfig LeaseManagerConfig) storage.Interface {
-	return newStore(c, codec, newFunc, prefix, pagingEnabled, leaseManagerConfig)
+func New(c *clientv3.Client, codec runtime.Codec, newFunc func() runtime.Object, prefix string, transformer value.Transformer, pagingEnabled bool, leaseManagerConfig LeaseManagerConfig) storage.Interface {
+	return newStore(c, codec, newFunc, prefix, transformer, pagingEnabled, leaseManagerConfig)
 }
 
-func newStore(c *clientv3.Client, codec runtime.Codec, newFunc func() runtime.Object, prefix string, pagingEnabled bool, leaseManagerConfig LeaseManagerConfig) *store {
-	versioner := APIObjectVersioner{}
+func newStore(c *clientv3.Client, codec runtime.Codec, newFunc func() runtime.Object, prefix string, transformer value.Transformer, pagingEnabled bool, leaseManagerConfig LeaseManagerConfig) *store {
+	versioner := storage.APIObjectVersioner{}
 	result := &store{
 		client:        c,
 		codec:         codec,
 		versioner:     versioner,
+		transformer:   transformer,
 		pagingEnabled: pagingEnabled,
 		// for compatibility with etcd2 impl.
 		// no-op for default prefix of '/registry'.
 		// keeps compatibility with etcd2 impl for custom prefixes that don't start with '/'
 		pathPrefix:   path.Join("/", prefix),
-		watcher:      newWatcher(c, codec, newFunc, versioner),
+		watcher:      newWatcher(c, codec, newFunc, versioner, transformer),
 		leaseManager: newDefaultLeaseManager(c, leaseManagerConfig),
 	}
 	return result
@@ -90,9 +105,9 @@ func (s *store) Versioner() storage.Versioner {
 // Get implements storage.Interface.Get.
 func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, out runtime.Object) error {
 	key = path.Join(s.pathPrefix, key)
-	//startTime := time.Now()
+	startTime := time.Now()
 	getResp, err := s.client.KV.Get(ctx, key)
-	//metrics.RecordEtcdRequestLatency("get", getTypeName(out), startTime)
+	metrics.RecordEtcdRequestLatency("get", getTypeName(out), startTime)
 	if err != nil {
 		return err
 	}
@@ -110,12 +125,12 @@ func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, ou
 	}
 	kv := getResp.Kvs[0]
 
-	//data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(key))
-	//if err != nil {
-	//	return storage.NewInternalError(err.Error())
-	//}
+	data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(key))
+	if err != nil {
+		return storage.NewInternalError(err.Error())
+	}
 
-	return decode(s.codec, s.versioner, kv.Value, out, kv.ModRevision)
+	return decode(s.codec, s.versioner, data, out, kv.ModRevision)
 }
 
 // Create implements storage.Interface.Create.
@@ -137,18 +152,18 @@ func (s *store) Create(ctx context.Context, key string, obj, out runtime.Object,
 		return err
 	}
 
-	//newData, err := s.transformer.TransformToStorage(data, authenticatedDataString(key))
-	//if err != nil {
-	//	return storage.NewInternalError(err.Error())
-	//}
+	newData, err := s.transformer.TransformToStorage(data, authenticatedDataString(key))
+	if err != nil {
+		return storage.NewInternalError(err.Error())
+	}
 
-	//startTime := time.Now()
+	startTime := time.Now()
 	txnResp, err := s.client.KV.Txn(ctx).If(
 		notFound(key),
 	).Then(
-		clientv3.OpPut(key, string(data), opts...),
+		clientv3.OpPut(key, string(newData), opts...),
 	).Commit()
-	//metrics.RecordEtcdRequestLatency("create", getTypeName(obj), startTime)
+	metrics.RecordEtcdRequestLatency("create", getTypeName(obj), startTime)
 	if err != nil {
 		return err
 	}
@@ -179,9 +194,9 @@ func (s *store) conditionalDelete(
 	ctx context.Context, key string, out runtime.Object, v reflect.Value, preconditions *storage.Preconditions,
 	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
 	getCurrentState := func() (*objState, error) {
-		//startTime := time.Now()
+		startTime := time.Now()
 		getResp, err := s.client.KV.Get(ctx, key)
-		//metrics.RecordEtcdRequestLatency("get", getTypeName(out), startTime)
+		metrics.RecordEtcdRequestLatency("get", getTypeName(out), startTime)
 		if err != nil {
 			return nil, err
 		}
@@ -235,7 +250,7 @@ func (s *store) conditionalDelete(
 			continue
 		}
 
-		//startTime := time.Now()
+		startTime := time.Now()
 		txnResp, err := s.client.KV.Txn(ctx).If(
 			clientv3.Compare(clientv3.ModRevision(key), "=", origState.rev),
 		).Then(
@@ -243,13 +258,13 @@ func (s *store) conditionalDelete(
 		).Else(
 			clientv3.OpGet(key),
 		).Commit()
-		//metrics.RecordEtcdRequestLatency("delete", getTypeName(out), startTime)
+		metrics.RecordEtcdRequestLatency("delete", getTypeName(out), startTime)
 		if err != nil {
 			return err
 		}
 		if !txnResp.Succeeded {
 			getResp := (*clientv3.GetResponse)(txnResp.Responses[0].GetResponseRange())
-			klog.V(4).Infof("deletion of %s failed because of a conflict, going to retry", key)
+			klog.V(4).InfoS("Deletion of object failed because of a conflict, going to retry", "key", key)
 			origState, err = s.getState(getResp, key, v, false)
 			if err != nil {
 				return err
@@ -275,9 +290,9 @@ func (s *store) GuaranteedUpdate(
 	key = path.Join(s.pathPrefix, key)
 
 	getCurrentState := func() (*objState, error) {
-		//startTime := time.Now()
+		startTime := time.Now()
 		getResp, err := s.client.KV.Get(ctx, key)
-		//metrics.RecordEtcdRequestLatency("get", getTypeName(out), startTime)
+		metrics.RecordEtcdRequestLatency("get", getTypeName(out), startTime)
 		if err != nil {
 			return nil, err
 		}
@@ -297,7 +312,7 @@ func (s *store) GuaranteedUpdate(
 	}
 	//trace.Step("initial value restored")
 
-	//transformContext := authenticatedDataString(key)
+	transformContext := authenticatedDataString(key)
 	for {
 		if err := preconditions.Check(key, origState.obj); err != nil {
 			// If our data is already up to date, return the error
@@ -359,10 +374,10 @@ func (s *store) GuaranteedUpdate(
 			}
 		}
 
-		//newData, err := s.transformer.TransformToStorage(data, transformContext)
-		//if err != nil {
-		//	return storage.NewInternalError(err.Error())
-		//}
+		newData, err := s.transformer.TransformToStorage(data, transformContext)
+		if err != nil {
+			return storage.NewInternalError(err.Error())
+		}
 
 		opts, err := s.ttlOpts(ctx, int64(ttl))
 		if err != nil {
@@ -370,22 +385,22 @@ func (s *store) GuaranteedUpdate(
 		}
 		//trace.Step("Transaction prepared")
 
-		//startTime := time.Now()
+		startTime := time.Now()
 		txnResp, err := s.client.KV.Txn(ctx).If(
 			clientv3.Compare(clientv3.ModRevision(key), "=", origState.rev),
 		).Then(
-			clientv3.OpPut(key, string(data), opts...),
+			clientv3.OpPut(key, string(newData), opts...),
 		).Else(
 			clientv3.OpGet(key),
 		).Commit()
-		//metrics.RecordEtcdRequestLatency("update", getTypeName(out), startTime)
+		metrics.RecordEtcdRequestLatency("update", getTypeName(out), startTime)
 		if err != nil {
 			return err
 		}
 		//trace.Step("Transaction committed")
 		if !txnResp.Succeeded {
 			getResp := (*clientv3.GetResponse)(txnResp.Responses[0].GetResponseRange())
-			klog.V(4).Infof("GuaranteedUpdate of %s failed because of a conflict, going to retry", key)
+			klog.V(4).InfoS("GuaranteedUpdate failed because of a conflict, going to retry", "key", key)
 			origState, err = s.getState(getResp, key, v, ignoreNotFound)
 			if err != nil {
 				return err
@@ -400,6 +415,152 @@ func (s *store) GuaranteedUpdate(
 	}
 }
 
+// Txn implements storage.Interface.Txn.
+func (s *store) Txn(ctx context.Context) storage.Txn {
+	return storage.NewTxn(ctx, s.commitTxn)
+}
+
+// commitTxn applies ops in a single etcd transaction, comparing the
+// ModRevision of each key to the revision its operation expects. The
+// operations that do not expect a revision are based on the current one,
+// the transaction is retried if it changes in between.
+func (s *store) commitTxn(ctx context.Context, ops []storage.TxnOp) error {
+	states := make([]txnOpState, len(ops))
+	for i, op := range ops {
+		st := &states[i]
+		st.key = path.Join(s.pathPrefix, op.Key)
+		if op.Type == storage.TxnOpDelete {
+			continue
+		}
+
+		version, err := s.versioner.ObjectResourceVersion(op.Obj)
+		switch op.Type {
+		case storage.TxnOpCreate:
+			if err == nil && version != 0 {
+				return storage.NewTxnOpError(errors.New("resourceVersion should not be set on objects to be created"), i, op.Type)
+			}
+			st.fixed = true
+		case storage.TxnOpUpdate:
+			if err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+			st.rev, st.fixed = int64(version), version != 0
+		}
+		if err := s.versioner.PrepareObjectForStorage(op.Obj); err != nil {
+			return storage.NewTxnOpError(fmt.Errorf("PrepareObjectForStorage failed: %v", err), i, op.Type)
+		}
+		if st.data, err = runtime.Encode(s.codec, op.Obj); err != nil {
+			return storage.NewTxnOpError(err, i, op.Type)
+		}
+		if st.newData, err = s.transformer.TransformToStorage(st.data, authenticatedDataString(st.key)); err != nil {
+			return storage.NewTxnOpError(storage.NewInternalError(err.Error()), i, op.Type)
+		}
+		if st.opts, err = s.ttlOpts(ctx, int64(op.TTL)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		cmps := make([]clientv3.Cmp, 0, len(ops))
+		thenOps := make([]clientv3.Op, 0, len(ops))
+		elseOps := make([]clientv3.Op, 0, len(ops))
+		for i, op := range ops {
+			st := &states[i]
+			if !st.fixed {
+				if err := s.readTxnOpState(ctx, st, op); err != nil {
+					return storage.NewTxnOpError(err, i, op.Type)
+				}
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(st.key), "=", st.rev))
+			if op.Type == storage.TxnOpDelete {
+				thenOps = append(thenOps, clientv3.OpDelete(st.key))
+			} else {
+				thenOps = append(thenOps, clientv3.OpPut(st.key, string(st.newData), st.opts...))
+			}
+			elseOps = append(elseOps, clientv3.OpGet(st.key, clientv3.WithKeysOnly()))
+		}
+
+		startTime := time.Now()
+		txnResp, err := s.client.KV.Txn(ctx).If(cmps...).Then(thenOps...).Else(elseOps...).Commit()
+		metrics.RecordEtcdRequestLatency("txn", getTypeName(txnOpObject(ops[0])), startTime)
+		if err != nil {
+			return err
+		}
+		if !txnResp.Succeeded {
+			var conflictKey string
+			for i, op := range ops {
+				st := &states[i]
+				var rev int64
+				if kvs := txnResp.Responses[i].GetResponseRange().Kvs; len(kvs) > 0 {
+					rev = kvs[0].ModRevision
+				}
+				if rev == st.rev {
+					continue
+				}
+				if st.fixed {
+					return storage.NewTxnConflictError(st.key, rev, i, op.Type)
+				}
+				if len(conflictKey) == 0 {
+					conflictKey = st.key
+				}
+			}
+			klog.V(4).InfoS("Transaction failed because of a conflict, going to retry", "key", conflictKey)
+			continue
+		}
+
+		for i, op := range ops {
+			st := &states[i]
+			switch {
+			case op.Type == storage.TxnOpDelete:
+				err = decode(s.codec, s.versioner, st.data, op.Out, st.rev)
+			case op.Out != nil:
+				err = decode(s.codec, s.versioner, st.data, op.Out, txnResp.Header.Revision)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// readTxnOpState bases the operation of st on the current version of its
+// key. The object a delete removes is read and checked against the
+// preconditions.
+func (s *store) readTxnOpState(ctx context.Context, st *txnOpState, op storage.TxnOp) error {
+	var opts []clientv3.OpOption
+	if op.Type != storage.TxnOpDelete {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+	startTime := time.Now()
+	getResp, err := s.client.KV.Get(ctx, st.key, opts...)
+	metrics.RecordEtcdRequestLatency("get", getTypeName(txnOpObject(op)), startTime)
+	if err != nil {
+		return err
+	}
+	if op.Type != storage.TxnOpDelete {
+		if len(getResp.Kvs) == 0 {
+			return storage.NewKeyNotFoundError(st.key, 0)
+		}
+		st.rev = getResp.Kvs[0].ModRevision
+		return nil
+	}
+
+	v, err := conversion.EnforcePtr(op.Out)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	state, err := s.getState(getResp, st.key, v, false)
+	if err != nil {
+		return err
+	}
+	if err := op.Preconditions.Check(st.key, state.obj); err != nil {
+		return err
+	}
+	st.rev, st.data = state.rev, state.data
+	return nil
+}
+
 // GetToList implements storage.Interface.GetToList.
 func (s *store) GetToList(ctx context.Context, key string, listOpts storage.ListOptions, listObj runtime.Object) error {
 	resourceVersion := listOpts.ResourceVersion
@@ -424,7 +585,7 @@ func (s *store) GetToList(ctx context.Context, key string, listOpts storage.List
 	newItemFunc := getNewItemFunc(listObj, v)
 
 	key = path.Join(s.pathPrefix, key)
-	//startTime := time.Now()
+	startTime := time.Now()
 	var opts []clientv3.OpOption
 	if len(resourceVersion) > 0 && match == meta.ResourceVersionMatchExact {
 		rv, err := s.versioner.ParseResourceVersion(resourceVersion)
@@ -435,7 +596,7 @@ func (s *store) GetToList(ctx context.Context, key string, listOpts storage.List
 	}
 
 	getResp, err := s.client.KV.Get(ctx, key, opts...)
-	//metrics.RecordEtcdRequestLatency("get", getTypeName(listPtr), startTime)
+	metrics.RecordEtcdRequestLatency("get", getTypeName(listPtr), startTime)
 	if err != nil {
 		return err
 	}
@@ -444,11 +605,11 @@ func (s *store) GetToList(ctx context.Context, key string, listOpts storage.List
 	}
 
 	if len(getResp.Kvs) > 0 {
-		//data, _, err := s.transformer.TransformFromStorage(getResp.Kvs[0].Value, authenticatedDataString(key))
-		//if err != nil {
-		//	return storage.NewInternalError(err.Error())
-		//}
-		if err := appendListItem(v, getResp.Kvs[0].Value, uint64(getResp.Kvs[0].ModRevision), pred, s.codec, s.versioner, newItemFunc); err != nil {
+		data, _, err := s.transformer.TransformFromStorage(getResp.Kvs[0].Value, authenticatedDataString(key))
+		if err != nil {
+			return storage.NewInternalError(err.Error())
+		}
+		if err := appendListItem(v, data, uint64(getResp.Kvs[0].ModRevision), pred, s.codec, s.versioner, newItemFunc); err != nil {
 			return err
 		}
 	}
@@ -483,9 +644,9 @@ func (s *store) Count(key string) (int64, error) {
 		key += "/"
 	}
 
-	//startTime := time.Now()
+	startTime := time.Now()
 	getResp, err := s.client.KV.Get(context.Background(), key, clientv3.WithRange(clientv3.GetPrefixRangeEnd(key)), clientv3.WithCountOnly())
-	//metrics.RecordEtcdRequestLatency("listWithCount", key, startTime)
+	metrics.RecordEtcdRequestLatency("listWithCount", key, startTime)
 	if err != nil {
 		return 0, err
 	}
@@ -547,7 +708,7 @@ func (s *store) List(ctx context.Context, key string, opts storage.ListOptions,
 	var continueKey string
 	switch {
 	case s.pagingEnabled && len(pred.Continue) > 0:
-		continueKey, continueRV, err = decodeContinue(pred.Continue, keyPrefix)
+		continueKey, continueRV, err = storage.DecodeContinue(pred.Continue, keyPrefix)
 		if err != nil {
 			return apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
 		}
@@ -614,9 +775,9 @@ func (s *store) List(ctx context.Context, key string, opts storage.ListOptions,
 	var hasMore bool
 	var getResp *clientv3.GetResponse
 	for {
-		//startTime := time.Now()
+		startTime := time.Now()
 		getResp, err = s.client.KV.Get(ctx, key, options...)
-		//metrics.RecordEtcdRequestLatency("list", getTypeName(listPtr), startTime)
+		metrics.RecordEtcdRequestLatency("list", getTypeName(listPtr), startTime)
 		if err != nil {
 			return interpretListError(err, len(pred.Continue) > 0, continueKey, keyPrefix)
 		}
@@ -645,12 +806,12 @@ func (s *store) List(ctx context.Context, key string, opts storage.ListOptions,
 			}
 			lastKey = kv.Key
 
-			//data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(kv.Key))
-			//if err != nil {
-			//	return storage.NewInternalErrorf("unable to transform key %q: %v", kv.Key, err)
-			//}
+			data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(kv.Key))
+			if err != nil {
+				return storage.NewInternalErrorf("unable to transform key %q: %v", kv.Key, err)
+			}
 
-			if err := appendListItem(v, kv.Value, uint64(kv.ModRevision), pred, s.codec, s.versioner, newItemFunc); err != nil {
+			if err := appendListItem(v, data, uint64(kv.ModRevision), pred, s.codec, s.versioner, newItemFunc); err != nil {
 				return err
 			}
 		}
@@ -679,7 +840,7 @@ func (s *store) List(ctx context.Context, key string, opts storage.ListOptions,
 	// we never return a key that the client wouldn't be allowed to see
 	if hasMore {
 		// we want to start immediately after the last key
-		next, err := encodeContinue(string(lastKey)+"\x00", keyPrefix, returnedRV)
+		next, err := storage.EncodeContinue(string(lastKey)+"\x00", keyPrefix, returnedRV)
 		if err != nil {
 			return err
 		}
@@ -772,14 +933,14 @@ func (s *store) getState(getResp *clientv3.GetResponse, key string, v reflect.Va
 			return nil, err
 		}
 	} else {
-		//data, stale, err := s.transformer.TransformFromStorage(getResp.Kvs[0].Value, authenticatedDataString(key))
-		//if err != nil {
-		//	return nil, storage.NewInternalError(err.Error())
-		//}
+		data, stale, err := s.transformer.TransformFromStorage(getResp.Kvs[0].Value, authenticatedDataString(key))
+		if err != nil {
+			return nil, storage.NewInternalError(err.Error())
+		}
 		state.rev = getResp.Kvs[0].ModRevision
 		state.meta.ResourceVersion = uint64(state.rev)
-		state.data = getResp.Kvs[0].Value
-		//state.stale = stale
+		state.data = data
+		state.stale = stale
 		if err := decode(s.codec, s.versioner, state.data, state.obj, state.rev); err != nil {
 			return nil, err
 		}
@@ -810,7 +971,7 @@ func (s *store) getStateFromObject(obj runtime.Object) (*objState, error) {
 		return nil, err
 	}
 	if err := s.versioner.UpdateObject(state.obj, uint64(rv)); err != nil {
-		klog.Errorf("failed to update object version: %v", err)
+		klog.ErrorS(err, "Failed to update object version", "rev", rv)
 	}
 	return state, nil
 }
@@ -874,7 +1035,7 @@ func decode(codec runtime.Codec, versioner storage.Versioner, value []byte, objP
 	}
 	// being unable to set the version does not prevent the object from being extracted
 	if err := versioner.UpdateObject(objPtr, uint64(rev)); err != nil {
-		klog.Errorf("failed to update object version: %v", err)
+		klog.ErrorS(err, "Failed to update object version", "rev", rev)
 	}
 	return nil
 }
@@ -887,7 +1048,7 @@ func appendListItem(v reflect.Value, data []byte, rev uint64, pred storage.Selec
 	}
 	// being unable to set the version does not prevent the object from being extracted
 	if err := versioner.UpdateObject(obj, rev); err != nil {
-		klog.Errorf("failed to update object version: %v", err)
+		klog.ErrorS(err, "Failed to update object version", "rev", rev)
 	}
 	if matched, err := pred.Matches(obj); err == nil && matched {
 		v.Set(reflect.Append(v, reflect.ValueOf(obj).Elem()))
@@ -899,67 +1060,16 @@ func notFound(key string) clientv3.Cmp {
 	return clientv3.Compare(clientv3.ModRevision(key), "=", 0)
 }
 
-// getTypeName returns type name of an object for reporting purposes.
-func getTypeName(obj interface{}) string {
-	return reflect.TypeOf(obj).String()
-}
-
-// continueToken is a simple structured object for encoding the state of a continue token.
-// TODO: if we change the version of the encoded from, we can't start encoding the new version
-// until all other servers are upgraded (i.e. we need to support rolling schema)
-// This is a public API struct and cannot change.
-type continueToken struct {
-	APIVersion      string `json:"v"`
-	ResourceVersion int64  `json:"rv"`
-	StartKey        string `json:"start"`
-}
-
-// parseFrom transforms an encoded predicate from into a versioned struct.
-// TODO: return a typed error that instructs clients that they must relist
-func decodeContinue(continueValue, keyPrefix string) (fromKey string, rv int64, err error) {
-	data, err := base64.RawURLEncoding.DecodeString(continueValue)
-	if err != nil {
-		return "", 0, fmt.Errorf("continue key is not valid: %v", err)
-	}
-	var c continueToken
-	if err := json.Unmarshal(data, &c); err != nil {
-		return "", 0, fmt.Errorf("continue key is not valid: %v", err)
-	}
-	switch c.APIVersion {
-	case continueTokenVersion:
-		if c.ResourceVersion == 0 {
-			return "", 0, fmt.Errorf("continue key is not valid: incorrect encoded start resourceVersion (version meta.k8s.io/v1)")
-		}
-		if len(c.StartKey) == 0 {
-			return "", 0, fmt.Errorf("continue key is not valid: encoded start key empty (version meta.k8s.io/v1)")
-		}
-		// defend against path traversal attacks by clients - path.Clean will ensure that startKey cannot
-		// be at a higher level of the hierarchy, and so when we append the key prefix we will end up with
-		// continue start key that is fully qualified and cannot range over anything less specific than
-		// keyPrefix.
-		key := c.StartKey
-		if !strings.HasPrefix(key, "/") {
-			key = "/" + key
-		}
-		cleaned := path.Clean(key)
-		if cleaned != key {
-			return "", 0, fmt.Errorf("continue key is not valid: %s", c.StartKey)
-		}
-		return keyPrefix + cleaned[1:], c.ResourceVersion, nil
-	default:
-		return "", 0, fmt.Errorf("continue key is not valid: server does not recognize this encoded version %q", c.APIVersion)
+// txnOpObject returns the object of a transaction operation for reporting
+// purposes.
+func txnOpObject(op storage.TxnOp) runtime.Object {
+	if op.Type == storage.TxnOpDelete {
+		return op.Out
 	}
+	return op.Obj
 }
 
-// encodeContinue returns a string representing the encoded continuation of the current query.
-func encodeContinue(key, keyPrefix string, resourceVersion int64) (string, error) {
-	nextKey := strings.TrimPrefix(key, keyPrefix)
-	if nextKey == key {
-		return "", fmt.Errorf("unable to encode next field: the key and key prefix do not match")
-	}
-	out, err := json.Marshal(&continueToken{APIVersion: continueTokenVersion, ResourceVersion: resourceVersion, StartKey: nextKey})
-	if err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(out), nil
+// getTypeName returns type name of an object for reporting purposes.
+func getTypeName(obj interface{}) string {
+	return reflect.TypeOf(obj).String()
 }
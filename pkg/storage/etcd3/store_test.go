@@ -0,0 +1,341 @@
+package etcd3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	serializer "github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	"github.com/x893675/opa-server/pkg/storage/value/encryptionconfig"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/integration"
+)
+
+func newTestStore(t *testing.T, client *clientv3.Client, transformer value.Transformer) *store {
+	codec := serializer.NewSerializerWithOptions(serializer.DefaultMetaFactory, nil, nil, serializer.SerializerOptions{})
+	newFunc := func() runtime.Object { return &model.User{} }
+	return newStore(client, codec, newFunc, "/registry", transformer, true, NewDefaultLeaseManagerConfig())
+}
+
+func newTestTransformer(t *testing.T, config string) value.Transformer {
+	t.Helper()
+	transformer, err := encryptionconfig.ParseEncryptionConfiguration([]byte(config))
+	if err != nil {
+		t.Fatalf("ParseEncryptionConfiguration failed: %v", err)
+	}
+	return transformer
+}
+
+// getRaw returns the value of key as it is stored in etcd.
+func getRaw(t *testing.T, client *clientv3.Client, key string) []byte {
+	t.Helper()
+	resp, err := client.Get(context.TODO(), key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(resp.Kvs) == 0 {
+		t.Fatalf("expected %s to exist", key)
+	}
+	return resp.Kvs[0].Value
+}
+
+// noopUpdate returns the object unchanged.
+func noopUpdate(obj runtime.Object) (runtime.Object, error) {
+	return obj, nil
+}
+
+func TestEncryptedValues(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	client := cluster.RandClient()
+	ctx := context.Background()
+
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("1"), 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("2"), 32))
+	plain := newTestStore(t, client, value.IdentityTransformer)
+	encrypted := newTestStore(t, client, newTestTransformer(t, fmt.Sprintf(`
+providers:
+- aesgcm:
+    keys:
+    - name: key1
+      secret: %s
+- identity: {}
+`, key1)))
+	rotated := newTestStore(t, client, newTestTransformer(t, fmt.Sprintf(`
+providers:
+- aesgcm:
+    keys:
+    - name: key2
+      secret: %s
+    - name: key1
+      secret: %s
+- identity: {}
+`, key2, key1)))
+
+	// bob is written before encryption is enabled
+	bob := &model.User{}
+	if err := plain.Create(ctx, "/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, bob, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if raw := getRaw(t, client, "/registry/users/bob"); !json.Valid(raw) {
+		t.Fatalf("expected plain JSON in etcd, got %q", raw)
+	}
+
+	alice := &model.User{}
+	if err := encrypted.Create(ctx, "/users/alice", &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Roles: []string{"admin"}}, alice, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	raw := getRaw(t, client, "/registry/users/alice")
+	if json.Valid(raw) || bytes.Contains(raw, []byte("alice")) || bytes.Contains(raw, []byte("admin")) {
+		t.Errorf("expected the value in etcd not to be plain JSON, got %q", raw)
+	}
+	if prefix := []byte("k8s:enc:aesgcm:v1:key1:"); !bytes.HasPrefix(raw, prefix) {
+		t.Errorf("expected the value in etcd to start with %q, got %q", prefix, raw)
+	}
+	got := &model.User{}
+	if err := encrypted.Get(ctx, "/users/alice", storage.GetOptions{}, got); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Name != "alice" || len(got.Roles) != 1 || got.Roles[0] != "admin" {
+		t.Errorf("expected alice to be decrypted, got %+v", got)
+	}
+
+	// the value is bound to its key, a copy to another key is not read
+	if _, err := client.Put(ctx, "/registry/users/carol", string(raw)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := encrypted.Get(ctx, "/users/carol", storage.GetOptions{}, &model.User{}); !storage.IsInternalError(err) {
+		t.Errorf("expected a value copied to another key to fail, got %v", err)
+	}
+	if _, err := client.Delete(ctx, "/registry/users/carol"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// the plain text of bob is read, and encrypted on its next update
+	got = &model.User{}
+	if err := encrypted.GuaranteedUpdate(ctx, "/users/bob", got, false, nil, storage.SimpleUpdate(noopUpdate), nil); err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	if got.ResourceVersion == bob.ResourceVersion {
+		t.Errorf("expected the stale plain text to be rewritten")
+	}
+	if raw := getRaw(t, client, "/registry/users/bob"); !bytes.HasPrefix(raw, []byte("k8s:enc:aesgcm:v1:key1:")) {
+		t.Errorf("expected bob to be encrypted, got %q", raw)
+	}
+
+	// after the rotation alice is read with key1, and rewritten with key2
+	got = &model.User{}
+	if err := rotated.GuaranteedUpdate(ctx, "/users/alice", got, false, nil, storage.SimpleUpdate(noopUpdate), nil); err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	if got.ResourceVersion == alice.ResourceVersion || got.Roles[0] != "admin" {
+		t.Errorf("expected the value of key1 to be rewritten, got %+v", got)
+	}
+	if raw := getRaw(t, client, "/registry/users/alice"); !bytes.HasPrefix(raw, []byte("k8s:enc:aesgcm:v1:key2:")) {
+		t.Errorf("expected alice to be encrypted with key2, got %q", raw)
+	}
+	// a value of the first key is not rewritten
+	again := &model.User{}
+	if err := rotated.GuaranteedUpdate(ctx, "/users/alice", again, false, nil, storage.SimpleUpdate(noopUpdate), nil); err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	if again.ResourceVersion != got.ResourceVersion {
+		t.Errorf("expected a no-op update of a current value not to write, got resource version %s, was %s", again.ResourceVersion, got.ResourceVersion)
+	}
+
+	list := &model.UserList{}
+	if err := rotated.List(ctx, "/users", storage.ListOptions{Predicate: storage.Everything}, list); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("expected 2 users, got %+v", list.Items)
+	}
+	// the old configuration can not read the values of key2
+	if err := encrypted.Get(ctx, "/users/alice", storage.GetOptions{}, &model.User{}); err == nil {
+		t.Errorf("expected the value of key2 not to be read without it")
+	}
+}
+
+func newTestRole(name string) *model.Role {
+	return &model.Role{
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Rules:      []model.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{model.GroupName}, Resources: []string{"users"}}},
+	}
+}
+
+func newTestRoleBinding(name string) *model.RoleBinding {
+	return &model.RoleBinding{
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Subjects:   []model.Subject{{Kind: model.UserKind, APIGroup: model.GroupName, Name: "alice"}},
+		RoleRef:    model.RoleRef{APIGroup: model.GroupName, Kind: model.RoleKind, Name: name},
+	}
+}
+
+// getRevision returns the ModRevision of key, 0 if it does not exist.
+func getRevision(t *testing.T, client *clientv3.Client, key string) int64 {
+	t.Helper()
+	resp, err := client.Get(context.TODO(), key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return 0
+	}
+	return resp.Kvs[0].ModRevision
+}
+
+func TestTxnCommit(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	client := cluster.RandClient()
+	store := newTestStore(t, client, value.IdentityTransformer)
+	ctx := context.Background()
+
+	// a role and its binding are created together
+	role, binding := &model.Role{}, &model.RoleBinding{}
+	err := store.Txn(ctx).
+		Create("/roles/admin", newTestRole("admin"), role, 0).
+		Create("/rolebindings/admin", newTestRoleBinding("admin"), binding, 0).
+		Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if role.ResourceVersion == "" || role.ResourceVersion != binding.ResourceVersion {
+		t.Errorf("expected the objects to be created at the same revision, got %q and %q", role.ResourceVersion, binding.ResourceVersion)
+	}
+	stored := &model.RoleBinding{}
+	if err := store.Get(ctx, "/rolebindings/admin", storage.GetOptions{}, stored); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if stored.RoleRef.Name != "admin" || stored.ResourceVersion != binding.ResourceVersion {
+		t.Errorf("expected the created binding, got %#v", stored)
+	}
+
+	// an update based on the current version, an unconditional update and
+	// a delete
+	if err := store.Create(ctx, "/roles/viewer", newTestRole("viewer"), nil, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	role.Rules[0].Verbs = []string{"get", "list"}
+	updated := &model.Role{}
+	deleted := &model.RoleBinding{}
+	err = store.Txn(ctx).
+		Update("/roles/admin", role, updated, 0).
+		Update("/roles/viewer", newTestRole("viewer"), nil, 0).
+		Delete("/rolebindings/admin", deleted, nil).
+		Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if len(updated.Rules[0].Verbs) != 2 || updated.ResourceVersion == role.ResourceVersion {
+		t.Errorf("expected the updated role at a new revision, got %#v", updated)
+	}
+	if deleted.Name != "admin" || deleted.ResourceVersion != binding.ResourceVersion {
+		t.Errorf("expected the deleted binding to be returned, got %#v", deleted)
+	}
+	if rev := getRevision(t, client, "/registry/rolebindings/admin"); rev != 0 {
+		t.Errorf("expected the binding to be deleted, got revision %d", rev)
+	}
+	if a, v := getRevision(t, client, "/registry/roles/admin"), getRevision(t, client, "/registry/roles/viewer"); a != v {
+		t.Errorf("expected the roles to be updated at the same revision, got %d and %d", a, v)
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	client := cluster.RandClient()
+	store := newTestStore(t, client, value.IdentityTransformer)
+	ctx := context.Background()
+
+	existing := &model.Role{}
+	if err := store.Create(ctx, "/roles/existing", newTestRole("existing"), existing, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	stale := *existing
+	if err := store.GuaranteedUpdate(ctx, "/roles/existing", &model.Role{}, false, nil, storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+		obj.(*model.Role).Rules[0].Verbs = []string{"list"}
+		return obj, nil
+	}), nil); err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	otherUID := meta.UID("other")
+
+	testCases := []struct {
+		name string
+		txn  func(txn storage.Txn) storage.Txn
+		// check returns whether the error of the transaction is expected.
+		check func(err error) bool
+		// msg is the operation the error is expected to name.
+		msg string
+	}{
+		{
+			name: "create of an existing key",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.
+					Create("/rolebindings/existing", newTestRoleBinding("existing"), nil, 0).
+					Create("/roles/existing", newTestRole("existing"), nil, 0)
+			},
+			check: storage.IsNodeExist,
+			msg:   "operation 1 (create)",
+		},
+		{
+			name: "update of a stale version",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.
+					Create("/rolebindings/existing", newTestRoleBinding("existing"), nil, 0).
+					Update("/roles/existing", &stale, nil, 0)
+			},
+			check: storage.IsConflict,
+			msg:   "operation 1 (update)",
+		},
+		{
+			name: "update of a missing key",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.
+					Update("/roles/missing", newTestRole("missing"), nil, 0).
+					Create("/rolebindings/existing", newTestRoleBinding("existing"), nil, 0)
+			},
+			check: storage.IsNotFound,
+			msg:   "operation 0 (update)",
+		},
+		{
+			name: "delete with failed preconditions",
+			txn: func(txn storage.Txn) storage.Txn {
+				return txn.
+					Create("/rolebindings/existing", newTestRoleBinding("existing"), nil, 0).
+					Delete("/roles/existing", &model.Role{}, &storage.Preconditions{UID: &otherUID})
+			},
+			check: storage.IsInvalidObj,
+			msg:   "operation 1 (delete)",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rev := getRevision(t, client, "/registry/roles/existing")
+			err := tc.txn(store.Txn(ctx)).Commit()
+			if !tc.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.msg) {
+				t.Errorf("expected the error to name %q, got %v", tc.msg, err)
+			}
+			// none of the operations is applied
+			if got := getRevision(t, client, "/registry/roles/existing"); got != rev {
+				t.Errorf("expected the role to be left at revision %d, got %d", rev, got)
+			}
+			if got := getRevision(t, client, "/registry/rolebindings/existing"); got != 0 {
+				t.Errorf("expected the binding not to be created, got revision %d", got)
+			}
+		})
+	}
+}
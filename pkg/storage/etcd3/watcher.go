@@ -12,9 +12,13 @@ import (
 
 	"github.com/x893675/opa-server/pkg/runtime"
 	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/etcd3/metrics"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
 	"github.com/x893675/opa-server/pkg/watch"
 	"go.etcd.io/etcd/clientv3"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -48,12 +52,12 @@ func TestOnlySetFatalOnDecodeError(b bool) {
 }
 
 type watcher struct {
-	client     *clientv3.Client
-	codec      runtime.Codec
-	newFunc    func() runtime.Object
-	objectType string
-	versioner  storage.Versioner
-	//transformer value.Transformer
+	client      *clientv3.Client
+	codec       runtime.Codec
+	newFunc     func() runtime.Object
+	objectType  string
+	versioner   storage.Versioner
+	transformer value.Transformer
 }
 
 // watchChan implements watch.Interface.
@@ -71,13 +75,13 @@ type watchChan struct {
 	errChan           chan error
 }
 
-func newWatcher(client *clientv3.Client, codec runtime.Codec, newFunc func() runtime.Object, versioner storage.Versioner) *watcher {
+func newWatcher(client *clientv3.Client, codec runtime.Codec, newFunc func() runtime.Object, versioner storage.Versioner, transformer value.Transformer) *watcher {
 	res := &watcher{
-		client:    client,
-		codec:     codec,
-		newFunc:   newFunc,
-		versioner: versioner,
-		//transformer: transformer,
+		client:      client,
+		codec:       codec,
+		newFunc:     newFunc,
+		versioner:   versioner,
+		transformer: transformer,
 	}
 	if newFunc == nil {
 		res.objectType = "<unknown>"
@@ -193,12 +197,13 @@ func (wc *watchChan) sync() error {
 }
 
 // logWatchChannelErr checks whether the error is about mvcc revision compaction which is regarded as warning
-func logWatchChannelErr(err error) {
+func (wc *watchChan) logWatchChannelErr(err error) {
 	if !strings.Contains(err.Error(), "mvcc: required revision has been compacted") {
-		// TODO: log error
-		//klog.Errorf("watch chan error: %v", err)
+		klog.ErrorS(err, "Watch chan error", "key", wc.key, "objectType", wc.watcher.objectType)
 	} else {
-		//klog.Warningf("watch chan error: %v", err)
+		// the history is compacted periodically, the client has to
+		// start over from a fresh list
+		klog.V(2).InfoS("Watch chan error", "err", err, "key", wc.key, "objectType", wc.watcher.objectType)
 	}
 }
 
@@ -208,8 +213,7 @@ func logWatchChannelErr(err error) {
 func (wc *watchChan) startWatching(watchClosedCh chan struct{}) {
 	if wc.initialRev == 0 {
 		if err := wc.sync(); err != nil {
-			// TODO: log error
-			//klog.Errorf("failed to sync with latest state: %v", err)
+			klog.ErrorS(err, "Failed to sync with latest state", "key", wc.key, "objectType", wc.watcher.objectType)
 			wc.sendError(err)
 			return
 		}
@@ -226,20 +230,20 @@ func (wc *watchChan) startWatching(watchClosedCh chan struct{}) {
 		if wres.Err() != nil {
 			err := wres.Err()
 			// If there is an error on server (e.g. compaction), the channel will return it before closed.
-			logWatchChannelErr(err)
+			wc.logWatchChannelErr(err)
 			wc.sendError(err)
 			return
 		}
 		if wres.IsProgressNotify() {
 			wc.sendEvent(progressNotifyEvent(wres.Header.GetRevision()))
-			//metrics.RecordEtcdBookmark(wc.watcher.objectType)
+			metrics.RecordEtcdBookmark(wc.watcher.objectType)
 			continue
 		}
 
 		for _, e := range wres.Events {
 			parsedEvent, err := parseEvent(e)
 			if err != nil {
-				logWatchChannelErr(err)
+				wc.logWatchChannelErr(err)
 				wc.sendError(err)
 				return
 			}
@@ -264,9 +268,10 @@ func (wc *watchChan) processEvent(wg *sync.WaitGroup) {
 			if res == nil {
 				continue
 			}
+			metrics.RecordEtcdWatchChannelBacklog("outgoing", wc.watcher.objectType, len(wc.resultChan))
 			if len(wc.resultChan) == outgoingBufSize {
-				// TODO: log error
-				//klog.V(3).InfoS("Fast watcher, slow processing. Probably caused by slow dispatching events to watchers", "outgoingEvents", outgoingBufSize)
+				klog.V(3).InfoS("Fast watcher, slow processing. Probably caused by slow dispatching events to watchers",
+					"outgoingEvents", outgoingBufSize, "objectType", wc.watcher.objectType)
 			}
 			// If user couldn't receive results fast enough, we also block incoming events from watcher.
 			// Because storing events in local will cause more memory usage.
@@ -298,8 +303,7 @@ func (wc *watchChan) acceptAll() bool {
 func (wc *watchChan) transform(e *event) (res *watch.Event) {
 	curObj, oldObj, err := wc.prepareObjs(e)
 	if err != nil {
-		// TODO: log error
-		//klog.Errorf("failed to prepare current and previous objects: %v", err)
+		klog.ErrorS(err, "Failed to prepare current and previous objects", "key", e.key, "rev", e.rev, "objectType", wc.watcher.objectType)
 		wc.sendError(err)
 		return nil
 	}
@@ -311,8 +315,7 @@ func (wc *watchChan) transform(e *event) (res *watch.Event) {
 		}
 		object := wc.watcher.newFunc()
 		if err := wc.watcher.versioner.UpdateObject(object, uint64(e.rev)); err != nil {
-			// TODO: log error
-			//klog.Errorf("failed to propagate object version: %v", err)
+			klog.ErrorS(err, "Failed to propagate object version", "rev", e.rev, "objectType", wc.watcher.objectType)
 			return nil
 		}
 		res = &watch.Event{
@@ -371,11 +374,9 @@ func transformErrorToEvent(err error) *watch.Event {
 	if _, ok := err.(apierrors.APIStatus); !ok {
 		err = apierrors.NewInternalError(err)
 	}
-	//TODO
-	//status := err.(apierrors.APIStatus).Status()
 	return &watch.Event{
-		Type: watch.Error,
-		//Object: &status,
+		Type:   watch.Error,
+		Object: &meta.Status{Status: err.(apierrors.APIStatus).Status()},
 	}
 }
 
@@ -387,9 +388,10 @@ func (wc *watchChan) sendError(err error) {
 }
 
 func (wc *watchChan) sendEvent(e *event) {
+	metrics.RecordEtcdWatchChannelBacklog("incoming", wc.watcher.objectType, len(wc.incomingEventChan))
 	if len(wc.incomingEventChan) == incomingBufSize {
-		//TODO: log error
-		//klog.V(3).InfoS("Fast watcher, slow processing. Probably caused by slow decoding, user not receiving fast, or other processing logic", "incomingEvents", incomingBufSize)
+		klog.V(3).InfoS("Fast watcher, slow processing. Probably caused by slow decoding, user not receiving fast, or other processing logic",
+			"incomingEvents", incomingBufSize, "objectType", wc.watcher.objectType)
 	}
 	select {
 	case wc.incomingEventChan <- e:
@@ -404,11 +406,11 @@ func (wc *watchChan) prepareObjs(e *event) (curObj runtime.Object, oldObj runtim
 	}
 
 	if !e.isDeleted {
-		//data, _, err := wc.watcher.transformer.TransformFromStorage(e.value, authenticatedDataString(e.key))
-		//if err != nil {
-		//	return nil, nil, err
-		//}
-		curObj, err = decodeObj(wc.watcher.codec, wc.watcher.versioner, e.value, e.rev)
+		data, _, err := wc.watcher.transformer.TransformFromStorage(e.value, authenticatedDataString(e.key))
+		if err != nil {
+			return nil, nil, err
+		}
+		curObj, err = decodeObj(wc.watcher.codec, wc.watcher.versioner, wc.watcher.newFunc, data, e.rev)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -419,13 +421,13 @@ func (wc *watchChan) prepareObjs(e *event) (curObj runtime.Object, oldObj runtim
 	// we need the object only to compute whether it was filtered out
 	// before).
 	if len(e.prevValue) > 0 && (e.isDeleted || !wc.acceptAll()) {
-		//data, _, err := wc.watcher.transformer.TransformFromStorage(e.prevValue, authenticatedDataString(e.key))
-		//if err != nil {
-		//	return nil, nil, err
-		//}
+		data, _, err := wc.watcher.transformer.TransformFromStorage(e.prevValue, authenticatedDataString(e.key))
+		if err != nil {
+			return nil, nil, err
+		}
 		// Note that this sends the *old* object with the etcd revision for the time at
 		// which it gets deleted.
-		oldObj, err = decodeObj(wc.watcher.codec, wc.watcher.versioner, e.value, e.rev)
+		oldObj, err = decodeObj(wc.watcher.codec, wc.watcher.versioner, wc.watcher.newFunc, data, e.rev)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -433,8 +435,10 @@ func (wc *watchChan) prepareObjs(e *event) (curObj runtime.Object, oldObj runtim
 	return curObj, oldObj, nil
 }
 
-func decodeObj(codec runtime.Codec, versioner storage.Versioner, data []byte, rev int64) (_ runtime.Object, err error) {
-	obj, err := runtime.Decode(codec, []byte(data))
+func decodeObj(codec runtime.Codec, versioner storage.Versioner, newFunc func() runtime.Object, data []byte, rev int64) (_ runtime.Object, err error) {
+	// the codec has no scheme to look the type up, so decode into a fresh
+	// instance of the watched type
+	obj, err := codec.Decode(data, newFunc())
 	if err != nil {
 		if fatalOnDecodeError {
 			// catch watch decode error iff we caused it on
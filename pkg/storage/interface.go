@@ -6,6 +6,7 @@ import (
 
 	"github.com/x893675/opa-server/pkg/runtime"
 	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 )
@@ -162,7 +163,7 @@ type Interface interface {
 	// (e.g. reconnecting without missing any updates).
 	// If resource version is "0", this interface will get current object at given key
 	// and send it in an "ADDED" event, before watch starts.
-	//Watch(ctx context.Context, key string, opts ListOptions) (watch.Interface, error)
+	Watch(ctx context.Context, key string, opts ListOptions) (watch.Interface, error)
 
 	// WatchList begins watching the specified key's items. Items are decoded into API
 	// objects and any item selected by 'p' are sent down to returned watch.Interface.
@@ -171,7 +172,7 @@ type Interface interface {
 	// (e.g. reconnecting without missing any updates).
 	// If resource version is "0", this interface will list current objects directory defined by key
 	// and send them in "ADDED" events, before watch starts.
-	//WatchList(ctx context.Context, key string, opts ListOptions) (watch.Interface, error)
+	WatchList(ctx context.Context, key string, opts ListOptions) (watch.Interface, error)
 
 	// Get unmarshals json found at key into objPtr. On a not found error, will either
 	// return a zero object of the requested type, or an error, depending on 'opts.ignoreNotFound'.
@@ -229,6 +230,51 @@ type Interface interface {
 
 	// Count returns number of different entries under the key (generally being path prefix).
 	Count(key string) (int64, error)
+
+	// Txn returns a transaction of operations on several keys that are applied
+	// atomically when it is committed: either all of them succeed, or none is
+	// applied. The keys are relative to the same prefix as the keys of the other
+	// methods, so the objects of other resources kept in the same backend may be
+	// written in the transaction too.
+	//
+	// Example:
+	//
+	// err := s.Txn(ctx).
+	//     Create("/roles/admin", role, nil, 0).
+	//     Create("/rolebindings/admin", binding, nil, 0).
+	//     Commit()
+	Txn(ctx context.Context) Txn
+}
+
+// Txn collects operations on different keys which are applied all together,
+// or not at all, by Commit. Each key may be used by a single operation of
+// the transaction.
+type Txn interface {
+	// Create adds a new object at key, the transaction fails with a KeyExists
+	// storage error if it already exists. 'ttl' is time-to-live in seconds (0
+	// means forever). If the transaction succeeds and out is not nil, out will
+	// be set to the created object.
+	Create(key string, obj, out runtime.Object, ttl uint64) Txn
+
+	// Update replaces the object at key with obj. If obj has a resource version,
+	// the transaction fails with a ResourceVersionConflicts storage error unless
+	// the stored object is still at that version, otherwise the object is
+	// replaced whatever its current version. The transaction fails with a
+	// NotFound storage error if key doesn't exist. 'ttl' is time-to-live in
+	// seconds (0 means forever). If the transaction succeeds and out is not
+	// nil, out will be set to the updated object.
+	Update(key string, obj, out runtime.Object, ttl uint64) Txn
+
+	// Delete removes the object at key, out is set to the value that existed
+	// at that spot. The transaction fails with a NotFound storage error if key
+	// doesn't exist, or with the error of preconditions if they are not
+	// fulfilled.
+	Delete(key string, out runtime.Object, preconditions *Preconditions) Txn
+
+	// Commit applies the operations of the transaction. If an operation fails,
+	// none is applied and the returned error, usually a StorageError, names the
+	// operation that failed.
+	Commit() error
 }
 
 // GetOptions provides the options that may be provided for storage get operations.
@@ -0,0 +1,85 @@
+// Package kvstore implements storage.Interface on top of a multi-version
+// key value Backend, the same way package etcd3 does on etcd. The backends
+// live in their own packages, memory keeps the keys in the process and bolt
+// persists them to a bbolt file.
+package kvstore
+
+import (
+	"errors"
+)
+
+// ErrCompacted is returned by a backend when the requested revision has been
+// compacted.
+var ErrCompacted = errors.New("required revision has been compacted")
+
+// KeyValue is a version of a key. The json tags let a backend persist it.
+type KeyValue struct {
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"createRevision"`
+	ModRevision    int64  `json:"modRevision"`
+}
+
+// TxnOp is a conditional write of a transaction.
+type TxnOp struct {
+	Key   string
+	Value []byte
+	// ModRev is the revision the current version of Key has to be last
+	// modified at, 0 meaning that Key must not exist.
+	ModRev int64
+	TTL    uint64
+	// Delete is set if Key is deleted rather than written.
+	Delete bool
+}
+
+// Matches returns true if prev, the current version of the key of op,
+// fulfills the condition of op.
+func (op TxnOp) Matches(prev *KeyValue) bool {
+	if prev == nil {
+		return op.ModRev == 0 && !op.Delete
+	}
+	return prev.ModRevision == op.ModRev
+}
+
+// Backend is a key value store that, like etcd, keeps a single
+// monotonically increasing revision that is bumped by every write and is
+// used as the resource version of the stored objects. Every write is
+// recorded as an Event, the history of the last revisions serves lists at
+// an older resource version and the watches that start in the past.
+//
+// Reads at a revision that is newer than the current one fail with a
+// "too large resource version" error, reads at a compacted revision with
+// ErrCompacted.
+type Backend interface {
+	// Get returns the version of key at rev, or at the current revision if
+	// rev is 0, and the revision it was read at. The version is nil if key
+	// did not exist.
+	Get(key string, rev int64) (*KeyValue, int64, error)
+	// List returns up to limit keys with prefix, starting at the key from,
+	// at rev or at the current revision if rev is 0. count is the number of
+	// keys with prefix starting at from, regardless of limit. A limit of 0
+	// means no limit.
+	List(prefix, from string, rev, limit int64) (kvs []*KeyValue, count, readRev int64, err error)
+	// Put writes value to key if the current version of key was last
+	// modified at modRev, 0 meaning that key must not exist. If ttl is not 0
+	// the key is deleted after ttl seconds unless it is written again in
+	// between. It returns the revision of the write, or the current version
+	// of the key if it was modified at another revision.
+	Put(key string, value []byte, modRev int64, ttl uint64) (rev int64, cur *KeyValue, ok bool, err error)
+	// Remove deletes key if it was last modified at modRev. It returns the
+	// revision of the delete, or the current version of the key if it does
+	// not exist or was modified at another revision.
+	Remove(key string, modRev int64) (rev int64, cur *KeyValue, ok bool, err error)
+	// Txn applies ops, which must have different keys, at a single revision
+	// if all of their conditions are fulfilled. It returns the revision of
+	// the writes, or the index of the first op whose condition failed and
+	// the current version of its key.
+	Txn(ops []TxnOp) (rev int64, failed int, cur *KeyValue, ok bool, err error)
+	// Snapshot returns the current versions of key, or of all keys with the
+	// prefix key if recursive is set, and the current revision.
+	Snapshot(key string, recursive bool) (kvs []*KeyValue, rev int64, err error)
+	// EventsAfter returns the next events after rev and a channel that is
+	// closed on the next write. The changes of a transaction are never
+	// split across two calls.
+	EventsAfter(rev int64) ([]*Event, <-chan struct{}, error)
+}
@@ -0,0 +1,32 @@
+package kvstore
+
+// Event is a change of a key at a revision of a backend.
+type Event struct {
+	Key string
+	// Value is the value written at Rev, nil if the key was deleted.
+	Value []byte
+	// PrevValue is the value before Rev, nil if the key was created.
+	PrevValue []byte
+	Rev       int64
+	IsDeleted bool
+	IsCreated bool
+
+	isProgressNotify bool
+}
+
+// parseKV converts a KeyValue retrieved from an initial snapshot to a synthetic IsCreated event.
+func parseKV(kv *KeyValue) *Event {
+	return &Event{
+		Key:       kv.Key,
+		Value:     kv.Value,
+		Rev:       kv.ModRevision,
+		IsCreated: true,
+	}
+}
+
+func progressNotifyEvent(rev int64) *Event {
+	return &Event{
+		Rev:              rev,
+		isProgressNotify: true,
+	}
+}
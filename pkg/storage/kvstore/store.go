@@ -0,0 +1,856 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/klog/v2"
+)
+
+// authenticatedDataString satisfies the value.Context interface. It uses the key to
+// authenticate the stored data, an encrypted value copied to another key
+// can not be read.
+type authenticatedDataString string
+
+// AuthenticatedData implements the value.Context interface.
+func (d authenticatedDataString) AuthenticatedData() []byte {
+	return []byte(d)
+}
+
+var _ value.Context = authenticatedDataString("")
+
+type store struct {
+	backend       Backend
+	codec         runtime.Codec
+	newFunc       func() runtime.Object
+	objectType    string
+	versioner     storage.Versioner
+	transformer   value.Transformer
+	pathPrefix    string
+	pagingEnabled bool
+}
+
+type objState struct {
+	obj   runtime.Object
+	meta  *storage.ResponseMeta
+	rev   int64
+	data  []byte
+	stale bool
+}
+
+// txnOpState is the state of an operation of a transaction.
+type txnOpState struct {
+	// data is the encoded object written by the operation, or the deleted one.
+	data []byte
+	// fixed is set if the revision the key has to be last modified at is
+	// given by the operation rather than read from the backend, a conflict is
+	// then an error of the transaction instead of a reason to retry it.
+	fixed bool
+}
+
+// New returns an implementation of storage.Interface that keeps its objects
+// in backend. The values are transformed with transformer before they are
+// written to the backend.
+func New(backend Backend, codec runtime.Codec, newFunc func() runtime.Object, prefix string, transformer value.Transformer, pagingEnabled bool) storage.Interface {
+	return newStore(backend, codec, newFunc, prefix, transformer, pagingEnabled)
+}
+
+func newStore(backend Backend, codec runtime.Codec, newFunc func() runtime.Object, prefix string, transformer value.Transformer, pagingEnabled bool) *store {
+	s := &store{
+		backend:       backend,
+		codec:         codec,
+		newFunc:       newFunc,
+		versioner:     storage.APIObjectVersioner{},
+		transformer:   transformer,
+		pathPrefix:    path.Join("/", prefix),
+		pagingEnabled: pagingEnabled,
+	}
+	if newFunc == nil {
+		s.objectType = "<unknown>"
+	} else {
+		s.objectType = reflect.TypeOf(newFunc()).String()
+	}
+	return s
+}
+
+// Versioner implements storage.Interface.Versioner.
+func (s *store) Versioner() storage.Versioner {
+	return s.versioner
+}
+
+// Get implements storage.Interface.Get.
+func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, out runtime.Object) error {
+	key = path.Join(s.pathPrefix, key)
+	kv, rev, err := s.backend.Get(key, 0)
+	if err != nil {
+		return err
+	}
+	if err = s.validateMinimumResourceVersion(opts.ResourceVersion, uint64(rev)); err != nil {
+		return err
+	}
+
+	if kv == nil {
+		if opts.IgnoreNotFound {
+			return out.SetZeroValue()
+		}
+		return storage.NewKeyNotFoundError(key, 0)
+	}
+
+	data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(key))
+	if err != nil {
+		return storage.NewInternalError(err.Error())
+	}
+
+	return decode(s.codec, s.versioner, data, out, kv.ModRevision)
+}
+
+// Create implements storage.Interface.Create.
+func (s *store) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	if version, err := s.versioner.ObjectResourceVersion(obj); err == nil && version != 0 {
+		return errors.New("resourceVersion should not be set on objects to be created")
+	}
+	if err := s.versioner.PrepareObjectForStorage(obj); err != nil {
+		return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+	}
+	data, err := runtime.Encode(s.codec, obj)
+	if err != nil {
+		return err
+	}
+	key = path.Join(s.pathPrefix, key)
+
+	newData, err := s.transformer.TransformToStorage(data, authenticatedDataString(key))
+	if err != nil {
+		return storage.NewInternalError(err.Error())
+	}
+
+	rev, _, ok, err := s.backend.Put(key, newData, 0, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return storage.NewKeyExistsError(key, 0)
+	}
+
+	if out != nil {
+		return decode(s.codec, s.versioner, data, out, rev)
+	}
+	return nil
+}
+
+// Delete implements storage.Interface.Delete.
+func (s *store) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
+	v, err := conversion.EnforcePtr(out)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	key = path.Join(s.pathPrefix, key)
+	return s.conditionalDelete(ctx, key, out, v, preconditions, validateDeletion, cachedExistingObject)
+}
+
+func (s *store) conditionalDelete(
+	ctx context.Context, key string, out runtime.Object, v reflect.Value, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
+	getCurrentState := func() (*objState, error) {
+		kv, _, err := s.backend.Get(key, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.getState(kv, key, v, false)
+	}
+
+	var origState *objState
+	var err error
+	var origStateIsCurrent bool
+	if cachedExistingObject != nil {
+		origState, err = s.getStateFromObject(cachedExistingObject)
+	} else {
+		origState, err = getCurrentState()
+		origStateIsCurrent = true
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		if preconditions != nil {
+			if err := preconditions.Check(key, origState.obj); err != nil {
+				if origStateIsCurrent {
+					return err
+				}
+
+				// It's possible we're working with stale data.
+				// Actually fetch
+				origState, err = getCurrentState()
+				if err != nil {
+					return err
+				}
+				origStateIsCurrent = true
+				// Retry
+				continue
+			}
+		}
+		if err := validateDeletion(ctx, origState.obj); err != nil {
+			if origStateIsCurrent {
+				return err
+			}
+
+			// It's possible we're working with stale data.
+			// Actually fetch
+			origState, err = getCurrentState()
+			if err != nil {
+				return err
+			}
+			origStateIsCurrent = true
+			// Retry
+			continue
+		}
+
+		_, kv, ok, err := s.backend.Remove(key, origState.rev)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			klog.V(4).InfoS("Deletion of object failed because of a conflict, going to retry", "key", key)
+			origState, err = s.getState(kv, key, v, false)
+			if err != nil {
+				return err
+			}
+			origStateIsCurrent = true
+			continue
+		}
+		return decode(s.codec, s.versioner, origState.data, out, origState.rev)
+	}
+}
+
+// GuaranteedUpdate implements storage.Interface.GuaranteedUpdate.
+func (s *store) GuaranteedUpdate(
+	ctx context.Context, key string, out runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	v, err := conversion.EnforcePtr(out)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	key = path.Join(s.pathPrefix, key)
+
+	getCurrentState := func() (*objState, error) {
+		kv, _, err := s.backend.Get(key, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.getState(kv, key, v, ignoreNotFound)
+	}
+
+	var origState *objState
+	var origStateIsCurrent bool
+	if cachedExistingObject != nil {
+		origState, err = s.getStateFromObject(cachedExistingObject)
+	} else {
+		origState, err = getCurrentState()
+		origStateIsCurrent = true
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := preconditions.Check(key, origState.obj); err != nil {
+			// If our data is already up to date, return the error
+			if origStateIsCurrent {
+				return err
+			}
+
+			// It's possible we were working with stale data
+			// Actually fetch
+			origState, err = getCurrentState()
+			if err != nil {
+				return err
+			}
+			origStateIsCurrent = true
+			// Retry
+			continue
+		}
+
+		ret, ttl, err := s.updateState(origState, tryUpdate)
+		if err != nil {
+			// If our data is already up to date, return the error
+			if origStateIsCurrent {
+				return err
+			}
+
+			// It's possible we were working with stale data
+			// Actually fetch
+			origState, err = getCurrentState()
+			if err != nil {
+				return err
+			}
+			origStateIsCurrent = true
+			// Retry
+			continue
+		}
+
+		data, err := runtime.Encode(s.codec, ret)
+		if err != nil {
+			return err
+		}
+		if !origState.stale && bytes.Equal(data, origState.data) {
+			// if we skipped the original Get in this loop, we must refresh from
+			// the backend in order to be sure the data in the store is equivalent
+			// to our desired serialization
+			if !origStateIsCurrent {
+				origState, err = getCurrentState()
+				if err != nil {
+					return err
+				}
+				origStateIsCurrent = true
+				if !bytes.Equal(data, origState.data) {
+					// original data changed, restart loop
+					continue
+				}
+			}
+			// recheck that the data from the backend is not stale before short-circuiting a write
+			if !origState.stale {
+				return decode(s.codec, s.versioner, origState.data, out, origState.rev)
+			}
+		}
+
+		newData, err := s.transformer.TransformToStorage(data, authenticatedDataString(key))
+		if err != nil {
+			return storage.NewInternalError(err.Error())
+		}
+
+		rev, kv, ok, err := s.backend.Put(key, newData, origState.rev, ttl)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			klog.V(4).InfoS("GuaranteedUpdate failed because of a conflict, going to retry", "key", key)
+			origState, err = s.getState(kv, key, v, ignoreNotFound)
+			if err != nil {
+				return err
+			}
+			origStateIsCurrent = true
+			continue
+		}
+
+		return decode(s.codec, s.versioner, data, out, rev)
+	}
+}
+
+// Txn implements storage.Interface.Txn.
+func (s *store) Txn(ctx context.Context) storage.Txn {
+	return storage.NewTxn(ctx, s.commitTxn)
+}
+
+// commitTxn applies ops in a single backend transaction, comparing the
+// revision each key was last modified at to the one its operation expects.
+// The operations that do not expect a revision are based on the current
+// one, the transaction is retried if it changes in between.
+func (s *store) commitTxn(ctx context.Context, ops []storage.TxnOp) error {
+	states := make([]txnOpState, len(ops))
+	backendOps := make([]TxnOp, len(ops))
+	for i, op := range ops {
+		st, bop := &states[i], &backendOps[i]
+		bop.Key = path.Join(s.pathPrefix, op.Key)
+		if op.Type == storage.TxnOpDelete {
+			bop.Delete = true
+			continue
+		}
+
+		version, err := s.versioner.ObjectResourceVersion(op.Obj)
+		switch op.Type {
+		case storage.TxnOpCreate:
+			if err == nil && version != 0 {
+				return storage.NewTxnOpError(errors.New("resourceVersion should not be set on objects to be created"), i, op.Type)
+			}
+			st.fixed = true
+		case storage.TxnOpUpdate:
+			if err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+			bop.ModRev, st.fixed = int64(version), version != 0
+		}
+		if err := s.versioner.PrepareObjectForStorage(op.Obj); err != nil {
+			return storage.NewTxnOpError(fmt.Errorf("PrepareObjectForStorage failed: %v", err), i, op.Type)
+		}
+		if st.data, err = runtime.Encode(s.codec, op.Obj); err != nil {
+			return storage.NewTxnOpError(err, i, op.Type)
+		}
+		if bop.Value, err = s.transformer.TransformToStorage(st.data, authenticatedDataString(bop.Key)); err != nil {
+			return storage.NewTxnOpError(storage.NewInternalError(err.Error()), i, op.Type)
+		}
+		bop.TTL = op.TTL
+	}
+
+	for {
+		for i, op := range ops {
+			if states[i].fixed {
+				continue
+			}
+			if err := s.readTxnOpState(&states[i], &backendOps[i], op); err != nil {
+				return storage.NewTxnOpError(err, i, op.Type)
+			}
+		}
+
+		rev, i, kv, ok, err := s.backend.Txn(backendOps)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if states[i].fixed {
+				var modRev int64
+				if kv != nil {
+					modRev = kv.ModRevision
+				}
+				return storage.NewTxnConflictError(backendOps[i].Key, modRev, i, ops[i].Type)
+			}
+			klog.V(4).InfoS("Transaction failed because of a conflict, going to retry", "key", backendOps[i].Key)
+			continue
+		}
+
+		for i, op := range ops {
+			switch {
+			case op.Type == storage.TxnOpDelete:
+				err = decode(s.codec, s.versioner, states[i].data, op.Out, backendOps[i].ModRev)
+			case op.Out != nil:
+				err = decode(s.codec, s.versioner, states[i].data, op.Out, rev)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// readTxnOpState bases op on the current version of its key. The object a
+// delete removes is read and checked against the preconditions.
+func (s *store) readTxnOpState(st *txnOpState, bop *TxnOp, op storage.TxnOp) error {
+	kv, _, err := s.backend.Get(bop.Key, 0)
+	if err != nil {
+		return err
+	}
+	if op.Type != storage.TxnOpDelete {
+		if kv == nil {
+			return storage.NewKeyNotFoundError(bop.Key, 0)
+		}
+		bop.ModRev = kv.ModRevision
+		return nil
+	}
+
+	v, err := conversion.EnforcePtr(op.Out)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	state, err := s.getState(kv, bop.Key, v, false)
+	if err != nil {
+		return err
+	}
+	if err := op.Preconditions.Check(bop.Key, state.obj); err != nil {
+		return err
+	}
+	bop.ModRev, st.data = state.rev, state.data
+	return nil
+}
+
+// GetToList implements storage.Interface.GetToList.
+func (s *store) GetToList(ctx context.Context, key string, listOpts storage.ListOptions, listObj runtime.Object) error {
+	resourceVersion := listOpts.ResourceVersion
+	match := listOpts.ResourceVersionMatch
+	pred := listOpts.Predicate
+	listPtr, err := meta.GetItemsPtr(listObj)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(listPtr)
+	if err != nil || v.Kind() != reflect.Slice {
+		return fmt.Errorf("need ptr to slice: %v", err)
+	}
+
+	newItemFunc := getNewItemFunc(listObj, v)
+
+	key = path.Join(s.pathPrefix, key)
+	var withRev int64
+	if len(resourceVersion) > 0 && match == meta.ResourceVersionMatchExact {
+		rv, err := s.versioner.ParseResourceVersion(resourceVersion)
+		if err != nil {
+			return apierrors.NewBadRequest(fmt.Sprintf("invalid resource version: %v", err))
+		}
+		withRev = int64(rv)
+	}
+
+	kv, rev, err := s.backend.Get(key, withRev)
+	if err != nil {
+		return interpretListError(err, false, "", "")
+	}
+	if err = s.validateMinimumResourceVersion(resourceVersion, uint64(rev)); err != nil {
+		return err
+	}
+
+	if kv != nil {
+		data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(key))
+		if err != nil {
+			return storage.NewInternalError(err.Error())
+		}
+		if err := appendListItem(v, data, uint64(kv.ModRevision), pred, s.codec, s.versioner, newItemFunc); err != nil {
+			return err
+		}
+	}
+	// update version with the backend level revision
+	return s.versioner.UpdateList(listObj, uint64(rev), "", nil)
+}
+
+func getNewItemFunc(listObj runtime.Object, v reflect.Value) func() runtime.Object {
+	elem := v.Type().Elem()
+	return func() runtime.Object {
+		return reflect.New(elem).Interface().(runtime.Object)
+	}
+}
+
+func (s *store) Count(key string) (int64, error) {
+	key = path.Join(s.pathPrefix, key)
+
+	// We need to make sure the key ended with "/" so that we only get children "directories".
+	// e.g. if we have key "/a", "/a/b", "/ab", getting keys with prefix "/a" will return all three,
+	// while with prefix "/a/" will return only "/a/b" which is the correct answer.
+	if !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+
+	_, count, _, err := s.backend.List(key, key, 0, 1)
+	return count, err
+}
+
+// List implements storage.Interface.List.
+func (s *store) List(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	resourceVersion := opts.ResourceVersion
+	match := opts.ResourceVersionMatch
+	pred := opts.Predicate
+	listPtr, err := meta.GetItemsPtr(listObj)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(listPtr)
+	if err != nil || v.Kind() != reflect.Slice {
+		return fmt.Errorf("need ptr to slice: %v", err)
+	}
+
+	key = path.Join(s.pathPrefix, key)
+	// We need to make sure the key ended with "/" so that we only get children "directories".
+	// e.g. if we have key "/a", "/a/b", "/ab", getting keys with prefix "/a" will return all three,
+	// while with prefix "/a/" will return only "/a/b" which is the correct answer.
+	if !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	keyPrefix := key
+
+	var paging bool
+	var limit int64
+	if s.pagingEnabled && pred.Limit > 0 {
+		paging = true
+		limit = pred.Limit
+	}
+
+	newItemFunc := getNewItemFunc(listObj, v)
+
+	var fromRV *uint64
+	if len(resourceVersion) > 0 {
+		parsedRV, err := s.versioner.ParseResourceVersion(resourceVersion)
+		if err != nil {
+			return apierrors.NewBadRequest(fmt.Sprintf("invalid resource version: %v", err))
+		}
+		fromRV = &parsedRV
+	}
+
+	var returnedRV, continueRV, withRev int64
+	var continueKey string
+	switch {
+	case s.pagingEnabled && len(pred.Continue) > 0:
+		continueKey, continueRV, err = storage.DecodeContinue(pred.Continue, keyPrefix)
+		if err != nil {
+			return apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
+		}
+
+		if len(resourceVersion) > 0 && resourceVersion != "0" {
+			return apierrors.NewBadRequest("specifying resource version is not allowed when using continue")
+		}
+
+		key = continueKey
+
+		// If continueRV > 0, the LIST request needs a specific resource version.
+		// continueRV==0 is invalid.
+		// If continueRV < 0, the request is for the latest resource version.
+		if continueRV > 0 {
+			withRev = continueRV
+			returnedRV = continueRV
+		}
+	case s.pagingEnabled && pred.Limit > 0:
+		if fromRV != nil {
+			switch match {
+			case meta.ResourceVersionMatchNotOlderThan:
+				// The not older than constraint is checked after we get a response from the backend,
+				// and returnedRV is then set to the revision we get from the response.
+			case meta.ResourceVersionMatchExact:
+				returnedRV = int64(*fromRV)
+				withRev = returnedRV
+			case "": // legacy case
+				if *fromRV > 0 {
+					returnedRV = int64(*fromRV)
+					withRev = returnedRV
+				}
+			default:
+				return fmt.Errorf("unknown ResourceVersionMatch value: %v", match)
+			}
+		}
+	default:
+		if fromRV != nil {
+			switch match {
+			case meta.ResourceVersionMatchNotOlderThan:
+				// The not older than constraint is checked after we get a response from the backend,
+				// and returnedRV is then set to the revision we get from the response.
+			case meta.ResourceVersionMatchExact:
+				returnedRV = int64(*fromRV)
+				withRev = returnedRV
+			case "": // legacy case
+			default:
+				return fmt.Errorf("unknown ResourceVersionMatch value: %v", match)
+			}
+		}
+	}
+
+	// loop until we have filled the requested limit from the backend or there are no more results
+	var lastKey string
+	var hasMore bool
+	var count int64
+	for {
+		var kvs []*KeyValue
+		var rev int64
+		kvs, count, rev, err = s.backend.List(keyPrefix, key, withRev, limit)
+		if err != nil {
+			return interpretListError(err, len(pred.Continue) > 0, continueKey, keyPrefix)
+		}
+		if err = s.validateMinimumResourceVersion(resourceVersion, uint64(rev)); err != nil {
+			return err
+		}
+		hasMore = count > int64(len(kvs))
+
+		// take items from the response until the bucket is full, filtering as we go
+		for _, kv := range kvs {
+			if paging && int64(v.Len()) >= pred.Limit {
+				hasMore = true
+				break
+			}
+			lastKey = kv.Key
+
+			data, _, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(kv.Key))
+			if err != nil {
+				return storage.NewInternalErrorf("unable to transform key %q: %v", kv.Key, err)
+			}
+
+			if err := appendListItem(v, data, uint64(kv.ModRevision), pred, s.codec, s.versioner, newItemFunc); err != nil {
+				return err
+			}
+		}
+
+		// indicate to the client which resource version was returned
+		if returnedRV == 0 {
+			returnedRV = rev
+		}
+
+		// no more results remain or we didn't request paging
+		if !hasMore || !paging {
+			break
+		}
+		// we're paging but we have filled our bucket
+		if int64(v.Len()) >= pred.Limit {
+			break
+		}
+		key = lastKey + "\x00"
+		if withRev == 0 {
+			withRev = returnedRV
+		}
+	}
+
+	// instruct the client to begin querying from immediately after the last key we returned
+	// we never return a key that the client wouldn't be allowed to see
+	if hasMore {
+		// we want to start immediately after the last key
+		next, err := storage.EncodeContinue(lastKey+"\x00", keyPrefix, returnedRV)
+		if err != nil {
+			return err
+		}
+		var remainingItemCount *int64
+		// count counts in objects that do not match the pred.
+		// Instead of returning inaccurate count for non-empty selectors, we return nil.
+		// Only set remainingItemCount if the predicate is empty.
+		if pred.Empty() {
+			c := count - pred.Limit
+			remainingItemCount = &c
+		}
+		return s.versioner.UpdateList(listObj, uint64(returnedRV), next, remainingItemCount)
+	}
+
+	// no continuation
+	return s.versioner.UpdateList(listObj, uint64(returnedRV), "", nil)
+}
+
+// Watch implements storage.Interface.Watch.
+func (s *store) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return s.watch(ctx, key, opts, false)
+}
+
+// WatchList implements storage.Interface.WatchList.
+func (s *store) WatchList(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return s.watch(ctx, key, opts, true)
+}
+
+func (s *store) watch(ctx context.Context, key string, opts storage.ListOptions, recursive bool) (watch.Interface, error) {
+	rev, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	key = path.Join(s.pathPrefix, key)
+	if recursive && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	wc := newWatchChan(ctx, s, key, int64(rev), recursive, opts.ProgressNotify, opts.Predicate)
+	go wc.run()
+	return wc, nil
+}
+
+func (s *store) getState(kv *KeyValue, key string, v reflect.Value, ignoreNotFound bool) (*objState, error) {
+	state := &objState{
+		meta: &storage.ResponseMeta{},
+	}
+	state.obj = reflect.New(v.Type()).Interface().(runtime.Object)
+
+	if kv == nil {
+		if !ignoreNotFound {
+			return nil, storage.NewKeyNotFoundError(key, 0)
+		}
+		if err := state.obj.SetZeroValue(); err != nil {
+			return nil, err
+		}
+	} else {
+		data, stale, err := s.transformer.TransformFromStorage(kv.Value, authenticatedDataString(key))
+		if err != nil {
+			return nil, storage.NewInternalError(err.Error())
+		}
+		state.rev = kv.ModRevision
+		state.meta.ResourceVersion = uint64(state.rev)
+		state.data = data
+		state.stale = stale
+		if err := decode(s.codec, s.versioner, state.data, state.obj, state.rev); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+func (s *store) getStateFromObject(obj runtime.Object) (*objState, error) {
+	state := &objState{
+		obj:  obj,
+		meta: &storage.ResponseMeta{},
+	}
+
+	rv, err := s.versioner.ObjectResourceVersion(obj)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get resource version: %v", err)
+	}
+	state.rev = int64(rv)
+	state.meta.ResourceVersion = uint64(state.rev)
+
+	// Compute the serialized form - for that we need to temporarily clean
+	// its resource version field (those are not stored in the backend).
+	if err := s.versioner.PrepareObjectForStorage(obj); err != nil {
+		return nil, fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+	}
+	state.data, err = runtime.Encode(s.codec, obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.versioner.UpdateObject(state.obj, uint64(rv)); err != nil {
+		klog.ErrorS(err, "Failed to update object version", "rev", rv)
+	}
+	return state, nil
+}
+
+func (s *store) updateState(st *objState, userUpdate storage.UpdateFunc) (runtime.Object, uint64, error) {
+	ret, ttlPtr, err := userUpdate(st.obj, *st.meta)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := s.versioner.PrepareObjectForStorage(ret); err != nil {
+		return nil, 0, fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+	}
+	var ttl uint64
+	if ttlPtr != nil {
+		ttl = *ttlPtr
+	}
+	return ret, ttl, nil
+}
+
+// validateMinimumResourceVersion returns a 'too large resource' version error when the provided minimumResourceVersion is
+// greater than the most recent actualRevision available from storage.
+func (s *store) validateMinimumResourceVersion(minimumResourceVersion string, actualRevision uint64) error {
+	if minimumResourceVersion == "" {
+		return nil
+	}
+	minimumRV, err := s.versioner.ParseResourceVersion(minimumResourceVersion)
+	if err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid resource version: %v", err))
+	}
+	// Enforce the storage.Interface guarantee that the resource version of the returned data
+	// "will be at least 'resourceVersion'".
+	if minimumRV > actualRevision {
+		return storage.NewTooLargeResourceVersionError(minimumRV, actualRevision, 0)
+	}
+	return nil
+}
+
+// decode decodes value of bytes into object. It will also set the object resource version to rev.
+// On success, objPtr would be set to the object.
+func decode(codec runtime.Codec, versioner storage.Versioner, value []byte, objPtr runtime.Object, rev int64) error {
+	if _, err := conversion.EnforcePtr(objPtr); err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	_, err := codec.Decode(value, objPtr)
+	if err != nil {
+		return err
+	}
+	// being unable to set the version does not prevent the object from being extracted
+	if err := versioner.UpdateObject(objPtr, uint64(rev)); err != nil {
+		klog.ErrorS(err, "Failed to update object version", "rev", rev)
+	}
+	return nil
+}
+
+// appendListItem decodes and appends the object (if it passes filter) to v, which must be a slice.
+func appendListItem(v reflect.Value, data []byte, rev uint64, pred storage.SelectionPredicate, codec runtime.Codec, versioner storage.Versioner, newItemFunc func() runtime.Object) error {
+	obj, err := codec.Decode(data, newItemFunc())
+	if err != nil {
+		return err
+	}
+	// being unable to set the version does not prevent the object from being extracted
+	if err := versioner.UpdateObject(obj, rev); err != nil {
+		klog.ErrorS(err, "Failed to update object version", "rev", rev)
+	}
+	if matched, err := pred.Matches(obj); err == nil && matched {
+		v.Set(reflect.Append(v, reflect.ValueOf(obj).Elem()))
+	}
+	return nil
+}
@@ -0,0 +1,548 @@
+package kvstore_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/bolt"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// testBackends are the backends every test of the store is run against.
+var testBackends = []struct {
+	name string
+	// open returns an empty backend and the function compacting it.
+	open func(t *testing.T) (kvstore.Backend, func(rev int64) error)
+}{
+	{
+		name: "memory",
+		open: func(t *testing.T) (kvstore.Backend, func(rev int64) error) {
+			b := memory.NewBackend(0)
+			return b, b.Compact
+		},
+	},
+	{
+		name: "bolt",
+		open: func(t *testing.T) (kvstore.Backend, func(rev int64) error) {
+			b, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			t.Cleanup(func() {
+				if err := b.Close(); err != nil {
+					t.Errorf("Close failed: %v", err)
+				}
+			})
+			return b, b.Compact
+		},
+	},
+}
+
+// runBackends runs test with a store of users on each of the test backends.
+func runBackends(t *testing.T, test func(t *testing.T, s storage.Interface, compact func(rev int64) error)) {
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	newFunc := func() runtime.Object { return &model.User{} }
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			backend, compact := b.open(t)
+			test(t, kvstore.New(backend, codec, newFunc, "/registry", value.IdentityTransformer, true), compact)
+		})
+	}
+}
+
+// testCreate creates a user named name with roles and returns the stored
+// object.
+func testCreate(t *testing.T, s storage.Interface, name string, roles ...string) *model.User {
+	t.Helper()
+	out := &model.User{}
+	obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: name}, Roles: roles}
+	if err := s.Create(context.TODO(), "/users/"+name, obj, out, 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return out
+}
+
+// testUpdate sets the roles of the user named name and returns the stored
+// object.
+func testUpdate(t *testing.T, s storage.Interface, name string, roles ...string) *model.User {
+	t.Helper()
+	out := &model.User{}
+	err := s.GuaranteedUpdate(context.TODO(), "/users/"+name, out, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			user := obj.(*model.User)
+			user.Roles = roles
+			return user, nil
+		}), nil)
+	if err != nil {
+		t.Fatalf("GuaranteedUpdate failed: %v", err)
+	}
+	return out
+}
+
+func testList(s storage.Interface, rv string) (*model.UserList, error) {
+	list := &model.UserList{}
+	err := s.List(context.TODO(), "/users", storage.ListOptions{
+		ResourceVersion:      rv,
+		ResourceVersionMatch: meta.ResourceVersionMatchExact,
+		Predicate:            storage.Everything,
+	}, list)
+	return list, err
+}
+
+// testRevision returns the current revision of the backend of s.
+func testRevision(t *testing.T, s storage.Interface) int64 {
+	t.Helper()
+	list := &model.UserList{}
+	if err := s.List(context.TODO(), "/users", storage.ListOptions{Predicate: storage.Everything}, list); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	rev, err := strconv.ParseInt(list.ResourceVersion, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rev
+}
+
+func TestCreateGet(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		created := testCreate(t, s, "alice", "dev")
+		if created.ResourceVersion == "" {
+			t.Errorf("expected the created user to have a resource version")
+		}
+
+		got := &model.User{}
+		if err := s.Get(context.TODO(), "/users/alice", storage.GetOptions{}, got); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got.Name != "alice" || got.ResourceVersion != created.ResourceVersion || len(got.Roles) != 1 || got.Roles[0] != "dev" {
+			t.Errorf("expected %+v, got %+v", created, got)
+		}
+
+		obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}
+		if err := s.Create(context.TODO(), "/users/alice", obj, nil, 0); !storage.IsNodeExist(err) {
+			t.Errorf("expected creating an existing key to fail, got %v", err)
+		}
+		obj = &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob", ResourceVersion: "1"}}
+		if err := s.Create(context.TODO(), "/users/bob", obj, nil, 0); err == nil {
+			t.Errorf("expected creating an object with a resource version to fail")
+		}
+
+		if err := s.Get(context.TODO(), "/users/bob", storage.GetOptions{}, &model.User{}); !storage.IsNotFound(err) {
+			t.Errorf("expected a missing key not to be found, got %v", err)
+		}
+		got = &model.User{ObjectMeta: meta.ObjectMeta{Name: "stale"}}
+		if err := s.Get(context.TODO(), "/users/bob", storage.GetOptions{IgnoreNotFound: true}, got); err != nil || got.Name != "" {
+			t.Errorf("expected a zero value for a missing key, got %+v, %v", got, err)
+		}
+	})
+}
+
+func TestGuaranteedUpdate(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		created := testCreate(t, s, "alice", "dev")
+		updated := testUpdate(t, s, "alice", "admin")
+		if updated.ResourceVersion == created.ResourceVersion {
+			t.Errorf("expected the update to change the resource version")
+		}
+
+		// a precondition on the stale resource version is not met
+		err := s.GuaranteedUpdate(context.TODO(), "/users/alice", &model.User{}, false,
+			&storage.Preconditions{ResourceVersion: &created.ResourceVersion},
+			storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) { return obj, nil }), nil)
+		if !storage.IsInvalidObj(err) {
+			t.Errorf("expected a failed precondition, got %v", err)
+		}
+
+		// an update based on a stale cached object is retried on the current
+		// one, the cached object is owned by the store from then on
+		staleRV, currentRV := created.ResourceVersion, updated.ResourceVersion
+		var seen []string
+		out := &model.User{}
+		err = s.GuaranteedUpdate(context.TODO(), "/users/alice", out, false, nil,
+			storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+				user := obj.(*model.User)
+				seen = append(seen, user.ResourceVersion)
+				user.Roles = append(user.Roles, "ops")
+				return user, nil
+			}), created)
+		if err != nil {
+			t.Fatalf("GuaranteedUpdate failed: %v", err)
+		}
+		if len(seen) != 2 || seen[0] != staleRV || seen[1] != currentRV {
+			t.Errorf("expected the update to be tried on %s then %s, got %v", staleRV, currentRV, seen)
+		}
+		if len(out.Roles) != 2 || out.Roles[0] != "admin" || out.Roles[1] != "ops" {
+			t.Errorf("expected the roles [admin ops], got %v", out.Roles)
+		}
+
+		// concurrent updates conflict, but none of them is lost
+		const updates = 10
+		var wg sync.WaitGroup
+		for i := 0; i < updates; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := s.GuaranteedUpdate(context.TODO(), "/users/bob", &model.User{}, true, nil,
+					storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+						user := obj.(*model.User)
+						user.Name = "bob"
+						user.Roles = append(user.Roles, strconv.Itoa(i))
+						return user, nil
+					}), nil)
+				if err != nil {
+					t.Errorf("GuaranteedUpdate failed: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		bob := &model.User{}
+		if err := s.Get(context.TODO(), "/users/bob", storage.GetOptions{}, bob); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if len(bob.Roles) != updates {
+			t.Errorf("expected %d roles, got %v", updates, bob.Roles)
+		}
+
+		err = s.GuaranteedUpdate(context.TODO(), "/users/carol", &model.User{}, false, nil,
+			storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) { return obj, nil }), nil)
+		if !storage.IsNotFound(err) {
+			t.Errorf("expected updating a missing key to fail, got %v", err)
+		}
+	})
+}
+
+func TestDelete(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		created := testCreate(t, s, "alice", "dev")
+		testUpdate(t, s, "alice", "admin")
+
+		// a deletion based on a stale cached object conflicts, it is
+		// validated again against the current object
+		var seen [][]string
+		out := &model.User{}
+		err := s.Delete(context.TODO(), "/users/alice", out, nil, func(ctx context.Context, obj runtime.Object) error {
+			seen = append(seen, obj.(*model.User).Roles)
+			return nil
+		}, created)
+		if err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if len(seen) != 2 || seen[0][0] != "dev" || seen[1][0] != "admin" {
+			t.Errorf("expected the deletion to be validated against the roles [dev] then [admin], got %v", seen)
+		}
+		if len(out.Roles) != 1 || out.Roles[0] != "admin" {
+			t.Errorf("expected the deleted user to be returned, got %+v", out)
+		}
+		if err := s.Get(context.TODO(), "/users/alice", storage.GetOptions{}, &model.User{}); !storage.IsNotFound(err) {
+			t.Errorf("expected the deleted user not to be found, got %v", err)
+		}
+		err = s.Delete(context.TODO(), "/users/alice", &model.User{}, nil, storage.ValidateAllObjectFunc, nil)
+		if !storage.IsNotFound(err) {
+			t.Errorf("expected deleting a missing key to fail, got %v", err)
+		}
+	})
+}
+
+func TestListCount(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		for _, name := range []string{"carol", "alice", "bob"} {
+			testCreate(t, s, name)
+		}
+		// a key that shares the prefix of the users, but is not one of them
+		obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: "dave"}}
+		if err := s.Create(context.TODO(), "/usersx/dave", obj, nil, 0); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		count, err := s.Count("/users")
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if count != 3 {
+			t.Errorf("expected 3 users, got %d", count)
+		}
+
+		list, err := testList(s, "")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var names []string
+		for _, user := range list.Items {
+			names = append(names, user.Name)
+		}
+		if len(names) != 3 || names[0] != "alice" || names[1] != "bob" || names[2] != "carol" {
+			t.Errorf("expected the users [alice bob carol], got %v", names)
+		}
+
+		// the pages are read at the revision of the first one
+		pred := storage.Everything
+		pred.Limit = 2
+		page := &model.UserList{}
+		if err := s.List(context.TODO(), "/users", storage.ListOptions{Predicate: pred}, page); err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(page.Items) != 2 || page.Continue == "" {
+			t.Fatalf("expected a first page of 2 users, got %d, continue %q", len(page.Items), page.Continue)
+		}
+		if page.RemainingItemCount == nil || *page.RemainingItemCount != 1 {
+			t.Errorf("expected 1 remaining user, got %v", page.RemainingItemCount)
+		}
+		testCreate(t, s, "bobby")
+		pred.Continue = page.Continue
+		next := &model.UserList{}
+		if err := s.List(context.TODO(), "/users", storage.ListOptions{Predicate: pred}, next); err != nil {
+			t.Fatalf("List of the next page failed: %v", err)
+		}
+		if len(next.Items) != 1 || next.Items[0].Name != "carol" || next.Continue != "" {
+			t.Errorf("expected a last page with carol, got %+v", next)
+		}
+		if next.ResourceVersion != page.ResourceVersion {
+			t.Errorf("expected the pages to share resource version %s, got %s", page.ResourceVersion, next.ResourceVersion)
+		}
+	})
+}
+
+func TestListAtOldRevision(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		created := testCreate(t, s, "alice", "dev")
+		testUpdate(t, s, "alice", "admin")
+		testCreate(t, s, "bob")
+
+		list, err := testList(s, created.ResourceVersion)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(list.Items) != 1 {
+			t.Fatalf("expected 1 user at revision %s, got %d", created.ResourceVersion, len(list.Items))
+		}
+		if got := list.Items[0].Roles; len(got) != 1 || got[0] != "dev" {
+			t.Errorf("expected the roles at revision %s to be [dev], got %v", created.ResourceVersion, got)
+		}
+		if list.ResourceVersion != created.ResourceVersion {
+			t.Errorf("expected list resource version %s, got %s", created.ResourceVersion, list.ResourceVersion)
+		}
+
+		list, err = testList(s, "")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(list.Items) != 2 {
+			t.Errorf("expected 2 users at the current revision, got %d", len(list.Items))
+		}
+
+		future := strconv.FormatInt(testRevision(t, s)+1, 10)
+		if _, err := testList(s, future); !apierrors.IsTimeout(err) {
+			t.Errorf("expected a list at a future revision to fail, got %v", err)
+		}
+	})
+}
+
+func TestCompact(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, compact func(int64) error) {
+		created := testCreate(t, s, "alice", "dev")
+		updated := testUpdate(t, s, "alice", "admin")
+
+		rev, err := strconv.ParseInt(updated.ResourceVersion, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := compact(rev); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+
+		_, err = testList(s, created.ResourceVersion)
+		if !apierrors.IsResourceExpired(err) || err.(apierrors.APIStatus).Status().Code != http.StatusGone {
+			t.Errorf("expected a list before the compacted revision to fail with 410, got %v", err)
+		}
+		// the state at the compacted revision itself is kept
+		list, err := testList(s, updated.ResourceVersion)
+		if err != nil {
+			t.Fatalf("List at the compacted revision failed: %v", err)
+		}
+		if len(list.Items) != 1 || list.Items[0].Roles[0] != "admin" {
+			t.Errorf("unexpected list at the compacted revision: %+v", list.Items)
+		}
+
+		w, err := s.Watch(context.TODO(), "/users/alice", storage.ListOptions{ResourceVersion: created.ResourceVersion, Predicate: storage.Everything})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		defer w.Stop()
+		event := expectEvent(t, w, watch.Error)
+		if status, ok := event.Object.(*meta.Status); !ok || status.Code != http.StatusGone {
+			t.Errorf("expected a watch before the compacted revision to fail with 410, got %+v", event.Object)
+		}
+
+		if err := compact(testRevision(t, s) + 1); err == nil {
+			t.Errorf("expected compacting a future revision to fail")
+		}
+	})
+}
+
+func TestTTL(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		start := strconv.FormatInt(testRevision(t, s), 10)
+		obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}
+		if err := s.Create(context.TODO(), "/users/alice", obj, nil, 1); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		w, err := s.Watch(context.TODO(), "/users/alice", storage.ListOptions{ResourceVersion: start, Predicate: storage.Everything})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		defer w.Stop()
+
+		expectEvent(t, w, watch.Added)
+		// the key expires after about a second
+		expectEvent(t, w, watch.Deleted)
+		err = s.Get(context.TODO(), "/users/alice", storage.GetOptions{}, &model.User{})
+		if !storage.IsNotFound(err) {
+			t.Errorf("expected the expired user not to be found, got %v", err)
+		}
+
+		// writing the key again without a ttl keeps it
+		testCreate(t, s, "bob")
+		err = s.GuaranteedUpdate(context.TODO(), "/users/bob", &model.User{}, false, nil,
+			func(obj runtime.Object, _ storage.ResponseMeta) (runtime.Object, *uint64, error) {
+				ttl := uint64(1)
+				return obj, &ttl, nil
+			}, nil)
+		if err != nil {
+			t.Fatalf("GuaranteedUpdate failed: %v", err)
+		}
+		testUpdate(t, s, "bob", "admin")
+		time.Sleep(1500 * time.Millisecond)
+		if err := s.Get(context.TODO(), "/users/bob", storage.GetOptions{}, &model.User{}); err != nil {
+			t.Errorf("expected bob not to expire after being written without a ttl, got %v", err)
+		}
+	})
+}
+
+func TestTxnConflict(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		alice := testCreate(t, s, "alice", "dev")
+		testUpdate(t, s, "alice", "admin")
+
+		// alice is updated based on a stale resource version, so bob must not
+		// be created either
+		alice.Roles = []string{"ops"}
+		err := s.Txn(context.TODO()).
+			Create("/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, nil, 0).
+			Update("/users/alice", alice, nil, 0).
+			Commit()
+		if !storage.IsConflict(err) {
+			t.Fatalf("expected a conflict, got %v", err)
+		}
+		if err := s.Get(context.TODO(), "/users/bob", storage.GetOptions{}, &model.User{}); !storage.IsNotFound(err) {
+			t.Errorf("expected bob not to be created by the failed transaction, got %v", err)
+		}
+
+		err = s.Txn(context.TODO()).
+			Create("/users/alice", &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}, nil, 0).
+			Commit()
+		if !storage.IsNodeExist(err) {
+			t.Errorf("expected creating an existing key to fail, got %v", err)
+		}
+
+		// an update without a resource version is based on the current one
+		current := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Roles: []string{"ops"}}
+		bob, out := &model.User{}, &model.User{}
+		err = s.Txn(context.TODO()).
+			Create("/users/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, bob, 0).
+			Update("/users/alice", current, out, 0).
+			Commit()
+		if err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		if bob.ResourceVersion != out.ResourceVersion {
+			t.Errorf("expected the writes of a transaction to share a revision, got %s and %s", bob.ResourceVersion, out.ResourceVersion)
+		}
+	})
+}
+
+func TestWatchFromResourceVersion(t *testing.T) {
+	runBackends(t, func(t *testing.T, s storage.Interface, _ func(int64) error) {
+		start := strconv.FormatInt(testRevision(t, s), 10)
+		created := testCreate(t, s, "alice", "dev")
+		testUpdate(t, s, "alice", "admin")
+		if err := s.Delete(context.TODO(), "/users/alice", &model.User{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		w, err := s.WatchList(context.TODO(), "/users", storage.ListOptions{ResourceVersion: start, Predicate: storage.Everything})
+		if err != nil {
+			t.Fatalf("WatchList failed: %v", err)
+		}
+		defer w.Stop()
+		expectEvent(t, w, watch.Added)
+		if event := expectEvent(t, w, watch.Modified); event.Object.(*model.User).Roles[0] != "admin" {
+			t.Errorf("expected the modified user to have the role admin, got %+v", event.Object)
+		}
+		expectEvent(t, w, watch.Deleted)
+
+		// a watch resumed from the resource version of an event starts
+		// after it, and only sees the keys it watches
+		w, err = s.WatchList(context.TODO(), "/users", storage.ListOptions{ResourceVersion: created.ResourceVersion, Predicate: storage.Everything})
+		if err != nil {
+			t.Fatalf("WatchList failed: %v", err)
+		}
+		defer w.Stop()
+		expectEvent(t, w, watch.Modified)
+		expectEvent(t, w, watch.Deleted)
+		obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: "dave"}}
+		if err := s.Create(context.TODO(), "/usersx/dave", obj, nil, 0); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		testCreate(t, s, "bob")
+		if event := expectEvent(t, w, watch.Added); event.Object.(*model.User).Name != "bob" {
+			t.Errorf("expected bob to be added, got %+v", event.Object)
+		}
+
+		// a watch without a resource version starts with the current state
+		w, err = s.WatchList(context.TODO(), "/users", storage.ListOptions{Predicate: storage.Everything})
+		if err != nil {
+			t.Fatalf("WatchList failed: %v", err)
+		}
+		defer w.Stop()
+		if event := expectEvent(t, w, watch.Added); event.Object.(*model.User).Name != "bob" {
+			t.Errorf("expected bob to be added, got %+v", event.Object)
+		}
+		testUpdate(t, s, "bob", "admin")
+		expectEvent(t, w, watch.Modified)
+	})
+}
+
+func expectEvent(t *testing.T, w watch.Interface, eventType watch.EventType) watch.Event {
+	t.Helper()
+	var event watch.Event
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		select {
+		case event = <-w.ResultChan():
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected a %s event, got none", eventType)
+	}
+	if event.Type != eventType {
+		t.Fatalf("expected a %s event, got %s: %+v", eventType, event.Type, event.Object)
+	}
+	return event
+}
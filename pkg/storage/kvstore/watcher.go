@@ -0,0 +1,308 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/watch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// We have set a buffer in order to reduce times of context switches.
+	outgoingBufSize = 100
+
+	// progressNotifyInterval is how often an idle watch that requested
+	// progress notifications is sent a bookmark, the same as the default
+	// of etcd.
+	progressNotifyInterval = 10 * time.Minute
+)
+
+// watchChan implements watch.Interface.
+type watchChan struct {
+	store          *store
+	key            string
+	initialRev     int64
+	recursive      bool
+	progressNotify bool
+	internalPred   storage.SelectionPredicate
+	ctx            context.Context
+	cancel         context.CancelFunc
+	resultChan     chan watch.Event
+}
+
+func newWatchChan(ctx context.Context, s *store, key string, rev int64, recursive, progressNotify bool, pred storage.SelectionPredicate) *watchChan {
+	wc := &watchChan{
+		store:          s,
+		key:            key,
+		initialRev:     rev,
+		recursive:      recursive,
+		progressNotify: progressNotify,
+		internalPred:   pred,
+		resultChan:     make(chan watch.Event, outgoingBufSize),
+	}
+	if pred.Empty() {
+		// The filter doesn't filter out any object.
+		wc.internalPred = storage.Everything
+	}
+	wc.ctx, wc.cancel = context.WithCancel(ctx)
+	return wc
+}
+
+// run sends the existing objects if initialRev is 0 and then the events
+// after initialRev until the watch is stopped or its revision is compacted.
+func (wc *watchChan) run() {
+	defer close(wc.resultChan)
+	defer wc.cancel()
+
+	rev := wc.initialRev
+	if rev == 0 {
+		var kvs []*KeyValue
+		var err error
+		kvs, rev, err = wc.store.backend.Snapshot(wc.key, wc.recursive)
+		if err != nil {
+			klog.ErrorS(err, "Failed to sync with latest state", "key", wc.key, "objectType", wc.store.objectType)
+			wc.sendError(err)
+			return
+		}
+		for _, kv := range kvs {
+			if !wc.send(parseKV(kv)) {
+				return
+			}
+		}
+	}
+
+	var progressC <-chan time.Time
+	if wc.progressNotify {
+		ticker := time.NewTicker(progressNotifyInterval)
+		defer ticker.Stop()
+		progressC = ticker.C
+	}
+
+	for {
+		events, changed, err := wc.store.backend.EventsAfter(rev)
+		if err != nil {
+			if err == ErrCompacted {
+				// the history is compacted periodically, the client has
+				// to start over from a fresh list
+				klog.V(2).InfoS("Watch chan error", "err", err, "key", wc.key, "objectType", wc.store.objectType)
+			} else {
+				klog.ErrorS(err, "Watch chan error", "key", wc.key, "objectType", wc.store.objectType)
+			}
+			wc.sendError(err)
+			return
+		}
+		for _, e := range events {
+			rev = e.Rev
+			if !wc.matches(e.Key) {
+				continue
+			}
+			if !wc.send(e) {
+				return
+			}
+		}
+		if len(events) > 0 {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-progressC:
+			if !wc.send(progressNotifyEvent(rev)) {
+				return
+			}
+		case <-wc.ctx.Done():
+			return
+		}
+	}
+}
+
+func (wc *watchChan) Stop() {
+	wc.cancel()
+}
+
+func (wc *watchChan) ResultChan() <-chan watch.Event {
+	return wc.resultChan
+}
+
+// matches returns true if the watch is interested in the changes of key.
+func (wc *watchChan) matches(key string) bool {
+	if wc.recursive {
+		return strings.HasPrefix(key, wc.key)
+	}
+	return key == wc.key
+}
+
+// send transforms e and sends the result, it returns false if the watch has
+// been stopped.
+func (wc *watchChan) send(e *Event) bool {
+	res, err := wc.transform(e)
+	if err != nil {
+		klog.ErrorS(err, "Failed to prepare current and previous objects", "key", e.Key, "rev", e.Rev, "objectType", wc.store.objectType)
+		wc.sendError(err)
+		return false
+	}
+	if res == nil {
+		return true
+	}
+	select {
+	case wc.resultChan <- *res:
+		return true
+	case <-wc.ctx.Done():
+		return false
+	}
+}
+
+func (wc *watchChan) sendError(err error) {
+	select {
+	case wc.resultChan <- transformErrorToEvent(err):
+	case <-wc.ctx.Done():
+	}
+}
+
+func (wc *watchChan) filter(obj runtime.Object) bool {
+	if wc.internalPred.Empty() {
+		return true
+	}
+	matched, err := wc.internalPred.Matches(obj)
+	return err == nil && matched
+}
+
+func (wc *watchChan) acceptAll() bool {
+	return wc.internalPred.Empty()
+}
+
+// transform transforms an event into a result for user if not filtered.
+func (wc *watchChan) transform(e *Event) (res *watch.Event, err error) {
+	curObj, oldObj, err := wc.prepareObjs(e)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case e.isProgressNotify:
+		if wc.store.newFunc == nil {
+			return nil, nil
+		}
+		object := wc.store.newFunc()
+		if err := wc.store.versioner.UpdateObject(object, uint64(e.Rev)); err != nil {
+			klog.ErrorS(err, "Failed to propagate object version", "rev", e.Rev, "objectType", wc.store.objectType)
+			return nil, nil
+		}
+		res = &watch.Event{
+			Type:   watch.Bookmark,
+			Object: object,
+		}
+	case e.IsDeleted:
+		if !wc.filter(oldObj) {
+			return nil, nil
+		}
+		res = &watch.Event{
+			Type:   watch.Deleted,
+			Object: oldObj,
+		}
+	case e.IsCreated:
+		if !wc.filter(curObj) {
+			return nil, nil
+		}
+		res = &watch.Event{
+			Type:   watch.Added,
+			Object: curObj,
+		}
+	default:
+		if wc.acceptAll() {
+			res = &watch.Event{
+				Type:   watch.Modified,
+				Object: curObj,
+			}
+			return res, nil
+		}
+		curObjPasses := wc.filter(curObj)
+		oldObjPasses := wc.filter(oldObj)
+		switch {
+		case curObjPasses && oldObjPasses:
+			res = &watch.Event{
+				Type:   watch.Modified,
+				Object: curObj,
+			}
+		case curObjPasses && !oldObjPasses:
+			res = &watch.Event{
+				Type:   watch.Added,
+				Object: curObj,
+			}
+		case !curObjPasses && oldObjPasses:
+			res = &watch.Event{
+				Type:   watch.Deleted,
+				Object: oldObj,
+			}
+		}
+	}
+	return res, nil
+}
+
+func transformErrorToEvent(err error) watch.Event {
+	err = interpretWatchError(err)
+	if _, ok := err.(apierrors.APIStatus); !ok {
+		err = apierrors.NewInternalError(err)
+	}
+	return watch.Event{
+		Type:   watch.Error,
+		Object: &meta.Status{Status: err.(apierrors.APIStatus).Status()},
+	}
+}
+
+func (wc *watchChan) prepareObjs(e *Event) (curObj runtime.Object, oldObj runtime.Object, err error) {
+	if e.isProgressNotify {
+		// progressNotify events doesn't contain neither current nor previous object version,
+		return nil, nil, nil
+	}
+
+	if !e.IsDeleted {
+		data, _, err := wc.store.transformer.TransformFromStorage(e.Value, authenticatedDataString(e.Key))
+		if err != nil {
+			return nil, nil, err
+		}
+		curObj, err = decodeObj(wc.store.codec, wc.store.versioner, wc.store.newFunc, data, e.Rev)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	// We need to decode prevValue, only if this is deletion event or
+	// the underlying filter doesn't accept all objects (otherwise we
+	// know that the filter for previous object will return true and
+	// we need the object only to compute whether it was filtered out
+	// before).
+	if len(e.PrevValue) > 0 && (e.IsDeleted || !wc.acceptAll()) {
+		data, _, err := wc.store.transformer.TransformFromStorage(e.PrevValue, authenticatedDataString(e.Key))
+		if err != nil {
+			return nil, nil, err
+		}
+		// Note that this sends the *old* object with the revision for the time at
+		// which it gets deleted.
+		oldObj, err = decodeObj(wc.store.codec, wc.store.versioner, wc.store.newFunc, data, e.Rev)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return curObj, oldObj, nil
+}
+
+func decodeObj(codec runtime.Codec, versioner storage.Versioner, newFunc func() runtime.Object, data []byte, rev int64) (runtime.Object, error) {
+	// the codec has no scheme to look the type up, so decode into a fresh
+	// instance of the watched type
+	obj, err := codec.Decode(data, newFunc())
+	if err != nil {
+		return nil, err
+	}
+	// ensure resource version is set on the object we load from the backend
+	if err := versioner.UpdateObject(obj, uint64(rev)); err != nil {
+		return nil, fmt.Errorf("failure to version api object (%d) %#v: %v", rev, obj, err)
+	}
+	return obj, nil
+}
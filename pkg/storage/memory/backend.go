@@ -0,0 +1,328 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+)
+
+var _ kvstore.Backend = &Backend{}
+
+const (
+	// DefaultHistorySize is the number of revisions a backend keeps for
+	// lists at an older resource version and watches that start in the past.
+	DefaultHistorySize = 10000
+)
+
+// revision is an entry in the history of a key, kv is nil if the key was
+// deleted at rev.
+type revision struct {
+	rev int64
+	kv  *kvstore.KeyValue
+}
+
+// Backend is an in-memory implementation of kvstore.Backend. All the stores
+// created on a backend share its revision, so they can be used together the
+// same way as the stores of a single etcd cluster.
+//
+// The history of the last revisions is kept to serve lists at an older
+// resource version and to replay the events of watches that start in the
+// past, older revisions are compacted.
+type Backend struct {
+	lock sync.RWMutex
+	// rev is the current revision.
+	rev int64
+	// compactRev is the revision the backend was compacted at, revisions
+	// before it can not be read anymore.
+	compactRev int64
+	// keys holds all keys with a history in sorted order.
+	keys []string
+	// history holds the versions of each key, ordered by revision.
+	history map[string][]revision
+	// events holds the events after compactRev, ordered by revision.
+	events []*kvstore.Event
+	// changed is closed and replaced on every write.
+	changed chan struct{}
+	// timers expire the keys written with a ttl.
+	timers map[string]*time.Timer
+
+	historySize int
+}
+
+// NewBackend returns an empty backend that keeps historySize revisions. If
+// historySize is not positive DefaultHistorySize is used.
+func NewBackend(historySize int) *Backend {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Backend{
+		// like etcd start at revision 1, 0 is not a valid resource version
+		rev:         1,
+		history:     map[string][]revision{},
+		changed:     make(chan struct{}),
+		timers:      map[string]*time.Timer{},
+		historySize: historySize,
+	}
+}
+
+// Revision returns the current revision of the backend.
+func (b *Backend) Revision() int64 {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.rev
+}
+
+// Compact discards the history before rev. Lists at a resource version
+// older than rev and watches starting before it fail with a "resource
+// version too old" error afterwards.
+func (b *Backend) Compact(rev int64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if rev > b.rev {
+		return fmt.Errorf("memory: cannot compact revision %d, current revision is %d", rev, b.rev)
+	}
+	b.compact(rev)
+	return nil
+}
+
+func (b *Backend) compact(rev int64) {
+	if rev <= b.compactRev {
+		return
+	}
+	b.compactRev = rev
+
+	i := sort.Search(len(b.events), func(i int) bool { return b.events[i].Rev > rev })
+	// copy the remaining events, watchers may still hold the old slice
+	b.events = append([]*kvstore.Event(nil), b.events[i:]...)
+
+	keys := b.keys[:0]
+	for _, key := range b.keys {
+		revs := b.history[key]
+		// keep the last version at or before rev to be able to read at rev
+		i := sort.Search(len(revs), func(i int) bool { return revs[i].rev > rev }) - 1
+		if i >= 0 && revs[i].kv == nil {
+			i++
+		}
+		if i > 0 {
+			revs = append([]revision(nil), revs[i:]...)
+		}
+		if len(revs) == 0 {
+			delete(b.history, key)
+			continue
+		}
+		b.history[key] = revs
+		keys = append(keys, key)
+	}
+	b.keys = keys
+}
+
+// checkRevision returns an error if the state at rev can not be read. A rev
+// of 0 stands for the current revision.
+func (b *Backend) checkRevision(rev int64) error {
+	if rev > b.rev {
+		return storage.NewTooLargeResourceVersionError(uint64(rev), uint64(b.rev), 0)
+	}
+	if rev > 0 && rev < b.compactRev {
+		return kvstore.ErrCompacted
+	}
+	return nil
+}
+
+// at returns the version of key at rev, nil if it did not exist.
+func (b *Backend) at(key string, rev int64) *kvstore.KeyValue {
+	revs := b.history[key]
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].rev > rev }) - 1
+	if i < 0 {
+		return nil
+	}
+	return revs[i].kv
+}
+
+// Get implements kvstore.Backend.Get.
+func (b *Backend) Get(key string, rev int64) (*kvstore.KeyValue, int64, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if err := b.checkRevision(rev); err != nil {
+		return nil, 0, err
+	}
+	if rev == 0 {
+		rev = b.rev
+	}
+	return b.at(key, rev), rev, nil
+}
+
+// List implements kvstore.Backend.List.
+func (b *Backend) List(prefix, from string, rev, limit int64) (kvs []*kvstore.KeyValue, count, readRev int64, err error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if err := b.checkRevision(rev); err != nil {
+		return nil, 0, 0, err
+	}
+	if rev == 0 {
+		rev = b.rev
+	}
+	if from < prefix {
+		from = prefix
+	}
+	for i := sort.SearchStrings(b.keys, from); i < len(b.keys) && strings.HasPrefix(b.keys[i], prefix); i++ {
+		kv := b.at(b.keys[i], rev)
+		if kv == nil {
+			continue
+		}
+		count++
+		if limit <= 0 || int64(len(kvs)) < limit {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, count, rev, nil
+}
+
+// Put implements kvstore.Backend.Put.
+func (b *Backend) Put(key string, value []byte, modRev int64, ttl uint64) (int64, *kvstore.KeyValue, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	prev := b.at(key, b.rev)
+	if prev == nil && modRev != 0 || prev != nil && prev.ModRevision != modRev {
+		return 0, prev, false, nil
+	}
+
+	rev := b.rev + 1
+	return rev, b.set(key, value, prev, rev, ttl), true, nil
+}
+
+// Txn implements kvstore.Backend.Txn.
+func (b *Backend) Txn(ops []kvstore.TxnOp) (int64, int, *kvstore.KeyValue, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, op := range ops {
+		if prev := b.at(op.Key, b.rev); !op.Matches(prev) {
+			return 0, i, prev, false, nil
+		}
+	}
+
+	rev := b.rev + 1
+	for _, op := range ops {
+		// the keys differ, so the writes of the previous ops leave the
+		// version of this one alone
+		prev := b.at(op.Key, b.rev)
+		if op.Delete {
+			b.delete(prev, rev)
+			continue
+		}
+		b.set(op.Key, op.Value, prev, rev, op.TTL)
+	}
+	return rev, 0, nil, true, nil
+}
+
+// set writes value to key at rev, prev being the current version of key.
+func (b *Backend) set(key string, value []byte, prev *kvstore.KeyValue, rev int64, ttl uint64) *kvstore.KeyValue {
+	kv := &kvstore.KeyValue{Key: key, Value: value, CreateRevision: rev, ModRevision: rev}
+	e := &kvstore.Event{Key: key, Value: value, Rev: rev, IsCreated: true}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		e.PrevValue = prev.Value
+		e.IsCreated = false
+	}
+	b.write(kv.Key, kv, e)
+
+	if timer, ok := b.timers[key]; ok {
+		timer.Stop()
+		delete(b.timers, key)
+	}
+	if ttl != 0 {
+		b.timers[key] = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
+			b.expire(key, rev)
+		})
+	}
+	return kv
+}
+
+// Remove implements kvstore.Backend.Remove.
+func (b *Backend) Remove(key string, modRev int64) (int64, *kvstore.KeyValue, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	prev := b.at(key, b.rev)
+	if prev == nil || prev.ModRevision != modRev {
+		return 0, prev, false, nil
+	}
+	return b.delete(prev, b.rev+1), prev, true, nil
+}
+
+// expire deletes key if it has not been written since rev.
+func (b *Backend) expire(key string, rev int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	prev := b.at(key, b.rev)
+	if prev == nil || prev.ModRevision != rev {
+		return
+	}
+	b.delete(prev, b.rev+1)
+}
+
+// delete deletes the key of prev, which must be its current version, at rev.
+func (b *Backend) delete(prev *kvstore.KeyValue, rev int64) int64 {
+	b.write(prev.Key, nil, &kvstore.Event{Key: prev.Key, PrevValue: prev.Value, Rev: rev, IsDeleted: true})
+	if timer, ok := b.timers[prev.Key]; ok {
+		timer.Stop()
+		delete(b.timers, prev.Key)
+	}
+	return rev
+}
+
+// write records kv as the version of key at the revision of e, which is
+// the next revision or the current one for the writes of a transaction
+// after the first, and notifies the watchers of e.
+func (b *Backend) write(key string, kv *kvstore.KeyValue, e *kvstore.Event) {
+	b.rev = e.Rev
+	if _, ok := b.history[key]; !ok {
+		i := sort.SearchStrings(b.keys, key)
+		b.keys = append(b.keys, "")
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = key
+	}
+	b.history[key] = append(b.history[key], revision{rev: b.rev, kv: kv})
+	b.events = append(b.events, e)
+
+	// compact in batches to not walk all keys on every write
+	if len(b.events) >= 2*b.historySize {
+		b.compact(b.rev - int64(b.historySize))
+	}
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Snapshot implements kvstore.Backend.Snapshot.
+func (b *Backend) Snapshot(key string, recursive bool) ([]*kvstore.KeyValue, int64, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if !recursive {
+		if kv := b.at(key, b.rev); kv != nil {
+			return []*kvstore.KeyValue{kv}, b.rev, nil
+		}
+		return nil, b.rev, nil
+	}
+	var kvs []*kvstore.KeyValue
+	for i := sort.SearchStrings(b.keys, key); i < len(b.keys) && strings.HasPrefix(b.keys[i], key); i++ {
+		if kv := b.at(b.keys[i], b.rev); kv != nil {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, b.rev, nil
+}
+
+// EventsAfter implements kvstore.Backend.EventsAfter.
+func (b *Backend) EventsAfter(rev int64) ([]*kvstore.Event, <-chan struct{}, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if rev < b.compactRev {
+		return nil, nil, kvstore.ErrCompacted
+	}
+	i := sort.Search(len(b.events), func(i int) bool { return b.events[i].Rev > rev })
+	return b.events[i:len(b.events):len(b.events)], b.changed, nil
+}
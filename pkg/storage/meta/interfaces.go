@@ -1,5 +1,7 @@
 package meta
 
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
 // TODO: move this, Object, List, and Type to a different package
 type ObjectMetaAccessor interface {
 	GetObjectMeta() Object
@@ -69,6 +71,26 @@ type Type interface {
 	SetKind(kind string)
 }
 
+var _ Type = &TypeMeta{}
+
+func (obj *TypeMeta) GetAPIVersion() string        { return obj.APIVersion }
+func (obj *TypeMeta) SetAPIVersion(version string) { obj.APIVersion = version }
+func (obj *TypeMeta) GetKind() string              { return obj.Kind }
+func (obj *TypeMeta) SetKind(kind string)          { obj.Kind = kind }
+
+// GetObjectKind implements runtime.Object for all objects that embed TypeMeta.
+func (obj *TypeMeta) GetObjectKind() schema.ObjectKind { return obj }
+
+// SetGroupVersionKind satisfies the ObjectKind interface for all objects that embed TypeMeta
+func (obj *TypeMeta) SetGroupVersionKind(gvk schema.GroupVersionKind) {
+	obj.APIVersion, obj.Kind = gvk.ToAPIVersionAndKind()
+}
+
+// GroupVersionKind satisfies the ObjectKind interface for all objects that embed TypeMeta
+func (obj *TypeMeta) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind)
+}
+
 var _ ListInterface = &ListMeta{}
 
 func (meta *ListMeta) GetResourceVersion() string        { return meta.ResourceVersion }
@@ -0,0 +1,17 @@
+package meta
+
+import (
+	"github.com/x893675/opa-server/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// StatusGroupVersion is the group version Status objects are written with,
+// the one of the Status objects of the kube-apiserver.
+var StatusGroupVersion = schema.GroupVersion{Version: "v1"}
+
+// AddToScheme adds the types of this package that are sent on their own,
+// i.e. Status, to a scheme.
+func AddToScheme(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(StatusGroupVersion, &Status{})
+	return nil
+}
@@ -0,0 +1,16 @@
+package meta
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Status is a return value for calls that don't return other objects, such
+// as a delete, and the object of watch events of type ERROR.
+type Status struct {
+	metav1.Status `json:",inline"`
+}
+
+func (s *Status) SetZeroValue() error {
+	*s = Status{}
+	return nil
+}
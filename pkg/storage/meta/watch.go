@@ -0,0 +1,32 @@
+package meta
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// WatchEvent is the wire representation of a watch.Event. It is written to
+// watch streams as one JSON object per line.
+type WatchEvent struct {
+	// Type is one of ADDED, MODIFIED, DELETED, BOOKMARK or ERROR.
+	Type string `json:"type"`
+
+	// Object is:
+	//  * If Type is Added or Modified: the new state of the object.
+	//  * If Type is Deleted: the state of the object immediately before deletion.
+	//  * If Type is Bookmark: the object with only its resourceVersion set.
+	//  * If Type is Error: a Status describing the error.
+	Object json.RawMessage `json:"object"`
+}
+
+func (e *WatchEvent) SetZeroValue() error {
+	*e = WatchEvent{}
+	return nil
+}
+
+// GetObjectKind implements runtime.Object. Watch events are written without
+// apiVersion and kind, only the objects they carry are typed.
+func (e *WatchEvent) GetObjectKind() schema.ObjectKind {
+	return schema.EmptyObjectKind
+}
@@ -5,11 +5,18 @@ import (
 
 	"github.com/x893675/opa-server/pkg/runtime"
 	"github.com/x893675/opa-server/pkg/storage/etcd3"
+	"github.com/x893675/opa-server/pkg/storage/value"
 )
 
 const (
 	StorageTypeUnset = ""
 	StorageTypeETCD3 = "etcd3"
+	// StorageTypeMemory keeps the objects in the memory of the process, they
+	// are lost on restart and not shared between instances.
+	StorageTypeMemory = "memory"
+	// StorageTypeBolt keeps the objects in a local bbolt file, for single
+	// instance deployments without an etcd cluster.
+	StorageTypeBolt = "bolt"
 
 	DefaultCompactInterval      = 5 * time.Minute
 	DefaultDBMetricPollInterval = 30 * time.Second
@@ -34,6 +41,8 @@ type Config struct {
 	Prefix string
 	// Transport holds all connection related info, i.e. equal TransportConfig means equal servers we talk to.
 	Transport TransportConfig
+	// BoltPath is the file the bolt backend keeps the objects in.
+	BoltPath string
 	// Paging indicates whether the server implementation should allow paging (if it is
 	// supported). This is generally configured by feature gating, or by a specific
 	// resource type not wishing to allow paging, and is not intended for end users to
@@ -47,7 +56,7 @@ type Config struct {
 	// converted to before persisted in etcd.
 	//EncodeVersioner runtime.GroupVersioner
 	// Transformer allows the value to be transformed prior to persisting into etcd.
-	//Transformer value.Transformer
+	Transformer value.Transformer
 
 	// CompactionInterval is an interval of requesting compaction from apiserver.
 	// If the value is 0, no compaction will be issued.
@@ -67,6 +76,7 @@ func NewDefaultConfig(prefix string, codec runtime.Codec) *Config {
 		Paging:               true,
 		Prefix:               prefix,
 		Codec:                codec,
+		Transformer:          value.IdentityTransformer,
 		CompactionInterval:   DefaultCompactInterval,
 		DBMetricPollInterval: DefaultDBMetricPollInterval,
 		HealthcheckTimeout:   DefaultHealthcheckTimeout,
@@ -0,0 +1,69 @@
+package factory
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/bolt"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"github.com/x893675/opa-server/pkg/storage/value"
+	"k8s.io/klog/v2"
+)
+
+// boltBackends holds the open bolt backends by file. A file can be opened
+// only once, the storages on the same file share its backend, which is
+// closed when the last of them is destroyed.
+var boltBackends = struct {
+	sync.Mutex
+	backends map[string]*boltBackend
+}{backends: map[string]*boltBackend{}}
+
+type boltBackend struct {
+	backend *bolt.Backend
+	refs    int
+}
+
+func newBoltStorage(c storagebackend.Config, newFunc func() runtime.Object) (storage.Interface, DestroyFunc, error) {
+	path, err := filepath.Abs(c.BoltPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	boltBackends.Lock()
+	defer boltBackends.Unlock()
+	b, ok := boltBackends.backends[path]
+	if !ok {
+		backend, err := bolt.Open(path, bolt.DefaultHistorySize)
+		if err != nil {
+			return nil, nil, err
+		}
+		b = &boltBackend{backend: backend}
+		boltBackends.backends[path] = b
+	}
+	b.refs++
+
+	var once sync.Once
+	destroyFunc := func() {
+		// the destroy func of a storage may be called more than once, e.g.
+		// on shutdown after a failed start
+		once.Do(func() {
+			boltBackends.Lock()
+			defer boltBackends.Unlock()
+			if b.refs--; b.refs > 0 {
+				return
+			}
+			delete(boltBackends.backends, path)
+			if err := b.backend.Close(); err != nil {
+				klog.ErrorS(err, "Failed to close bolt database", "path", path)
+			}
+		})
+	}
+	transformer := c.Transformer
+	if transformer == nil {
+		transformer = value.IdentityTransformer
+	}
+	return kvstore.New(b.backend, c.Codec, newFunc, c.Prefix, transformer, c.Paging), destroyFunc, nil
+}
@@ -0,0 +1,132 @@
+package factory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+)
+
+func newBoltTestConfig(t *testing.T, prefix string) storagebackend.Config {
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	c := storagebackend.NewDefaultConfig(prefix, codec)
+	c.Type = storagebackend.StorageTypeBolt
+	c.BoltPath = filepath.Join(t.TempDir(), "opa-server.db")
+	return *c
+}
+
+func newUser() runtime.Object { return &model.User{} }
+
+func TestBoltPersistsAcrossReopen(t *testing.T) {
+	c := newBoltTestConfig(t, "/users")
+	s, destroyFunc, err := Create(c, newUser)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	created := &model.User{}
+	obj := &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}, Roles: []string{"admin"}}
+	if err := s.Create(context.TODO(), "/alice", obj, created, 0); err != nil {
+		t.Fatalf("Create of alice failed: %v", err)
+	}
+	destroyFunc()
+
+	s, destroyFunc, err = Create(c, newUser)
+	if err != nil {
+		t.Fatalf("Create after reopening failed: %v", err)
+	}
+	defer destroyFunc()
+	got := &model.User{}
+	if err := s.Get(context.TODO(), "/alice", storage.GetOptions{}, got); err != nil {
+		t.Fatalf("Get after reopening failed: %v", err)
+	}
+	if got.ResourceVersion != created.ResourceVersion || len(got.Roles) != 1 || got.Roles[0] != "admin" {
+		t.Errorf("expected %+v after reopening, got %+v", created, got)
+	}
+
+	// the revision continues where it was, resource versions are never reused
+	bob := &model.User{}
+	if err := s.Create(context.TODO(), "/bob", &model.User{ObjectMeta: meta.ObjectMeta{Name: "bob"}}, bob, 0); err != nil {
+		t.Fatalf("Create of bob failed: %v", err)
+	}
+	createdRV, _ := s.Versioner().ObjectResourceVersion(created)
+	bobRV, _ := s.Versioner().ObjectResourceVersion(bob)
+	if bobRV <= createdRV {
+		t.Errorf("expected the resource version of bob (%d) to be larger than the one of alice (%d)", bobRV, createdRV)
+	}
+}
+
+func TestBoltSharesBackend(t *testing.T) {
+	c := newBoltTestConfig(t, "/users")
+	users, destroyUsers, err := Create(c, newUser)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	c.Prefix = "/groups"
+	groups, destroyGroups, err := Create(c, func() runtime.Object { return &model.Group{} })
+	if err != nil {
+		t.Fatalf("Create on the same file failed: %v", err)
+	}
+	path, err := filepath.Abs(c.BoltPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := boltBackendRefs(path); refs != 2 {
+		t.Fatalf("expected 2 references to the backend, got %d", refs)
+	}
+
+	// the storages share the revision of the backend
+	user, group := &model.User{}, &model.Group{}
+	if err := users.Create(context.TODO(), "/alice", &model.User{ObjectMeta: meta.ObjectMeta{Name: "alice"}}, user, 0); err != nil {
+		t.Fatalf("Create of alice failed: %v", err)
+	}
+	if err := groups.Create(context.TODO(), "/dev", &model.Group{ObjectMeta: meta.ObjectMeta{Name: "dev"}}, group, 0); err != nil {
+		t.Fatalf("Create of dev failed: %v", err)
+	}
+	userRV, _ := users.Versioner().ObjectResourceVersion(user)
+	groupRV, _ := groups.Versioner().ObjectResourceVersion(group)
+	if groupRV != userRV+1 {
+		t.Errorf("expected the group to be written at revision %d, got %d", userRV+1, groupRV)
+	}
+
+	// destroying a storage more than once drops a single reference
+	destroyUsers()
+	destroyUsers()
+	if refs := boltBackendRefs(path); refs != 1 {
+		t.Fatalf("expected 1 reference to the backend, got %d", refs)
+	}
+	if err := groups.Get(context.TODO(), "/dev", storage.GetOptions{}, &model.Group{}); err != nil {
+		t.Errorf("expected the remaining storage to be usable, got %v", err)
+	}
+
+	destroyGroups()
+	if refs := boltBackendRefs(path); refs != 0 {
+		t.Fatalf("expected the backend to be closed, got %d references", refs)
+	}
+	// the file is not locked anymore
+	c.Prefix = "/users"
+	users, destroyUsers, err = Create(c, newUser)
+	if err != nil {
+		t.Fatalf("Create after closing the backend failed: %v", err)
+	}
+	defer destroyUsers()
+	if err := users.Get(context.TODO(), "/alice", storage.GetOptions{}, &model.User{}); err != nil {
+		t.Errorf("Get after reopening failed: %v", err)
+	}
+}
+
+// boltBackendRefs returns the number of storages using the backend of path.
+func boltBackendRefs(path string) int {
+	boltBackends.Lock()
+	defer boltBackends.Unlock()
+	b, ok := boltBackends.backends[path]
+	if !ok {
+		return 0
+	}
+	return b.refs
+}
@@ -1,12 +1,24 @@
 package factory
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/etcd3"
+	"github.com/x893675/opa-server/pkg/storage/etcd3/metrics"
 	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"github.com/x893675/opa-server/pkg/storage/value"
 	"go.etcd.io/etcd/clientv3"
 	"go.etcd.io/etcd/pkg/transport"
 	"google.golang.org/grpc"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -23,6 +35,29 @@ const (
 	dbMetricsMonitorJitter = 0.5
 )
 
+type runningCompactor struct {
+	interval time.Duration
+	cancel   context.CancelFunc
+	client   *clientv3.Client
+	refs     int
+}
+
+var (
+	lock       sync.Mutex
+	compactors = map[string]*runningCompactor{}
+)
+
+type runningDBSizeMonitor struct {
+	cancel context.CancelFunc
+	client *clientv3.Client
+	refs   int
+}
+
+var (
+	dbMetricsMonitorsMu sync.Mutex
+	dbMetricsMonitors   = map[string]*runningDBSizeMonitor{}
+)
+
 func NewETCD3Client(c storagebackend.TransportConfig) (*clientv3.Client, error) {
 	tlsInfo := transport.TLSInfo{
 		CertFile:      c.CertFile,
@@ -72,3 +107,254 @@ func NewETCD3Client(c storagebackend.TransportConfig) (*clientv3.Client, error)
 
 	return clientv3.New(cfg)
 }
+
+func newETCD3HealthCheck(c storagebackend.Config, stopCh <-chan struct{}) (func() error, error) {
+	timeout := storagebackend.DefaultHealthcheckTimeout
+	if c.HealthcheckTimeout != time.Duration(0) {
+		timeout = c.HealthcheckTimeout
+	}
+
+	// constructing the etcd v3 client blocks and times out if etcd is not available.
+	// retry in a loop in the background until we successfully create the client, storing the client or error encountered
+
+	lock := sync.RWMutex{}
+	var client *clientv3.Client
+	clientErr := fmt.Errorf("etcd client connection not yet established")
+
+	go wait.PollUntil(time.Second, func() (bool, error) {
+		newClient, err := NewETCD3Client(c.Transport)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		// Ensure that server is already not shutting down.
+		select {
+		case <-stopCh:
+			if err == nil {
+				newClient.Close()
+			}
+			return true, nil
+		default:
+		}
+
+		if err != nil {
+			clientErr = err
+			return false, nil
+		}
+		client = newClient
+		clientErr = nil
+		return true, nil
+	}, stopCh)
+
+	// Close the client connection. After stopCh is closed there might
+	// be running goroutines, but they will fail soon.
+	go func() {
+		<-stopCh
+
+		lock.Lock()
+		defer lock.Unlock()
+		if client != nil {
+			client.Close()
+			clientErr = fmt.Errorf("server is shutting down")
+		}
+	}()
+
+	return func() error {
+		// Given that client is closed on shutdown we hold the lock for
+		// the entire period of healthcheck call to ensure that client will
+		// not be closed during healthcheck.
+		// Given that healthchecks has a 2s timeout, worst case of blocking
+		// shutdown for additional 2s seems acceptable.
+		lock.RLock()
+		defer lock.RUnlock()
+
+		if clientErr != nil {
+			return clientErr
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		// every endpoint is asked for its status within the same timeout, so
+		// that the check names each one that is unreachable or reports errors
+		endpoints := client.Endpoints()
+		statuses := make(chan endpointStatusResult, len(endpoints))
+		for i, ep := range endpoints {
+			go func(i int, ep string) {
+				statuses <- endpointStatusResult{index: i, err: endpointStatus(ctx, client, ep)}
+			}(i, ep)
+		}
+		errs := make([]error, len(endpoints))
+		for i, ep := range endpoints {
+			errs[i] = fmt.Errorf("%s: %v", ep, context.DeadlineExceeded)
+		}
+	collect:
+		for range endpoints {
+			select {
+			case status := <-statuses:
+				errs[status.index] = status.err
+			case <-ctx.Done():
+				// dialing an endpoint that is down blocks for the dial
+				// timeout of the client rather than until ctx is done
+				break collect
+			}
+		}
+		if err := utilerrors.NewAggregate(errs); err != nil {
+			return fmt.Errorf("unhealthy etcd endpoints: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// endpointStatusResult is the result of endpointStatus for the endpoint at
+// index of the endpoints of the client.
+type endpointStatusResult struct {
+	index int
+	err   error
+}
+
+// endpointStatus returns an error if the etcd member at ep cannot be reached
+// or reports errors, e.g. raised alarms.
+func endpointStatus(ctx context.Context, client *clientv3.Client, ep string) error {
+	resp, err := client.Maintenance.Status(ctx, ep)
+	if err != nil {
+		return fmt.Errorf("%s: %v", ep, err)
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("%s: %s", ep, strings.Join(resp.Errors, ", "))
+	}
+	return nil
+}
+
+func newETCD3Storage(c storagebackend.Config, newFunc func() runtime.Object) (storage.Interface, DestroyFunc, error) {
+	client, err := NewETCD3Client(c.Transport)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stopCompactor, err := startCompactorOnce(c.Transport, c.CompactionInterval)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+
+	stopDBSizeMonitor, err := startDBSizeMonitorOnce(c.Transport, c.DBMetricPollInterval)
+	if err != nil {
+		stopCompactor()
+		client.Close()
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	destroyFunc := func() {
+		// the destroy func of a storage may be called more than once, e.g.
+		// on shutdown after a failed start
+		once.Do(func() {
+			stopDBSizeMonitor()
+			stopCompactor()
+			client.Close()
+		})
+	}
+	transformer := c.Transformer
+	if transformer == nil {
+		transformer = value.IdentityTransformer
+	}
+	return etcd3.New(client, c.Codec, newFunc, c.Prefix, transformer, c.Paging, c.LeaseManagerConfig), destroyFunc, nil
+}
+
+// startCompactorOnce start one compactor per transport. If the interval get smaller on repeated calls, the
+// compactor is replaced. A destroy func is returned. If all destroy funcs with the same transport are called,
+// the compactor is stopped.
+func startCompactorOnce(c storagebackend.TransportConfig, interval time.Duration) (func(), error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := fmt.Sprintf("%v", c) // gives: {[server1 server2] keyFile certFile caFile}
+	if compactor, foundBefore := compactors[key]; !foundBefore || compactor.interval > interval {
+		compactorClient, err := NewETCD3Client(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if foundBefore {
+			// replace compactor
+			compactor.cancel()
+			compactor.client.Close()
+		} else {
+			// start new compactor
+			compactor = &runningCompactor{}
+			compactors[key] = compactor
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		compactor.interval = interval
+		compactor.cancel = cancel
+		compactor.client = compactorClient
+
+		etcd3.StartCompactor(ctx, compactorClient, interval)
+	}
+
+	compactors[key].refs++
+
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		compactor := compactors[key]
+		compactor.refs--
+		if compactor.refs == 0 {
+			compactor.cancel()
+			compactor.client.Close()
+			delete(compactors, key)
+		}
+	}, nil
+}
+
+// startDBSizeMonitorOnce starts one monitor of the database size of every
+// endpoint per transport. A destroy func is returned. If all destroy funcs
+// with the same transport are called, the monitor is stopped.
+func startDBSizeMonitorOnce(c storagebackend.TransportConfig, interval time.Duration) (func(), error) {
+	if interval == 0 {
+		return func() {}, nil
+	}
+	dbMetricsMonitorsMu.Lock()
+	defer dbMetricsMonitorsMu.Unlock()
+
+	key := fmt.Sprintf("%v", c) // gives: {[server1 server2] keyFile certFile caFile}
+	if _, found := dbMetricsMonitors[key]; !found {
+		client, err := NewETCD3Client(c)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		for _, ep := range client.Endpoints() {
+			endpoint := ep
+			klog.V(4).InfoS("Start monitoring storage db size metric", "endpoint", endpoint, "interval", interval)
+			go wait.JitterUntilWithContext(ctx, func(context.Context) {
+				epStatus, err := client.Maintenance.Status(ctx, endpoint)
+				if err != nil {
+					klog.V(4).InfoS("Failed to get storage db size", "endpoint", endpoint, "err", err)
+					metrics.UpdateEtcdDbSize(endpoint, -1)
+				} else {
+					metrics.UpdateEtcdDbSize(endpoint, epStatus.DbSize)
+				}
+			}, interval, dbMetricsMonitorJitter, true)
+		}
+		dbMetricsMonitors[key] = &runningDBSizeMonitor{cancel: cancel, client: client}
+	}
+
+	dbMetricsMonitors[key].refs++
+
+	return func() {
+		dbMetricsMonitorsMu.Lock()
+		defer dbMetricsMonitorsMu.Unlock()
+
+		monitor := dbMetricsMonitors[key]
+		monitor.refs--
+		if monitor.refs == 0 {
+			monitor.cancel()
+			monitor.client.Close()
+			delete(dbMetricsMonitors, key)
+		}
+	}, nil
+}
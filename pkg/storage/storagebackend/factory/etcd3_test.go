@@ -0,0 +1,70 @@
+package factory
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/runtime/serializer/json"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"go.etcd.io/etcd/integration"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestETCD3HealthCheck(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 3})
+	defer cluster.Terminate(t)
+	var endpoints []string
+	for i := range cluster.Members {
+		endpoints = append(endpoints, cluster.Client(i).Endpoints()...)
+	}
+
+	codec := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{})
+	c := storagebackend.NewDefaultConfig("/registry", codec)
+	c.Transport.ServerList = endpoints
+	c.HealthcheckTimeout = time.Second
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	healthCheck, err := CreateHealthCheck(*c, stopCh)
+	if err != nil {
+		t.Fatalf("CreateHealthCheck failed: %v", err)
+	}
+
+	// the client is created in the background
+	var checkErr error
+	err = wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		checkErr = healthCheck()
+		return checkErr == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("expected etcd to be healthy, got %v", checkErr)
+	}
+
+	// the cluster keeps its quorum, but the stopped member is reported
+	// within the timeout of the check
+	cluster.Members[2].Stop(t)
+	start := time.Now()
+	checkErr = healthCheck()
+	if elapsed := time.Since(start); elapsed > 2*c.HealthcheckTimeout {
+		t.Errorf("expected the check to return within %v, took %v", c.HealthcheckTimeout, elapsed)
+	}
+	if checkErr == nil || !strings.Contains(checkErr.Error(), endpoints[2]) {
+		t.Fatalf("expected an error naming %s, got %v", endpoints[2], checkErr)
+	}
+	for _, ep := range endpoints[:2] {
+		if strings.Contains(checkErr.Error(), ep) {
+			t.Errorf("expected only the stopped member to be reported, got %v", checkErr)
+		}
+	}
+
+	if err := cluster.Members[2].Restart(t); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	err = wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		checkErr = healthCheck()
+		return checkErr == nil, nil
+	})
+	if err != nil {
+		t.Errorf("expected etcd to be healthy after the restart, got %v", checkErr)
+	}
+}
@@ -1 +1,40 @@
 package factory
+
+import (
+	"fmt"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+)
+
+// DestroyFunc is to destroy any resources used by the storage returned in Create() together.
+type DestroyFunc func()
+
+// Create creates a storage backend based on given config.
+func Create(c storagebackend.Config, newFunc func() runtime.Object) (storage.Interface, DestroyFunc, error) {
+	switch c.Type {
+	case storagebackend.StorageTypeUnset, storagebackend.StorageTypeETCD3:
+		return newETCD3Storage(c, newFunc)
+	case storagebackend.StorageTypeMemory:
+		return newMemoryStorage(c, newFunc)
+	case storagebackend.StorageTypeBolt:
+		return newBoltStorage(c, newFunc)
+	default:
+		return nil, nil, fmt.Errorf("unknown storage type: %s", c.Type)
+	}
+}
+
+// CreateHealthCheck creates a healthcheck function based on given config.
+// Resources used by the healthcheck are released once stopCh is closed.
+func CreateHealthCheck(c storagebackend.Config, stopCh <-chan struct{}) (func() error, error) {
+	switch c.Type {
+	case storagebackend.StorageTypeUnset, storagebackend.StorageTypeETCD3:
+		return newETCD3HealthCheck(c, stopCh)
+	case storagebackend.StorageTypeMemory, storagebackend.StorageTypeBolt:
+		// both live in the process, they are healthy as long as it is
+		return func() error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", c.Type)
+	}
+}
@@ -0,0 +1,30 @@
+package factory
+
+import (
+	"sync"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+	"github.com/x893675/opa-server/pkg/storage"
+	"github.com/x893675/opa-server/pkg/storage/kvstore"
+	"github.com/x893675/opa-server/pkg/storage/memory"
+	"github.com/x893675/opa-server/pkg/storage/storagebackend"
+	"github.com/x893675/opa-server/pkg/storage/value"
+)
+
+var (
+	memoryBackendOnce sync.Once
+	memoryBackend     *memory.Backend
+)
+
+// newMemoryStorage returns a storage on the in-memory backend of the process.
+// All the storages share the backend, like they share an etcd cluster, so
+// that they see each other's writes and use the same resource versions.
+func newMemoryStorage(c storagebackend.Config, newFunc func() runtime.Object) (storage.Interface, DestroyFunc, error) {
+	memoryBackendOnce.Do(func() {
+		memoryBackend = memory.NewBackend(memory.DefaultHistorySize)
+	})
+	// the objects live as long as the process, there is nothing to destroy
+	destroyFunc := func() {}
+	// the values never leave the process, they are not encrypted
+	return kvstore.New(memoryBackend, c.Codec, newFunc, c.Prefix, value.IdentityTransformer, c.Paging), destroyFunc, nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/x893675/opa-server/pkg/runtime"
+)
+
+// TxnOpType is the type of an operation of a transaction.
+type TxnOpType string
+
+const (
+	TxnOpCreate TxnOpType = "create"
+	TxnOpUpdate TxnOpType = "update"
+	TxnOpDelete TxnOpType = "delete"
+)
+
+// TxnOp is an operation of a transaction, as collected by the Txn returned
+// by NewTxn.
+type TxnOp struct {
+	Type TxnOpType
+	Key  string
+	// Obj is the object written by creates and updates.
+	Obj runtime.Object
+	// Out is set to the result of the operation once the transaction is
+	// committed, it is always set for deletes.
+	Out runtime.Object
+	// TTL is the time-to-live in seconds of the object written by creates
+	// and updates, 0 means forever.
+	TTL uint64
+	// Preconditions are the preconditions of deletes.
+	Preconditions *Preconditions
+}
+
+// TxnCommitFunc applies the operations of a transaction atomically.
+type TxnCommitFunc func(ctx context.Context, ops []TxnOp) error
+
+// NewTxn returns a Txn that collects its operations and passes them to
+// commit, once they have been checked for the errors common to all the
+// implementations of Interface.
+func NewTxn(ctx context.Context, commit TxnCommitFunc) Txn {
+	return &txn{ctx: ctx, commit: commit}
+}
+
+type txn struct {
+	ctx    context.Context
+	commit TxnCommitFunc
+	ops    []TxnOp
+}
+
+var _ Txn = &txn{}
+
+// Create implements Txn.Create.
+func (t *txn) Create(key string, obj, out runtime.Object, ttl uint64) Txn {
+	t.ops = append(t.ops, TxnOp{Type: TxnOpCreate, Key: key, Obj: obj, Out: out, TTL: ttl})
+	return t
+}
+
+// Update implements Txn.Update.
+func (t *txn) Update(key string, obj, out runtime.Object, ttl uint64) Txn {
+	t.ops = append(t.ops, TxnOp{Type: TxnOpUpdate, Key: key, Obj: obj, Out: out, TTL: ttl})
+	return t
+}
+
+// Delete implements Txn.Delete.
+func (t *txn) Delete(key string, out runtime.Object, preconditions *Preconditions) Txn {
+	t.ops = append(t.ops, TxnOp{Type: TxnOpDelete, Key: key, Out: out, Preconditions: preconditions})
+	return t
+}
+
+// Commit implements Txn.Commit.
+func (t *txn) Commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+	keys := make(map[string]int, len(t.ops))
+	for i, op := range t.ops {
+		// the keys are joined to the prefix of the store, compare them the
+		// way they end up in the backend
+		key := path.Join("/", op.Key)
+		if j, ok := keys[key]; ok {
+			return NewTxnOpError(NewInvalidObjError(op.Key, fmt.Sprintf("key is already used by operation %d", j)), i, op.Type)
+		}
+		keys[key] = i
+
+		switch {
+		case op.Type != TxnOpDelete && op.Obj == nil:
+			return NewTxnOpError(NewInvalidObjError(op.Key, "no object to write"), i, op.Type)
+		case op.Type == TxnOpDelete && op.Out == nil:
+			return NewTxnOpError(NewInvalidObjError(op.Key, "no object to set to the deleted one"), i, op.Type)
+		}
+	}
+	return t.commit(t.ctx, t.ops)
+}
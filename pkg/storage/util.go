@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"github.com/x893675/opa-server/pkg/runtime"
+)
+
+type SimpleUpdateFunc func(runtimeObj runtime.Object) (runtime.Object, error)
+
+// SimpleUpdateFunc converts SimpleUpdateFunc into UpdateFunc
+func SimpleUpdate(fn SimpleUpdateFunc) UpdateFunc {
+	return func(input runtime.Object, _ ResponseMeta) (runtime.Object, *uint64, error) {
+		out, err := fn(input)
+		return out, nil, err
+	}
+}
@@ -0,0 +1,161 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/storage/value"
+)
+
+func newTestBlock(t *testing.T, size int) cipher.Block {
+	t.Helper()
+	block, err := aes.NewCipher(bytes.Repeat([]byte("k"), size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestRoundTrip(t *testing.T) {
+	lengths := []int{0, 1, 16, 17, 1024}
+	for _, keySize := range []int{16, 24, 32} {
+		block := newTestBlock(t, keySize)
+		transformers := map[string]value.Transformer{
+			"GCM": NewGCMTransformer(block),
+			"CBC": NewCBCTransformer(block),
+		}
+		for name, transformer := range transformers {
+			for _, l := range lengths {
+				t.Run(fmt.Sprintf("%s key %d data %d", name, keySize, l), func(t *testing.T) {
+					data := bytes.Repeat([]byte("d"), l)
+					context := value.DefaultContext("/registry/users/alice")
+					out, err := transformer.TransformToStorage(data, context)
+					if err != nil {
+						t.Fatalf("TransformToStorage failed: %v", err)
+					}
+					if l >= aes.BlockSize && bytes.Contains(out, data) {
+						t.Errorf("expected the stored data not to contain the plain text")
+					}
+					again, err := transformer.TransformToStorage(data, context)
+					if err != nil {
+						t.Fatalf("TransformToStorage failed: %v", err)
+					}
+					if bytes.Equal(out, again) {
+						t.Errorf("expected the random IV to make the stored data differ")
+					}
+
+					result, stale, err := transformer.TransformFromStorage(out, context)
+					if err != nil {
+						t.Fatalf("TransformFromStorage failed: %v", err)
+					}
+					if stale {
+						t.Errorf("expected the data not to be stale")
+					}
+					if !bytes.Equal(data, result) {
+						t.Errorf("expected %q, got %q", data, result)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestGCMRejectsTamperedData(t *testing.T) {
+	transformer := NewGCMTransformer(newTestBlock(t, 32))
+	context := value.DefaultContext("/registry/users/alice")
+	out, err := transformer.TransformToStorage([]byte(`{"name":"alice"}`), context)
+	if err != nil {
+		t.Fatalf("TransformToStorage failed: %v", err)
+	}
+
+	for i := range out {
+		tampered := append([]byte(nil), out...)
+		tampered[i] ^= 1
+		if _, _, err := transformer.TransformFromStorage(tampered, context); err == nil {
+			t.Errorf("expected the data tampered at byte %d to be rejected", i)
+		}
+	}
+	if _, _, err := transformer.TransformFromStorage(out[:len(out)-1], context); err == nil {
+		t.Errorf("expected truncated data to be rejected")
+	}
+	if _, _, err := transformer.TransformFromStorage(out[:4], context); err == nil {
+		t.Errorf("expected data shorter than the nonce to be rejected")
+	}
+
+	// the value is bound to its key, it can not be copied to another one
+	if _, _, err := transformer.TransformFromStorage(out, value.DefaultContext("/registry/users/bob")); err == nil {
+		t.Errorf("expected data read with other authenticated data to be rejected")
+	}
+	// or read with another key
+	other := NewGCMTransformer(newTestBlock(t, 16))
+	if _, _, err := other.TransformFromStorage(out, context); err == nil {
+		t.Errorf("expected data read with another key to be rejected")
+	}
+}
+
+func TestCBCRejectsInvalidData(t *testing.T) {
+	block := newTestBlock(t, 32)
+	transformer := NewCBCTransformer(block)
+	context := value.DefaultContext("/registry/users/alice")
+
+	// encrypt returns the stored form of a plain text that is not padded
+	// by the transformer
+	encrypt := func(plain []byte) []byte {
+		out := make([]byte, aes.BlockSize+len(plain))
+		cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plain)
+		return out
+	}
+	testCases := []struct {
+		name      string
+		data      []byte
+		expectErr error
+	}{
+		{
+			name: "shorter than the IV",
+			data: make([]byte, aes.BlockSize-1),
+		},
+		{
+			name:      "not a multiple of the block size",
+			data:      make([]byte, 2*aes.BlockSize+1),
+			expectErr: errInvalidBlockSize,
+		},
+		{
+			name:      "zero padding",
+			data:      encrypt(make([]byte, aes.BlockSize)),
+			expectErr: errInvalidPKCS7Data,
+		},
+		{
+			name:      "padding longer than the data",
+			data:      encrypt(bytes.Repeat([]byte{aes.BlockSize + 1}, aes.BlockSize)),
+			expectErr: errInvalidPKCS7Data,
+		},
+		{
+			name:      "inconsistent padding",
+			data:      encrypt(append(bytes.Repeat([]byte{1}, aes.BlockSize-1), 2)),
+			expectErr: errInvalidPKCS7Padding,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := transformer.TransformFromStorage(tc.data, context)
+			if err == nil || tc.expectErr != nil && err != tc.expectErr {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+
+	// CBC does not authenticate the data, another key yields garbage or
+	// a padding error but never the plain text
+	plain := []byte(`{"name":"alice"}`)
+	out, err := transformer.TransformToStorage(plain, context)
+	if err != nil {
+		t.Fatalf("TransformToStorage failed: %v", err)
+	}
+	other := NewCBCTransformer(newTestBlock(t, 16))
+	if result, _, err := other.TransformFromStorage(out, context); err == nil && bytes.Equal(result, plain) {
+		t.Errorf("expected data read with another key not to be decrypted")
+	}
+}
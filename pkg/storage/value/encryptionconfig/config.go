@@ -0,0 +1,183 @@
+// Package encryptionconfig builds the transformer of values stored at rest
+// from an encryption provider configuration file, e.g.
+//
+//	providers:
+//	- aesgcm:
+//	    keys:
+//	    - name: key2
+//	      secret: c2VjcmV0IGlzIHNlY3VyZSwgaXMgaXQ/
+//	    - name: key1
+//	      secret: dGhpcyBpcyBwYXNzd29yZA==
+//	- identity: {}
+//
+// Values are written with key2 of the first provider. Values written with
+// key1, or in plain text before encryption was enabled, are still read and
+// are rewritten with key2 on their next update.
+package encryptionconfig
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/x893675/opa-server/pkg/storage/value"
+	aestransformer "github.com/x893675/opa-server/pkg/storage/value/encrypt/aes"
+	"github.com/x893675/opa-server/pkg/storage/value/encrypt/identity"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	aesCBCTransformerPrefixV1 = "k8s:enc:aescbc:v1:"
+	aesGCMTransformerPrefixV1 = "k8s:enc:aesgcm:v1:"
+)
+
+// GetTransformer reads the configuration at filepath and returns the
+// transformer it describes.
+func GetTransformer(filepath string) (value.Transformer, error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening encryption provider configuration file %q: %v", filepath, err)
+	}
+
+	result, err := ParseEncryptionConfiguration(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing encryption provider configuration file %q: %v", filepath, err)
+	}
+	return result, nil
+}
+
+// ParseEncryptionConfiguration returns the transformer described by the YAML
+// or JSON encoded EncryptionConfiguration data.
+func ParseEncryptionConfiguration(data []byte) (value.Transformer, error) {
+	config := &EncryptionConfiguration{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if len(config.Providers) == 0 {
+		return nil, errors.New("at least one provider is required")
+	}
+
+	transformers, err := prefixTransformers(config)
+	if err != nil {
+		return nil, err
+	}
+	return value.NewMutableTransformer(value.NewPrefixTransformers(fmt.Errorf("no matching prefix found"), transformers...)), nil
+}
+
+func prefixTransformers(config *EncryptionConfiguration) ([]value.PrefixTransformer, error) {
+	var result []value.PrefixTransformer
+	for i, provider := range config.Providers {
+		if n := countProviders(provider); n != 1 {
+			return nil, fmt.Errorf("provider %d must set exactly one of aesgcm, aescbc or identity, found %d", i, n)
+		}
+
+		var (
+			transformer value.PrefixTransformer
+			err         error
+		)
+
+		switch {
+		case provider.AESGCM != nil:
+			transformer, err = aesPrefixTransformer(provider.AESGCM, aestransformer.NewGCMTransformer, aesGCMTransformerPrefixV1, 16, 24, 32)
+		case provider.AESCBC != nil:
+			// like kube-apiserver only 256 bit keys are accepted for
+			// aescbc, shorter keys are left to aesgcm
+			transformer, err = aesPrefixTransformer(provider.AESCBC, aestransformer.NewCBCTransformer, aesCBCTransformerPrefixV1, 32)
+		case provider.Identity != nil:
+			transformer = value.PrefixTransformer{
+				Transformer: identity.NewEncryptCheckTransformer(),
+				Prefix:      []byte{},
+			}
+		}
+
+		if err != nil {
+			return result, err
+		}
+		result = append(result, transformer)
+	}
+	return result, nil
+}
+
+func countProviders(provider ProviderConfiguration) int {
+	n := 0
+	if provider.AESGCM != nil {
+		n++
+	}
+	if provider.AESCBC != nil {
+		n++
+	}
+	if provider.Identity != nil {
+		n++
+	}
+	return n
+}
+
+type blockTransformerFunc func(cipher.Block) value.Transformer
+
+func aesPrefixTransformer(config *AESConfiguration, fn blockTransformerFunc, prefix string, keyLengths ...int) (value.PrefixTransformer, error) {
+	var result value.PrefixTransformer
+
+	if len(config.Keys) == 0 {
+		return result, fmt.Errorf("aes provider has no valid keys")
+	}
+	names := map[string]bool{}
+	for _, key := range config.Keys {
+		if key.Name == "" {
+			return result, fmt.Errorf("key with invalid name provided")
+		}
+		if strings.Contains(key.Name, ":") {
+			return result, fmt.Errorf("key name %q must not contain ':'", key.Name)
+		}
+		if names[key.Name] {
+			return result, fmt.Errorf("duplicate key name %q", key.Name)
+		}
+		names[key.Name] = true
+		if key.Secret == "" {
+			return result, fmt.Errorf("key %v has no provided secret", key.Name)
+		}
+	}
+
+	keyTransformers := []value.PrefixTransformer{}
+
+	for _, keyData := range config.Keys {
+		key, err := base64.StdEncoding.DecodeString(keyData.Secret)
+		if err != nil {
+			return result, fmt.Errorf("could not obtain secret for named key %s: %s", keyData.Name, err)
+		}
+		if !validKeyLength(len(key), keyLengths) {
+			return result, fmt.Errorf("secret of named key %s is %d bytes long, expected one of %v", keyData.Name, len(key), keyLengths)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return result, fmt.Errorf("error while creating cipher for named key %s: %s", keyData.Name, err)
+		}
+
+		keyTransformers = append(keyTransformers,
+			value.PrefixTransformer{
+				Transformer: fn(block),
+				Prefix:      []byte(keyData.Name + ":"),
+			})
+	}
+
+	keyTransformer := value.NewPrefixTransformers(
+		fmt.Errorf("no matching key was found for the provided AES transformer"), keyTransformers...)
+
+	result = value.PrefixTransformer{
+		Transformer: keyTransformer,
+		Prefix:      []byte(prefix),
+	}
+	return result, nil
+}
+
+func validKeyLength(n int, keyLengths []int) bool {
+	for _, l := range keyLengths {
+		if n == l {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package encryptionconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/x893675/opa-server/pkg/storage/value"
+)
+
+// testSecret returns a base64 encoded key of size bytes.
+func testSecret(c byte, size int) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{c}, size))
+}
+
+func mustParse(t *testing.T, config string) value.Transformer {
+	t.Helper()
+	transformer, err := ParseEncryptionConfiguration([]byte(config))
+	if err != nil {
+		t.Fatalf("ParseEncryptionConfiguration failed: %v", err)
+	}
+	return transformer
+}
+
+var (
+	key1 = testSecret('1', 32)
+	key2 = testSecret('2', 32)
+
+	key1Config = fmt.Sprintf(`
+providers:
+- %%s:
+    keys:
+    - name: key1
+      secret: %s
+`, key1)
+
+	// rotatedConfig writes with key2 and still reads key1
+	rotatedConfig = fmt.Sprintf(`
+providers:
+- %%s:
+    keys:
+    - name: key2
+      secret: %s
+    - name: key1
+      secret: %s
+`, key2, key1)
+)
+
+func TestKeyRotation(t *testing.T) {
+	context := value.DefaultContext("/registry/users/alice")
+	data := []byte(`{"name":"alice"}`)
+	for _, provider := range []string{"aesgcm", "aescbc"} {
+		t.Run(provider, func(t *testing.T) {
+			old := mustParse(t, fmt.Sprintf(key1Config, provider))
+			rotated := mustParse(t, fmt.Sprintf(rotatedConfig, provider))
+
+			stored, err := old.TransformToStorage(data, context)
+			if err != nil {
+				t.Fatalf("TransformToStorage failed: %v", err)
+			}
+			if prefix := "k8s:enc:" + provider + ":v1:key1:"; !bytes.HasPrefix(stored, []byte(prefix)) {
+				t.Errorf("expected the stored data to start with %q, got %q", prefix, stored)
+			}
+
+			// the value of the old key is read, but has to be rewritten
+			result, stale, err := rotated.TransformFromStorage(stored, context)
+			if err != nil {
+				t.Fatalf("TransformFromStorage failed: %v", err)
+			}
+			if !stale {
+				t.Errorf("expected the value of a non-first key to be stale")
+			}
+			if !bytes.Equal(result, data) {
+				t.Errorf("expected %q, got %q", data, result)
+			}
+
+			stored, err = rotated.TransformToStorage(data, context)
+			if err != nil {
+				t.Fatalf("TransformToStorage failed: %v", err)
+			}
+			if prefix := "k8s:enc:" + provider + ":v1:key2:"; !bytes.HasPrefix(stored, []byte(prefix)) {
+				t.Errorf("expected the stored data to start with %q, got %q", prefix, stored)
+			}
+			if _, stale, err := rotated.TransformFromStorage(stored, context); err != nil || stale {
+				t.Errorf("expected the value of the first key not to be stale, got %v, %v", stale, err)
+			}
+			if _, _, err := old.TransformFromStorage(stored, context); err == nil {
+				t.Errorf("expected the value of key2 not to be read without it")
+			}
+		})
+	}
+}
+
+func TestIdentityFallback(t *testing.T) {
+	context := value.DefaultContext("/registry/users/alice")
+	data := []byte(`{"name":"alice"}`)
+	encrypted := mustParse(t, fmt.Sprintf(`
+providers:
+- aesgcm:
+    keys:
+    - name: key1
+      secret: %s
+- identity: {}
+`, key1))
+
+	// plain text written before encryption was enabled is still read, and
+	// encrypted on the next write
+	result, stale, err := encrypted.TransformFromStorage(data, context)
+	if err != nil {
+		t.Fatalf("TransformFromStorage failed: %v", err)
+	}
+	if !stale || !bytes.Equal(result, data) {
+		t.Errorf("expected %q to be read as stale, got %q, %v", data, result, stale)
+	}
+	stored, err := encrypted.TransformToStorage(data, context)
+	if err != nil {
+		t.Fatalf("TransformToStorage failed: %v", err)
+	}
+	if bytes.Contains(stored, data) {
+		t.Errorf("expected the plain text to be encrypted, got %q", stored)
+	}
+
+	// identity first decrypts the values that are still encrypted, and
+	// writes plain text
+	decrypted := mustParse(t, fmt.Sprintf(`
+providers:
+- identity: {}
+- aesgcm:
+    keys:
+    - name: key1
+      secret: %s
+`, key1))
+	result, stale, err = decrypted.TransformFromStorage(stored, context)
+	if err != nil {
+		t.Fatalf("TransformFromStorage failed: %v", err)
+	}
+	if !stale || !bytes.Equal(result, data) {
+		t.Errorf("expected %q to be read as stale, got %q, %v", data, result, stale)
+	}
+	if stored, err := decrypted.TransformToStorage(data, context); err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("expected the plain text to be written, got %q, %v", stored, err)
+	}
+
+	// without the key the encrypted values can not be read
+	identity := mustParse(t, "providers:\n- identity: {}\n")
+	if _, _, err := identity.TransformFromStorage(stored, context); err == nil {
+		t.Errorf("expected encrypted data not to be read by identity")
+	}
+}
+
+func TestKeyLengths(t *testing.T) {
+	testCases := []struct {
+		provider  string
+		size      int
+		expectErr bool
+	}{
+		{provider: "aesgcm", size: 16},
+		{provider: "aesgcm", size: 24},
+		{provider: "aesgcm", size: 32},
+		{provider: "aesgcm", size: 8, expectErr: true},
+		{provider: "aesgcm", size: 64, expectErr: true},
+		// aescbc only accepts 256 bit keys
+		{provider: "aescbc", size: 32},
+		{provider: "aescbc", size: 16, expectErr: true},
+		{provider: "aescbc", size: 24, expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %d", tc.provider, tc.size), func(t *testing.T) {
+			config := fmt.Sprintf(`
+providers:
+- %s:
+    keys:
+    - name: key1
+      secret: %s
+`, tc.provider, testSecret('k', tc.size))
+			_, err := ParseEncryptionConfiguration([]byte(config))
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("is %d bytes long", tc.size)) {
+				t.Errorf("expected the error to name the key length, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInvalidConfiguration(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "no providers",
+			config: "providers: []\n",
+		},
+		{
+			name:   "unknown field",
+			config: "providers:\n- identity: {}\n  kms: {}\n",
+		},
+		{
+			name:   "two providers in one",
+			config: fmt.Sprintf("providers:\n- identity: {}\n  aesgcm:\n    keys:\n    - name: key1\n      secret: %s\n", key1),
+		},
+		{
+			name:   "no keys",
+			config: "providers:\n- aesgcm:\n    keys: []\n",
+		},
+		{
+			name:   "no key name",
+			config: fmt.Sprintf("providers:\n- aesgcm:\n    keys:\n    - secret: %s\n", key1),
+		},
+		{
+			name:   "colon in key name",
+			config: fmt.Sprintf("providers:\n- aesgcm:\n    keys:\n    - name: key:1\n      secret: %s\n", key1),
+		},
+		{
+			name:   "duplicate key name",
+			config: fmt.Sprintf("providers:\n- aesgcm:\n    keys:\n    - name: key1\n      secret: %s\n    - name: key1\n      secret: %s\n", key1, key2),
+		},
+		{
+			name:   "no secret",
+			config: "providers:\n- aesgcm:\n    keys:\n    - name: key1\n",
+		},
+		{
+			name:   "secret not base64",
+			config: "providers:\n- aesgcm:\n    keys:\n    - name: key1\n      secret: not base64!\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseEncryptionConfiguration([]byte(tc.config)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestGetTransformer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption.yaml")
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(key1Config, "aesgcm")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	transformer, err := GetTransformer(path)
+	if err != nil {
+		t.Fatalf("GetTransformer failed: %v", err)
+	}
+	if _, err := transformer.TransformToStorage([]byte("data"), value.DefaultContext("key")); err != nil {
+		t.Errorf("TransformToStorage failed: %v", err)
+	}
+
+	if _, err := GetTransformer(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("expected a missing file to fail")
+	}
+}
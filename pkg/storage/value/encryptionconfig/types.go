@@ -0,0 +1,45 @@
+package encryptionconfig
+
+// EncryptionConfiguration stores the providers that transform the values
+// written to and read from storage.
+type EncryptionConfiguration struct {
+	// Providers is the ordered list of providers. The first provider is used
+	// to transform values written to storage. Values read from storage are
+	// transformed by the first provider their prefix matches, values that
+	// were not written by the first provider are rewritten on their next
+	// update.
+	Providers []ProviderConfiguration `json:"providers"`
+}
+
+// ProviderConfiguration stores the configuration of a single provider,
+// exactly one of its fields must be set.
+type ProviderConfiguration struct {
+	// AESGCM is the configuration for the AES-GCM transformer.
+	AESGCM *AESConfiguration `json:"aesgcm,omitempty"`
+	// AESCBC is the configuration for the AES-CBC transformer.
+	AESCBC *AESConfiguration `json:"aescbc,omitempty"`
+	// Identity is the (empty) configuration for the identity transformer,
+	// which stores values as plain text.
+	Identity *IdentityConfiguration `json:"identity,omitempty"`
+}
+
+// AESConfiguration contains the API configuration for an AES transformer.
+type AESConfiguration struct {
+	// Keys is a list of keys to be used for creating the AES transformer.
+	// Each key has to be 32 bytes long for AES-CBC and 16, 24 or 32 bytes for
+	// AES-GCM. The first key is used for encryption, all of them for
+	// decryption, which allows to rotate keys.
+	Keys []Key `json:"keys"`
+}
+
+// Key contains name and secret of the provided key for a transformer.
+type Key struct {
+	// Name is the name of the key to be used while storing data to disk.
+	Name string `json:"name"`
+	// Secret is the actual key, encoded in base64.
+	Secret string `json:"secret"`
+}
+
+// IdentityConfiguration is an empty struct to allow identity transformer in
+// provider configuration.
+type IdentityConfiguration struct{}
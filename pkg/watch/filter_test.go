@@ -0,0 +1,103 @@
+package watch
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestFilter(t *testing.T) {
+	table := []Event{
+		{Type: Added, Object: &myType{"foo", ""}},
+		{Type: Added, Object: &myType{"bar", ""}},
+		{Type: Added, Object: &myType{"baz", ""}},
+		{Type: Added, Object: &myType{"qux", ""}},
+		{Type: Added, Object: &myType{"zoo", ""}},
+	}
+
+	source := NewFake()
+	filtered := Filter(source, func(e Event) (Event, bool) {
+		id := e.Object.(*myType).ID
+		if id[0] == 'z' {
+			return e, false
+		}
+		e.Object.(*myType).Value = id + id
+		return e, true
+	})
+
+	go func() {
+		for _, item := range table {
+			source.Action(item.Type, item.Object)
+		}
+		source.Stop()
+	}()
+
+	var got []string
+	for {
+		event, ok := <-filtered.ResultChan()
+		if !ok {
+			break
+		}
+		got = append(got, event.Object.(*myType).Value)
+	}
+
+	if e, a := []string{"foofoo", "barbar", "bazbaz", "quxqux"}, got; !reflect.DeepEqual(e, a) {
+		t.Errorf("got %v, wanted %v", e, a)
+	}
+}
+
+func TestFilterStop(t *testing.T) {
+	source := NewFake()
+	filtered := Filter(source, func(e Event) (Event, bool) {
+		return e, true
+	})
+
+	go func() {
+		source.Add(&myType{"foo", ""})
+		filtered.Stop()
+	}()
+
+	var got []string
+	for {
+		event, ok := <-filtered.ResultChan()
+		if !ok {
+			break
+		}
+		got = append(got, event.Object.(*myType).ID)
+	}
+
+	// the event being sent when the watch is stopped may be dropped
+	if len(got) > 1 || (len(got) == 1 && got[0] != "foo") {
+		t.Errorf("got %v, wanted at most [foo]", got)
+	}
+}
+
+// TestFilterStopWithoutReader tests that stopping a filtered watch whose
+// events are not read anymore doesn't leave it blocked on sending one.
+func TestFilterStopWithoutReader(t *testing.T) {
+	source := NewFakeWithChanSize(1, false)
+	filtered := Filter(source, func(e Event) (Event, bool) {
+		return e, true
+	})
+	source.Add(&myType{"foo", ""})
+	// give the filter time to block on sending the event
+	time.Sleep(100 * time.Millisecond)
+	filtered.Stop()
+
+	select {
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timeout: filtered watch not closed")
+	case _, ok := <-filtered.ResultChan():
+		// the event may still be received before the watch is closed
+		if ok {
+			if _, ok := <-filtered.ResultChan(); ok {
+				t.Errorf("expected the filtered watch to be closed")
+			}
+		}
+	}
+	if !source.IsStopped() {
+		t.Errorf("expected the source to be stopped")
+	}
+}
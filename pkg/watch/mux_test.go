@@ -0,0 +1,244 @@
+package watch
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type myType struct {
+	ID    string
+	Value string
+}
+
+func (obj *myType) GetObjectKind() schema.ObjectKind { return schema.EmptyObjectKind }
+
+func (obj *myType) SetZeroValue() error {
+	*obj = myType{}
+	return nil
+}
+
+func TestBroadcaster(t *testing.T) {
+	table := []Event{
+		{Type: Added, Object: &myType{"foo", "hello world 1"}},
+		{Type: Added, Object: &myType{"bar", "hello world 2"}},
+		{Type: Modified, Object: &myType{"foo", "goodbye world 3"}},
+		{Type: Deleted, Object: &myType{"bar", "hello world 4"}},
+	}
+
+	// The broadcaster we're testing
+	m := NewBroadcaster(0, WaitIfChannelFull)
+
+	// Add a bunch of watchers
+	const testWatchers = 2
+	wg := sync.WaitGroup{}
+	wg.Add(testWatchers)
+	for i := 0; i < testWatchers; i++ {
+		// Verify that each watcher gets the events in the correct order
+		go func(watcher int, w Interface) {
+			tableLine := 0
+			for {
+				event, ok := <-w.ResultChan()
+				if !ok {
+					break
+				}
+				if e, a := table[tableLine], event; !reflect.DeepEqual(e, a) {
+					t.Errorf("Watcher %v, line %v: Expected (%v, %#v), got (%v, %#v)",
+						watcher, tableLine, e.Type, e.Object, a.Type, a.Object)
+				} else {
+					t.Logf("Got (%v, %#v)", event.Type, event.Object)
+				}
+				tableLine++
+			}
+			wg.Done()
+		}(i, m.Watch())
+	}
+
+	for i, item := range table {
+		t.Logf("Sending %v", i)
+		m.Action(item.Type, item.Object)
+	}
+
+	m.Shutdown()
+
+	wg.Wait()
+}
+
+func TestBroadcasterWatcherClose(t *testing.T) {
+	m := NewBroadcaster(0, WaitIfChannelFull)
+	w := m.Watch()
+	w2 := m.Watch()
+	w.Stop()
+	m.Shutdown()
+	if _, open := <-w.ResultChan(); open {
+		t.Errorf("Stop didn't work?")
+	}
+	if _, open := <-w2.ResultChan(); open {
+		t.Errorf("Shutdown didn't work?")
+	}
+	// Extra stops don't hurt things
+	w.Stop()
+	w2.Stop()
+}
+
+func TestBroadcasterWatcherStopDeadlock(t *testing.T) {
+	done := make(chan bool)
+	m := NewBroadcaster(0, WaitIfChannelFull)
+	go func(w0, w1 Interface) {
+		// We know Broadcaster is in the distribute loop once one watcher receives
+		// an event. Stop the other watcher while distribute is trying to
+		// send to it.
+		select {
+		case <-w0.ResultChan():
+			w1.Stop()
+		case <-w1.ResultChan():
+			w0.Stop()
+		}
+		close(done)
+	}(m.Watch(), m.Watch())
+	m.Action(Added, &myType{})
+	select {
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Error("timeout: deadlocked")
+	case <-done:
+	}
+	m.Shutdown()
+}
+
+// TestBroadcasterWaitIfChannelFull tests that a watcher whose queue is full
+// holds up the distribution of the events, but doesn't lose any of them.
+func TestBroadcasterWaitIfChannelFull(t *testing.T) {
+	m := NewBroadcaster(1, WaitIfChannelFull)
+	w := m.Watch()
+
+	// the first event fills the queue of w, the second one is being
+	// distributed to it
+	m.Action(Added, &myType{"foo", "1"})
+	m.Action(Modified, &myType{"foo", "2"})
+	added := make(chan Interface)
+	go func() {
+		added <- m.Watch()
+	}()
+	select {
+	case <-added:
+		t.Fatal("expected adding a watcher to wait for the distribution to the full watcher")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for _, value := range []string{"1", "2"} {
+		event := <-w.ResultChan()
+		if obj := event.Object.(*myType); obj.Value != value {
+			t.Errorf("expected event %s, got %#v", value, obj)
+		}
+	}
+	select {
+	case w2 := <-added:
+		w2.Stop()
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timeout: watcher not added after the full watcher was drained")
+	}
+	m.Shutdown()
+	if _, open := <-w.ResultChan(); open {
+		t.Errorf("expected no further events")
+	}
+}
+
+// TestBroadcasterDropIfChannelFull tests that the events a watcher has no
+// room for are dropped for it only.
+func TestBroadcasterDropIfChannelFull(t *testing.T) {
+	m := NewBroadcaster(1, DropIfChannelFull)
+
+	event1 := Event{Type: Added, Object: &myType{"foo", "good"}}
+	event2 := Event{Type: Added, Object: &myType{"bar", "hello world"}}
+
+	// Add a couple watchers
+	watches := make([]Interface, 2)
+	for i := range watches {
+		watches[i] = m.Watch()
+	}
+
+	// Send a couple events before closing the broadcast channel.
+	t.Log("Sending event 1")
+	m.Action(event1.Type, event1.Object)
+	t.Log("Sending event 2")
+	m.Action(event2.Type, event2.Object)
+	m.Shutdown()
+
+	// Pull events from the queue.
+	wg := sync.WaitGroup{}
+	wg.Add(len(watches))
+	for i := range watches {
+		// Verify that each watcher only gets the first event because its watch
+		// queue of length one was full from the first one.
+		go func(watcher int, w Interface) {
+			defer wg.Done()
+			e1, ok := <-w.ResultChan()
+			if !ok {
+				t.Errorf("Watcher %v failed to retrieve first event.", watcher)
+			}
+			if e, a := event1, e1; !reflect.DeepEqual(e, a) {
+				t.Errorf("Watcher %v: Expected (%v, %#v), got (%v, %#v)",
+					watcher, e.Type, e.Object, a.Type, a.Object)
+			}
+			t.Logf("Got (%v, %#v)", e1.Type, e1.Object)
+			e2, ok := <-w.ResultChan()
+			if ok {
+				t.Errorf("Watcher %v received second event (%v, %#v) even though it shouldn't have.",
+					watcher, e2.Type, e2.Object)
+			}
+		}(i, watches[i])
+	}
+	wg.Wait()
+}
+
+// TestBroadcasterWatchWithPrefix tests that the queue of a watcher is made
+// large enough for the events it is started with.
+func TestBroadcasterWatchWithPrefix(t *testing.T) {
+	m := NewBroadcaster(1, DropIfChannelFull)
+	prefix := []Event{
+		{Type: Added, Object: &myType{"foo", "1"}},
+		{Type: Added, Object: &myType{"bar", "2"}},
+		{Type: Modified, Object: &myType{"foo", "3"}},
+	}
+	w := m.WatchWithPrefix(prefix)
+	if c := cap(w.(*broadcasterWatcher).result); c != len(prefix)+1 {
+		t.Errorf("expected a queue length of %d, got %d", len(prefix)+1, c)
+	}
+
+	// there is room for one event after the prefix, the next one is dropped
+	m.Action(Deleted, &myType{"bar", "4"})
+	m.Action(Deleted, &myType{"foo", "5"})
+	m.Shutdown()
+	var values []string
+	for event := range w.ResultChan() {
+		values = append(values, event.Object.(*myType).Value)
+	}
+	if e, a := []string{"1", "2", "3", "4"}, values; !reflect.DeepEqual(e, a) {
+		t.Errorf("expected events %v, got %v", e, a)
+	}
+}
+
+func TestBroadcasterForward(t *testing.T) {
+	source := NewFake()
+	m := NewBroadcaster(10, WaitIfChannelFull)
+	w1, w2 := m.Watch(), m.Watch()
+	m.Forward(source)
+
+	source.Add(&myType{"foo", "1"})
+	source.Modify(&myType{"foo", "2"})
+	// the broadcaster is shut down once the source is drained
+	source.Stop()
+	for _, w := range []Interface{w1, w2} {
+		var values []string
+		for event := range w.ResultChan() {
+			values = append(values, event.Object.(*myType).Value)
+		}
+		if e, a := []string{"1", "2"}, values; !reflect.DeepEqual(e, a) {
+			t.Errorf("expected events %v, got %v", e, a)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// WatchFunc starts a watch of the changes made after resourceVersion. The
+// watch should deliver BOOKMARK events, so that it can be resumed from a
+// recent resource version even if none of the watched objects changes.
+type WatchFunc func(ctx context.Context, resourceVersion string) (Interface, error)
+
+// RetryWatcher will make sure that in case the underlying watcher is closed (e.g. due to API timeout or etcd timeout)
+// it will get restarted from the last point without the consumer even knowing about it.
+// RetryWatcher does that by inspecting events and keeping track of resourceVersion.
+// Especially useful when using watch.Until where we need to have the watch open
+// for a long time and losing events at the end of a single watch is not acceptable.
+// Note that this can still fail if the resource version the watch has to be
+// restarted from has been compacted, the watcher then sends an event that
+// IsRelistRequired returns true for and closes.
+type RetryWatcher struct {
+	lastResourceVersion string
+	watchFunc           WatchFunc
+	resultChan          chan Event
+	stopChan            chan struct{}
+	doneChan            chan struct{}
+	minRestartDelay     time.Duration
+}
+
+// NewRetryWatcher creates a new RetryWatcher.
+// It will make sure that watches gets restarted in case of recoverable errors.
+// The initialResourceVersion will be given to watchFunc when first called.
+func NewRetryWatcher(initialResourceVersion string, watchFunc WatchFunc) (*RetryWatcher, error) {
+	return newRetryWatcher(initialResourceVersion, watchFunc, 1*time.Second)
+}
+
+func newRetryWatcher(initialResourceVersion string, watchFunc WatchFunc, minRestartDelay time.Duration) (*RetryWatcher, error) {
+	switch initialResourceVersion {
+	case "", "0":
+		// A watch from "0" starts with the current state instead of the
+		// changes after a known point, it can not be resumed consistently.
+		return nil, fmt.Errorf("initial RV %q is not supported due to issues with underlying WATCH", initialResourceVersion)
+	default:
+		break
+	}
+
+	rw := &RetryWatcher{
+		lastResourceVersion: initialResourceVersion,
+		watchFunc:           watchFunc,
+		stopChan:            make(chan struct{}),
+		doneChan:            make(chan struct{}),
+		resultChan:          make(chan Event),
+		minRestartDelay:     minRestartDelay,
+	}
+
+	go rw.receive()
+	return rw, nil
+}
+
+// IsRelistRequired returns true if event is the error a RetryWatcher ends
+// with because the resource version it would resume from is too old. The
+// consumer has to list the current state again and watch from the resource
+// version of the list.
+func IsRelistRequired(event Event) bool {
+	if event.Type != Error {
+		return false
+	}
+	status, ok := event.Object.(*meta.Status)
+	return ok && status.Code == http.StatusGone
+}
+
+func (rw *RetryWatcher) send(event Event) bool {
+	// Writing to an unbuffered channel is blocking operation
+	// and we need to check if stop wasn't requested while doing so.
+	select {
+	case rw.resultChan <- event:
+		return true
+	case <-rw.stopChan:
+		return false
+	}
+}
+
+// doReceive returns true when it is done, false otherwise.
+// If it is not done the second return value holds the time to wait before calling it again.
+func (rw *RetryWatcher) doReceive(ctx context.Context) (bool, time.Duration) {
+	watcher, err := rw.watchFunc(ctx, rw.lastResourceVersion)
+	if err != nil {
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			// Never retry RV too old errors
+			_ = rw.send(Event{Type: Error, Object: &meta.Status{Status: err.(apierrors.APIStatus).Status()}})
+			return true, 0
+		}
+		klog.ErrorS(err, "Watch failed", "resourceVersion", rw.lastResourceVersion)
+		// Retry
+		return false, 0
+	}
+
+	if watcher == nil {
+		klog.ErrorS(nil, "Watch returned nil watcher")
+		// Retry
+		return false, 0
+	}
+
+	ch := watcher.ResultChan()
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-rw.stopChan:
+			klog.V(4).InfoS("Stopping RetryWatcher")
+			return true, 0
+		case event, ok := <-ch:
+			if !ok {
+				klog.V(4).InfoS("Failed to get event, re-creating the watcher", "resourceVersion", rw.lastResourceVersion)
+				return false, 0
+			}
+
+			// We need to inspect the event and get ResourceVersion out of it
+			switch event.Type {
+			case Added, Modified, Deleted, Bookmark:
+				accessor, err := meta.Accessor(event.Object)
+				if err != nil {
+					_ = rw.send(Event{
+						Type:   Error,
+						Object: &meta.Status{Status: apierrors.NewInternalError(errors.New("retryWatcher: doesn't support resourceVersion")).ErrStatus},
+					})
+					// We have to abort here because this might cause lastResourceVersion inconsistency by skipping a potential RV with valid data!
+					return true, 0
+				}
+
+				resourceVersion := accessor.GetResourceVersion()
+				if resourceVersion == "" {
+					_ = rw.send(Event{
+						Type:   Error,
+						Object: &meta.Status{Status: apierrors.NewInternalError(fmt.Errorf("retryWatcher: object %#v doesn't support resourceVersion", event.Object)).ErrStatus},
+					})
+					// We have to abort here because this might cause lastResourceVersion inconsistency by skipping a potential RV with valid data!
+					return true, 0
+				}
+
+				// All is fine; send the non-bookmark events and update resource version.
+				if event.Type != Bookmark {
+					ok = rw.send(event)
+					if !ok {
+						return true, 0
+					}
+				}
+				rw.lastResourceVersion = resourceVersion
+
+				continue
+
+			case Error:
+				status, ok := event.Object.(*meta.Status)
+				if !ok {
+					klog.ErrorS(nil, "Received an error which is not *meta.Status", "object", event.Object)
+					// Retry unknown errors
+					return false, 0
+				}
+
+				statusDelay := time.Duration(0)
+				if status.Details != nil {
+					statusDelay = time.Duration(status.Details.RetryAfterSeconds) * time.Second
+				}
+
+				switch status.Code {
+				case http.StatusGone:
+					// Never retry RV too old errors
+					_ = rw.send(event)
+					return true, 0
+
+				case http.StatusGatewayTimeout, http.StatusInternalServerError:
+					// Retry
+					klog.V(2).InfoS("Watch ended with an error, retrying", "resourceVersion", rw.lastResourceVersion, "message", status.Message)
+					return false, statusDelay
+
+				default:
+					// We retry by default. RetryWatcher is meant to proceed unless it is certain
+					// that it can't. If we are not certain, we proceed with retry and leave it
+					// up to the user to timeout if needed.
+
+					// Log here so we have a record of hitting the unexpected error
+					// and we can add the error codes we missed that are expected.
+					klog.V(5).InfoS("Retrying after unexpected error", "status", status)
+
+					// Retry
+					return false, statusDelay
+				}
+
+			default:
+				klog.ErrorS(nil, "Failed to recognize event type", "type", event.Type)
+				_ = rw.send(Event{
+					Type:   Error,
+					Object: &meta.Status{Status: apierrors.NewInternalError(fmt.Errorf("retryWatcher failed to recognize Event type %q", event.Type)).ErrStatus},
+				})
+				// We are unable to restart the watch and have to stop the loop or this might cause lastResourceVersion inconsistency by skipping a potential RV with valid data!
+				return true, 0
+			}
+		}
+	}
+}
+
+// receive reads the result from a watcher, restarting it if necessary.
+func (rw *RetryWatcher) receive() {
+	defer close(rw.doneChan)
+	defer close(rw.resultChan)
+
+	klog.V(4).InfoS("Starting RetryWatcher")
+	defer klog.V(4).InfoS("Stopping RetryWatcher")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-rw.stopChan:
+			cancel()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}()
+
+	// We use non sliding until so we don't introduce delays on happy path when WATCH call
+	// timeouts or gets closed and we need to reestablish it while also avoiding hot loops.
+	wait.NonSlidingUntilWithContext(ctx, func(ctx context.Context) {
+		done, retryAfter := rw.doReceive(ctx)
+		if done {
+			cancel()
+			return
+		}
+
+		time.Sleep(retryAfter)
+
+		klog.V(4).InfoS("Restarting RetryWatcher", "resourceVersion", rw.lastResourceVersion)
+	}, rw.minRestartDelay)
+}
+
+// ResultChan implements Interface.
+func (rw *RetryWatcher) ResultChan() <-chan Event {
+	return rw.resultChan
+}
+
+// Stop implements Interface.
+func (rw *RetryWatcher) Stop() {
+	close(rw.stopChan)
+}
+
+// Done allows the caller to be notified when Retry watcher stops.
+func (rw *RetryWatcher) Done() <-chan struct{} {
+	return rw.doneChan
+}
@@ -0,0 +1,317 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x893675/opa-server/pkg/model"
+	"github.com/x893675/opa-server/pkg/storage/meta"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newUser(name, resourceVersion string) *model.User {
+	return &model.User{ObjectMeta: meta.ObjectMeta{Name: name, ResourceVersion: resourceVersion}}
+}
+
+// testWatchFunc is a WatchFunc recording the resource versions it is called
+// with. Every watch is a FakeWatcher sent to watchers, unless an error is
+// queued for it in errs.
+type testWatchFunc struct {
+	lock     sync.Mutex
+	versions []string
+	errs     []error
+	watchers chan *FakeWatcher
+}
+
+func newTestWatchFunc() *testWatchFunc {
+	return &testWatchFunc{watchers: make(chan *FakeWatcher, 10)}
+}
+
+func (f *testWatchFunc) watch(_ context.Context, resourceVersion string) (Interface, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.versions = append(f.versions, resourceVersion)
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	w := NewFake()
+	f.watchers <- w
+	return w, nil
+}
+
+// failNext makes the next watches fail with errs.
+func (f *testWatchFunc) failNext(errs ...error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.errs = append(f.errs, errs...)
+}
+
+func (f *testWatchFunc) resourceVersions() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.versions...)
+}
+
+func (f *testWatchFunc) nextWatcher(t *testing.T) *FakeWatcher {
+	t.Helper()
+	select {
+	case w := <-f.watchers:
+		return w
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for a watch")
+		return nil
+	}
+}
+
+func newTestRetryWatcher(t *testing.T, f *testWatchFunc) *RetryWatcher {
+	t.Helper()
+	rw, err := newRetryWatcher("1", f.watch, time.Millisecond)
+	if err != nil {
+		t.Fatalf("newRetryWatcher failed: %v", err)
+	}
+	return rw
+}
+
+func expectEvent(t *testing.T, w Interface, eventType EventType, resourceVersion string) Event {
+	t.Helper()
+	select {
+	case event, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatalf("expected a %s event, the watch is closed", eventType)
+		}
+		if event.Type != eventType {
+			t.Fatalf("expected a %s event, got %#v", eventType, event)
+		}
+		if eventType != Error {
+			if rv := event.Object.(*model.User).ResourceVersion; rv != resourceVersion {
+				t.Errorf("expected a %s event at %s, got %s", eventType, resourceVersion, rv)
+			}
+		}
+		return event
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for a %s event", eventType)
+		return Event{}
+	}
+}
+
+func expectClosed(t *testing.T, rw *RetryWatcher) {
+	t.Helper()
+	select {
+	case event, ok := <-rw.ResultChan():
+		if ok {
+			t.Fatalf("expected the watch to be closed, got %#v", event)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for the watch to close")
+	}
+	select {
+	case <-rw.Done():
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for the watcher to be done")
+	}
+}
+
+func expectVersions(t *testing.T, f *testWatchFunc, expected ...string) {
+	t.Helper()
+	got := f.resourceVersions()
+	if len(got) != len(expected) {
+		t.Fatalf("expected watches from %q, got %q", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected watches from %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestRetryWatcherInitialResourceVersion(t *testing.T) {
+	for _, rv := range []string{"", "0"} {
+		if _, err := NewRetryWatcher(rv, newTestWatchFunc().watch); err == nil {
+			t.Errorf("expected the initial resource version %q to be rejected", rv)
+		}
+	}
+}
+
+func TestRetryWatcherResume(t *testing.T) {
+	f := newTestWatchFunc()
+	rw := newTestRetryWatcher(t, f)
+	defer rw.Stop()
+
+	// the watch is resumed from the last event when it closes
+	w := f.nextWatcher(t)
+	w.Add(newUser("alice", "2"))
+	expectEvent(t, rw, Added, "2")
+	w.Stop()
+
+	// and from a bookmark, which is not delivered
+	w = f.nextWatcher(t)
+	w.Action(Bookmark, newUser("", "5"))
+	w.Stop()
+
+	// 500 and 504 errors are retried from the last resource version
+	w = f.nextWatcher(t)
+	w.Error(&meta.Status{Status: apierrors.NewInternalError(errors.New("leader changed")).ErrStatus})
+	w = f.nextWatcher(t)
+	w.Error(&meta.Status{Status: apierrors.NewTimeoutError("request timed out", 0).ErrStatus})
+	w = f.nextWatcher(t)
+	// as are the errors that are not a status
+	w.Error(newUser("", ""))
+	w = f.nextWatcher(t)
+	w.Modify(newUser("alice", "6"))
+	expectEvent(t, rw, Modified, "6")
+
+	// and the failed watch requests
+	f.failNext(apierrors.NewInternalError(errors.New("no leader")), apierrors.NewServiceUnavailable("unavailable"))
+	w.Stop()
+	w = f.nextWatcher(t)
+	w.Delete(newUser("alice", "7"))
+	expectEvent(t, rw, Deleted, "7")
+
+	expectVersions(t, f, "1", "2", "5", "5", "5", "5", "6", "6", "6")
+}
+
+func TestRetryWatcherRelistRequired(t *testing.T) {
+	testCases := []struct {
+		name string
+		// expire makes the watch of f fail because the resource version it
+		// is resumed from has been compacted.
+		expire func(f *testWatchFunc, w *FakeWatcher)
+	}{
+		{
+			name: "expired error event",
+			expire: func(f *testWatchFunc, w *FakeWatcher) {
+				w.Error(&meta.Status{Status: apierrors.NewResourceExpired("too old resource version").ErrStatus})
+			},
+		},
+		{
+			name: "gone error event",
+			expire: func(f *testWatchFunc, w *FakeWatcher) {
+				w.Error(&meta.Status{Status: apierrors.NewGone("too old resource version").ErrStatus})
+			},
+		},
+		{
+			name: "expired watch request",
+			expire: func(f *testWatchFunc, w *FakeWatcher) {
+				f.failNext(apierrors.NewResourceExpired("too old resource version"))
+				w.Stop()
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestWatchFunc()
+			rw := newTestRetryWatcher(t, f)
+			defer rw.Stop()
+
+			w := f.nextWatcher(t)
+			w.Add(newUser("alice", "2"))
+			expectEvent(t, rw, Added, "2")
+			tc.expire(f, w)
+			event := expectEvent(t, rw, Error, "")
+			if !IsRelistRequired(event) {
+				t.Errorf("expected the watcher to require a relist, got %#v", event)
+			}
+			expectClosed(t, rw)
+
+			// the watch is not retried
+			select {
+			case w := <-f.watchers:
+				t.Errorf("expected no watch after a 410, got %v", w)
+			default:
+			}
+		})
+	}
+}
+
+func TestRetryWatcherInvalidEvents(t *testing.T) {
+	testCases := []struct {
+		name  string
+		event Event
+	}{
+		{
+			name:  "no resource version",
+			event: Event{Type: Added, Object: newUser("alice", "")},
+		},
+		{
+			name:  "no metadata",
+			event: Event{Type: Added, Object: &myType{ID: "alice"}},
+		},
+		{
+			name:  "unknown type",
+			event: Event{Type: "UNKNOWN", Object: newUser("alice", "2")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestWatchFunc()
+			rw := newTestRetryWatcher(t, f)
+			defer rw.Stop()
+
+			f.nextWatcher(t).Action(tc.event.Type, tc.event.Object)
+			// the watcher cannot know where to resume from, it fails
+			// without a relist
+			event := expectEvent(t, rw, Error, "")
+			if status, ok := event.Object.(*meta.Status); !ok || status.Code != 500 || IsRelistRequired(event) {
+				t.Errorf("expected an internal error, got %#v", event.Object)
+			}
+			expectClosed(t, rw)
+		})
+	}
+}
+
+func TestRetryWatcherStop(t *testing.T) {
+	f := newTestWatchFunc()
+	rw := newTestRetryWatcher(t, f)
+	w := f.nextWatcher(t)
+
+	rw.Stop()
+	expectClosed(t, rw)
+	if !w.IsStopped() {
+		t.Errorf("expected the underlying watch to be stopped")
+	}
+	expectVersions(t, f, "1")
+}
+
+func TestIsRelistRequired(t *testing.T) {
+	testCases := []struct {
+		name     string
+		event    Event
+		expected bool
+	}{
+		{
+			name:     "gone",
+			event:    Event{Type: Error, Object: &meta.Status{Status: apierrors.NewGone("gone").ErrStatus}},
+			expected: true,
+		},
+		{
+			name:     "expired",
+			event:    Event{Type: Error, Object: &meta.Status{Status: apierrors.NewResourceExpired("expired").ErrStatus}},
+			expected: true,
+		},
+		{
+			name:  "internal error",
+			event: Event{Type: Error, Object: &meta.Status{Status: apierrors.NewInternalError(errors.New("failed")).ErrStatus}},
+		},
+		{
+			name:  "not a status",
+			event: Event{Type: Error, Object: newUser("alice", "2")},
+		},
+		{
+			name:  "not an error",
+			event: Event{Type: Added, Object: &meta.Status{Status: apierrors.NewGone("gone").ErrStatus}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsRelistRequired(tc.event); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
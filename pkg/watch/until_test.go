@@ -0,0 +1,137 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestUntil(t *testing.T) {
+	fw := NewFake()
+	go func() {
+		obj := &myType{ID: "foo"}
+		fw.Modify(obj)
+		fw.Delete(obj)
+	}()
+	conditions := []ConditionFunc{
+		func(event Event) (bool, error) { return event.Type == Modified, nil },
+		func(event Event) (bool, error) { return event.Type == Deleted, nil },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait.ForeverTestTimeout)
+	defer cancel()
+
+	lastEvent, err := Until(ctx, fw, conditions...)
+	if err != nil {
+		t.Fatalf("expected nil error, got %#v", err)
+	}
+	if lastEvent == nil {
+		t.Fatal("expected an event")
+	}
+	if lastEvent.Type != Deleted {
+		t.Fatalf("expected DELETE event type, got %v", lastEvent.Type)
+	}
+	if got, isMyType := lastEvent.Object.(*myType); !isMyType {
+		t.Fatalf("expected a myType, got %#v", got)
+	}
+	if !fw.IsStopped() {
+		t.Errorf("expected the watch to be stopped")
+	}
+}
+
+func TestUntilMultipleConditions(t *testing.T) {
+	fw := NewFake()
+	go func() {
+		obj := &myType{ID: "foo"}
+		fw.Add(obj)
+	}()
+	conditions := []ConditionFunc{
+		func(event Event) (bool, error) { return event.Type == Added, nil },
+		func(event Event) (bool, error) { return event.Type == Added, nil },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait.ForeverTestTimeout)
+	defer cancel()
+
+	lastEvent, err := Until(ctx, fw, conditions...)
+	if err != nil {
+		t.Fatalf("expected nil error, got %#v", err)
+	}
+	if lastEvent == nil {
+		t.Fatal("expected an event")
+	}
+	if lastEvent.Type != Added {
+		t.Fatalf("expected ADDED event type, got %v", lastEvent.Type)
+	}
+}
+
+func TestUntilTimeout(t *testing.T) {
+	fw := NewFake()
+	go func() {
+		fw.Add(&myType{ID: "foo"})
+	}()
+	conditions := []ConditionFunc{
+		func(event Event) (bool, error) { return event.Type == Added, nil },
+		func(event Event) (bool, error) { return event.Type == Deleted, nil },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	lastEvent, err := Until(ctx, fw, conditions...)
+	if err != wait.ErrWaitTimeout {
+		t.Fatalf("expected ErrWaitTimeout error, got %#v", err)
+	}
+	if lastEvent == nil || lastEvent.Type != Added {
+		t.Fatalf("expected the last event received to be ADDED, got %#v", lastEvent)
+	}
+}
+
+func TestUntilErrorCondition(t *testing.T) {
+	fw := NewFake()
+	go func() {
+		fw.Add(&myType{ID: "foo"})
+	}()
+	expected := "something bad"
+	conditions := []ConditionFunc{
+		func(event Event) (bool, error) { return event.Type == Added, nil },
+		func(event Event) (bool, error) { return false, errors.New(expected) },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait.ForeverTestTimeout)
+	defer cancel()
+
+	_, err := Until(ctx, fw, conditions...)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q in error string, got %q", expected, err.Error())
+	}
+}
+
+func TestUntilWatchClosed(t *testing.T) {
+	fw := NewFake()
+	go func() {
+		fw.Add(&myType{ID: "foo"})
+		fw.Stop()
+	}()
+	conditions := []ConditionFunc{
+		func(event Event) (bool, error) { return event.Type == Deleted, nil },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait.ForeverTestTimeout)
+	defer cancel()
+
+	lastEvent, err := Until(ctx, fw, conditions...)
+	if err != ErrWatchClosed {
+		t.Fatalf("expected ErrWatchClosed error, got %#v", err)
+	}
+	if lastEvent == nil || lastEvent.Type != Added {
+		t.Fatalf("expected the last event received to be ADDED, got %#v", lastEvent)
+	}
+}